@@ -0,0 +1,165 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+// The admin config locks settings for everyone on the machine, e.g. for a
+// parent or an admin managing a shared computer. It has the format of the
+// config file, and its settings win over the user's config file, the
+// command line and --policy rules. `enforce = on` also makes protection
+// mandatory: no pause, no disable and no trusted networks.
+//
+// A kill switch is out of scope: protect-wan only changes Tailscale's
+// prefs and never installs firewall rules, so it can't block traffic
+// while no exit node works. A `kill-switch` setting is refused rather
+// than ignored, so nobody believes it is in place.
+//
+//	# /etc/protect-wan/admin.conf
+//	allow-country = CH,DE
+//	ipv6-leak = fail
+//	enforce = on
+
+// adminLocked are the settings of the admin config, by flag name
+var adminLocked = make(map[string]string)
+
+// adminEnforce is set by `enforce = on` in the admin config
+var adminEnforce bool
+
+// errEnforced is returned for a pause or disable while the admin config
+// enforces protection
+var errEnforced = errors.New("protection is enforced by the admin config and can't be paused or disabled")
+
+// adminConfigPath returns the admin config location, only writable by
+// administrators: /etc/protect-wan/admin.conf on Linux and BSD,
+// /Library/Application Support/protect-wan/admin.conf on macOS and
+// %ProgramData%\protect-wan\admin.conf on Windows
+func adminConfigPath() string {
+	switch runtime.GOOS {
+	case "windows":
+		dir := os.Getenv("ProgramData")
+		if dir == "" {
+			return ""
+		}
+		return filepath.Join(dir, appDir, "admin.conf")
+	case "darwin":
+		return filepath.Join("/Library/Application Support", appDir, "admin.conf")
+	}
+	return filepath.Join("/etc", appDir, "admin.conf")
+}
+
+// loadAdminConfig applies the admin config, if there is one, over the
+// config file. A locked setting given on the command line with another
+// value is an error rather than silently ignored.
+func loadAdminConfig() error {
+	clear(adminLocked)
+	adminEnforce = false
+	path := adminConfigPath()
+	if path == "" {
+		return nil
+	}
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to open admin config: %w", err)
+	}
+	defer f.Close()
+
+	// A file others can edit locks nothing
+	if fi, err := f.Stat(); err == nil && runtime.GOOS != "windows" && fi.Mode().Perm()&0o022 != 0 {
+		return fmt.Errorf("admin config %s is writable by other users, make it writable by root only", path)
+	}
+
+	err = readConfigLines(f, path, func(where, name, value string) error {
+		if name == "enforce" {
+			if value != "on" && value != "off" {
+				return fmt.Errorf("%s: invalid enforce %q (expected on or off)", where, value)
+			}
+			adminEnforce = value == "on"
+			return nil
+		}
+		if name == "kill-switch" {
+			return fmt.Errorf("%s: kill-switch is not supported, protect-wan doesn't install firewall rules; block traffic outside the tailnet with the system firewall", where)
+		}
+		fl := flag.Lookup(name)
+		if fl == nil || name == "config" || name == "override-policy" {
+			return fmt.Errorf("%s: unknown or unsupported setting %q", where, name)
+		}
+
+		// List flags may be repeated, the first line replaces the value
+		var err error
+		if _, repeated := adminLocked[name]; repeated {
+			err = fl.Value.Set(value)
+		} else {
+			err = replaceFlag(name, value)
+		}
+		if err != nil {
+			return fmt.Errorf("%s: invalid value for %s: %w", where, name, err)
+		}
+		adminLocked[name] = fl.Value.String()
+		configSet[name] = true
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if *overridePolicyFlag && countriesLocked() {
+		return errOverrideLocked()
+	}
+	return nil
+}
+
+// errOverrideLocked is the error for --override-policy while the admin
+// config locks the countries
+func errOverrideLocked() error {
+	return fmt.Errorf("--override-policy is not allowed, the admin config %s locks the countries", adminConfigPath())
+}
+
+// checkCommandLineLocks fails for a command line flag the admin config
+// locks to another value. Call before loadAdminConfig replaces it.
+func checkCommandLineLocks() error {
+	cmdline := make(map[string]string)
+	for name := range commandLineFlags {
+		cmdline[name] = flag.Lookup(name).Value.String()
+	}
+	if err := loadAdminConfig(); err != nil {
+		return err
+	}
+	var conflicts []string
+	for name, value := range cmdline {
+		if locked, ok := adminLocked[name]; ok && locked != value {
+			conflicts = append(conflicts, fmt.Sprintf("--%s (locked to %q)", name, locked))
+		}
+	}
+	if len(conflicts) > 0 {
+		sort.Strings(conflicts)
+		return fmt.Errorf("the admin config %s locks %s", adminConfigPath(), strings.Join(conflicts, ", "))
+	}
+	return nil
+}
+
+// countriesLocked reports whether the admin config locks the countries
+// exit nodes may be in, so --override-policy can't lift them
+func countriesLocked() bool {
+	_, forbid := adminLocked["forbid-country"]
+	_, allow := adminLocked["allow-country"]
+	return forbid || allow
+}
+
+// checkEnforced fails while the admin config enforces protection
+func checkEnforced() error {
+	if adminEnforce {
+		return errEnforced
+	}
+	return nil
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestOverrideWithLockedCountries(t *testing.T) {
+	fake := newTestClient(t, testTailnet...)
+	withFlag(t, "forbid-country", "DE")
+	withFlag(t, "override-policy", "true")
+	adminLocked["forbid-country"] = "DE"
+	t.Cleanup(func() { delete(adminLocked, "forbid-country") })
+
+	// Neither the flag nor a daemon's override lifts a locked country
+	for _, ctx := range []context.Context{context.Background(), withPolicyOverride(context.Background(), "")} {
+		var forbidden *forbiddenError
+		if err := setExitNodeByName(ctx, fake, "de-fra-wg-001"); !errors.As(err, &forbidden) {
+			t.Errorf("setExitNodeByName = %v, want a forbiddenError", err)
+		}
+	}
+	if prefs, _ := fake.GetPrefs(context.Background()); !prefs.ExitNodeID.IsZero() {
+		t.Errorf("exit node = %q, want none", prefs.ExitNodeID)
+	}
+}
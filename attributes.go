@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var requireFlag, preferAttrFlag stringList
+
+func init() {
+	flag.Var(&requireFlag, "require", "Only use relays with this attribute: ram-only, daita, owned or provider:<name>; prefix ! to exclude (repeatable)")
+	flag.Var(&preferAttrFlag, "prefer-attr", "Rank relays with this attribute first, same syntax as --require (repeatable)")
+}
+
+// mullvadServersURL lists Mullvad's WireGuard servers with their hosting
+// details, which the app relay list lacks
+const mullvadServersURL = "https://api.mullvad.net/www/relays/wireguard/"
+
+// relayAttrsTTL is how long the server list is reused within a process,
+// e.g. across daemon checks
+const relayAttrsTTL = time.Hour
+
+// relayAttrs are the properties of a Mullvad server that matter for some
+// threat models
+type relayAttrs struct {
+	Hostname string `json:"hostname"`
+	Provider string `json:"provider"`
+	Owned    bool   `json:"owned"`  // Hardware owned by Mullvad, not rented
+	RAMOnly  bool   `json:"stboot"` // Diskless, booted over the network
+	DAITA    bool   `json:"daita"`  // Defense against AI-guided traffic analysis
+}
+
+// attrRule is one --require or --prefer-attr entry
+type attrRule struct {
+	Negate bool
+	Name   string // ram-only, daita, owned or provider
+	Value  string // Provider name
+}
+
+var relayAttrsCache struct {
+	mu      sync.Mutex
+	fetched time.Time
+	byName  map[string]relayAttrs
+}
+
+// parseAttrRules parses --require style entries
+func parseAttrRules(values []string) ([]attrRule, error) {
+	var rules []attrRule
+	for _, v := range values {
+		r := attrRule{}
+		v = strings.TrimSpace(v)
+		if strings.HasPrefix(v, "!") {
+			r.Negate = true
+			v = v[1:]
+		}
+		name, value, _ := strings.Cut(strings.ToLower(v), ":")
+		switch name {
+		case "ram-only", "diskless", "stboot":
+			r.Name = "ram-only"
+		case "daita", "owned":
+			r.Name = name
+		case "provider":
+			if value == "" {
+				return nil, fmt.Errorf("invalid attribute %q (expected provider:<name>)", v)
+			}
+			r.Name, r.Value = name, value
+		default:
+			return nil, fmt.Errorf("unknown attribute %q (expected ram-only, daita, owned or provider:<name>)", v)
+		}
+		rules = append(rules, r)
+	}
+	return rules, nil
+}
+
+// matches reports whether the server satisfies the rule
+func (r attrRule) matches(a relayAttrs) bool {
+	var has bool
+	switch r.Name {
+	case "ram-only":
+		has = a.RAMOnly
+	case "daita":
+		has = a.DAITA
+	case "owned":
+		has = a.Owned
+	case "provider":
+		has = strings.EqualFold(a.Provider, r.Value)
+	}
+	return has != r.Negate
+}
+
+func (r attrRule) String() string {
+	s := r.Name
+	if r.Value != "" {
+		s += ":" + r.Value
+	}
+	if r.Negate {
+		s = "!" + s
+	}
+	return s
+}
+
+// fetchRelayAttrs returns the server list by Mullvad server name
+func fetchRelayAttrs(ctx context.Context) (map[string]relayAttrs, error) {
+	relayAttrsCache.mu.Lock()
+	defer relayAttrsCache.mu.Unlock()
+	if relayAttrsCache.byName != nil && time.Since(relayAttrsCache.fetched) < relayAttrsTTL {
+		return relayAttrsCache.byName, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, mullvadServersURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch Mullvad server list: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch Mullvad server list: %s", resp.Status)
+	}
+
+	var servers []relayAttrs
+	if err := json.NewDecoder(resp.Body).Decode(&servers); err != nil {
+		return nil, fmt.Errorf("failed to decode Mullvad server list: %w", err)
+	}
+	byName := make(map[string]relayAttrs, len(servers))
+	for _, s := range servers {
+		byName[strings.ToLower(s.Hostname)] = s
+	}
+	relayAttrsCache.byName, relayAttrsCache.fetched = byName, time.Now()
+	return byName, nil
+}
+
+// filterByAttributes keeps the nodes whose servers satisfy every --require
+// rule. Nodes missing from the server list can't be vouched for and are
+// dropped.
+func filterByAttributes(ctx context.Context, nodes []MullvadNode) ([]MullvadNode, error) {
+	if len(requireFlag) == 0 {
+		return nodes, nil
+	}
+	rules, err := parseAttrRules(requireFlag)
+	if err != nil {
+		return nil, err
+	}
+	attrs, err := fetchRelayAttrs(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, r := range rules {
+		filtered := make([]MullvadNode, 0)
+		for _, node := range nodes {
+			a, ok := attrs[mullvadHostname(node)]
+			switch {
+			case !ok:
+				explain.eliminate("not in the Mullvad server list, attributes unknown", node)
+			case !r.matches(a):
+				explain.eliminate("fails --require "+r.String(), node)
+			default:
+				filtered = append(filtered, node)
+			}
+		}
+		explain.filter("require "+r.String(), len(nodes), len(filtered))
+		if len(filtered) == 0 {
+			return nil, fmt.Errorf("no Mullvad exit nodes match --require %s", r)
+		}
+		nodes = filtered
+	}
+	return nodes, nil
+}
+
+// preferAttributes moves the candidates matching the most --prefer-attr
+// rules first, keeping the strategy's order otherwise. Without the server
+// list the order is left alone.
+func preferAttributes(ctx context.Context, candidates []MullvadNode) []MullvadNode {
+	if len(preferAttrFlag) == 0 {
+		return candidates
+	}
+	rules, err := parseAttrRules(preferAttrFlag)
+	if err != nil {
+		return candidates
+	}
+	attrs, err := fetchRelayAttrs(ctx)
+	if err != nil {
+		fmt.Printf("Warning: %v, ignoring --prefer-attr\n", err)
+		return candidates
+	}
+
+	score := make(map[string]int, len(candidates))
+	for _, node := range candidates {
+		if a, ok := attrs[mullvadHostname(node)]; ok {
+			for _, r := range rules {
+				if r.matches(a) {
+					score[node.DNSName]++
+				}
+			}
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return score[candidates[i].DNSName] > score[candidates[j].DNSName]
+	})
+	explain.note("Candidates matching --prefer-attr %s ranked first", strings.Join(preferAttrFlag, ", "))
+	return candidates
+}
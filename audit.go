@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"time"
+
+	"tailscale.com/ipn"
+)
+
+var auditLogFlag = flag.String("audit-log", "", "Append every Tailscale prefs change (before/after, reason, command) to this file as JSON lines")
+
+// auditEntry is one line of the audit log
+type auditEntry struct {
+	Time    time.Time              `json:"time"`
+	User    string                 `json:"user"`
+	PID     int                    `json:"pid"`
+	Command string                 `json:"command"`
+	Reason  string                 `json:"reason"`
+	Source  string                 `json:"source,omitempty"` // Selection source, for exit node changes
+	Changes map[string]auditChange `json:"changes"`
+	Error   string                 `json:"error,omitempty"`
+}
+
+// auditChange is the value of one pref before and after the edit. After is
+// what tailscaled reports, which may differ from what was asked for.
+type auditChange struct {
+	Before any `json:"before"`
+	Want   any `json:"want"`
+	After  any `json:"after,omitempty"`
+}
+
+type auditReasonKey struct{}
+
+// withAuditReason labels the prefs edits made with ctx for the audit log
+func withAuditReason(ctx context.Context, reason string) context.Context {
+	return context.WithValue(ctx, auditReasonKey{}, reason)
+}
+
+// auditReasonOr labels ctx with reason unless a caller labeled it already
+func auditReasonOr(ctx context.Context, reason string) context.Context {
+	if _, ok := ctx.Value(auditReasonKey{}).(string); ok {
+		return ctx
+	}
+	return withAuditReason(ctx, reason)
+}
+
+// newAuditEntry starts an entry about this process
+func newAuditEntry(reason string, changes map[string]auditChange) auditEntry {
+	entry := auditEntry{
+		Time:    time.Now().UTC(),
+		PID:     os.Getpid(),
+		Command: strings.Join(os.Args, " "),
+		Reason:  reason,
+		Changes: changes,
+	}
+	if u, err := user.Current(); err == nil {
+		entry.User = u.Username
+	}
+	return entry
+}
+
+// auditClient records every EditPrefs call of the wrapped client
+type auditClient struct {
+	LocalClient
+	path string
+}
+
+func (a auditClient) EditPrefs(ctx context.Context, mp *ipn.MaskedPrefs) (*ipn.Prefs, error) {
+	before, _ := a.LocalClient.GetPrefs(ctx)
+	after, err := a.LocalClient.EditPrefs(ctx, mp)
+
+	reason, _ := ctx.Value(auditReasonKey{}).(string)
+	entry := newAuditEntry(reason, prefChanges(mp, before, after))
+	if mp.ExitNodeIDSet || mp.ExitNodeIPSet {
+		entry.Source = selectionSource
+	}
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	if werr := appendAudit(a.path, entry); werr != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to write audit log: %v\n", werr)
+	}
+	return after, err
+}
+
+// SwitchProfile is audited too: switching login profiles swaps every pref
+func (a auditClient) SwitchProfile(ctx context.Context, profile ipn.ProfileID) error {
+	before, _, _ := a.LocalClient.ProfileStatus(ctx)
+	err := a.LocalClient.SwitchProfile(ctx, profile)
+	after, _, _ := a.LocalClient.ProfileStatus(ctx)
+
+	entry := newAuditEntry("switch login profile", map[string]auditChange{
+		"Profile": {Before: before.ID, Want: profile, After: after.ID},
+	})
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	if werr := appendAudit(a.path, entry); werr != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to write audit log: %v\n", werr)
+	}
+	return err
+}
+
+// prefChanges lists the prefs an edit sets: every XSet field of the masked
+// prefs names the pref X
+func prefChanges(mp *ipn.MaskedPrefs, before, after *ipn.Prefs) map[string]auditChange {
+	changes := make(map[string]auditChange)
+	mv := reflect.ValueOf(mp).Elem()
+	want := reflect.ValueOf(mp.Prefs)
+	for i := 0; i < mv.NumField(); i++ {
+		name, ok := strings.CutSuffix(mv.Type().Field(i).Name, "Set")
+		if !ok || mv.Field(i).Kind() != reflect.Bool || !mv.Field(i).Bool() {
+			continue
+		}
+		f := want.FieldByName(name)
+		if !f.IsValid() {
+			continue
+		}
+		c := auditChange{Want: f.Interface()}
+		if before != nil {
+			c.Before = reflect.ValueOf(*before).FieldByName(name).Interface()
+		}
+		if after != nil {
+			c.After = reflect.ValueOf(*after).FieldByName(name).Interface()
+		}
+		changes[name] = c
+	}
+	return changes
+}
+
+// appendAudit appends an entry to the audit log. The file is only ever
+// opened for appending, never rewritten or rotated by protect-wan.
+func appendAudit(path string, entry auditEntry) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o640)
+	if err != nil {
+		return err
+	}
+	line, err := json.Marshal(entry)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	"tailscale.com/ipn"
+	"tailscale.com/tailcfg"
+)
+
+var tailscaleAutoFlag = flag.String("tailscale-auto", "defer", "When tailscaled selects exit nodes itself (auto exit node) or a system policy pins one: defer, override or reconcile")
+
+// pinnedRetry is how long automatic selection leaves a pinned exit node
+// alone before trying again, in case the policy was lifted
+const pinnedRetry = 30 * time.Minute
+
+// exitNodePinnedAt is when tailscaled last reverted an exit node change,
+// which means a system policy (MDM, registry, managed profile) pins the
+// exit node. A change that sticks clears it.
+var exitNodePinnedAt time.Time
+
+// exitNodePinned reports whether a system policy pinned the exit node
+// within pinnedRetry
+func exitNodePinned() bool {
+	return !exitNodePinnedAt.IsZero() && time.Since(exitNodePinnedAt) < pinnedRetry
+}
+
+// pinnedError reports that tailscaled did not keep the exit node set
+type pinnedError struct {
+	Want, Got tailcfg.StableNodeID
+}
+
+func (e *pinnedError) Error() string {
+	got := string(e.Got)
+	if got == "" {
+		got = "none"
+	}
+	return fmt.Sprintf("tailscaled kept exit node %s instead of %s: the exit node is likely pinned by a system policy", got, e.Want)
+}
+
+// isPolicyError reports whether tailscaled refused a prefs edit because a
+// system policy manages the setting
+func isPolicyError(err error) bool {
+	s := strings.ToLower(err.Error())
+	return strings.Contains(s, "policy") || strings.Contains(s, "managed by")
+}
+
+// tailscaleAuto returns tailscaled's auto exit node expression, e.g. "any",
+// or "" when the exit node is chosen by hand
+func tailscaleAuto(ctx context.Context, lc LocalClient) ipn.ExitNodeExpression {
+	prefs, err := lc.GetPrefs(ctx)
+	if err != nil {
+		return ""
+	}
+	return prefs.AutoExitNode
+}
+
+// deferToTailscale reports whether selection should leave the exit node to
+// tailscaled, and why. candidates is the ranked selection, used by
+// --tailscale-auto reconcile to judge tailscaled's own pick. A place picked
+// by hand (--set, the tray) always overrides it, and so does an exit node
+// in a forbidden country.
+func deferToTailscale(ctx context.Context, lc LocalClient, candidates []MullvadNode) (bool, string) {
+	if selectionSource == sourceManual || selectionSource == sourceTray {
+		return false, ""
+	}
+	// Neither tailscaled's pick nor a pinned node may stay in a forbidden
+	// country
+	if err := checkActiveForbidden(ctx, lc); err != nil {
+		fmt.Printf("Not leaving the exit node to Tailscale: %v\n", err)
+		return false, ""
+	}
+	if exitNodePinned() && *tailscaleAutoFlag != "override" {
+		return true, "the exit node is pinned by a system policy"
+	}
+	auto := tailscaleAuto(ctx, lc)
+	if auto == "" {
+		return false, ""
+	}
+
+	switch *tailscaleAutoFlag {
+	case "override":
+		fmt.Printf("Turning off Tailscale's automatic exit node (%s), --tailscale-auto override\n", auto)
+		return false, ""
+	case "reconcile":
+		current, ok, err := currentExitNode(ctx, lc)
+		if err != nil || !ok || !current.Online {
+			fmt.Printf("Tailscale's automatic exit node (%s) has no working pick, overriding it\n", auto)
+			return false, ""
+		}
+		for _, c := range candidates {
+			if c.ID == current.ID {
+				return true, fmt.Sprintf("Tailscale's automatic pick %s meets the selection criteria", displayName(current))
+			}
+		}
+		fmt.Printf("Tailscale's automatic pick %s doesn't meet the selection criteria, overriding it\n", displayName(current))
+		return false, ""
+	}
+	return true, fmt.Sprintf("tailscaled selects exit nodes automatically (%s)", auto)
+}
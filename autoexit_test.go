@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"tailscale.com/ipn"
+)
+
+func TestExitNodePinned(t *testing.T) {
+	ctx := context.Background()
+	fake := newTestClient(t, testTailnet...)
+	t.Cleanup(func() { exitNodePinnedAt = time.Time{} })
+
+	fake.failWith("EditPrefs", errors.New("exit node is managed by a system policy"))
+	if err := setExitNode(ctx, fake, "de1"); err == nil {
+		t.Fatal("setExitNode succeeded, want the policy error")
+	}
+	if deferred, _ := deferToTailscale(ctx, fake, nil); !deferred {
+		t.Error("deferToTailscale after a policy error = false, want true")
+	}
+
+	// The pin is retried after a while
+	exitNodePinnedAt = time.Now().Add(-pinnedRetry)
+	if deferred, reason := deferToTailscale(ctx, fake, nil); deferred {
+		t.Errorf("deferToTailscale after %v = true (%s), want false", pinnedRetry, reason)
+	}
+
+	// A change that sticks clears it
+	exitNodePinnedAt = time.Now()
+	fake.failWith("EditPrefs", nil)
+	if err := setExitNode(ctx, fake, "de1"); err != nil {
+		t.Fatalf("setExitNode: %v", err)
+	}
+	if exitNodePinned() {
+		t.Error("exit node still pinned after a change stuck")
+	}
+}
+
+func TestDeferToTailscaleForbidden(t *testing.T) {
+	ctx := context.Background()
+	fake := newTestClient(t, testTailnet...)
+	withFlag(t, "forbid-country", "SE")
+
+	// tailscaled's own pick is in a forbidden country
+	mp := &ipn.MaskedPrefs{Prefs: ipn.Prefs{ExitNodeID: "se1", AutoExitNode: "any"}, ExitNodeIDSet: true, AutoExitNodeSet: true}
+	if _, err := fake.EditPrefs(ctx, mp); err != nil {
+		t.Fatalf("EditPrefs: %v", err)
+	}
+	if deferred, reason := deferToTailscale(ctx, fake, nil); deferred {
+		t.Fatalf("deferToTailscale = true (%s), want false for a forbidden exit node", reason)
+	}
+
+	if err := autoSelectMullvad(ctx, fake); err != nil {
+		t.Fatalf("autoSelectMullvad: %v", err)
+	}
+	if prefs, _ := fake.GetPrefs(ctx); prefs.ExitNodeID == "se1" || prefs.AutoExitNode != "" {
+		t.Errorf("exit node = %q, auto = %q; want another node and Tailscale's auto exit node off", prefs.ExitNodeID, prefs.AutoExitNode)
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// captiveProbeURL returns 204 No Content on an open network. Captive portals
+// intercept it and answer with a redirect or their login page instead.
+const captiveProbeURL = "http://connectivitycheck.gstatic.com/generate_204"
+
+// maxEnforcedBypass caps --portal-bypass while the admin config enforces
+// protection, so a bypass can't stand in for --disable
+const maxEnforcedBypass = 5 * time.Minute
+
+// captiveClient must not follow redirects, the redirect is the signal
+var captiveClient = &http.Client{
+	Timeout: 5 * time.Second,
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+// detectCaptivePortal probes captiveProbeURL
+// Returns true and the portal location (if known) when a portal intercepts the probe
+func detectCaptivePortal(ctx context.Context) (bool, string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, captiveProbeURL, nil)
+	if err != nil {
+		return false, "", err
+	}
+
+	resp, err := captiveClient.Do(req)
+	if err != nil {
+		return false, "", fmt.Errorf("captive portal probe failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNoContent {
+		return false, "", nil
+	}
+
+	return true, resp.Header.Get("Location"), nil
+}
+
+// bypassCaptivePortal disables the exit node for at most maxDuration so the
+// user can log in to a captive portal, then restores the previous exit node
+// and verifies protection. Interrupting with Ctrl-C restores immediately.
+func bypassCaptivePortal(ctx context.Context, lc LocalClient, maxDuration time.Duration) error {
+	prefs, err := lc.GetPrefs(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get prefs: %w", err)
+	}
+	previous := prefs.ExitNodeID
+
+	if previous.IsZero() {
+		fmt.Println("No exit node set, nothing to bypass. Log in to the portal, then run protect-wan again.")
+		return nil
+	}
+	if adminEnforce && maxDuration > maxEnforcedBypass {
+		fmt.Printf("Protection is enforced by the admin config, bypassing for at most %s\n", maxEnforcedBypass)
+		maxDuration = maxEnforcedBypass
+	}
+
+	if err := clearExitNode(withAuditReason(ctx, "captive portal bypass"), lc); err != nil {
+		return err
+	}
+
+	// From here on the previous exit node must be restored, even on Ctrl-C
+	waitCtx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	waitCtx, cancel := context.WithTimeout(waitCtx, maxDuration)
+
+	fmt.Printf("Exit node disabled for up to %s. Log in to the captive portal now.\n", maxDuration)
+	if captive, location, err := detectCaptivePortal(waitCtx); err == nil && captive && location != "" {
+		fmt.Printf("Portal login page: %s\n", location)
+	}
+
+	ticker := time.NewTicker(5 * time.Second)
+wait:
+	for {
+		select {
+		case <-waitCtx.Done():
+			fmt.Println("Bypass window ended")
+			break wait
+		case <-ticker.C:
+			captive, _, err := detectCaptivePortal(waitCtx)
+			if err == nil && !captive {
+				fmt.Println("Captive portal cleared")
+				break wait
+			}
+		}
+	}
+	ticker.Stop()
+	cancel()
+	stop()
+
+	if err := setExitNode(withAuditReason(ctx, "restore after captive portal bypass"), lc, previous); err != nil {
+		return fmt.Errorf("failed to restore exit node %s: %w", previous, err)
+	}
+
+	if err := waitForExitNode(ctx, lc, 30*time.Second); err != nil {
+		return fmt.Errorf("exit node %s restored but %w", previous, err)
+	}
+
+	fmt.Printf("WAN is protected again via %s\n", previous)
+	return nil
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"time"
+)
+
+var maxSwitchesFlag = flag.Int("max-switches-per-hour", 6, "Daemon: pause automatic switching and alert when the exit node changed this often in the last hour (0 to disable)")
+
+// switchHistory is how long automatic switches are remembered for the churn
+// metrics and guardrail
+const switchHistory = 24 * time.Hour
+
+var (
+	switchesCounter = newCounter("protect_wan_exit_node_switches",
+		"Automatic exit node switches by the daemon")
+	_ = newGaugeFunc("protect_wan_exit_node_switches_last_hour",
+		"Automatic exit node switches in the last hour", func() float64 { return float64(recentSwitches(time.Hour)) })
+	_ = newGaugeFunc("protect_wan_exit_node_switches_last_day",
+		"Automatic exit node switches in the last 24 hours", func() float64 { return float64(recentSwitches(24 * time.Hour)) })
+)
+
+// churnPaused is when the guardrail paused automatic switching, zero while
+// it isn't
+var churnPaused time.Time
+
+// recentSwitches counts the automatic switches within d
+func recentSwitches(d time.Duration) int {
+	if !stateEnabled() {
+		return 0
+	}
+	st, err := loadState()
+	if err != nil {
+		return 0
+	}
+	n := 0
+	for _, t := range st.Switches {
+		if time.Since(t) < d {
+			n++
+		}
+	}
+	return n
+}
+
+// churnGuard reports whether automatic switching is paused because the exit
+// node changed more than --max-switches-per-hour in the last hour, which
+// points at flapping rather than at better nodes. The pause is alerted
+// once. Only a working exit node (active, and not in a forbidden country)
+// is held: one is still set when there is none, and a failing one is still
+// replaced, so the guardrail never leaves the WAN unprotected.
+func churnGuard(ctx context.Context, lc LocalClient, active bool) (bool, string) {
+	if *maxSwitchesFlag <= 0 {
+		return false, ""
+	}
+
+	n := recentSwitches(time.Hour)
+	if n < *maxSwitchesFlag {
+		if !churnPaused.IsZero() {
+			log.Printf("Exit node churn back to %d switches in the last hour, automatic switching resumed", n)
+			notifyEvent(ctx, "switch-churn-resolved", fmt.Sprintf("automatic switching resumed after a pause of %s", time.Since(churnPaused).Round(time.Second)))
+			churnPaused = time.Time{}
+		}
+		return false, ""
+	}
+
+	if !active {
+		return false, ""
+	}
+	prefs, err := lc.GetPrefs(ctx)
+	if err != nil || prefs.ExitNodeID.IsZero() {
+		return false, ""
+	}
+	if current, ok, err := currentExitNode(ctx, lc); err == nil && ok && checkForbidden(ctx, current) != nil {
+		return false, ""
+	}
+
+	if churnPaused.IsZero() {
+		churnPaused = time.Now()
+		notifyFailure(ctx, "switch-churn", fmt.Sprintf("exit node changed %d times in the last hour (--max-switches-per-hour %d), automatic switching paused", n, *maxSwitchesFlag))
+	}
+	return true, fmt.Sprintf("%d switches in the last hour (--max-switches-per-hour %d), automatic switching paused", n, *maxSwitchesFlag)
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"tailscale.com/ipn"
+	"tailscale.com/tailcfg"
+)
+
+func TestChurnGuard(t *testing.T) {
+	tests := []struct {
+		name     string
+		current  tailcfg.StableNodeID
+		active   bool // Whether current passed checkExitNode
+		forbid   string
+		switches int // In the last hour
+		wantHold bool
+	}{
+		{name: "below the limit", current: "de1", active: true, switches: 2},
+		{name: "churning", current: "de1", active: true, switches: 3, wantHold: true},
+		{name: "no exit node", switches: 3},
+		{name: "exit node not working", current: "de1", switches: 3},
+		{name: "forbidden exit node", current: "de1", active: true, forbid: "DE", switches: 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			fake := newTestClient(t, testTailnet...)
+			withFlag(t, "max-switches-per-hour", "3")
+			withFlag(t, "forbid-country", tt.forbid)
+			t.Cleanup(func() { churnPaused = time.Time{} })
+
+			if tt.current != "" {
+				mp := &ipn.MaskedPrefs{Prefs: ipn.Prefs{ExitNodeID: tt.current}, ExitNodeIDSet: true}
+				if _, err := fake.EditPrefs(ctx, mp); err != nil {
+					t.Fatalf("EditPrefs: %v", err)
+				}
+			}
+			err := updateState(func(st *state) {
+				for i := range tt.switches {
+					st.Switches = append(st.Switches, time.Now().Add(-time.Duration(i+1)*time.Minute))
+				}
+			})
+			if err != nil {
+				t.Fatalf("updateState: %v", err)
+			}
+
+			if hold, reason := churnGuard(ctx, fake, tt.active); hold != tt.wantHold {
+				t.Errorf("churnGuard = %v (%s), want %v", hold, reason, tt.wantHold)
+			}
+		})
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"tailscale.com/ipn"
+	"tailscale.com/tailcfg"
+)
+
+// cliLocalAPIEnv tells a re-executed test binary to run main against the
+// fake tailscaled at this address
+const cliLocalAPIEnv = "PROTECT_WAN_TEST_LOCALAPI"
+
+func TestMain(m *testing.M) {
+	if addr := os.Getenv(cliLocalAPIEnv); addr != "" {
+		newLocalClient = func() LocalClient { return localAPIClient(addr) }
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// TestCLI runs the command line end to end, from flag parsing through the
+// prefs the fake tailscaled receives, for the tailnet in testdata
+func TestCLI(t *testing.T) {
+	if testing.Short() {
+		t.Skip("runs the CLI in subprocesses")
+	}
+	tests := []struct {
+		name     string
+		args     []string
+		exitNode tailcfg.StableNodeID // Before the run
+		wantCode int
+		wantOut  string               // Part of the output
+		want     tailcfg.StableNodeID // Exit node after the run
+	}{
+		{name: "best only ranks", args: []string{"best"}, wantOut: "Best Mullvad exit node: de-fra-wg-004"},
+		{name: "set by hostname", args: []string{"--set", "de-fra-wg-001"}, want: "n4"},
+		{name: "auto applies the fastest", args: []string{"--auto"}, want: "n7"},
+		{name: "auto honors --country", args: []string{"--auto", "--country", "CH"}, want: "n2"},
+		{name: "check without exit node", args: []string{"--check"}, wantCode: 1, wantOut: "No exit node active"},
+		{name: "disable", args: []string{"--disable"}, exitNode: "n4", wantOut: "Exit node disabled"},
+		{name: "forbidden country refused", args: []string{"--forbid-country", "DE", "--set", "de-fra-wg-001"}, exitNode: "n2", wantCode: 1, wantOut: "ruled out by --forbid-country DE", want: "n2"},
+		{name: "forbidden exit node replaced", args: []string{"--forbid-country", "CH"}, exitNode: "n2", wantOut: "ruled out by --forbid-country CH", want: "n7"},
+		{name: "override policy", args: []string{"--forbid-country", "DE", "--set", "de-fra-wg-001", "--override-policy"}, want: "n4"},
+		{name: "invalid flag value", args: []string{"--state-store", "sqlite"}, wantCode: 1, wantOut: "Invalid --state-store"},
+		{name: "zero interval", args: []string{"--top-interval", "0", "top"}, wantCode: 1, wantOut: "Invalid --top-interval"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			fake, err := loadReplay(filepath.Join("testdata", "tailnet.json"), filepath.Join("testdata", "latency.json"))
+			if err != nil {
+				t.Fatalf("loadReplay: %v", err)
+			}
+			if tt.exitNode != "" {
+				mp := &ipn.MaskedPrefs{Prefs: ipn.Prefs{ExitNodeID: tt.exitNode}, ExitNodeIDSet: true}
+				if _, err := fake.EditPrefs(ctx, mp); err != nil {
+					t.Fatalf("EditPrefs: %v", err)
+				}
+			}
+
+			out, code := runCLI(t, serveFakeLocalAPI(t, fake).Listener.Addr().String(), tt.args...)
+			if code != tt.wantCode {
+				t.Errorf("exit code = %d, want %d", code, tt.wantCode)
+			}
+			if !strings.Contains(out, tt.wantOut) {
+				t.Errorf("output lacks %q", tt.wantOut)
+			}
+			prefs, _ := fake.GetPrefs(ctx)
+			if prefs.ExitNodeID != tt.want {
+				t.Errorf("exit node = %q, want %q", prefs.ExitNodeID, tt.want)
+			}
+			if t.Failed() {
+				t.Logf("protect-wan %s:\n%s", strings.Join(tt.args, " "), out)
+			}
+		})
+	}
+}
+
+// runCLI runs protect-wan with args against the fake tailscaled at addr,
+// returning its combined output and exit code. The user's config, state
+// and logs stay out of it.
+func runCLI(t *testing.T, addr string, args ...string) (string, int) {
+	t.Helper()
+	home := t.TempDir()
+	cmd := exec.Command(os.Args[0], args...)
+	cmd.Env = append(os.Environ(),
+		cliLocalAPIEnv+"="+addr,
+		"HOME="+home,
+		"XDG_CONFIG_HOME="+filepath.Join(home, "config"),
+		"XDG_STATE_HOME="+filepath.Join(home, "state"),
+		"XDG_CACHE_HOME="+filepath.Join(home, "cache"),
+		"APPDATA="+home,
+		"LOCALAPPDATA="+home,
+	)
+	out, err := cmd.CombinedOutput()
+	var exit *exec.ExitError
+	if errors.As(err, &exit) {
+		return string(out), exit.ExitCode()
+	} else if err != nil {
+		t.Fatalf("failed to run protect-wan: %v", err)
+	}
+	return string(out), 0
+}
+
+func TestCLIConfigInit(t *testing.T) {
+	if testing.Short() {
+		t.Skip("runs the CLI in subprocesses")
+	}
+	fake := newTestClient(t, testTailnet...)
+	path := filepath.Join(t.TempDir(), "new.conf")
+
+	// The explicit --config doesn't exist yet, config init creates it
+	out, code := runCLI(t, serveFakeLocalAPI(t, fake).Listener.Addr().String(), "config", "init", "--config", path)
+	if code != 0 {
+		t.Fatalf("config init exit code = %d, want 0:\n%s", code, out)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("config init did not write %s: %v", path, err)
+	}
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/netip"
+	"slices"
+	"sync"
+	"time"
+
+	"tailscale.com/client/tailscale"
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/ipn"
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/key"
+)
+
+// LocalClient is the part of the tailscaled LocalAPI protect-wan uses.
+// *tailscale.LocalClient implements it; fakeClient stands in for it when
+// running the selection pipeline without tailscaled.
+type LocalClient interface {
+	Status(ctx context.Context) (*ipnstate.Status, error)
+	StatusWithoutPeers(ctx context.Context) (*ipnstate.Status, error)
+	GetPrefs(ctx context.Context) (*ipn.Prefs, error)
+	EditPrefs(ctx context.Context, mp *ipn.MaskedPrefs) (*ipn.Prefs, error)
+	Ping(ctx context.Context, ip netip.Addr, pingtype tailcfg.PingType) (*ipnstate.PingResult, error)
+	SuggestExitNode(ctx context.Context) (apitype.ExitNodeSuggestionResponse, error)
+	ProfileStatus(ctx context.Context) (current ipn.LoginProfile, all []ipn.LoginProfile, err error)
+	SwitchProfile(ctx context.Context, profile ipn.ProfileID) error
+}
+
+// newLocalClient connects to tailscaled. The end-to-end tests replace it to
+// run the CLI against a fake LocalAPI.
+var newLocalClient = func() LocalClient {
+	return &tailscale.LocalClient{}
+}
+
+// fakeClient is an in-memory LocalClient with scriptable peers, latencies
+// and errors. EditPrefs updates the prefs, and Status reports the exit node
+// they select, so a whole check/select/apply cycle can run against it.
+type fakeClient struct {
+	mu         sync.Mutex
+	status     *ipnstate.Status
+	prefs      *ipn.Prefs
+	latency    map[netip.Addr]time.Duration // Pings to peers without an entry time out at once
+	suggestion tailcfg.StableNodeID
+	profiles   []ipn.LoginProfile
+	profile    ipn.ProfileID    // Active profile
+	errs       map[string]error // Method name -> error returned by every call
+}
+
+// newFakeClient creates a fake tailscaled with the given status
+func newFakeClient(status *ipnstate.Status) *fakeClient {
+	if status == nil {
+		status = &ipnstate.Status{}
+	}
+	if status.Peer == nil {
+		status.Peer = make(map[key.NodePublic]*ipnstate.PeerStatus)
+	}
+	return &fakeClient{
+		status:  status,
+		prefs:   &ipn.Prefs{WantRunning: true},
+		latency: make(map[netip.Addr]time.Duration),
+		errs:    make(map[string]error),
+	}
+}
+
+// addPeer adds a peer to the fake tailnet under a fresh node key
+func (f *fakeClient) addPeer(peer *ipnstate.PeerStatus) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if peer.PublicKey.IsZero() {
+		peer.PublicKey = key.NewNode().Public()
+	}
+	f.status.Peer[peer.PublicKey] = peer
+}
+
+// setLatency makes pings to ip answer after d
+func (f *fakeClient) setLatency(ip netip.Addr, d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.latency[ip] = d
+}
+
+// setSuggestion sets the node returned by SuggestExitNode
+func (f *fakeClient) setSuggestion(id tailcfg.StableNodeID) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.suggestion = id
+}
+
+// failWith makes every call to method return err; nil clears it
+func (f *fakeClient) failWith(method string, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err == nil {
+		delete(f.errs, method)
+		return
+	}
+	f.errs[method] = err
+}
+
+func (f *fakeClient) Status(ctx context.Context) (*ipnstate.Status, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.errs["Status"]; err != nil {
+		return nil, err
+	}
+	return f.snapshot(true), nil
+}
+
+func (f *fakeClient) StatusWithoutPeers(ctx context.Context) (*ipnstate.Status, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.errs["StatusWithoutPeers"]; err != nil {
+		return nil, err
+	}
+	return f.snapshot(false), nil
+}
+
+// snapshot copies the status, reflecting the exit node selected by the
+// prefs. Must be called with f.mu held.
+func (f *fakeClient) snapshot(withPeers bool) *ipnstate.Status {
+	st := *f.status
+	st.ExitNodeStatus = nil
+	st.Peer = nil
+	if withPeers {
+		st.Peer = make(map[key.NodePublic]*ipnstate.PeerStatus, len(f.status.Peer))
+	}
+
+	for k, p := range f.status.Peer {
+		peer := *p
+		peer.ExitNode = !f.prefs.ExitNodeID.IsZero() && peer.ID == f.prefs.ExitNodeID
+		if peer.ExitNode {
+			es := &ipnstate.ExitNodeStatus{ID: peer.ID, Online: peer.Online}
+			for _, ip := range peer.TailscaleIPs {
+				es.TailscaleIPs = append(es.TailscaleIPs, netip.PrefixFrom(ip, ip.BitLen()))
+			}
+			st.ExitNodeStatus = es
+		}
+		if withPeers {
+			st.Peer[k] = &peer
+		}
+	}
+	return &st
+}
+
+func (f *fakeClient) GetPrefs(ctx context.Context) (*ipn.Prefs, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.errs["GetPrefs"]; err != nil {
+		return nil, err
+	}
+	return f.prefs.Clone(), nil
+}
+
+func (f *fakeClient) EditPrefs(ctx context.Context, mp *ipn.MaskedPrefs) (*ipn.Prefs, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.errs["EditPrefs"]; err != nil {
+		return nil, err
+	}
+	f.prefs.ApplyEdits(mp)
+	// tailscaled resolves an exit node IP to the peer holding it
+	if f.prefs.ExitNodeIP.IsValid() {
+		for _, p := range f.status.Peer {
+			if slices.Contains(p.TailscaleIPs, f.prefs.ExitNodeIP) {
+				f.prefs.ExitNodeID, f.prefs.ExitNodeIP = p.ID, netip.Addr{}
+				break
+			}
+		}
+	}
+	return f.prefs.Clone(), nil
+}
+
+func (f *fakeClient) Ping(ctx context.Context, ip netip.Addr, pingtype tailcfg.PingType) (*ipnstate.PingResult, error) {
+	f.mu.Lock()
+	err := f.errs["Ping"]
+	d, ok := f.latency[ip]
+	f.mu.Unlock()
+
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, errors.New("timed out")
+	}
+	return &ipnstate.PingResult{IP: ip.String(), NodeIP: ip.String(), LatencySeconds: d.Seconds()}, nil
+}
+
+func (f *fakeClient) SuggestExitNode(ctx context.Context) (apitype.ExitNodeSuggestionResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.errs["SuggestExitNode"]; err != nil {
+		return apitype.ExitNodeSuggestionResponse{}, err
+	}
+	if f.suggestion.IsZero() {
+		return apitype.ExitNodeSuggestionResponse{}, errors.New("no exit node suggestion available")
+	}
+	for _, p := range f.status.Peer {
+		if p.ID == f.suggestion {
+			return apitype.ExitNodeSuggestionResponse{ID: p.ID, Name: p.DNSName}, nil
+		}
+	}
+	return apitype.ExitNodeSuggestionResponse{}, fmt.Errorf("suggested node %s not found", f.suggestion)
+}
+
+func (f *fakeClient) ProfileStatus(ctx context.Context) (ipn.LoginProfile, []ipn.LoginProfile, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.errs["ProfileStatus"]; err != nil {
+		return ipn.LoginProfile{}, nil, err
+	}
+	var current ipn.LoginProfile
+	for _, p := range f.profiles {
+		if p.ID == f.profile {
+			current = p
+		}
+	}
+	return current, slices.Clone(f.profiles), nil
+}
+
+// SwitchProfile makes profile active. The fake has a single tailnet, so
+// the peers stay the same.
+func (f *fakeClient) SwitchProfile(ctx context.Context, profile ipn.ProfileID) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.errs["SwitchProfile"]; err != nil {
+		return err
+	}
+	if !slices.ContainsFunc(f.profiles, func(p ipn.LoginProfile) bool { return p.ID == profile }) {
+		return fmt.Errorf("profile %s not found", profile)
+	}
+	f.profile = profile
+	return nil
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"net/netip"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"tailscale.com/ipn"
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/tailcfg"
+)
+
+// testPeer is a Mullvad exit node of the fake tailnet
+type testPeer struct {
+	id       tailcfg.StableNodeID
+	host     string // First label of the hostname, e.g. ch-zrh-wg-001
+	country  string // Country code
+	city     string // City code
+	priority int
+	offline  bool
+	latency  time.Duration // 0 for a node that doesn't answer pings
+	lastSeen time.Duration // How long ago control last saw it online, 0 for unknown
+}
+
+// newTestClient returns a fake tailscaled with the peers as Mullvad exit
+// nodes, and a state file of the test's own
+func newTestClient(t *testing.T, peers ...testPeer) *fakeClient {
+	t.Helper()
+	withFlag(t, "state", filepath.Join(t.TempDir(), "state.json"))
+	withFlag(t, "other-vpn", "off")
+
+	fake := newFakeClient(&ipnstate.Status{BackendState: "Running"})
+	for i, p := range peers {
+		ip := netip.AddrFrom4([4]byte{100, 100, 0, byte(i + 1)})
+		var lastSeen time.Time
+		if p.lastSeen > 0 {
+			lastSeen = time.Now().Add(-p.lastSeen)
+		}
+		fake.addPeer(&ipnstate.PeerStatus{
+			ID:             p.id,
+			LastSeen:       lastSeen,
+			DNSName:        p.host + "." + mullvadDomain + ".",
+			TailscaleIPs:   []netip.Addr{ip},
+			Online:         !p.offline,
+			ExitNodeOption: true,
+			Location: &tailcfg.Location{
+				Country:     p.country,
+				CountryCode: p.country,
+				City:        p.city,
+				CityCode:    p.city,
+				Priority:    p.priority,
+			},
+		})
+		if p.latency > 0 {
+			fake.setLatency(ip, p.latency)
+		}
+	}
+	return fake
+}
+
+// withFlag sets a flag for the duration of the test
+func withFlag(t *testing.T, name, value string) {
+	t.Helper()
+	f := flag.Lookup(name)
+	if f == nil {
+		t.Fatalf("no flag --%s", name)
+	}
+	old := f.Value.String()
+	if err := replaceFlag(name, value); err != nil {
+		t.Fatalf("failed to set --%s: %v", name, err)
+	}
+	t.Cleanup(func() { replaceFlag(name, old) })
+}
+
+// testTailnet is the fake tailnet most tests share: two Swiss, two German
+// and a Swedish node, with one German node offline. ch1 has been online
+// for a month, so its LastSeen is old.
+var testTailnet = []testPeer{
+	{id: "ch1", host: "ch-zrh-wg-001", country: "CH", city: "zrh", priority: 1, latency: 25 * time.Millisecond, lastSeen: 30 * 24 * time.Hour},
+	{id: "ch2", host: "ch-zrh-wg-002", country: "CH", city: "zrh", priority: 2, latency: 22 * time.Millisecond},
+	{id: "de1", host: "de-fra-wg-001", country: "DE", city: "fra", priority: 3, latency: 18 * time.Millisecond},
+	{id: "de2", host: "de-fra-wg-002", country: "DE", city: "fra", priority: 4, offline: true},
+	{id: "se1", host: "se-sto-wg-001", country: "SE", city: "sto", priority: 5, latency: 40 * time.Millisecond},
+}
+
+func TestFakeClientEditPrefs(t *testing.T) {
+	ctx := context.Background()
+	fake := newTestClient(t, testTailnet...)
+
+	tests := []struct {
+		name string
+		mp   ipn.MaskedPrefs
+		want tailcfg.StableNodeID
+	}{
+		{"by id", ipn.MaskedPrefs{Prefs: ipn.Prefs{ExitNodeID: "de1"}, ExitNodeIDSet: true}, "de1"},
+		{"by ip", ipn.MaskedPrefs{Prefs: ipn.Prefs{ExitNodeIP: netip.MustParseAddr("100.100.0.2")}, ExitNodeIPSet: true}, "ch2"},
+		{"cleared", ipn.MaskedPrefs{ExitNodeIDSet: true}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := fake.EditPrefs(ctx, &tt.mp); err != nil {
+				t.Fatalf("EditPrefs: %v", err)
+			}
+			status, err := fake.Status(ctx)
+			if err != nil {
+				t.Fatalf("Status: %v", err)
+			}
+			var got tailcfg.StableNodeID
+			if status.ExitNodeStatus != nil {
+				got = status.ExitNodeStatus.ID
+			}
+			if got != tt.want {
+				t.Errorf("exit node = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFakeClientFailWith(t *testing.T) {
+	ctx := context.Background()
+	fake := newTestClient(t, testTailnet...)
+	errDown := errors.New("tailscaled is down")
+
+	fake.failWith("Status", errDown)
+	if _, err := getMullvadNodes(ctx, fake); !errors.Is(err, errDown) {
+		t.Errorf("getMullvadNodes error = %v, want %v", err, errDown)
+	}
+	fake.failWith("Status", nil)
+	if nodes, err := getMullvadNodes(ctx, fake); err != nil || len(nodes) != len(testTailnet) {
+		t.Errorf("getMullvadNodes = %d nodes, %v; want %d nodes", len(nodes), err, len(testTailnet))
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// command is a subcommand, invoked as `protect-wan <name> [args] [flags]`
+type command struct {
+	Usage string
+	Run   func(ctx context.Context, lc LocalClient, args []string) error
+}
+
+// commands holds all subcommands by name
+var commands = map[string]command{
+	"best": {
+		Usage: "Run the full selection and print the node it would choose, without applying it",
+		Run:   runBest,
+	},
+}
+
+// flagCommands are command spellings of the original mode flags, e.g.
+// `protect-wan list` is the same as `protect-wan --list`
+var flagCommands = map[string]string{
+	"check":   "Only check current exit node status and exit",
+	"list":    "List all available Mullvad exit nodes",
+	"auto":    "Auto-select and set the best Mullvad exit node",
+	"disable": "Disable exit node",
+	"set":     "Set specific exit node: set <hostname|ID|IP|city|country>",
+}
+
+func init() {
+	flag.Usage = usage
+}
+
+// usage prints the commands followed by the flag defaults
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "Usage: %s [command] [flags]\n\nCommands:\n", os.Args[0])
+
+	var lines []string
+	for name, cmd := range commands {
+		lines = append(lines, fmt.Sprintf("  %-16s %s", name, cmd.Usage))
+	}
+	for name, desc := range flagCommands {
+		lines = append(lines, fmt.Sprintf("  %-16s %s", name, desc))
+	}
+	sort.Strings(lines)
+	fmt.Fprintln(out, strings.Join(lines, "\n"))
+
+	fmt.Fprintf(out, "\nWithout a command, checks protection and auto-selects a node if needed.\n\nFlags:\n")
+	flag.PrintDefaults()
+}
+
+// parseCommandLine parses the flags, which may appear both before and
+// after the command name and its arguments.
+// Returns the command name ("" for none) and its positional arguments.
+func parseCommandLine() (string, []string) {
+	defer flag.Visit(func(f *flag.Flag) {
+		commandLineFlags[f.Name] = true
+	})
+
+	flag.Parse()
+	if flag.NArg() == 0 {
+		return "", nil
+	}
+
+	name := flag.Arg(0)
+	rest := flag.Args()[1:]
+
+	var args []string
+	for {
+		// ExitOnError: a bad flag prints usage and exits
+		_ = flag.CommandLine.Parse(rest)
+		if flag.NArg() == 0 {
+			break
+		}
+		args = append(args, flag.Arg(0))
+		rest = flag.Args()[1:]
+	}
+
+	return name, args
+}
+
+// commandLineFlags are the flags set on the command line, as opposed to
+// the config file
+var commandLineFlags = make(map[string]bool)
+
+// applyFlagCommand turns a flag command into its mode flag
+func applyFlagCommand(name string, args []string) error {
+	if _, ok := flagCommands[name]; !ok {
+		return fmt.Errorf("unknown command %q (see --help)", name)
+	}
+
+	value := "true"
+	if name == "set" {
+		if len(args) != 1 {
+			return fmt.Errorf("usage: %s set <hostname|ID|IP|city|country>", os.Args[0])
+		}
+		value = args[0]
+	} else if len(args) > 0 {
+		return fmt.Errorf("%s takes no arguments", name)
+	}
+
+	return flag.Set(name, value)
+}
+
+// runBest computes the best node and runner-ups without editing prefs
+func runBest(ctx context.Context, lc LocalClient, args []string) error {
+	candidates, err := rankCandidates(ctx, lc)
+	if err != nil {
+		return err
+	}
+	best := candidates[0]
+	name := displayName(best)
+
+	fmt.Printf("\nBest Mullvad exit node: %s (%s, %s)", name, best.City, best.CountryCode)
+	if best.Latency > 0 {
+		fmt.Printf(" - Latency: %dms", best.Latency.Milliseconds())
+	}
+	fmt.Println()
+	if selectionSeed != 0 {
+		fmt.Printf("Random seed: %d (repeat with --seed %d)\n", selectionSeed, selectionSeed)
+	}
+
+	if len(candidates) > 1 {
+		n := *topFlag
+		if n == 0 {
+			n = 5
+		}
+		printTopCandidates(candidates, n, "best")
+	}
+
+	if *compareSuggestFlag {
+		fmt.Println()
+		compareWithSuggestion(ctx, lc, candidates)
+	}
+
+	fmt.Printf("\nTo use it: %s --set %s\n", os.Args[0], name)
+	return nil
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// The config file mirrors the command line: every line is `flag = value`
+// using the long flag name, `#` starts a comment, and list flags may be
+// repeated. Flags given on the command line override the file.
+//
+//	# ~/.config/protect-wan/config
+//	country = CH
+//	trusted = 192.168.1.0/24
+//	trusted = home.lan
+
+var configFlag = flag.String("config", "", "Path to config file (default: <user config dir>/protect-wan/config)")
+
+// stringList is a flag.Value collecting repeated or comma-separated values.
+// A value written as /regex/ keeps its commas, e.g. /wg-0{1,2}/.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	parts := strings.Split(value, ",")
+	for i := 0; i < len(parts); i++ {
+		v := strings.TrimSpace(parts[i])
+		if strings.HasPrefix(v, "/") {
+			// Rejoin the commas inside the regex, up to its closing slash
+			for (len(v) < 2 || !strings.HasSuffix(v, "/")) && i+1 < len(parts) {
+				i++
+				v = strings.TrimSpace(v + "," + parts[i])
+			}
+		}
+		if v != "" {
+			*l = append(*l, v)
+		}
+	}
+	return nil
+}
+
+// flagOrEnv returns the value of a secret flag, or else of the environment
+// variable it falls back to. Scheduled runs pass secrets that way, off their
+// command line (see installSecretFlags).
+func flagOrEnv(value, env string) string {
+	if value == "" {
+		return os.Getenv(env)
+	}
+	return value
+}
+
+// defaultConfigPath returns the per-user config file location
+func defaultConfigPath() string {
+	dir, err := configDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "config")
+}
+
+// configSet are the flags the config file and the admin config set. A
+// reload resets them to their defaults before reading the files again, so
+// list settings aren't doubled and removed settings don't linger.
+var configSet = make(map[string]bool)
+
+// resetConfigFlags returns the flags set by the config files to their
+// defaults
+func resetConfigFlags() error {
+	for name := range configSet {
+		if commandLineFlags[name] {
+			continue
+		}
+		if err := replaceFlag(name, flag.Lookup(name).DefValue); err != nil {
+			return fmt.Errorf("failed to reset %s: %w", name, err)
+		}
+	}
+	clear(configSet)
+	return nil
+}
+
+// configFilePath returns the config file in use: --config, or the
+// default location
+func configFilePath() string {
+	if *configFlag != "" {
+		return *configFlag
+	}
+	return defaultConfigPath()
+}
+
+// loadConfig applies the config file to all flags not set on the command line.
+// A missing default config file is not an error.
+func loadConfig() error {
+	if err := resetConfigFlags(); err != nil {
+		return err
+	}
+	path := *configFlag
+	explicit := path != ""
+	if !explicit {
+		path = defaultConfigPath()
+		if path == "" {
+			return nil
+		}
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		if !explicit && errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return fmt.Errorf("failed to open config: %w", err)
+	}
+	defer f.Close()
+
+	err = readConfigLines(f, path, func(where, name, value string) error {
+		if name == "config" || flag.Lookup(name) == nil {
+			return fmt.Errorf("%s: unknown setting %q", where, name)
+		}
+		if name == "override-policy" {
+			return fmt.Errorf("%s: override-policy is only accepted on the command line", where)
+		}
+		// Command line flags win over the config file
+		if commandLineFlags[name] {
+			return nil
+		}
+		// List flags may be repeated, the first line replaces the value
+		set := flag.Set
+		if !configSet[name] {
+			set = replaceFlag
+			configSet[name] = true
+		}
+		if err := set(name, value); err != nil {
+			return fmt.Errorf("%s: invalid value for %s: %w", where, name, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if *verboseFlag {
+		fmt.Printf("Loaded config from %s\n", path)
+	}
+
+	return nil
+}
+
+// readConfigLines calls fn with each `name = value` line of a config file,
+// where being path:line for errors
+func readConfigLines(r io.Reader, path string, fn func(where, name, value string) error) error {
+	scanner := bufio.NewScanner(r)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		where := fmt.Sprintf("%s:%d", path, lineNum)
+		name, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return fmt.Errorf("%s: expected `name = value`", where)
+		}
+		name = strings.TrimPrefix(strings.TrimSpace(name), "--")
+		value = strings.Trim(strings.TrimSpace(value), `"`)
+		if err := fn(where, name, value); err != nil {
+			return err
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read config: %w", err)
+	}
+	return nil
+}
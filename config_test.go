@@ -0,0 +1,53 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestLoadConfigReload(t *testing.T) {
+	path := writeTestFile(t, "country = CH\nsmtp-to = a@example.com\nsmtp-to = b@example.com\n")
+	withFlag(t, "config", path)
+	t.Cleanup(func() { resetConfigFlags() })
+
+	for range 2 {
+		if err := loadConfig(); err != nil {
+			t.Fatalf("loadConfig: %v", err)
+		}
+	}
+	if *countryFlag != "CH" || smtpToFlag.String() != "a@example.com,b@example.com" {
+		t.Errorf("after two loads: country = %q, smtp-to = %q; want CH and both addresses once", *countryFlag, smtpToFlag.String())
+	}
+
+	// A setting removed from the file is back to its default
+	if err := os.WriteFile(path, []byte("smtp-to = c@example.com\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := loadConfig(); err != nil {
+		t.Fatalf("loadConfig: %v", err)
+	}
+	if *countryFlag != "" || smtpToFlag.String() != "c@example.com" {
+		t.Errorf("after the edit: country = %q, smtp-to = %q; want none and c@example.com", *countryFlag, smtpToFlag.String())
+	}
+}
+
+func TestStringListSet(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{"us-*", "us-*"},
+		{"us-*, de-*", "us-*|de-*"},
+		{"/wg-0{1,2}/", "/wg-0{1,2}/"},
+		{"us-*,/wg-0{1,2}/, de-*", "us-*|/wg-0{1,2}/|de-*"},
+		{"/a,b", "/a,b"}, // Unclosed, left for the regex to reject
+	}
+	for _, tt := range tests {
+		var l stringList
+		l.Set(tt.value)
+		if got := strings.Join(l, "|"); got != tt.want {
+			t.Errorf("Set(%q) = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+func init() {
+	commands["config"] = command{
+		Usage: "Manage the config file: config init writes a commented default config",
+		Run:   runConfig,
+	}
+}
+
+// configSkipFlags are one-shot modes that make no sense in a config file
+var configSkipFlags = map[string]bool{
+	"config":          true,
+	"check":           true,
+	"list":            true,
+	"auto":            true,
+	"disable":         true,
+	"set":             true,
+	"portal-bypass":   true,
+	"override-policy": true,
+}
+
+// runConfig dispatches config subcommands
+func runConfig(ctx context.Context, lc LocalClient, args []string) error {
+	if len(args) != 1 || args[0] != "init" {
+		return fmt.Errorf("usage: %s config init [--config <path>]", os.Args[0])
+	}
+	return configInit(ctx, lc)
+}
+
+// isConfigInit reports whether the command line runs config init
+func isConfigInit(cmdName string, cmdArgs []string) bool {
+	return cmdName == "config" && len(cmdArgs) == 1 && cmdArgs[0] == "init"
+}
+
+// configInit writes a commented default config file, pre-populated with
+// values detected from the current tailnet and network
+func configInit(ctx context.Context, lc LocalClient) error {
+	path := *configFlag
+	if path == "" {
+		path = defaultConfigPath()
+		if path == "" {
+			return errors.New("cannot determine the user config directory, pass --config <path>")
+		}
+	}
+
+	if _, err := os.Stat(path); err == nil {
+		return fmt.Errorf("%s already exists, remove it first or pass --config <path>", path)
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	detected := detectConfigDefaults(ctx, lc)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "# protect-wan config\n")
+	fmt.Fprintf(&b, "#\n")
+	fmt.Fprintf(&b, "# One `flag = value` per line, using the long flag names from --help.\n")
+	fmt.Fprintf(&b, "# Repeat a line for repeatable flags. Command line flags take precedence.\n")
+	fmt.Fprintf(&b, "# Uncomment a line to change the default.\n")
+
+	flag.VisitAll(func(f *flag.Flag) {
+		if configSkipFlags[f.Name] {
+			return
+		}
+		fmt.Fprintf(&b, "\n# %s\n", f.Usage)
+		if hint, ok := detected[f.Name]; ok {
+			fmt.Fprintf(&b, "# Detected: %s\n", hint.comment)
+			for _, v := range hint.values {
+				fmt.Fprintf(&b, "# %s = %s\n", f.Name, v)
+			}
+			return
+		}
+		fmt.Fprintf(&b, "# %s = %s\n", f.Name, f.DefValue)
+	})
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("failed to create config directory: %w", err)
+	}
+	if err := os.WriteFile(path, []byte(b.String()), 0o644); err != nil {
+		return fmt.Errorf("failed to write config: %w", err)
+	}
+
+	fmt.Printf("Config written to %s\n", path)
+	return nil
+}
+
+// configHint is a detected value suggested in the generated config
+type configHint struct {
+	comment string
+	values  []string
+}
+
+// detectConfigDefaults suggests values for the generated config: the
+// nearest countries by latency and the current network for --trusted.
+// Detection is best-effort; anything that fails is left out.
+func detectConfigDefaults(ctx context.Context, lc LocalClient) map[string]configHint {
+	hints := make(map[string]configHint)
+
+	if nodes, err := getMullvadNodes(ctx, lc); err == nil {
+		var online []MullvadNode
+		for _, node := range nodes {
+			if node.Online {
+				online = append(online, node)
+			}
+		}
+		if p, err := newProber(lc); err == nil && len(online) > 0 {
+			fmt.Println("Measuring latency to find the nearest countries...")
+			ranked := testCountryRepresentatives(ctx, p, groupByCountry(online))
+			var nearest []string
+			for _, g := range ranked[:min(3, len(ranked))] {
+				nearest = append(nearest, fmt.Sprintf("%s %dms", g.CountryCode, g.Latency.Milliseconds()))
+			}
+			if len(ranked) > 0 {
+				hints["country"] = configHint{
+					comment: "nearest countries: " + strings.Join(nearest, ", "),
+					values:  []string{ranked[0].CountryCode},
+				}
+			}
+		}
+	}
+
+	id := currentNetwork()
+	var trusted []string
+	if id.GatewayMAC != nil {
+		trusted = append(trusted, id.GatewayMAC.String())
+	}
+	for _, addr := range id.Addrs {
+		if addr.Addr().Is4() {
+			trusted = append(trusted, addr.Masked().String())
+		}
+	}
+	if len(trusted) > 0 {
+		hints["trusted"] = configHint{
+			comment: "current network (gateway MAC, local subnets); uncomment on your home network",
+			values:  trusted,
+		}
+	}
+
+	return hints
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// controlRefresh is how often the status shown by the tray and D-Bus is
+// brought up to date
+const controlRefresh = 10 * time.Second
+
+// controlStatus is what the tray and D-Bus show of the daemon
+type controlStatus struct {
+	Protected bool
+	Node      MullvadNode // Active exit node, zero if none
+	Paused    *pause      // Nil unless paused
+	Country   string      // Country code picked in the tray or over D-Bus, "" for automatic
+	Countries []countryEntry
+}
+
+// countryEntry is a country that can be picked
+type countryEntry struct {
+	Code, Name string
+}
+
+// control exposes a daemon running in the same process to the tray and
+// D-Bus: a status refreshed every controlRefresh, and the actions they
+// offer. The actions return at once: the work runs on the daemon loop, so
+// a UI stays responsive while a new exit node is selected.
+type control struct {
+	ctx context.Context
+	lc  LocalClient
+	d   *daemon
+
+	mu     sync.Mutex
+	status controlStatus
+	subs   []chan struct{}
+}
+
+func newControl(ctx context.Context, lc LocalClient, d *daemon) *control {
+	c := &control{ctx: ctx, lc: lc, d: d}
+	c.status.Country = *countryFlag
+	return c
+}
+
+// subscribe returns a channel signalled whenever the status was refreshed
+func (c *control) subscribe() <-chan struct{} {
+	ch := make(chan struct{}, 1)
+	c.mu.Lock()
+	c.subs = append(c.subs, ch)
+	c.mu.Unlock()
+	return ch
+}
+
+// poll refreshes the status every controlRefresh until ctx is done
+func (c *control) poll() {
+	ticker := time.NewTicker(controlRefresh)
+	defer ticker.Stop()
+	for {
+		c.refresh()
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// refresh reads the current status and signals the subscribers
+func (c *control) refresh() {
+	node, ok, err := currentExitNode(c.ctx, c.lc)
+	if err != nil && c.ctx.Err() == nil {
+		log.Printf("Error checking exit node: %v", err)
+	}
+
+	countries, _ := onlineCountries(c.ctx, c.lc)
+
+	c.mu.Lock()
+	c.status.Protected = ok && node.Online
+	c.status.Node = node
+	c.status.Paused = currentPause()
+	if countries != nil {
+		c.status.Countries = countries
+	}
+	subs := c.subs
+	c.mu.Unlock()
+
+	for _, ch := range subs {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// current returns a copy of the status
+func (c *control) current() controlStatus {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.status
+}
+
+// onlineCountries returns the countries with online Mullvad nodes, by name
+func onlineCountries(ctx context.Context, lc LocalClient) ([]countryEntry, error) {
+	nodes, err := getMullvadNodes(ctx, lc)
+	if err != nil {
+		return nil, err
+	}
+	var countries []countryEntry
+	seen := make(map[string]bool)
+	for _, n := range nodes {
+		if n.Online && !seen[n.CountryCode] {
+			seen[n.CountryCode] = true
+			countries = append(countries, countryEntry{Code: n.CountryCode, Name: n.Country})
+		}
+	}
+	sort.Slice(countries, func(i, j int) bool { return countries[i].Name < countries[j].Name })
+	return countries, nil
+}
+
+// pickCountry selects the best exit node in a country, and keeps the
+// daemon to that country. An empty code goes back to any country.
+func (c *control) pickCountry(code string) {
+	c.mu.Lock()
+	c.status.Country = code
+	c.mu.Unlock()
+
+	go c.d.do(func(ctx context.Context) {
+		*countryFlag = code
+		c.d.pinned = ""
+		if _, err := clearPause(); err != nil {
+			log.Printf("Error resuming protection: %v", err)
+		}
+
+		// A pick is a user's choice, which churn and hysteresis must not hold up
+		autoSwitching = false
+		selectionSource = sourceTray
+		if err := autoSelectMullvad(ctx, c.d.lc); err != nil {
+			log.Printf("Error selecting exit node: %v", err)
+		}
+		autoSwitching = true
+		c.refresh()
+	})
+}
+
+// pause stops the daemon enforcing an exit node for d, or until resumed
+// if d is 0
+func (c *control) pause(d time.Duration) {
+	go func() {
+		if _, err := setPause(d); err != nil {
+			log.Printf("Error pausing protection: %v", err)
+		}
+		c.refresh()
+	}()
+}
+
+// resume ends a pause and re-checks protection right away
+func (c *control) resume() {
+	go c.d.do(func(ctx context.Context) {
+		if _, err := clearPause(); err != nil {
+			log.Printf("Error resuming protection: %v", err)
+		}
+		c.d.check(ctx, false)
+		c.refresh()
+	})
+}
+
+// disable turns the exit node off. Protection is paused as well, or the
+// daemon would turn it back on at the next check.
+func (c *control) disable() {
+	go c.d.do(func(ctx context.Context) {
+		c.d.pinned = ""
+		if _, err := setPause(0); err != nil {
+			log.Printf("Error pausing protection: %v", err)
+		} else if err := clearExitNode(withAuditReason(ctx, "disabled from the tray or D-Bus"), c.d.lc); err != nil {
+			log.Printf("Error disabling exit node: %v", err)
+		}
+		c.refresh()
+	})
+}
@@ -0,0 +1,435 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+var controlSocketFlag = flag.String("control-socket", "", "Unix socket of the daemon's commands, also used to hand set, auto and disable to it (default: daemon.sock next to the state file, off to disable)")
+
+func init() {
+	commands["daemon"] = command{
+		Usage: "Talk to the running daemon: daemon status|stop|reload",
+		Run:   runDaemonCommand,
+	}
+}
+
+// controlSocketTimeout bounds a control request, including a reload's
+// wait for the daemon loop to finish a check in progress
+const controlSocketTimeout = 2 * time.Minute
+
+// controlRequest is one line sent to the control socket
+type controlRequest struct {
+	Command  string `json:"command"`                   // status, stop, reload, set, auto or disable
+	Arg      string `json:"arg,omitempty"`             // Node or place for set
+	Override bool   `json:"override_policy,omitempty"` // --override-policy given with set or auto
+
+	Flags map[string]string `json:"flags,omitempty"` // delegatedFlags given with set or auto
+}
+
+// delegatedFlags are the selection flags a one-shot set or auto hands to
+// the daemon with the request. The daemon selects with them for that
+// request only, and keeps the node it applies.
+var delegatedFlags = []string{
+	"country", "region", "include-node", "exclude-node", "strategy", "prefer", "prefer-attr",
+	"prefer-priority", "prefer-direct", "prefer-ipv6", "require", "require-direct", "require-ipv6",
+	"same-country-as-me", "different-country-than-me", "home-country", "location", "distance-weight",
+	"max-latency", "good-enough", "samples", "ping-type", "scorer-latency", "trial", "probe-service",
+}
+
+// undelegatedFlags can't be handed to the daemon: they would replace its
+// guardrails, or run a command as its user
+var undelegatedFlags = []string{"forbid-country", "allow-country", "scorer"}
+
+// controlResponse is the daemon's one line answer
+type controlResponse struct {
+	Error  string        `json:"error,omitempty"`
+	Status *daemonStatus `json:"status,omitempty"`
+	Node   string        `json:"node,omitempty"` // Exit node after set or auto
+}
+
+// daemonStatus is what daemon status reports
+type daemonStatus struct {
+	PID         int           `json:"pid"`
+	Started     time.Time     `json:"started"`
+	Interval    time.Duration `json:"interval"`
+	MonitorOnly bool          `json:"monitor_only,omitempty"`
+	Policy      string        `json:"policy,omitempty"`      // --policy file
+	PolicyRule  string        `json:"policy_rule,omitempty"` // Rule in effect
+	Enforce     string        `json:"enforce,omitempty"`     // on or off while a rule sets enforce
+	Pause       *pause        `json:"pause,omitempty"`
+	LastCheck   *checkResult  `json:"last_check,omitempty"`
+}
+
+// controlSocketPath returns the control socket location, "" if disabled
+func controlSocketPath() string {
+	switch *controlSocketFlag {
+	case "off":
+		return ""
+	case "":
+		if path := statePath(); path != "" {
+			return filepath.Join(filepath.Dir(path), "daemon.sock")
+		}
+		return ""
+	}
+	return *controlSocketFlag
+}
+
+// serveControlSocket answers daemon commands, and the set, auto and disable
+// requests one-shot runs hand to the daemon, on the control socket until
+// the returned func closes it. The socket is only
+// accessible to the daemon's user (and root).
+func serveControlSocket(d *daemon) func() {
+	noop := func() {}
+	path := controlSocketPath()
+	if path == "" {
+		return noop
+	}
+	if conn, err := net.Dial("unix", path); err == nil {
+		conn.Close()
+		log.Printf("Warning: another daemon answers on %s, control socket disabled", path)
+		return noop
+	}
+	if fi, err := os.Lstat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		os.Remove(path) // Left behind by a daemon that didn't exit cleanly
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		log.Printf("Error creating control socket directory: %v", err)
+		return noop
+	}
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		log.Printf("Error listening on control socket: %v", err)
+		return noop
+	}
+	if err := os.Chmod(path, 0o600); err != nil {
+		log.Printf("Warning: failed to restrict control socket: %v", err)
+	}
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go d.serveControl(conn)
+		}
+	}()
+	return func() { l.Close() }
+}
+
+// serveControl handles one control connection
+func (d *daemon) serveControl(conn net.Conn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(controlSocketTimeout))
+
+	var req controlRequest
+	var resp controlResponse
+	if err := json.NewDecoder(conn).Decode(&req); err != nil {
+		resp.Error = fmt.Sprintf("invalid request: %v", err)
+	} else {
+		switch req.Command {
+		case "status":
+			resp.Status = d.status()
+		case "stop":
+			d.stop()
+		case "reload":
+			reply := make(chan error, 1)
+			select {
+			case d.reloads <- reply:
+				if err := <-reply; err != nil {
+					resp.Error = err.Error()
+				}
+			case <-d.done:
+				resp.Error = "daemon is stopping"
+			}
+		case "set", "auto", "disable":
+			node, err := d.delegated(req.Command, req.Arg, req.Override, req.Flags)
+			if err != nil {
+				resp.Error = err.Error()
+			}
+			resp.Node = node
+		default:
+			resp.Error = fmt.Sprintf("unknown command %q", req.Command)
+		}
+	}
+	json.NewEncoder(conn).Encode(resp)
+}
+
+// delegated runs a set, auto or disable handed over by a one-shot run on
+// the daemon loop, and returns the exit node it leaves active. The daemon
+// keeps a node that was set, rather than select another at the next
+// network change, until the node stops working.
+func (d *daemon) delegated(command, arg string, override bool, flags map[string]string) (string, error) {
+	if *monitorOnlyFlag {
+		return "", errMonitorOnly
+	}
+	var node string
+	err := d.call(func(ctx context.Context) error {
+		ctx = withAuditReason(ctx, command+" through the daemon")
+		if override && countriesLocked() {
+			return errOverrideLocked()
+		}
+		restore, err := applyDelegatedFlags(flags)
+		defer restore()
+		if err != nil {
+			return err
+		}
+		if override {
+			ctx = withPolicyOverride(ctx, "")
+		}
+		d.pinned, d.overridden = "", ""
+		if command == "disable" {
+			// Paused too, or the next check would turn it back on
+			if _, err := setPause(0); err != nil {
+				return err
+			}
+			if err := clearExitNode(ctx, d.lc); err != nil {
+				return err
+			}
+			d.result("exit node disabled, protection paused", nil)
+			return nil
+		}
+
+		if _, err := clearPause(); err != nil {
+			return err
+		}
+		// A user's choice, which churn and hysteresis must not hold up
+		autoSwitching = false
+		defer func() { autoSwitching = true }()
+		if command == "set" {
+			selectionSource = sourceManual
+			err = setExitNodeByName(ctx, d.lc, arg)
+		} else {
+			selectionSource = sourceAuto
+			err = autoSelectMullvad(ctx, d.lc)
+		}
+		if err != nil {
+			d.result(command+" failed", err)
+			return err
+		}
+
+		active, _, _ := currentExitNode(ctx, d.lc)
+		// The daemon's own selection flags would replace a node chosen
+		// with others at the next check
+		if command == "set" || len(flags) > 0 {
+			d.pinned = active.ID
+			if override {
+				d.overridden = active.ID
+			}
+		}
+		node = displayName(active)
+		d.failing = ""
+		d.result("exit node "+node+" applied by "+command, nil)
+		return nil
+	})
+	return node, err
+}
+
+// applyDelegatedFlags sets the flags handed over with a request, and
+// returns the func restoring the daemon's own values
+func applyDelegatedFlags(flags map[string]string) (func(), error) {
+	saved := make(map[string]string)
+	restore := func() {
+		for name, value := range saved {
+			if err := replaceFlag(name, value); err != nil {
+				log.Printf("Error restoring --%s: %v", name, err)
+			}
+		}
+	}
+	for name, value := range flags {
+		if !slices.Contains(delegatedFlags, name) {
+			return restore, fmt.Errorf("--%s can't be handed to the daemon", name)
+		}
+		if locked, ok := adminLocked[name]; ok && locked != value {
+			return restore, fmt.Errorf("--%s is locked to %q by the admin config", name, locked)
+		}
+		saved[name] = flag.Lookup(name).Value.String()
+		if err := replaceFlag(name, value); err != nil {
+			return restore, fmt.Errorf("invalid value for --%s: %w", name, err)
+		}
+	}
+	return restore, nil
+}
+
+// status reports the daemon's state for daemon status
+func (d *daemon) status() *daemonStatus {
+	d.mu.Lock()
+	s := d.snapshot
+	d.mu.Unlock()
+	s.PID, s.Started, s.Pause = os.Getpid(), d.started, currentPause()
+	return &s
+}
+
+// sendControl sends a command to the running daemon
+func sendControl(command string) (*controlResponse, error) {
+	return sendControlRequest(controlRequest{Command: command})
+}
+
+// sendControlRequest sends a request to the running daemon
+func sendControlRequest(req controlRequest) (*controlResponse, error) {
+	path := controlSocketPath()
+	if path == "" {
+		return nil, errors.New("the control socket is disabled (--control-socket off)")
+	}
+	conn, err := net.DialTimeout("unix", path, 5*time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("no daemon answers on %s (is it running, as this user?): %w", path, err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(controlSocketTimeout))
+
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		return nil, fmt.Errorf("failed to send %s: %w", req.Command, err)
+	}
+	var resp controlResponse
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		return nil, fmt.Errorf("failed to read the daemon's answer: %w", err)
+	}
+	if resp.Error != "" {
+		return nil, errors.New(resp.Error)
+	}
+	return &resp, nil
+}
+
+// daemonRunning reports whether a daemon answers on the control socket
+func daemonRunning() bool {
+	path := controlSocketPath()
+	if path == "" {
+		return false
+	}
+	conn, err := net.DialTimeout("unix", path, time.Second)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
+
+// delegateToDaemon hands --set, --auto or --disable to a running daemon,
+// so it updates the daemon's choice instead of the daemon undoing it at
+// its next check. The selection flags on the command line go along.
+// Returns false if no daemon is running, or it is a --monitor-only daemon,
+// which leaves the prefs to the one-shot run.
+func delegateToDaemon() (bool, error) {
+	status, err := sendControl("status")
+	if err != nil || status.Status == nil || status.Status.MonitorOnly {
+		return false, nil
+	}
+	req := controlRequest{Command: "auto", Override: *overridePolicyFlag}
+	switch {
+	case *disableFlag:
+		req = controlRequest{Command: "disable"}
+	case *setFlag != "":
+		req.Command, req.Arg = "set", *setFlag
+	}
+	if req.Command != "disable" {
+		for name := range commandLineFlags {
+			if slices.Contains(undelegatedFlags, name) {
+				return true, fmt.Errorf("--%s can't be handed to the running daemon; set it in its config and run daemon reload, or stop the daemon", name)
+			}
+			if slices.Contains(delegatedFlags, name) {
+				if req.Flags == nil {
+					req.Flags = make(map[string]string)
+				}
+				req.Flags[name] = flag.Lookup(name).Value.String()
+			}
+		}
+	}
+
+	resp, err := sendControlRequest(req)
+	if err != nil {
+		return true, fmt.Errorf("the running daemon failed to %s: %w", req.Command, err)
+	}
+	switch req.Command {
+	case "disable":
+		fmt.Println("Exit node disabled by the running daemon, protection paused until resume")
+	case "set":
+		fmt.Printf("Exit node set to %s by the running daemon, kept while it works\n", resp.Node)
+	default:
+		if len(req.Flags) > 0 {
+			fmt.Printf("Exit node %s selected by the running daemon, kept while it works\n", resp.Node)
+		} else {
+			fmt.Printf("Exit node %s selected by the running daemon\n", resp.Node)
+		}
+	}
+	return true, nil
+}
+
+// runDaemonCommand implements daemon status|stop|reload
+func runDaemonCommand(ctx context.Context, lc LocalClient, args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: daemon status|stop|reload (start the daemon with --daemon)")
+	}
+	switch args[0] {
+	case "status":
+		resp, err := sendControl("status")
+		if err != nil {
+			return err
+		}
+		printDaemonStatus(resp.Status)
+	case "stop":
+		if _, err := sendControl("stop"); err != nil {
+			return err
+		}
+		fmt.Println("Daemon stopping")
+	case "reload":
+		if _, err := sendControl("reload"); err != nil {
+			return fmt.Errorf("reload failed: %w", err)
+		}
+		fmt.Println("Configuration reloaded")
+	default:
+		return fmt.Errorf("unknown daemon command %q (expected status, stop or reload)", args[0])
+	}
+	return nil
+}
+
+// printDaemonStatus prints a daemon status for people
+func printDaemonStatus(s *daemonStatus) {
+	if s == nil {
+		return
+	}
+	mode := "enforcing"
+	if s.MonitorOnly {
+		mode = "monitor-only"
+	}
+	fmt.Printf("Daemon running (PID %d), up %s\n", s.PID, time.Since(s.Started).Round(time.Second))
+	fmt.Printf("  Mode: %s, checking every %s\n", mode, s.Interval)
+
+	switch {
+	case s.Policy == "":
+		fmt.Println("  Policy: none")
+	case s.PolicyRule == "":
+		fmt.Printf("  Policy: %s, no rule applies\n", s.Policy)
+	default:
+		fmt.Printf("  Policy: %s, rule %q applies\n", s.Policy, s.PolicyRule)
+	}
+	if s.Enforce != "" {
+		fmt.Printf("  Enforce: %s\n", s.Enforce)
+	}
+
+	if s.Pause != nil {
+		fmt.Printf("  Paused: %s\n", s.Pause)
+	} else {
+		fmt.Println("  Paused: no")
+	}
+
+	if c := s.LastCheck; c != nil {
+		fmt.Printf("  Last check: %s (%s ago): %s\n", c.Time.Format(time.DateTime),
+			time.Since(c.Time).Round(time.Second), c.Outcome)
+		if c.Error != "" {
+			fmt.Printf("    Error: %s\n", c.Error)
+		}
+	} else {
+		fmt.Println("  Last check: none yet")
+	}
+}
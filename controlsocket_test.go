@@ -0,0 +1,29 @@
+package main
+
+import "testing"
+
+func TestApplyDelegatedFlags(t *testing.T) {
+	withFlag(t, "country", "SE")
+	withFlag(t, "exclude-node", "se-sto-wg-001")
+
+	restore, err := applyDelegatedFlags(map[string]string{"country": "CH", "exclude-node": "ch-zrh-wg-001"})
+	if err != nil {
+		t.Fatalf("applyDelegatedFlags: %v", err)
+	}
+	if *countryFlag != "CH" || excludeNodeFlag.String() != "ch-zrh-wg-001" {
+		t.Errorf("during the request: --country = %q, --exclude-node = %q", *countryFlag, excludeNodeFlag.String())
+	}
+	restore()
+	if *countryFlag != "SE" || excludeNodeFlag.String() != "se-sto-wg-001" {
+		t.Errorf("after the request: --country = %q, --exclude-node = %q; want the daemon's own", *countryFlag, excludeNodeFlag.String())
+	}
+
+	restore, err = applyDelegatedFlags(map[string]string{"country": "DE", "forbid-country": ""})
+	restore()
+	if err == nil {
+		t.Errorf("applyDelegatedFlags accepted --forbid-country")
+	}
+	if *countryFlag != "SE" {
+		t.Errorf("after a refused request: --country = %q, want SE", *countryFlag)
+	}
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+var regionFlag = flag.String("region", "", "Filter Mullvad nodes by region: eu, na, apac, latam, nordics")
+
+// cityFilter restricts selection to one city, set by `--set <city>`
+var cityFilter string
+
+// regions maps --region names to country codes. eu means geographic Europe,
+// not EU membership: for picking an exit, Oslo and Zurich are as European
+// as Paris.
+var regions = map[string][]string{
+	"eu": {"AL", "AT", "BA", "BE", "BG", "CH", "CY", "CZ", "DE", "DK", "EE", "ES", "FI", "FR", "GB", "GR", "HR", "HU",
+		"IE", "IS", "IT", "LT", "LU", "LV", "MD", "ME", "MK", "MT", "NL", "NO", "PL", "PT", "RO", "RS", "SE", "SI", "SK", "UA"},
+	"na":      {"US", "CA", "MX"},
+	"apac":    {"AU", "HK", "ID", "IN", "JP", "KR", "MY", "NZ", "PH", "SG", "TH", "TW", "VN"},
+	"latam":   {"AR", "BO", "BR", "CL", "CO", "CR", "EC", "GT", "MX", "PA", "PE", "PY", "UY", "VE"},
+	"nordics": {"DK", "FI", "IS", "NO", "SE"},
+}
+
+// countryAliases maps common alternative spellings to ISO codes. Official
+// English names are matched from the node locations themselves.
+var countryAliases = map[string]string{
+	"uk":                       "GB",
+	"britain":                  "GB",
+	"great britain":            "GB",
+	"england":                  "GB",
+	"scotland":                 "GB",
+	"wales":                    "GB",
+	"usa":                      "US",
+	"america":                  "US",
+	"united states":            "US",
+	"united states of america": "US",
+	"holland":                  "NL",
+	"the netherlands":          "NL",
+	"czechia":                  "CZ",
+	"czech republic":           "CZ",
+	"deutschland":              "DE",
+	"schweiz":                  "CH",
+	"suisse":                   "CH",
+	"svizzera":                 "CH",
+	"osterreich":               "AT",
+	"espana":                   "ES",
+	"sverige":                  "SE",
+	"norge":                    "NO",
+	"danmark":                  "DK",
+	"suomi":                    "FI",
+	"polska":                   "PL",
+	"brasil":                   "BR",
+	"uae":                      "AE",
+	"emirates":                 "AE",
+	"korea":                    "KR",
+	"south korea":              "KR",
+	"turkiye":                  "TR",
+}
+
+// diacritics folds accented Latin letters to their base letter
+var diacritics = strings.NewReplacer(
+	"á", "a", "à", "a", "â", "a", "ä", "a", "ã", "a", "å", "a",
+	"é", "e", "è", "e", "ê", "e", "ë", "e",
+	"í", "i", "ì", "i", "î", "i", "ï", "i",
+	"ó", "o", "ò", "o", "ô", "o", "ö", "o", "õ", "o", "ø", "o",
+	"ú", "u", "ù", "u", "û", "u", "ü", "u",
+	"ç", "c", "ñ", "n", "ß", "ss", "ł", "l", "ș", "s", "ş", "s", "ț", "t",
+)
+
+// foldName normalizes a place name or code for comparison: lower
+// case, no diacritics, punctuation and runs of spaces collapsed
+func foldName(s string) string {
+	s = diacritics.Replace(strings.ToLower(s))
+	s = strings.Map(func(r rune) rune {
+		if r == '-' || r == '_' || r == '.' || r == ',' {
+			return ' '
+		}
+		return r
+	}, s)
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// resolveCountry turns a --country value (ISO code, English name or alias,
+// in any case and with or without diacritics) into the ISO code used by
+// the nodes. Unknown values fail with the closest matches.
+func resolveCountry(input string, nodes []MullvadNode) (string, error) {
+	key := foldName(input)
+
+	// Spelling -> code, and code -> display name
+	known := make(map[string]string)
+	names := make(map[string]string)
+	for _, node := range nodes {
+		code := strings.ToUpper(node.CountryCode)
+		known[foldName(code)] = code
+		if node.Country != "" {
+			known[foldName(node.Country)] = code
+			names[code] = node.Country
+		}
+	}
+	for alias, code := range countryAliases {
+		if _, ok := known[alias]; !ok {
+			known[alias] = code
+		}
+	}
+
+	if code, ok := known[key]; ok {
+		return code, nil
+	}
+	// An ISO code without nodes is valid, it just matches nothing
+	if len(key) == 2 && strings.Trim(key, "abcdefghijklmnopqrstuvwxyz") == "" {
+		return strings.ToUpper(key), nil
+	}
+
+	var matches []string
+	seen := make(map[string]bool)
+	for spelling, code := range known {
+		if len(spelling) <= 2 || seen[code] {
+			continue
+		}
+		if strings.HasPrefix(spelling, key) || editDistance(key, spelling) <= 2 {
+			seen[code] = true
+			name := names[code]
+			if name == "" {
+				name = spelling
+			}
+			matches = append(matches, fmt.Sprintf("%s (%s)", name, code))
+		}
+	}
+	if len(matches) == 0 {
+		return "", fmt.Errorf("unknown country %q, use an ISO code like CH or a name like Switzerland", input)
+	}
+	sort.Strings(matches)
+	return "", fmt.Errorf("unknown country %q, did you mean %s?", input, strings.Join(matches, ", "))
+}
+
+// filterByLocation keeps the nodes in the --region and --country, if set
+func filterByLocation(nodes []MullvadNode) ([]MullvadNode, error) {
+	if *regionFlag != "" {
+		name := strings.ToLower(*regionFlag)
+		codes, ok := regions[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown region %q (expected eu, na, apac, latam or nordics)", *regionFlag)
+		}
+
+		filtered := make([]MullvadNode, 0)
+		for _, node := range nodes {
+			for _, code := range codes {
+				if strings.EqualFold(node.CountryCode, code) {
+					filtered = append(filtered, node)
+					break
+				}
+			}
+		}
+		if len(filtered) == 0 {
+			return nil, fmt.Errorf("no Mullvad exit nodes found for region: %s", name)
+		}
+		explain.filter("region = "+name, len(nodes), len(filtered))
+		nodes = filtered
+	}
+
+	if *countryFlag != "" {
+		code, err := resolveCountry(*countryFlag, nodes)
+		if err != nil {
+			return nil, err
+		}
+
+		filtered := make([]MullvadNode, 0)
+		for _, node := range nodes {
+			if strings.EqualFold(node.CountryCode, code) {
+				filtered = append(filtered, node)
+			}
+		}
+		if len(filtered) == 0 {
+			return nil, fmt.Errorf("no Mullvad exit nodes found for country: %s", code)
+		}
+		explain.filter("country = "+code, len(nodes), len(filtered))
+		nodes = filtered
+	}
+
+	if cityFilter != "" {
+		filtered := make([]MullvadNode, 0)
+		for _, node := range nodes {
+			if node.City == cityFilter {
+				filtered = append(filtered, node)
+			}
+		}
+		if len(filtered) == 0 {
+			return nil, fmt.Errorf("no Mullvad exit nodes found for city: %s", cityFilter)
+		}
+		explain.filter("city = "+cityFilter, len(nodes), len(filtered))
+		nodes = filtered
+	}
+
+	return nodes, nil
+}
+
+// matchCity returns the city of the nodes whose city name or code matches
+// name (case and diacritic insensitive), or ""
+func matchCity(name string, nodes []MullvadNode) string {
+	key := foldName(name)
+	for _, node := range nodes {
+		if node.City != "" && (foldName(node.City) == key || foldName(node.CityCode) == key) {
+			return node.City
+		}
+	}
+	return ""
+}
+
+// hasCountry reports whether any node is in the country
+func hasCountry(nodes []MullvadNode, code string) bool {
+	for _, node := range nodes {
+		if strings.EqualFold(node.CountryCode, code) {
+			return true
+		}
+	}
+	return false
+}
+
+// editDistance is the Levenshtein distance between a and b
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(rb)]
+}
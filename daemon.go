@@ -0,0 +1,405 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"tailscale.com/tailcfg"
+)
+
+var (
+	daemonFlag   = flag.Bool("daemon", false, "Run continuously, keeping the WAN protected")
+	intervalFlag = flag.Duration("interval", time.Minute, "How often the daemon re-checks protection")
+
+	batteryIntervalFlag = flag.Duration("battery-interval", 5*time.Minute, "Check interval while on battery power (0 to keep --interval)")
+)
+
+// powerCheckInterval is how often the daemon looks at the power source
+const powerCheckInterval = time.Minute
+
+// daemon holds the state of a running --daemon loop
+type daemon struct {
+	lc LocalClient
+
+	trustedRule string               // Non-empty while on a trusted network
+	failing     string               // Failure event already notified, until healthy again
+	paused      bool                 // Protection paused by the pause command or the tray
+	pinned      tailcfg.StableNodeID // Node set by a delegated set, kept while it works
+	overridden  tailcfg.StableNodeID // Pinned node allowed by --override-policy
+	quality     qualityWatch
+	relay       relayWatch
+
+	actions chan func(ctx context.Context) // Run on the daemon loop, e.g. by the tray
+	reloads chan chan error                // Reload requests from the control socket
+	stopc   chan struct{}                  // Closed by the stop command
+	done    chan struct{}                  // Closed when the loop returns
+
+	started  time.Time
+	stopOnce sync.Once
+	mu       sync.Mutex
+	snapshot daemonStatus // As of the last check, for daemon status
+}
+
+// checkResult is the outcome of one daemon check
+type checkResult struct {
+	Time    time.Time `json:"time"`
+	Outcome string    `json:"outcome"`
+	Error   string    `json:"error,omitempty"`
+}
+
+func newDaemon(lc LocalClient) *daemon {
+	return &daemon{
+		lc:      lc,
+		actions: make(chan func(ctx context.Context)),
+		reloads: make(chan chan error),
+		stopc:   make(chan struct{}),
+		done:    make(chan struct{}),
+		started: time.Now(),
+		snapshot: daemonStatus{
+			Interval:    *intervalFlag,
+			MonitorOnly: *monitorOnlyFlag,
+			Policy:      *policyFlag,
+		},
+	}
+}
+
+// runDaemon keeps the WAN protected until interrupted. Protection is
+// re-checked every --interval, and a change of the physical network or a
+// resume from sleep triggers an immediate re-check plus a fresh exit node
+// selection.
+func runDaemon(ctx context.Context, lc LocalClient) error {
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	d := newDaemon(lc)
+	if *dbusFlag != "" {
+		c := newControl(ctx, lc, d)
+		startDBus(ctx, c)
+		go c.poll()
+	}
+	return d.run(ctx)
+}
+
+// run is the daemon loop, returning when ctx is done
+func (d *daemon) run(ctx context.Context) error {
+	defer close(d.done)
+	release, err := acquirePIDFile()
+	if err != nil {
+		return err
+	}
+	defer release()
+	autoSwitching = !*monitorOnlyFlag
+	serveMetrics(ctx)
+	defer serveControlSocket(d)()
+	changes := watchNetworkChanges(ctx)
+	wakes := watchWake(ctx)
+
+	interval := d.checkInterval()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	powerTicker := time.NewTicker(powerCheckInterval)
+	defer powerTicker.Stop()
+
+	var qualityC <-chan time.Time
+	if degradeEnabled() {
+		qualityTicker := time.NewTicker(degradeProbeInterval)
+		defer qualityTicker.Stop()
+		qualityC = qualityTicker.C
+	}
+
+	if *monitorOnlyFlag {
+		log.Printf("Daemon started in monitor-only mode (interval %s), Tailscale prefs are never changed", interval)
+	} else {
+		log.Printf("Daemon started (interval %s)", interval)
+	}
+	d.check(ctx, false)
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Printf("Daemon stopping")
+			return nil
+		case <-d.stopc:
+			log.Printf("Daemon stopping (stop command)")
+			return nil
+		case reply := <-d.reloads:
+			err := d.reload()
+			reply <- err
+			if err != nil {
+				log.Printf("Error reloading configuration: %v", err)
+				continue
+			}
+			log.Printf("Configuration reloaded, re-evaluating exit node")
+			interval = d.checkInterval()
+			ticker.Reset(interval)
+			d.check(ctx, true)
+		case <-ticker.C:
+			d.check(ctx, false)
+		case <-powerTicker.C:
+			if next := d.checkInterval(); next != interval {
+				interval = next
+				ticker.Reset(interval)
+				log.Printf("Power source changed, checking every %s", interval)
+			}
+		case <-changes:
+			log.Printf("Network change detected, re-evaluating exit node")
+			d.check(ctx, true)
+		case <-wakes:
+			log.Printf("System resumed from sleep, re-validating exit node")
+			d.check(ctx, true)
+		case <-qualityC:
+			if !d.paused {
+				d.quality.sample(ctx, d.lc)
+			}
+		case fn := <-d.actions:
+			fn(ctx)
+		}
+	}
+}
+
+// do runs fn on the daemon loop, so it doesn't race with checks. It blocks
+// until the loop picks fn up, and drops fn once the loop has returned.
+func (d *daemon) do(fn func(ctx context.Context)) {
+	select {
+	case d.actions <- fn:
+	case <-d.done:
+	}
+}
+
+// call runs fn on the daemon loop like do, and waits for its result
+func (d *daemon) call(fn func(ctx context.Context) error) error {
+	reply := make(chan error, 1)
+	d.do(func(ctx context.Context) { reply <- fn(ctx) })
+	select {
+	case err := <-reply:
+		return err
+	case <-d.done:
+		select {
+		case err := <-reply:
+			return err
+		default:
+			return errors.New("daemon is stopping")
+		}
+	}
+}
+
+// stop makes the loop return, as the stop command does
+func (d *daemon) stop() {
+	d.stopOnce.Do(func() { close(d.stopc) })
+}
+
+// reload re-reads the config file, the admin config and the --policy file
+// as on startup. Flags given on the command line still win over the config
+// file. Policy rule settings are reverted first, so the rules apply on top
+// of the new configuration.
+func (d *daemon) reload() error {
+	if activePolicy != nil {
+		if err := activePolicy.reset(); err != nil {
+			return err
+		}
+	}
+	if err := loadConfig(); err != nil {
+		return err
+	}
+	if err := loadAdminConfig(); err != nil {
+		return err
+	}
+	if d.overridden != "" && countriesLocked() {
+		// Locked countries can't be overridden, not even by an earlier set
+		log.Printf("The admin config now locks the countries, dropping the --override-policy pin of %s", d.overridden)
+		if d.pinned == d.overridden {
+			d.pinned = ""
+		}
+		d.overridden = ""
+	}
+	if err := setLanguage(); err != nil {
+		return err
+	}
+	activePolicy = nil
+	if *policyFlag != "" {
+		p, err := loadPolicy(*policyFlag)
+		if err != nil {
+			return err
+		}
+		activePolicy = p
+	}
+	return nil
+}
+
+// result records the outcome of a check, along with the settings it ran
+// under, for daemon status. The control socket reads this snapshot rather
+// than the flags, which the loop may be changing.
+func (d *daemon) result(outcome string, err error) {
+	r := &checkResult{Time: time.Now(), Outcome: outcome}
+	if err != nil {
+		r.Error = err.Error()
+	}
+	snap := daemonStatus{
+		Interval:    d.checkInterval(),
+		MonitorOnly: *monitorOnlyFlag,
+		Policy:      *policyFlag,
+		Enforce:     policyEnforce,
+		LastCheck:   r,
+	}
+	if activePolicy != nil {
+		snap.PolicyRule = activePolicy.active
+	}
+	d.mu.Lock()
+	d.snapshot = snap
+	d.mu.Unlock()
+}
+
+// checkInterval returns the check cadence for the current power source.
+// On battery, --battery-interval stretches polling to save energy.
+func (d *daemon) checkInterval() time.Duration {
+	if *batteryIntervalFlag > 0 && onBattery() {
+		return *batteryIntervalFlag
+	}
+	return *intervalFlag
+}
+
+// check verifies protection and selects an exit node when none is active.
+// With reselect, selection runs even if an exit node is already active,
+// since the best exit from a new network is rarely the previous one.
+func (d *daemon) check(ctx context.Context, reselect bool) {
+	if *monitorOnlyFlag {
+		d.observe(ctx)
+		return
+	}
+	if p := currentPause(); p != nil {
+		if !d.paused {
+			log.Printf("Protection paused %s, exit node not enforced", p)
+		}
+		d.paused = true
+		d.result("paused "+p.String(), nil)
+		return
+	}
+	if d.paused {
+		log.Printf("Protection resumed")
+		d.paused = false
+	}
+
+	if err := applyPolicy(ctx, d.lc); err != nil {
+		log.Printf("Error applying policy: %v", err)
+	}
+
+	// A switch deferred for active traffic is retried on every check
+	reselect = reselect || !drainPending.IsZero()
+
+	// A node pinned by a set with --override-policy stays allowed
+	checkCtx := ctx
+	if d.pinned != "" && d.pinned == d.overridden {
+		checkCtx = withPolicyOverride(ctx, d.pinned)
+	}
+	active, err := checkExitNode(checkCtx, d.lc)
+	if err != nil {
+		log.Printf("Error checking exit node: %v", err)
+		if failureEvent(err) == "verification-failed" {
+			d.failed(ctx, err)
+		}
+	}
+
+	sampleProtection(active, d.checkInterval())
+	if active {
+		sampleTraffic(ctx, d.lc)
+	}
+
+	pinned := d.keepPinned(ctx, active)
+	if pinned {
+		reselect = false
+	}
+
+	// An exit node that fell back to a relay is replaced, preferably by
+	// one with a direct path, if NAT traversal doesn't recover
+	if active && !reselect && d.relay.check(ctx, d.lc, pinned) {
+		reselect = true
+		ctx = withPreferDirect(withAuditReason(ctx, "exit node relayed for --relay-grace"))
+	}
+
+	if active && !reselect {
+		if *verboseFlag {
+			log.Printf("WAN is protected")
+		}
+		d.failing = ""
+		d.result("protected", nil)
+		return
+	}
+
+	if rule, trusted := onTrustedNetwork(); trusted {
+		if d.trustedRule != rule {
+			log.Printf("Trusted network (%s): exit node not enforced", rule)
+		}
+		d.trustedRule = rule
+		d.result("trusted network ("+rule+"), exit node not enforced", err)
+		return
+	}
+	d.trustedRule = ""
+
+	if !active {
+		if captive, _, err := detectCaptivePortal(ctx); err == nil && captive {
+			log.Printf("No exit node active: captive portal detected, waiting for login")
+			d.result("captive portal detected, waiting for login", nil)
+			return
+		}
+	}
+
+	if !active && d.failing == "" {
+		d.failing = "exit-unprotected"
+		notifyFailure(ctx, d.failing, "No exit node active, the WAN is unprotected; selecting one")
+	}
+
+	// An exit node that is set but not working is being failed over
+	selectionSource = sourceDaemon
+	if !active {
+		if prefs, err := d.lc.GetPrefs(ctx); err == nil && !prefs.ExitNodeID.IsZero() {
+			selectionSource = sourceFailover
+		}
+	}
+
+	if err := autoSelectMullvad(withCurrentActive(ctx, active), d.lc); err != nil {
+		log.Printf("Error auto-selecting Mullvad node: %v", err)
+		d.failed(ctx, err)
+		d.result("exit node selection failed", err)
+		return
+	}
+	d.result("exit node selection completed", nil)
+}
+
+// keepPinned reports whether the active exit node is the one a delegated
+// set pinned, which network changes and drains don't switch away from.
+// The pin is dropped once the node stops working or is replaced.
+func (d *daemon) keepPinned(ctx context.Context, active bool) bool {
+	if d.pinned == "" {
+		return false
+	}
+	prefs, err := d.lc.GetPrefs(ctx)
+	if err != nil {
+		return active
+	}
+	switch {
+	case prefs.ExitNodeID != d.pinned:
+		log.Printf("Exit node changed, no longer keeping the one set manually")
+	case !active:
+		log.Printf("Exit node set manually stopped working, selecting another")
+	default:
+		return true
+	}
+	d.pinned = ""
+	return false
+}
+
+// failed notifies a failure once when it starts, not on every check
+func (d *daemon) failed(ctx context.Context, err error) {
+	event := failureEvent(err)
+	if event != d.failing {
+		notifyFailure(ctx, event, err.Error())
+	}
+	d.failing = event
+}
@@ -0,0 +1,747 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var dbusFlag = flag.String("dbus", "", "With --daemon or tray, serve status and controls on D-Bus: session or system")
+
+// The D-Bus name, object and interface of the service
+const (
+	dbusName      = "org.protectedwan.ProtectWan"
+	dbusPath      = "/org/protectedwan/ProtectWan"
+	dbusInterface = "org.protectedwan.ProtectWan"
+)
+
+// D-Bus message types and flags
+const (
+	dbusMethodCall   = 1
+	dbusMethodReturn = 2
+	dbusErrorReply   = 3
+	dbusSignal       = 4
+
+	dbusNoReplyExpected = 0x1
+)
+
+// dbusMaxMessage bounds the messages read from the bus
+const dbusMaxMessage = 1 << 24
+
+// errDBusMalformed marks a message read whole that could not be decoded.
+// The connection stays usable, so the message is skipped.
+var errDBusMalformed = errors.New("malformed D-Bus message")
+
+const dbusIntrospection = `<!DOCTYPE node PUBLIC "-//freedesktop//DTD D-BUS Object Introspection 1.0//EN"
+ "http://www.freedesktop.org/standards/dbus/1.0/introspect.dtd">
+<node>
+  <interface name="org.protectedwan.ProtectWan">
+    <method name="Pause">
+      <arg name="seconds" type="u" direction="in"/>
+    </method>
+    <method name="Resume"/>
+    <method name="Disable"/>
+    <method name="SetCountry">
+      <arg name="code" type="s" direction="in"/>
+    </method>
+    <property name="Protected" type="b" access="read"/>
+    <property name="Paused" type="b" access="read"/>
+    <property name="PausedUntil" type="x" access="read"/>
+    <property name="ExitNode" type="s" access="read"/>
+    <property name="City" type="s" access="read"/>
+    <property name="Country" type="s" access="read"/>
+    <property name="CountryCode" type="s" access="read"/>
+    <property name="PickedCountry" type="s" access="read"/>
+  </interface>
+  <interface name="org.freedesktop.DBus.Properties">
+    <method name="Get">
+      <arg name="interface" type="s" direction="in"/>
+      <arg name="property" type="s" direction="in"/>
+      <arg name="value" type="v" direction="out"/>
+    </method>
+    <method name="GetAll">
+      <arg name="interface" type="s" direction="in"/>
+      <arg name="properties" type="a{sv}" direction="out"/>
+    </method>
+    <method name="Set">
+      <arg name="interface" type="s" direction="in"/>
+      <arg name="property" type="s" direction="in"/>
+      <arg name="value" type="v" direction="in"/>
+    </method>
+    <signal name="PropertiesChanged">
+      <arg name="interface" type="s"/>
+      <arg name="changed" type="a{sv}"/>
+      <arg name="invalidated" type="as"/>
+    </signal>
+  </interface>
+  <interface name="org.freedesktop.DBus.Introspectable">
+    <method name="Introspect">
+      <arg name="xml" type="s" direction="out"/>
+    </method>
+  </interface>
+  <interface name="org.freedesktop.DBus.Peer">
+    <method name="Ping"/>
+  </interface>
+</node>
+`
+
+// startDBus serves the control on the bus chosen by --dbus, if any
+func startDBus(ctx context.Context, c *control) {
+	if *dbusFlag == "" {
+		return
+	}
+	go func() {
+		if err := serveDBus(ctx, c); err != nil {
+			log.Printf("Error serving D-Bus: %v", err)
+		}
+	}()
+}
+
+// serveDBus owns dbusName and answers calls on dbusPath until ctx is done.
+// Property changes are signalled with PropertiesChanged.
+func serveDBus(ctx context.Context, c *control) error {
+	conn, err := dialDBus(*dbusFlag)
+	if err != nil {
+		return fmt.Errorf("failed to connect to the %s bus: %w", *dbusFlag, err)
+	}
+	defer conn.Close()
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+
+	// Hello must be the first call on a bus connection
+	if _, err := conn.call("Hello"); err != nil {
+		return err
+	}
+	const doNotQueue = 0x4
+	request, err := conn.call("RequestName", dbusName, uint32(doNotQueue))
+	if err != nil {
+		return err
+	}
+
+	changed := c.subscribe()
+	go func() {
+		last := dbusProperties(c.current())
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-changed:
+			}
+			props := dbusProperties(c.current())
+			diff := make(map[string]any)
+			for k, v := range props {
+				if last[k] != v {
+					diff[k] = v
+				}
+			}
+			last = props
+			if len(diff) > 0 {
+				conn.send(&dbusMessage{Type: dbusSignal, Path: dbusPath, Interface: "org.freedesktop.DBus.Properties",
+					Member: "PropertiesChanged", Body: []any{dbusInterface, diff, []string{}}})
+			}
+		}
+	}()
+
+	for {
+		m, err := conn.read()
+		if errors.Is(err, errDBusMalformed) {
+			log.Printf("Ignoring D-Bus message: %v", err)
+			continue
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+
+		switch m.Type {
+		case dbusMethodReturn, dbusErrorReply:
+			if m.ReplySerial != request {
+				continue
+			}
+			if m.Type == dbusErrorReply {
+				return fmt.Errorf("failed to own %s: %s %v", dbusName, m.ErrorName, m.Body)
+			}
+			if r, _ := m.arg(0).(uint32); r != 1 {
+				return fmt.Errorf("%s is already owned on the %s bus, is another protect-wan running?", dbusName, *dbusFlag)
+			}
+			log.Printf("Serving %s on the %s bus", dbusName, *dbusFlag)
+		case dbusMethodCall:
+			body, err := handleDBusCall(c, m)
+			if m.Flags&dbusNoReplyExpected != 0 {
+				continue
+			}
+			reply := &dbusMessage{Type: dbusMethodReturn, ReplySerial: m.Serial, Destination: m.Sender, Body: body}
+			var derr *dbusError
+			if errors.As(err, &derr) {
+				reply = &dbusMessage{Type: dbusErrorReply, ErrorName: derr.Name, ReplySerial: m.Serial, Destination: m.Sender, Body: []any{derr.Message}}
+			}
+			if err := conn.send(reply); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// dbusProperties returns the properties of the interface for a status
+func dbusProperties(s controlStatus) map[string]any {
+	var pausedUntil int64
+	if s.Paused != nil && !s.Paused.Until.IsZero() {
+		pausedUntil = s.Paused.Until.Unix()
+	}
+	return map[string]any{
+		"Protected":     s.Protected,
+		"Paused":        s.Paused != nil,
+		"PausedUntil":   pausedUntil,
+		"ExitNode":      mullvadHostname(s.Node),
+		"City":          s.Node.City,
+		"Country":       s.Node.Country,
+		"CountryCode":   s.Node.CountryCode,
+		"PickedCountry": s.Country,
+	}
+}
+
+// dbusError is an error reply to a method call
+type dbusError struct {
+	Name, Message string
+}
+
+func (e *dbusError) Error() string {
+	return e.Name + ": " + e.Message
+}
+
+// handleDBusCall runs a method call and returns the reply body
+func handleDBusCall(c *control, m *dbusMessage) ([]any, error) {
+	if m.Member == "Introspect" && m.Path != dbusPath && strings.HasPrefix(dbusPath, strings.TrimSuffix(m.Path, "/")+"/") {
+		// Lets tools walk down the object tree to the service
+		child, _, _ := strings.Cut(strings.TrimPrefix(dbusPath, strings.TrimSuffix(m.Path, "/")+"/"), "/")
+		return []any{fmt.Sprintf("<node>\n  <node name=%q/>\n</node>\n", child)}, nil
+	}
+	if m.Path != dbusPath {
+		return nil, &dbusError{"org.freedesktop.DBus.Error.UnknownObject", "no object at " + m.Path}
+	}
+
+	if m.Body == nil && m.Signature != "" {
+		return nil, &dbusError{"org.freedesktop.DBus.Error.InvalidArgs", "unsupported argument types (" + m.Signature + ")"}
+	}
+
+	iface := m.Interface
+	if iface == "" {
+		iface = dbusInterface
+	}
+	props := dbusProperties(c.current())
+
+	switch iface + "." + m.Member {
+	case "org.freedesktop.DBus.Peer.Ping":
+		return nil, nil
+	case "org.freedesktop.DBus.Introspectable.Introspect":
+		return []any{dbusIntrospection}, nil
+	case "org.freedesktop.DBus.Properties.Get":
+		if m.Signature != "ss" {
+			return nil, dbusInvalidArgs("ss", m)
+		}
+		v, ok := props[m.arg(1).(string)]
+		if !ok || m.arg(0) != dbusInterface {
+			return nil, &dbusError{"org.freedesktop.DBus.Error.UnknownProperty", fmt.Sprintf("no property %s.%s", m.arg(0), m.arg(1))}
+		}
+		return []any{dbusVariant{v}}, nil
+	case "org.freedesktop.DBus.Properties.GetAll":
+		if m.Signature != "s" {
+			return nil, dbusInvalidArgs("s", m)
+		}
+		if m.arg(0) != dbusInterface {
+			return []any{map[string]any{}}, nil
+		}
+		return []any{props}, nil
+	case "org.freedesktop.DBus.Properties.Set":
+		return nil, &dbusError{"org.freedesktop.DBus.Error.PropertyReadOnly", "properties are read-only, use the methods"}
+
+	case dbusInterface + ".Pause":
+		if m.Signature != "u" {
+			return nil, dbusInvalidArgs("u", m)
+		}
+		c.pause(time.Duration(m.arg(0).(uint32)) * time.Second)
+		return nil, nil
+	case dbusInterface + ".Resume":
+		c.resume()
+		return nil, nil
+	case dbusInterface + ".Disable":
+		c.disable()
+		return nil, nil
+	case dbusInterface + ".SetCountry":
+		if m.Signature != "s" {
+			return nil, dbusInvalidArgs("s", m)
+		}
+		code := strings.ToUpper(m.arg(0).(string))
+		if code != "" && !countryListed(c.current().Countries, code) {
+			return nil, &dbusError{"org.freedesktop.DBus.Error.InvalidArgs", "no online Mullvad exit node in " + code}
+		}
+		c.pickCountry(code)
+		return nil, nil
+	}
+	return nil, &dbusError{"org.freedesktop.DBus.Error.UnknownMethod", fmt.Sprintf("no method %s.%s", iface, m.Member)}
+}
+
+func dbusInvalidArgs(want string, m *dbusMessage) error {
+	return &dbusError{"org.freedesktop.DBus.Error.InvalidArgs", fmt.Sprintf("%s takes (%s), not (%s)", m.Member, want, m.Signature)}
+}
+
+// countryListed reports whether code is one of the countries
+func countryListed(countries []countryEntry, code string) bool {
+	for _, c := range countries {
+		if strings.EqualFold(c.Code, code) {
+			return true
+		}
+	}
+	return false
+}
+
+// The D-Bus wire protocol, as far as the service needs it: little-endian
+// messages with basic types, variants, string arrays and a{sv} dicts.
+
+// dbusObjectPath and dbusSignature are the D-Bus o and g types
+type (
+	dbusObjectPath string
+	dbusSignature  string
+)
+
+// dbusVariant is the D-Bus v type
+type dbusVariant struct {
+	Value any
+}
+
+// dbusMessage is a message sent or received on the bus
+type dbusMessage struct {
+	Type        byte
+	Flags       byte
+	Serial      uint32
+	Path        string
+	Interface   string
+	Member      string
+	ErrorName   string
+	ReplySerial uint32
+	Destination string
+	Sender      string
+	Signature   string
+	Body        []any
+}
+
+// arg returns the i-th body argument, nil if missing
+func (m *dbusMessage) arg(i int) any {
+	if i >= len(m.Body) {
+		return nil
+	}
+	return m.Body[i]
+}
+
+// dbusSignatureOf returns the type signature of a value
+func dbusSignatureOf(v any) string {
+	switch v.(type) {
+	case byte:
+		return "y"
+	case bool:
+		return "b"
+	case uint32:
+		return "u"
+	case int64:
+		return "x"
+	case string:
+		return "s"
+	case dbusObjectPath:
+		return "o"
+	case dbusSignature:
+		return "g"
+	case dbusVariant:
+		return "v"
+	case []string:
+		return "as"
+	case map[string]any:
+		return "a{sv}"
+	}
+	panic(fmt.Sprintf("dbus: unsupported type %T", v))
+}
+
+// marshal encodes the message
+func (m *dbusMessage) marshal() []byte {
+	var body dbusEncoder
+	var sig strings.Builder
+	for _, v := range m.Body {
+		sig.WriteString(dbusSignatureOf(v))
+		body.value(v)
+	}
+
+	// Header fields, by their codes in the specification
+	var fields [][2]any
+	add := func(code byte, v any, set bool) {
+		if set {
+			fields = append(fields, [2]any{code, v})
+		}
+	}
+	add(1, dbusObjectPath(m.Path), m.Path != "")
+	add(2, m.Interface, m.Interface != "")
+	add(3, m.Member, m.Member != "")
+	add(4, m.ErrorName, m.ErrorName != "")
+	add(5, m.ReplySerial, m.ReplySerial != 0)
+	add(6, m.Destination, m.Destination != "")
+	add(8, dbusSignature(sig.String()), sig.Len() > 0)
+
+	var h dbusEncoder
+	h.buf = append(h.buf, 'l', m.Type, m.Flags, 1)
+	h.u32(uint32(len(body.buf)))
+	h.u32(m.Serial)
+	h.array(8, func() {
+		for _, f := range fields {
+			h.pad(8)
+			h.value(f[0])
+			h.value(dbusVariant{f[1]})
+		}
+	})
+	h.pad(8)
+	return append(h.buf, body.buf...)
+}
+
+// dbusEncoder appends values in the D-Bus wire format
+type dbusEncoder struct {
+	buf []byte
+}
+
+func (e *dbusEncoder) pad(n int) {
+	for len(e.buf)%n != 0 {
+		e.buf = append(e.buf, 0)
+	}
+}
+
+func (e *dbusEncoder) u32(v uint32) {
+	e.pad(4)
+	e.buf = binary.LittleEndian.AppendUint32(e.buf, v)
+}
+
+// array writes the length of the elements that elems writes, which start
+// aligned to align
+func (e *dbusEncoder) array(align int, elems func()) {
+	e.u32(0)
+	at := len(e.buf) - 4
+	e.pad(align)
+	start := len(e.buf)
+	elems()
+	binary.LittleEndian.PutUint32(e.buf[at:], uint32(len(e.buf)-start))
+}
+
+func (e *dbusEncoder) value(v any) {
+	switch v := v.(type) {
+	case byte:
+		e.buf = append(e.buf, v)
+	case bool:
+		var b uint32
+		if v {
+			b = 1
+		}
+		e.u32(b)
+	case uint32:
+		e.u32(v)
+	case int64:
+		e.pad(8)
+		e.buf = binary.LittleEndian.AppendUint64(e.buf, uint64(v))
+	case string:
+		e.u32(uint32(len(v)))
+		e.buf = append(append(e.buf, v...), 0)
+	case dbusObjectPath:
+		e.value(string(v))
+	case dbusSignature:
+		e.buf = append(append(append(e.buf, byte(len(v))), v...), 0)
+	case dbusVariant:
+		e.value(dbusSignature(dbusSignatureOf(v.Value)))
+		e.value(v.Value)
+	case []string:
+		e.array(4, func() {
+			for _, s := range v {
+				e.value(s)
+			}
+		})
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		e.array(8, func() {
+			for _, k := range keys {
+				e.pad(8)
+				e.value(k)
+				e.value(dbusVariant{v[k]})
+			}
+		})
+	default:
+		panic(fmt.Sprintf("dbus: unsupported type %T", v))
+	}
+}
+
+// dbusDecoder reads values in the D-Bus wire format. The first error
+// sticks; reads after it return zero values.
+type dbusDecoder struct {
+	buf []byte
+	off int
+	err error
+}
+
+func (d *dbusDecoder) pad(n int) {
+	d.off = (d.off + n - 1) / n * n
+}
+
+// take returns the next n bytes, or nil past the end of the buffer
+func (d *dbusDecoder) take(n int) []byte {
+	if d.err != nil || n < 0 || n > len(d.buf)-d.off {
+		if d.err == nil {
+			d.err = io.ErrUnexpectedEOF
+		}
+		return nil
+	}
+	b := d.buf[d.off : d.off+n]
+	d.off += n
+	return b
+}
+
+func (d *dbusDecoder) byte() byte {
+	if b := d.take(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (d *dbusDecoder) u32() uint32 {
+	d.pad(4)
+	if b := d.take(4); b != nil {
+		return binary.LittleEndian.Uint32(b)
+	}
+	return 0
+}
+
+// value reads a value of a basic type or a variant holding one
+func (d *dbusDecoder) value(sig byte) any {
+	switch sig {
+	case 'y':
+		return d.byte()
+	case 'b':
+		return d.u32() != 0
+	case 'u':
+		return d.u32()
+	case 'i':
+		return int32(d.u32())
+	case 's', 'o':
+		n := d.u32()
+		s := string(d.take(int(n)))
+		d.take(1)
+		return s
+	case 'g':
+		n := d.byte()
+		s := string(d.take(int(n)))
+		d.take(1)
+		return s
+	case 'v':
+		// Only basic types: a variant in a variant could nest deep enough
+		// to exhaust the stack
+		vsig := d.value('g').(string)
+		if len(vsig) != 1 || vsig[0] == 'v' {
+			d.err = fmt.Errorf("unsupported variant type %q", vsig)
+			return nil
+		}
+		return d.value(vsig[0])
+	}
+	d.err = fmt.Errorf("unsupported type %q", sig)
+	return nil
+}
+
+// dbusConn is an authenticated connection to a message bus
+type dbusConn struct {
+	net.Conn
+	r      *bufio.Reader
+	mu     sync.Mutex // Serializes writes
+	serial atomic.Uint32
+}
+
+// dbusBusAddress returns the address of the session or system bus
+func dbusBusAddress(bus string) string {
+	if bus == "system" {
+		if a := os.Getenv("DBUS_SYSTEM_BUS_ADDRESS"); a != "" {
+			return a
+		}
+		return "unix:path=/var/run/dbus/system_bus_socket"
+	}
+	if a := os.Getenv("DBUS_SESSION_BUS_ADDRESS"); a != "" {
+		return a
+	}
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		return "unix:path=" + filepath.Join(dir, "bus")
+	}
+	return ""
+}
+
+// dbusSocket returns the Unix socket of a bus address, which lists
+// alternatives as "transport:key=value,..." separated by semicolons
+func dbusSocket(addr string) (string, error) {
+	for _, a := range strings.Split(addr, ";") {
+		transport, params, _ := strings.Cut(a, ":")
+		if transport != "unix" {
+			continue
+		}
+		for _, kv := range strings.Split(params, ",") {
+			k, v, _ := strings.Cut(kv, "=")
+			v, err := url.PathUnescape(v)
+			if err != nil {
+				return "", fmt.Errorf("invalid D-Bus address %q: %w", addr, err)
+			}
+			switch k {
+			case "path":
+				return v, nil
+			case "abstract":
+				return "@" + v, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("no Unix socket in D-Bus address %q", addr)
+}
+
+// dialDBus connects to the session or system bus and authenticates as the
+// current user
+func dialDBus(bus string) (*dbusConn, error) {
+	path, err := dbusSocket(dbusBusAddress(bus))
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	c := &dbusConn{Conn: conn, r: bufio.NewReader(conn)}
+
+	uid := hex.EncodeToString([]byte(strconv.Itoa(os.Getuid())))
+	if _, err := fmt.Fprintf(conn, "\x00AUTH EXTERNAL %s\r\n", uid); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if !strings.HasPrefix(line, "OK ") {
+		conn.Close()
+		return nil, fmt.Errorf("authentication failed: %s", strings.TrimSpace(line))
+	}
+	if _, err := fmt.Fprint(conn, "BEGIN\r\n"); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// send writes a message with the next serial
+func (c *dbusConn) send(m *dbusMessage) error {
+	m.Serial = c.serial.Add(1)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, err := c.Write(m.marshal())
+	return err
+}
+
+// call sends a method call to the bus itself and returns its serial, which
+// the reply refers to
+func (c *dbusConn) call(member string, args ...any) (uint32, error) {
+	m := &dbusMessage{Type: dbusMethodCall, Path: "/org/freedesktop/DBus", Interface: "org.freedesktop.DBus",
+		Member: member, Destination: "org.freedesktop.DBus", Body: args}
+	if err := c.send(m); err != nil {
+		return 0, err
+	}
+	return m.Serial, nil
+}
+
+// read reads the next message. Bodies with types beyond the basic ones are
+// left undecoded. A message that is read whole but cannot be decoded
+// returns an error wrapping errDBusMalformed.
+func (c *dbusConn) read() (*dbusMessage, error) {
+	fixed := make([]byte, 16)
+	if _, err := io.ReadFull(c.r, fixed); err != nil {
+		return nil, err
+	}
+	var order binary.ByteOrder
+	switch fixed[0] {
+	case 'l':
+		order = binary.LittleEndian
+	case 'B':
+		order = binary.BigEndian
+	default:
+		return nil, fmt.Errorf("invalid D-Bus byte order %q", fixed[0])
+	}
+	bodyLen := order.Uint32(fixed[4:])
+	fieldsLen := order.Uint32(fixed[12:])
+	if bodyLen > dbusMaxMessage || fieldsLen > dbusMaxMessage {
+		return nil, errors.New("D-Bus message too large")
+	}
+	headerLen := (16 + int(fieldsLen) + 7) / 8 * 8
+	buf := make([]byte, headerLen+int(bodyLen))
+	copy(buf, fixed)
+	if _, err := io.ReadFull(c.r, buf[16:]); err != nil {
+		return nil, err
+	}
+	if order != binary.LittleEndian {
+		return nil, fmt.Errorf("%w: big-endian messages are not supported", errDBusMalformed)
+	}
+
+	m := &dbusMessage{Type: fixed[1], Flags: fixed[2], Serial: binary.LittleEndian.Uint32(fixed[8:])}
+	d := &dbusDecoder{buf: buf[:16+fieldsLen], off: 16}
+	for d.off < len(d.buf) && d.err == nil {
+		d.pad(8)
+		code := d.byte()
+		v := d.value('v')
+		switch code {
+		case 1:
+			m.Path, _ = v.(string)
+		case 2:
+			m.Interface, _ = v.(string)
+		case 3:
+			m.Member, _ = v.(string)
+		case 4:
+			m.ErrorName, _ = v.(string)
+		case 5:
+			m.ReplySerial, _ = v.(uint32)
+		case 6:
+			m.Destination, _ = v.(string)
+		case 7:
+			m.Sender, _ = v.(string)
+		case 8:
+			m.Signature, _ = v.(string)
+		}
+	}
+	if d.err != nil {
+		return nil, fmt.Errorf("%w: invalid header: %v", errDBusMalformed, d.err)
+	}
+
+	body := &dbusDecoder{buf: buf[headerLen:]}
+	var args []any
+	for i := 0; i < len(m.Signature) && body.err == nil; i++ {
+		args = append(args, body.value(m.Signature[i]))
+	}
+	if body.err == nil {
+		m.Body = args
+	}
+	return m, nil
+}
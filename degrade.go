@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"time"
+
+	"tailscale.com/tailcfg"
+)
+
+var (
+	degradeLatencyFlag = flag.Duration("degrade-latency", 0, "Daemon: alert when the exit node's average latency stays above this (e.g. 150ms, 0 to disable)")
+	degradeLossFlag    = flag.Float64("degrade-loss", 0, "Daemon: alert when the exit node's packet loss stays above this percentage (0 to disable)")
+	degradeWindowFlag  = flag.Duration("degrade-window", 5*time.Minute, "Daemon: how long degradation must last before alerting")
+	maxLatencyFlag     = flag.Duration("max-latency", 0, "With --check, ping the exit node and fail with exit code 4 if its latency exceeds this (e.g. 150ms, 0 to skip)")
+)
+
+// checkLatencySamples is how many pings --check --max-latency averages
+const checkLatencySamples = 3
+
+// degradeProbeInterval is how often the daemon pings the exit node while
+// degradation alerts are enabled
+const degradeProbeInterval = 10 * time.Second
+
+// degradeEnabled reports whether any degradation threshold is set
+func degradeEnabled() bool {
+	return *degradeLatencyFlag > 0 || *degradeLossFlag > 0
+}
+
+// qualityWatch tracks the quality of the active exit node for the daemon
+type qualityWatch struct {
+	node    tailcfg.StableNodeID
+	stats   *pingStats
+	since   time.Time // Start of the current degradation, zero while healthy
+	alerted bool
+}
+
+// sample pings the active exit node once and alerts when its latency or
+// loss has stayed above the thresholds for --degrade-window. Alerting does
+// not depend on auto-switching, so a dropping egress quality is reported
+// even when nothing switches away from it.
+func (w *qualityWatch) sample(ctx context.Context, lc LocalClient) {
+	node, ok, err := currentExitNode(ctx, lc)
+	if err != nil || !ok {
+		return
+	}
+	if node.ID != w.node {
+		// A new exit node starts with a clean slate
+		*w = qualityWatch{node: node.ID, stats: &pingStats{window: int(*degradeWindowFlag / degradeProbeInterval)}}
+		w.stats.window = max(w.stats.window, 3)
+	}
+
+	p, err := newProber(lc)
+	if err != nil {
+		return
+	}
+	w.stats.add(p.ping(ctx, node))
+
+	loss, _, avg, _, _, _ := w.stats.summary()
+	var reason string
+	switch {
+	case *degradeLossFlag > 0 && loss > *degradeLossFlag:
+		reason = fmt.Sprintf("%.0f%% packet loss (threshold %.0f%%)", loss, *degradeLossFlag)
+	case *degradeLatencyFlag > 0 && avg > *degradeLatencyFlag:
+		reason = fmt.Sprintf("average latency %s (threshold %s)", ms(avg), *degradeLatencyFlag)
+	}
+
+	if reason == "" {
+		if w.alerted {
+			msg := fmt.Sprintf("Exit node %s recovered: average latency %s, %.0f%% loss", displayName(node), ms(avg), loss)
+			log.Print(msg)
+			notifyEvent(ctx, "exit-recovered", msg)
+		}
+		w.since, w.alerted = time.Time{}, false
+		return
+	}
+
+	if w.since.IsZero() {
+		w.since = time.Now()
+	}
+	if !w.alerted && time.Since(w.since) >= *degradeWindowFlag {
+		w.alerted = true
+		msg := fmt.Sprintf("Exit node %s degraded for %s: %s", displayName(node), time.Since(w.since).Round(time.Second), reason)
+		log.Print(msg)
+		notifyFailure(ctx, "exit-degraded", msg)
+	}
+}
+
+// exitLatency pings the active exit node checkLatencySamples times and
+// returns the average latency of the answered pings
+func exitLatency(ctx context.Context, lc LocalClient) (MullvadNode, time.Duration, error) {
+	node, ok, err := currentExitNode(ctx, lc)
+	if err != nil {
+		return node, 0, err
+	}
+	if !ok {
+		return node, 0, errors.New("no exit node active")
+	}
+	p, err := newProber(lc)
+	if err != nil {
+		return node, 0, err
+	}
+
+	stats := &pingStats{window: checkLatencySamples}
+	for range checkLatencySamples {
+		stats.add(p.ping(ctx, node))
+	}
+	_, _, avg, _, _, _ := stats.summary()
+	if avg == 0 {
+		return node, 0, fmt.Errorf("exit node %s answered none of %d pings", displayName(node), checkLatencySamples)
+	}
+	return node, avg, nil
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+func init() {
+	commands["doctor"] = command{
+		Usage: "Check the Tailscale setup for problems with using Mullvad exit nodes",
+		Run:   runDoctor,
+	}
+}
+
+// doctorReport collects the findings of doctor
+type doctorReport struct {
+	failures int
+}
+
+func (r *doctorReport) ok(format string, args ...any) {
+	fmt.Printf("OK    %s\n", fmt.Sprintf(format, args...))
+}
+
+func (r *doctorReport) warn(format string, args ...any) {
+	fmt.Printf("WARN  %s\n", fmt.Sprintf(format, args...))
+}
+
+func (r *doctorReport) fail(format string, args ...any) {
+	r.failures++
+	fmt.Printf("FAIL  %s\n", fmt.Sprintf(format, args...))
+}
+
+// runDoctor checks tailscaled, the Mullvad add-on, the exit node and the
+// host's routing setup. With --subnet-conflicts adjust, it also allows LAN
+// access where that resolves a subnet route conflict.
+func runDoctor(ctx context.Context, lc LocalClient, args []string) error {
+	var r doctorReport
+
+	status, err := lc.StatusWithoutPeers(ctx)
+	if err != nil {
+		r.fail("tailscaled is not reachable: %v", err)
+		return errors.New("tailscaled is not reachable")
+	}
+	version, _, _ := strings.Cut(status.Version, "-")
+	if version == "" {
+		version = "(unknown version)"
+	}
+	if status.BackendState != "Running" {
+		r.fail("tailscaled %s is %s, not Running (try tailscale up)", version, status.BackendState)
+	} else {
+		r.ok("tailscaled %s is running", version)
+	}
+	if err := requireTailscale(ctx, lc, featureMullvad); err != nil {
+		r.fail("%v", err)
+	}
+
+	if _, err := lc.GetPrefs(ctx); err != nil {
+		r.fail("cannot read prefs: %v", err)
+	}
+
+	nodes, err := getMullvadNodes(ctx, lc)
+	switch {
+	case err != nil:
+		r.fail("%v", err)
+	case len(nodes) == 0:
+		r.warn("no Mullvad exit nodes found")
+	default:
+		online := 0
+		for _, n := range nodes {
+			if n.Online {
+				online++
+			}
+		}
+		r.ok("%d Mullvad exit nodes, %d online", len(nodes), online)
+	}
+
+	if active, err := checkExitNode(ctx, lc); err != nil {
+		r.fail("exit node check: %v", err)
+	} else if active {
+		node, _, _ := currentExitNode(ctx, lc)
+		r.ok("exit node %s is active", displayName(node))
+		if results, err := probePorts(ctx); err == nil {
+			for _, res := range results {
+				switch {
+				case res.Blocked:
+					r.warn("%s %s through %s: %s", res.Probe, res.Status, displayName(node), res.Detail)
+				case res.Status == "error":
+					r.warn("%s could not be probed: %s", res.Probe, res.Detail)
+				default:
+					r.ok("%s %s through %s", res.Probe, res.Status, displayName(node))
+				}
+			}
+		}
+	} else {
+		r.warn("no exit node active, the WAN is unprotected")
+	}
+
+	if auto := tailscaleAuto(ctx, lc); auto != "" {
+		r.warn("tailscaled selects the exit node itself (%s), see --tailscale-auto", auto)
+	}
+
+	if iface, err := detectOtherVPN(ctx, lc); err == nil && iface != "" {
+		r.warn("another VPN is active on %s, see --other-vpn", iface)
+	}
+
+	conflicts, err := subnetRouteConflicts(ctx, lc)
+	if err != nil {
+		r.warn("could not check subnet routes: %v", err)
+	}
+	if len(conflicts) == 0 && err == nil {
+		r.ok("no subnet route conflicts")
+	}
+	var lanConflicts []routeConflict
+	for _, c := range conflicts {
+		switch {
+		case c.lanAccess && *subnetConflictsFlag == "adjust":
+			lanConflicts = append(lanConflicts, c)
+		case c.lanAccess:
+			r.warn("%s (fix: --subnet-conflicts adjust, or tailscale set --exit-node-allow-lan-access)", c.msg)
+		default:
+			r.warn("%s", c.msg)
+		}
+	}
+	if len(lanConflicts) > 0 {
+		err := allowLANAccess(ctx, lc)
+		for _, c := range lanConflicts {
+			if err != nil {
+				r.fail("%s, and allowing LAN access failed: %v", c.msg, err)
+			} else {
+				r.ok("allowed LAN access: %s", c.msg)
+			}
+		}
+	}
+
+	if r.failures > 0 {
+		return fmt.Errorf("%d problems found", r.failures)
+	}
+	return nil
+}
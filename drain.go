@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"time"
+
+	"tailscale.com/tailcfg"
+)
+
+var (
+	drainRateFlag    = flag.Int("drain-rate", 0, "Daemon: defer non-urgent switches while traffic through the exit node exceeds this many KB/s (0 to disable)")
+	drainMaxWaitFlag = flag.Duration("drain-max-wait", 15*time.Minute, "Daemon: switch anyway after deferring for active traffic this long")
+)
+
+// drainSampleInterval is how long Rx/Tx counters are watched to estimate
+// the traffic rate through the exit node
+const drainSampleInterval = 3 * time.Second
+
+// drainPending is when a switch was first deferred for active traffic,
+// zero if none is pending. The daemon retries pending switches on every
+// check until traffic quiesces or --drain-max-wait passes.
+var drainPending time.Time
+
+// exitTrafficRate returns the bytes per second sent and received through
+// the exit node, from the peer's Rx/Tx counters over drainSampleInterval
+func exitTrafficRate(ctx context.Context, lc LocalClient, id tailcfg.StableNodeID) (float64, error) {
+	before, err := peerBytes(ctx, lc, id)
+	if err != nil {
+		return 0, err
+	}
+
+	select {
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	case <-time.After(drainSampleInterval):
+	}
+
+	after, err := peerBytes(ctx, lc, id)
+	if err != nil {
+		return 0, err
+	}
+	return float64(max(after-before, 0)) / drainSampleInterval.Seconds(), nil
+}
+
+// peerBytes returns the total bytes exchanged with peer id
+func peerBytes(ctx context.Context, lc LocalClient, id tailcfg.StableNodeID) (int64, error) {
+	rx, tx, err := peerCounters(ctx, lc, id)
+	return rx + tx, err
+}
+
+// deferForTraffic reports whether a switch away from the working exit node
+// should wait for traffic through it to quiesce, so long downloads and
+// calls aren't cut off. A switch is deferred at most --drain-max-wait.
+func deferForTraffic(ctx context.Context, lc LocalClient, current MullvadNode, pending time.Time) (bool, string) {
+	if *drainRateFlag <= 0 {
+		return false, ""
+	}
+	if !pending.IsZero() && time.Since(pending) >= *drainMaxWaitFlag {
+		return false, ""
+	}
+
+	rate, err := exitTrafficRate(ctx, lc, current.ID)
+	if err != nil || rate < float64(*drainRateFlag)*1024 {
+		return false, ""
+	}
+
+	if pending.IsZero() {
+		pending = time.Now()
+	}
+	drainPending = pending
+	return true, fmt.Sprintf("%.0f KB/s of active traffic, switch deferred (waiting %s of --drain-max-wait %s)",
+		rate/1024, time.Since(pending).Round(time.Second), *drainMaxWaitFlag)
+}
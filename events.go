@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// event is one line of --output ndjson: a step of the run as it happens
+type event struct {
+	Time      time.Time  `json:"time"`
+	Event     string     `json:"event"`
+	Command   string     `json:"command,omitempty"`
+	Phase     int        `json:"phase,omitempty"`
+	Nodes     int        `json:"nodes,omitempty"`
+	Node      *eventNode `json:"node,omitempty"`
+	LatencyMS float64    `json:"latency_ms,omitempty"`
+	Source    string     `json:"source,omitempty"`
+	Reason    string     `json:"reason,omitempty"`
+	Error     string     `json:"error,omitempty"`
+}
+
+// eventNode identifies a node in an event
+type eventNode struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	MullvadName string `json:"mullvad_name"`
+	City        string `json:"city,omitempty"`
+	CountryCode string `json:"country_code,omitempty"`
+	Priority    int    `json:"priority"`
+	Online      bool   `json:"online"`
+}
+
+// events writes the event stream; nil unless --output ndjson
+var events struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+// startEvents sends the event stream to stdout. Everything else printed
+// to stdout goes to stderr instead, so the stream stays parseable.
+func startEvents() {
+	events.enc = json.NewEncoder(os.Stdout)
+	os.Stdout = os.Stderr
+}
+
+// emit writes an event, if the event stream is on
+func emit(e event) {
+	events.mu.Lock()
+	defer events.mu.Unlock()
+	if events.enc == nil {
+		return
+	}
+	e.Time = time.Now().UTC()
+	e.Error = strings.ReplaceAll(e.Error, "\n", "; ")
+	events.enc.Encode(e)
+}
+
+// emitNode writes an event about a node, with its latency if measured
+func emitNode(name string, node MullvadNode, e event) {
+	e.Event = name
+	e.Node = &eventNode{
+		ID:          string(node.ID),
+		Name:        strings.TrimSuffix(node.DNSName, "."),
+		MullvadName: mullvadHostname(node),
+		City:        node.City,
+		CountryCode: node.CountryCode,
+		Priority:    node.Priority,
+		Online:      node.Online,
+	}
+	if node.Latency > 0 && e.Error == "" {
+		e.LatencyMS = float64(node.Latency.Microseconds()) / 1000
+	}
+	emit(e)
+}
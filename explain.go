@@ -0,0 +1,125 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var explainFlag = flag.Bool("explain", false, "Explain the selection: filters applied, candidates eliminated and why, per-factor scores")
+
+// explainMaxNames caps how many node names are listed per elimination reason
+const explainMaxNames = 8
+
+// explanation records the decisions made during one selection run, so
+// --explain can show why the winner won and everything else lost
+type explanation struct {
+	mu         sync.Mutex
+	notes      []string
+	filters    []string
+	eliminated map[string][]string // reason -> node names
+	reasons    []string            // reasons in first-seen order
+}
+
+// explain collects the current selection run; reset by rankCandidates
+var explain = &explanation{}
+
+// reset starts a new selection run
+func (e *explanation) reset() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.notes = nil
+	e.filters = nil
+	e.eliminated = make(map[string][]string)
+	e.reasons = nil
+}
+
+// note records a general decision (strategy, phase sizes, ...)
+func (e *explanation) note(format string, args ...any) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.notes = append(e.notes, fmt.Sprintf(format, args...))
+}
+
+// filter records a filter step and how many nodes it kept
+func (e *explanation) filter(name string, before, after int) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.filters = append(e.filters, fmt.Sprintf("%s: %d -> %d nodes", name, before, after))
+}
+
+// eliminate records why nodes dropped out of the selection
+func (e *explanation) eliminate(reason string, nodes ...MullvadNode) {
+	if len(nodes) == 0 {
+		return
+	}
+	reason = strings.ReplaceAll(reason, "\n", "; ")
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.eliminated == nil {
+		e.eliminated = make(map[string][]string)
+	}
+	if _, ok := e.eliminated[reason]; !ok {
+		e.reasons = append(e.reasons, reason)
+	}
+	for _, node := range nodes {
+		e.eliminated[reason] = append(e.eliminated[reason], displayName(node))
+	}
+}
+
+// print shows the explanation for the ranked candidates (best first)
+func (e *explanation) print(ranked []MullvadNode) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	fmt.Printf("\nSelection explained:\n")
+
+	if len(e.notes) > 0 {
+		fmt.Printf("  Decisions:\n")
+		for _, n := range e.notes {
+			fmt.Printf("    - %s\n", n)
+		}
+	}
+
+	if len(e.filters) > 0 {
+		fmt.Printf("  Filters:\n")
+		for _, f := range e.filters {
+			fmt.Printf("    - %s\n", f)
+		}
+	}
+
+	fmt.Printf("  Scores (best first):\n")
+	fmt.Printf("    %-4s %-36s %-22s %8s %8s\n", "RANK", "HOSTNAME", "LOCATION", "LATENCY", "PRIORITY")
+	for i, node := range ranked[:min(10, len(ranked))] {
+		latency := "-"
+		if node.Latency > 0 {
+			latency = fmt.Sprintf("%dms", node.Latency.Milliseconds())
+		}
+		fmt.Printf("    %-4d %-36s %-22s %8s %8d\n",
+			i+1,
+			displayName(node),
+			fmt.Sprintf("%s, %s", node.City, node.CountryCode),
+			latency,
+			node.Priority)
+	}
+	if len(ranked) > 10 {
+		fmt.Printf("    ... and %d more ranked candidates\n", len(ranked)-10)
+	}
+
+	if len(e.reasons) > 0 {
+		fmt.Printf("  Eliminated:\n")
+		for _, reason := range e.reasons {
+			names := e.eliminated[reason]
+			sort.Strings(names)
+			shown := names[:min(explainMaxNames, len(names))]
+			more := ""
+			if len(names) > len(shown) {
+				more = fmt.Sprintf(", ... and %d more", len(names)-len(shown))
+			}
+			fmt.Printf("    - %s (%d): %s%s\n", reason, len(names), strings.Join(shown, ", "), more)
+		}
+	}
+}
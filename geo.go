@@ -0,0 +1,260 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"tailscale.com/tailcfg"
+)
+
+var (
+	locationFlag       = flag.String("location", "", "Your location for distance scoring: lat,lon, or auto to estimate it from your public IP (empty to disable)")
+	distanceWeightFlag = flag.Duration("distance-weight", time.Millisecond, "Added to a node's latency score per 1000 km between you and it, with --location")
+	geoCountriesFlag   = flag.Int("geo-countries", 0, "With --location, probe only the nearest N countries in Phase 1 (0 for all)")
+)
+
+const (
+	earthRadiusKm = 6371.0
+
+	// locationMaxAge is how long an estimated location of a network is
+	// reused before it is estimated again
+	locationMaxAge = 7 * 24 * time.Hour
+
+	// relayListMaxAge is how long a daemon reuses the Mullvad relay list
+	relayListMaxAge = 24 * time.Hour
+)
+
+// geoPoint is a position on Earth in degrees
+type geoPoint struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+}
+
+// locationEstimate is the location of the public IP of one network
+type locationEstimate struct {
+	geoPoint
+	Country string    `json:"country,omitempty"`
+	Time    time.Time `json:"time"`
+}
+
+// parseGeoPoint parses "lat,lon" in degrees
+func parseGeoPoint(s string) (geoPoint, error) {
+	latStr, lonStr, ok := strings.Cut(s, ",")
+	if !ok {
+		return geoPoint{}, errors.New("expected lat,lon")
+	}
+	lat, err := strconv.ParseFloat(strings.TrimSpace(latStr), 64)
+	if err != nil || lat < -90 || lat > 90 {
+		return geoPoint{}, fmt.Errorf("invalid latitude %q", latStr)
+	}
+	lon, err := strconv.ParseFloat(strings.TrimSpace(lonStr), 64)
+	if err != nil || lon < -180 || lon > 180 {
+		return geoPoint{}, fmt.Errorf("invalid longitude %q", lonStr)
+	}
+	return geoPoint{Latitude: lat, Longitude: lon}, nil
+}
+
+// distanceKm returns the great-circle distance between two points
+func distanceKm(a, b geoPoint) float64 {
+	rad := func(deg float64) float64 { return deg * math.Pi / 180 }
+	dLat := rad(b.Latitude - a.Latitude)
+	dLon := rad(b.Longitude - a.Longitude)
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(rad(a.Latitude))*math.Cos(rad(b.Latitude))*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadiusKm * math.Asin(math.Sqrt(min(h, 1)))
+}
+
+// estimateLocation returns --location: the given coordinates, or for auto
+// the coordinates of this network's public IP
+func estimateLocation(ctx context.Context, lc LocalClient) (geoPoint, error) {
+	if *locationFlag != "auto" {
+		return parseGeoPoint(*locationFlag)
+	}
+	e, err := homeLocation(ctx, lc)
+	if err != nil {
+		return geoPoint{}, fmt.Errorf("%w (use --location lat,lon)", err)
+	}
+	if e.Latitude == 0 && e.Longitude == 0 {
+		return geoPoint{}, errors.New("the connection check returned no coordinates")
+	}
+	return e.geoPoint, nil
+}
+
+// homeLocation returns where this network's public IP is according to
+// Mullvad's connection check. The estimate is remembered per network, as
+// it can only be made while no exit node hides the real public IP.
+func homeLocation(ctx context.Context, lc LocalClient) (locationEstimate, error) {
+	fp := ""
+	if stateEnabled() {
+		fp = networkFingerprint(currentNetwork())
+	}
+	var cached *locationEstimate
+	if fp != "" {
+		if st, err := loadState(); err == nil {
+			// Older entries lack the country, which --same-country-as-me needs
+			if e, ok := st.Locations[fp]; ok && e.Country != "" {
+				cached = &e
+			}
+		}
+	}
+	if cached != nil && time.Since(cached.Time) < locationMaxAge {
+		return *cached, nil
+	}
+
+	prefs, err := lc.GetPrefs(ctx)
+	if err != nil {
+		return locationEstimate{}, fmt.Errorf("failed to get prefs: %w", err)
+	}
+	if !prefs.ExitNodeID.IsZero() || prefs.ExitNodeIP.IsValid() {
+		if cached != nil {
+			return *cached, nil
+		}
+		return locationEstimate{}, errors.New("an exit node is active, so the public IP doesn't tell where you are")
+	}
+
+	info, err := fetchPublicIP(ctx, mullvadCheckURL)
+	if err != nil {
+		return locationEstimate{}, err
+	}
+	e := locationEstimate{
+		geoPoint: geoPoint{Latitude: info.Latitude, Longitude: info.Longitude},
+		Country:  info.Country,
+		Time:     time.Now(),
+	}
+
+	if fp != "" {
+		err := updateState(func(st *state) {
+			if st.Locations == nil {
+				st.Locations = make(map[string]locationEstimate)
+			}
+			st.Locations[fp] = e
+		})
+		if err != nil {
+			log.Printf("Warning: failed to update state: %v", err)
+		}
+	}
+	return e, nil
+}
+
+// relayListCache keeps the relay list between daemon selections
+var relayListCache struct {
+	sync.Mutex
+	list *relayList
+	time time.Time
+}
+
+// cachedRelays returns the Mullvad relay list, fetched at most once every
+// relayListMaxAge
+func cachedRelays(ctx context.Context) (*relayList, error) {
+	relayListCache.Lock()
+	defer relayListCache.Unlock()
+	if relayListCache.list == nil || time.Since(relayListCache.time) >= relayListMaxAge {
+		list, err := fetchRelays(ctx)
+		if err != nil {
+			return nil, err
+		}
+		relayListCache.list, relayListCache.time = list, time.Now()
+	}
+	return relayListCache.list, nil
+}
+
+// geoScorer scores nodes by latency plus a distance term. A nil geoScorer
+// scores by latency alone.
+type geoScorer struct {
+	origin   geoPoint
+	distance map[tailcfg.StableNodeID]float64 // Km, nodes with known coordinates only
+}
+
+// newGeoScorer returns the scorer for --location, or nil when it is not set
+// or the location or node coordinates are unavailable. Distance scoring is
+// an optimization, so failures only fall back to latency.
+func newGeoScorer(ctx context.Context, lc LocalClient, nodes []MullvadNode) *geoScorer {
+	if *locationFlag == "" {
+		return nil
+	}
+	origin, err := estimateLocation(ctx, lc)
+	if err == nil {
+		var relays *relayList
+		if relays, err = cachedRelays(ctx); err == nil {
+			g := &geoScorer{origin: origin, distance: make(map[tailcfg.StableNodeID]float64)}
+			for _, node := range nodes {
+				if loc, ok := relays.locationFor(node); ok {
+					g.distance[node.ID] = distanceKm(origin, geoPoint{loc.Latitude, loc.Longitude})
+				}
+			}
+			explain.note("Location %.2f,%.2f: countries probed nearest first, %s added to the score per 1000 km",
+				origin.Latitude, origin.Longitude, *distanceWeightFlag)
+			return g
+		}
+	}
+	if *verboseFlag {
+		fmt.Printf("Distance scoring unavailable: %v\n", err)
+	}
+	explain.note("Distance scoring unavailable: %v", err)
+	return nil
+}
+
+// score is a node's latency plus --distance-weight per 1000 km
+func (g *geoScorer) score(node MullvadNode) time.Duration {
+	if g == nil {
+		return node.Latency
+	}
+	km, ok := g.distance[node.ID]
+	if !ok {
+		return node.Latency
+	}
+	return node.Latency + time.Duration(km/1000*float64(*distanceWeightFlag))
+}
+
+// sort orders tested nodes by score, best first
+func (g *geoScorer) sort(nodes []MullvadNode) {
+	sort.SliceStable(nodes, func(i, j int) bool {
+		return g.score(nodes[i]) < g.score(nodes[j])
+	})
+}
+
+// countryDistance is the distance to the nearest node of a country, or
+// +Inf if none has coordinates
+func (g *geoScorer) countryDistance(group *countryGroup) float64 {
+	d := math.Inf(1)
+	for _, node := range group.Nodes {
+		if km, ok := g.distance[node.ID]; ok {
+			d = min(d, km)
+		}
+	}
+	return d
+}
+
+// orderCountries pre-orders the Phase 1 countries nearest first, so the
+// probe pool and a --good-enough cutoff reach the likely fastest countries
+// first, and keeps only the nearest --geo-countries
+func (g *geoScorer) orderCountries(groups []*countryGroup) []*countryGroup {
+	if g == nil {
+		return groups
+	}
+	dist := make(map[*countryGroup]float64, len(groups))
+	for _, group := range groups {
+		dist[group] = g.countryDistance(group)
+	}
+	sort.SliceStable(groups, func(i, j int) bool {
+		return dist[groups[i]] < dist[groups[j]]
+	})
+
+	if n := *geoCountriesFlag; n > 0 && n < len(groups) {
+		for _, group := range groups[n:] {
+			explain.eliminate(fmt.Sprintf("country %s is %.0f km away, outside the %d nearest (--geo-countries)",
+				group.CountryCode, dist[group], n), group.Nodes...)
+		}
+		groups = groups[:n]
+	}
+	return groups
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+var outputFlag = flag.String("output", "text", "Output format: text, geojson (list) or ndjson (one JSON event per line as the run progresses)")
+
+// geoJSON types, as far as a point map needs them (RFC 7946)
+type (
+	featureCollection struct {
+		Type     string    `json:"type"` // FeatureCollection
+		Features []feature `json:"features"`
+	}
+	feature struct {
+		Type       string            `json:"type"` // Feature
+		Geometry   point             `json:"geometry"`
+		Properties featureProperties `json:"properties"`
+	}
+	point struct {
+		Type        string     `json:"type"`        // Point
+		Coordinates [2]float64 `json:"coordinates"` // Longitude, latitude
+	}
+)
+
+// featureProperties describe one node on the map
+type featureProperties struct {
+	Name        string  `json:"name"`
+	MullvadName string  `json:"mullvad_name"`
+	Country     string  `json:"country"`
+	CountryCode string  `json:"country_code"`
+	City        string  `json:"city"`
+	Online      bool    `json:"online"`
+	Priority    int     `json:"priority"`
+	Provider    string  `json:"provider,omitempty"`
+	LatencyMs   float64 `json:"latency_ms,omitempty"` // Cached for the current network
+}
+
+// writeGeoJSON prints nodes as a GeoJSON FeatureCollection for map tools
+// such as geojson.io or Grafana's geomap. Coordinates come from the Mullvad
+// relay list; latencies from the latency cache of the current network.
+func writeGeoJSON(ctx context.Context, nodes []MullvadNode) error {
+	relays, err := fetchRelays(ctx)
+	if err != nil {
+		return err
+	}
+	cache := openLatencyCache()
+
+	fc := featureCollection{Type: "FeatureCollection", Features: []feature{}}
+	var missing []string
+	for _, node := range nodes {
+		loc, ok := relays.locationFor(node)
+		if !ok {
+			missing = append(missing, mullvadHostname(node))
+			continue
+		}
+		props := featureProperties{
+			Name:        strings.TrimSuffix(node.DNSName, "."),
+			MullvadName: mullvadHostname(node),
+			Country:     node.Country,
+			CountryCode: node.CountryCode,
+			City:        node.City,
+			Online:      node.Online,
+			Priority:    node.Priority,
+		}
+		if r, ok := relays.relayFor(node); ok {
+			props.Provider = r.Provider
+		}
+		if cache != nil {
+			props.LatencyMs = msOf(cache.cached[node.ID].Latency)
+		}
+		fc.Features = append(fc.Features, feature{
+			Type:       "Feature",
+			Geometry:   point{Type: "Point", Coordinates: [2]float64{loc.Longitude, loc.Latitude}},
+			Properties: props,
+		})
+	}
+	if len(missing) > 0 {
+		fmt.Fprintf(os.Stderr, "Warning: no coordinates for %d nodes: %s\n", len(missing), strings.Join(missing, ", "))
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(fc)
+}
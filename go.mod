@@ -2,7 +2,10 @@ module protect-wan
 
 go 1.25.4
 
-require tailscale.com v1.92.0
+require (
+	go.etcd.io/bbolt v1.4.3
+	tailscale.com v1.92.0
+)
 
 require (
 	filippo.io/edwards25519 v1.1.0 // indirect
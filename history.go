@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+// statsWeeks is how many recent weeks stats breaks the history down by
+const statsWeeks = 8
+
+// dayRecord is the protection history of one day: how long the daemon saw
+// a working exit node, the automatic switches and the latency measured to
+// the exit nodes selected
+type dayRecord struct {
+	Day         string        `json:"day"`                   // 2006-01-02, local time
+	Protected   time.Duration `json:"protected,omitempty"`   // Time between daemon checks with a working exit node
+	Unprotected time.Duration `json:"unprotected,omitempty"` // Time between daemon checks without one
+	Switches    int           `json:"switches,omitempty"`    // Automatic switches
+
+	Selections          int           `json:"selections,omitempty"` // Selections with a measured latency
+	SelectionLatencySum time.Duration `json:"selection_latency_sum,omitempty"`
+}
+
+// dayFor returns the record for the day of t, adding it if needed
+func (st *state) dayFor(t time.Time) *dayRecord {
+	day := t.Format(time.DateOnly)
+	for i := range st.Days {
+		if st.Days[i].Day == day {
+			return &st.Days[i]
+		}
+	}
+	st.Days = append(st.Days, dayRecord{Day: day})
+	return &st.Days[len(st.Days)-1]
+}
+
+// sampleProtection accounts the time since the daemon's previous check as
+// protected or not, by whether this check found a working exit node. A gap
+// of more than two check intervals means the daemon wasn't running or was
+// paused, and isn't counted either way.
+func sampleProtection(active bool, interval time.Duration) {
+	if !stateEnabled() {
+		return
+	}
+	now := time.Now()
+	err := updateState(func(st *state) {
+		if elapsed := now.Sub(st.ProtectionSample); !st.ProtectionSample.IsZero() && elapsed > 0 && elapsed <= 2*interval {
+			day := st.dayFor(now)
+			if active {
+				day.Protected += elapsed
+			} else {
+				day.Unprotected += elapsed
+			}
+		}
+		st.ProtectionSample = now
+	})
+	if err != nil {
+		log.Printf("Warning: failed to update state: %v", err)
+	}
+}
+
+// historyTotal sums day records
+type historyTotal struct {
+	Protected, Unprotected time.Duration
+	Switches, Selections   int
+	SelectionLatencySum    time.Duration
+}
+
+func (t *historyTotal) add(d dayRecord) {
+	t.Protected += d.Protected
+	t.Unprotected += d.Unprotected
+	t.Switches += d.Switches
+	t.Selections += d.Selections
+	t.SelectionLatencySum += d.SelectionLatencySum
+}
+
+// protectedShare returns the percentage of watched time that was
+// protected, and whether any time was watched
+func (t historyTotal) protectedShare() (float64, bool) {
+	watched := t.Protected + t.Unprotected
+	if watched == 0 {
+		return 0, false
+	}
+	return 100 * float64(t.Protected) / float64(watched), true
+}
+
+// meanSelectionLatency returns the mean latency at selection time, 0 if
+// none was measured
+func (t historyTotal) meanSelectionLatency() time.Duration {
+	if t.Selections == 0 {
+		return 0
+	}
+	return t.SelectionLatencySum / time.Duration(t.Selections)
+}
+
+// weekStart returns the Monday starting the week of day
+func weekStart(day string) string {
+	t, err := time.ParseInLocation(time.DateOnly, day, time.Local)
+	if err != nil {
+		return day
+	}
+	return t.AddDate(0, 0, -(int(t.Weekday())+6)%7).Format(time.DateOnly)
+}
+
+// printHistory prints the protection summary of the day records, overall
+// and for the last statsWeeks weeks
+func printHistory(days []dayRecord) {
+	var total historyTotal
+	weeks := make(map[string]*historyTotal)
+	first, last := days[0].Day, days[0].Day
+	for _, d := range days {
+		total.add(d)
+		first, last = min(first, d.Day), max(last, d.Day)
+		w := weekStart(d.Day)
+		if weeks[w] == nil {
+			weeks[w] = &historyTotal{}
+		}
+		weeks[w].add(d)
+	}
+
+	fmt.Printf("Protection since %s:\n", first)
+	if share, ok := total.protectedShare(); ok {
+		fmt.Printf("  Protected: %.1f%% of %.1f days watched by the daemon\n", share, (total.Protected+total.Unprotected).Hours()/24)
+	}
+	if t, err := time.ParseInLocation(time.DateOnly, first, time.Local); err == nil {
+		end, _ := time.ParseInLocation(time.DateOnly, last, time.Local)
+		spanWeeks := max(end.Sub(t).Hours()/24+1, 7) / 7
+		fmt.Printf("  Switches: %.1f per week (%d in total)\n", float64(total.Switches)/spanWeeks, total.Switches)
+	}
+	if mean := total.meanSelectionLatency(); mean > 0 {
+		fmt.Printf("  Mean latency at selection: %s (%d selections)\n", ms(mean), total.Selections)
+	}
+
+	fmt.Printf("\nBy week:\n")
+	fmt.Printf("  %-12s %8s %10s %14s\n", "WEEK OF", "SWITCHES", "PROTECTED", "SEL. LATENCY")
+	week := weekStart(last)
+	for range statsWeeks {
+		if w := weeks[week]; w != nil {
+			protected, latency := "-", "-"
+			if share, ok := w.protectedShare(); ok {
+				protected = fmt.Sprintf("%.1f%%", share)
+			}
+			if mean := w.meanSelectionLatency(); mean > 0 {
+				latency = ms(mean)
+			}
+			fmt.Printf("  %-12s %8d %10s %14s\n", week, w.Switches, protected, latency)
+		}
+		if week <= first {
+			break
+		}
+		week = weekStart(prevDay(week))
+	}
+}
+
+// prevDay returns the day before day
+func prevDay(day string) string {
+	t, err := time.ParseInLocation(time.DateOnly, day, time.Local)
+	if err != nil {
+		return day
+	}
+	return t.AddDate(0, 0, -1).Format(time.DateOnly)
+}
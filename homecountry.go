@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"strings"
+)
+
+var (
+	sameCountryFlag      = flag.Bool("same-country-as-me", false, "Only use exit nodes in the country of your public IP, e.g. to keep a local IP for banking")
+	differentCountryFlag = flag.Bool("different-country-than-me", false, "Only use exit nodes outside the country of your public IP, e.g. to exit outside your jurisdiction")
+	homeCountryFlag      = flag.String("home-country", "", "Your country for --same-country-as-me and --different-country-than-me, instead of detecting it from your public IP")
+)
+
+// homeCountry returns the ISO code of the country the user is in:
+// --home-country, or else the country of this network's public IP
+func homeCountry(ctx context.Context, lc LocalClient, nodes []MullvadNode) (string, error) {
+	if *homeCountryFlag != "" {
+		return resolveCountry(*homeCountryFlag, nodes)
+	}
+	e, err := homeLocation(ctx, lc)
+	if err != nil {
+		return "", err
+	}
+	if e.Country == "" {
+		return "", errors.New("the connection check returned no country")
+	}
+	return resolveCountry(e.Country, nodes)
+}
+
+// filterByHomeCountry applies --same-country-as-me and
+// --different-country-than-me. When the country can't be detected, e.g.
+// while an exit node hides the public IP on a new network, the filter is
+// skipped with a warning rather than failing the selection, which would
+// keep a dead exit node from being replaced. An invalid --home-country
+// still fails.
+func filterByHomeCountry(ctx context.Context, lc LocalClient, nodes []MullvadNode) ([]MullvadNode, error) {
+	if !*sameCountryFlag && !*differentCountryFlag {
+		return nodes, nil
+	}
+	policy := "--same-country-as-me"
+	if *differentCountryFlag {
+		policy = "--different-country-than-me"
+	}
+
+	code, err := homeCountry(ctx, lc, nodes)
+	if err != nil && *homeCountryFlag != "" {
+		return nil, fmt.Errorf("invalid --home-country for %s: %w", policy, err)
+	}
+	if err != nil {
+		fmt.Printf("Warning: failed to determine your country, ignoring %s: %v (set --home-country)\n", policy, err)
+		explain.note("Your country is unknown, %s skipped", policy)
+		return nodes, nil
+	}
+
+	filtered := make([]MullvadNode, 0)
+	for _, node := range nodes {
+		same := strings.EqualFold(node.CountryCode, code)
+		switch {
+		case *sameCountryFlag && !same:
+			explain.eliminate(fmt.Sprintf("outside your country %s (%s)", code, policy), node)
+		case *differentCountryFlag && same:
+			explain.eliminate(fmt.Sprintf("in your country %s (%s)", code, policy), node)
+		default:
+			filtered = append(filtered, node)
+		}
+	}
+	explain.filter(fmt.Sprintf("%s (you are in %s)", strings.TrimPrefix(policy, "--"), code), len(nodes), len(filtered))
+	if len(filtered) == 0 {
+		if *sameCountryFlag {
+			return nil, fmt.Errorf("no Mullvad exit nodes found in your country %s", code)
+		}
+		return nil, fmt.Errorf("no Mullvad exit nodes found outside your country %s", code)
+	}
+	return filtered, nil
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"tailscale.com/ipn"
+)
+
+func TestFilterByHomeCountry(t *testing.T) {
+	tests := []struct {
+		name    string
+		flags   map[string]string
+		want    int // Nodes left
+		wantErr bool
+	}{
+		{name: "same country", flags: map[string]string{"same-country-as-me": "true", "home-country": "CH"}, want: 2},
+		{name: "different country", flags: map[string]string{"different-country-than-me": "true", "home-country": "CH"}, want: 3},
+		{name: "invalid home country", flags: map[string]string{"same-country-as-me": "true", "home-country": "Atlantis"}, wantErr: true},
+		// The exit node hides the public IP and nothing is cached
+		{name: "unknown country skips the filter", flags: map[string]string{"same-country-as-me": "true"}, want: 5},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			fake := newTestClient(t, testTailnet...)
+			for name, value := range tt.flags {
+				withFlag(t, name, value)
+			}
+			mp := &ipn.MaskedPrefs{Prefs: ipn.Prefs{ExitNodeID: "se1"}, ExitNodeIDSet: true}
+			if _, err := fake.EditPrefs(ctx, mp); err != nil {
+				t.Fatalf("EditPrefs: %v", err)
+			}
+			nodes, err := getMullvadNodes(ctx, fake)
+			if err != nil {
+				t.Fatalf("getMullvadNodes: %v", err)
+			}
+
+			filtered, err := filterByHomeCountry(ctx, fake, nodes)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("filterByHomeCountry = %v, want an error", nodeIDs(filtered))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("filterByHomeCountry: %v", err)
+			}
+			if len(filtered) != tt.want {
+				t.Errorf("filterByHomeCountry = %v, want %d nodes", nodeIDs(filtered), tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"time"
+
+	"tailscale.com/tailcfg"
+)
+
+var (
+	switchImprovementFlag = flag.Float64("switch-min-improvement", 20, "Daemon: only leave a working exit node for one at least this many percent faster (0 to disable)")
+	switchDwellFlag       = flag.Duration("switch-min-dwell", 10*time.Minute, "Daemon: keep a working exit node at least this long before switching away (0 to disable)")
+	switchCooldownFlag    = flag.Duration("switch-cooldown", 5*time.Minute, "Daemon: minimum time between automatic switches (0 to disable)")
+)
+
+// autoSwitching is set by the daemon, whose re-selections are subject to
+// hysteresis. One-shot runs always apply the best node.
+var autoSwitching bool
+
+type currentActiveKey struct{}
+
+// withCurrentActive records for the selection under ctx whether the
+// current exit node passed checkExitNode, which hysteresis and the churn
+// guardrail require to hold it
+func withCurrentActive(ctx context.Context, active bool) context.Context {
+	return context.WithValue(ctx, currentActiveKey{}, active)
+}
+
+// currentActive reports whether the selection under ctx found the current
+// exit node working. Not without a check.
+func currentActive(ctx context.Context) bool {
+	active, _ := ctx.Value(currentActiveKey{}).(bool)
+	return active
+}
+
+// holdCurrent reports whether the active exit node should be kept instead
+// of switching to best, and why. Only a working exit node is held: one that
+// failed checkExitNode (active is false), is offline or no longer matches
+// the filters is always replaced.
+func holdCurrent(ctx context.Context, lc LocalClient, active bool, best MullvadNode, candidates []MullvadNode) (bool, string) {
+	pending := drainPending
+	drainPending = time.Time{}
+	if !active {
+		return false, ""
+	}
+
+	current, ok, err := currentExitNode(ctx, lc)
+	if err != nil || !ok || current.ID == best.ID {
+		return false, ""
+	}
+	i := nodeIndex(candidates, current.ID)
+	if i < 0 {
+		return false, ""
+	}
+	current = candidates[i]
+
+	if stateEnabled() {
+		st, err := loadState()
+		if err != nil {
+			log.Printf("Warning: failed to load state: %v", err)
+		} else {
+			since := st.ExitNodeSince
+			if st.ExitNode != current.ID {
+				// Set by hand or by another tool: the dwell time starts now
+				since = time.Now()
+				if err := updateState(func(st *state) { st.ExitNode, st.ExitNodeSince = current.ID, since }); err != nil {
+					log.Printf("Warning: failed to update state: %v", err)
+				}
+			}
+			if d := time.Since(since); d < *switchDwellFlag {
+				return true, fmt.Sprintf("active for only %s (--switch-min-dwell %s)", d.Round(time.Second), *switchDwellFlag)
+			}
+			if d := time.Since(st.LastSwitch); !st.LastSwitch.IsZero() && d < *switchCooldownFlag {
+				return true, fmt.Sprintf("last switch %s ago (--switch-cooldown %s)", d.Round(time.Second), *switchCooldownFlag)
+			}
+		}
+	}
+
+	if hold, reason := marginalImprovement(ctx, lc, current, best); hold {
+		return true, reason
+	}
+	if hold, reason := noisyImprovement(ctx, lc, current, best); hold {
+		return true, reason
+	}
+	return deferForTraffic(ctx, lc, current, pending)
+}
+
+// marginalImprovement reports whether best is too little faster than current
+// to switch, and why
+func marginalImprovement(ctx context.Context, lc LocalClient, current, best MullvadNode) (bool, string) {
+	// Without a measured latency (e.g. --strategy priority) there is
+	// nothing to compare
+	if *switchImprovementFlag <= 0 || best.Latency <= 0 {
+		return false, ""
+	}
+	latency := current.Latency
+	if latency <= 0 {
+		p, err := newProber(lc)
+		if err != nil {
+			return false, ""
+		}
+		if latency, err = p.ping(ctx, current); err != nil {
+			return false, ""
+		}
+	}
+	improvement := float64(latency-best.Latency) / float64(latency) * 100
+	if improvement < *switchImprovementFlag {
+		return true, fmt.Sprintf("%s is only %.0f%% faster (%s vs %s, --switch-min-improvement %.0f%%)",
+			displayName(best), improvement, ms(best.Latency), ms(latency), *switchImprovementFlag)
+	}
+	return false, ""
+}
+
+// recordSwitch remembers an automatic switch for --switch-cooldown,
+// --switch-min-dwell and --max-switches-per-hour. Re-applying the same node
+// is not a switch.
+func recordSwitch(id tailcfg.StableNodeID) {
+	if !stateEnabled() {
+		return
+	}
+	now := time.Now()
+	err := updateState(func(st *state) {
+		if st.ExitNode == id {
+			return
+		}
+		st.LastSwitch = now
+		st.ExitNode, st.ExitNodeSince = id, now
+
+		st.Switches = append(st.Switches, now)
+		for len(st.Switches) > 0 && now.Sub(st.Switches[0]) >= switchHistory {
+			st.Switches = st.Switches[1:]
+		}
+		st.dayFor(now).Switches++
+		switchesCounter.inc()
+	})
+	if err != nil {
+		log.Printf("Warning: failed to update state: %v", err)
+	}
+}
+
+// nodeIndex returns the index of the node with id, or -1
+func nodeIndex(nodes []MullvadNode, id tailcfg.StableNodeID) int {
+	for i, node := range nodes {
+		if node.ID == id {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"tailscale.com/ipn"
+	"tailscale.com/tailcfg"
+)
+
+func TestHoldCurrent(t *testing.T) {
+	tests := []struct {
+		name       string
+		current    tailcfg.StableNodeID
+		active     bool          // Whether current passed checkExitNode
+		since      time.Duration // How long current has been active
+		lastSwitch time.Duration // How long ago the last switch was, 0 for never
+		wantHold   string        // Flag named by the reason, "" to switch
+	}{
+		{name: "within dwell time", current: "se1", active: true, since: time.Minute, wantHold: "--switch-min-dwell"},
+		{name: "within cooldown", current: "se1", active: true, since: time.Hour, lastSwitch: time.Minute, wantHold: "--switch-cooldown"},
+		{name: "marginal improvement", current: "ch2", active: true, since: time.Hour, wantHold: "--switch-min-improvement"},
+		{name: "worthwhile improvement", current: "se1", active: true, since: time.Hour},
+		{name: "current is best", current: "de1", active: true, since: time.Minute},
+		{name: "current offline", current: "de2", since: time.Minute},
+		{name: "not working within dwell time", current: "se1", since: time.Minute},
+		{name: "not working within cooldown", current: "ch2", since: time.Hour, lastSwitch: time.Minute},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			fake := newTestClient(t, testTailnet...)
+			candidates, err := rankCandidates(ctx, fake)
+			if err != nil {
+				t.Fatalf("rankCandidates: %v", err)
+			}
+
+			mp := &ipn.MaskedPrefs{Prefs: ipn.Prefs{ExitNodeID: tt.current}, ExitNodeIDSet: true}
+			if _, err := fake.EditPrefs(ctx, mp); err != nil {
+				t.Fatalf("EditPrefs: %v", err)
+			}
+			now := time.Now()
+			err = updateState(func(st *state) {
+				st.ExitNode, st.ExitNodeSince = tt.current, now.Add(-tt.since)
+				if tt.lastSwitch > 0 {
+					st.LastSwitch = now.Add(-tt.lastSwitch)
+				}
+			})
+			if err != nil {
+				t.Fatalf("updateState: %v", err)
+			}
+
+			hold, reason := holdCurrent(ctx, fake, tt.active, candidates[0], candidates)
+			switch {
+			case tt.wantHold == "" && hold:
+				t.Errorf("holdCurrent held %s (%s), want a switch to %s", tt.current, reason, candidates[0].ID)
+			case tt.wantHold != "" && !hold:
+				t.Errorf("holdCurrent switched away from %s, want it held by %s", tt.current, tt.wantHold)
+			case tt.wantHold != "" && !strings.Contains(reason, tt.wantHold):
+				t.Errorf("holdCurrent reason = %q, want it to name %s", reason, tt.wantHold)
+			}
+		})
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+var langFlag = flag.String("lang", "", "Language of the output for people: en, de, es or fr (default: from LC_ALL, LC_MESSAGES, LANG or the system; scripts parsing the output should pass en)")
+
+// language is the language user-facing output is translated to, "en" for
+// none. Logs, JSON output, events and the details of errors stay in
+// English, so they can be searched for and parsed.
+var language = "en"
+
+// setLanguage picks the output language from --lang or the locale. An
+// unsupported locale falls back to English; an unsupported --lang is an
+// error.
+func setLanguage() error {
+	language = "en"
+	if *langFlag != "" {
+		lang := localeLanguage(*langFlag)
+		if _, ok := translations[lang]; !ok && lang != "en" {
+			return fmt.Errorf("unsupported language %q (expected one of %s)", *langFlag, strings.Join(languages(), ", "))
+		}
+		language = lang
+		return nil
+	}
+
+	// The first locale variable set wins, as with gettext, even if its
+	// language is unsupported
+	locale := os.Getenv("LC_ALL")
+	if locale == "" {
+		locale = os.Getenv("LC_MESSAGES")
+	}
+	if locale == "" {
+		locale = os.Getenv("LANG")
+	}
+	if locale == "" {
+		locale = systemLocale()
+	}
+	if lang := localeLanguage(locale); translations[lang] != nil {
+		language = lang
+	}
+	return nil
+}
+
+// localeLanguage returns the language code of a locale such as de_DE.UTF-8,
+// fr-CA or C
+func localeLanguage(locale string) string {
+	lang, _, _ := strings.Cut(locale, ".")
+	lang, _, _ = strings.Cut(lang, "@")
+	lang, _, _ = strings.Cut(strings.ReplaceAll(lang, "-", "_"), "_")
+	lang = strings.ToLower(lang)
+	if lang == "c" || lang == "posix" {
+		return "en"
+	}
+	return lang
+}
+
+// languages lists the supported languages
+func languages() []string {
+	langs := []string{"en"}
+	for lang := range translations {
+		langs = append(langs, lang)
+	}
+	sort.Strings(langs[1:])
+	return langs
+}
+
+// tr translates a user-facing message, which is its own key, as with
+// gettext. Messages without a translation stay in English.
+func tr(msg string) string {
+	if t, ok := translations[language][msg]; ok {
+		return t
+	}
+	return msg
+}
+
+// trf formats a translated message; translations keep the verbs of the
+// English format in the same order
+func trf(format string, args ...any) string {
+	return fmt.Sprintf(tr(format), args...)
+}
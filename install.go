@@ -0,0 +1,488 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+)
+
+func init() {
+	commands["install-cron"] = command{
+		Usage: "Run protect-wan from cron with the current flags: install-cron [schedule|remove] (default every 5 minutes)",
+		Run:   runInstallCron,
+	}
+	commands["install-timer"] = command{
+		Usage: "Run protect-wan from a systemd timer with the current flags: install-timer [interval|remove] (default 5m)",
+		Run:   runInstallTimer,
+	}
+}
+
+const (
+	defaultCronSchedule  = "*/5 * * * *"
+	defaultTimerInterval = 5 * time.Minute
+
+	// cronMarker precedes the crontab line managed by install-cron
+	cronMarker = "# protect-wan install-cron"
+	// timerUnit is the name of the systemd units written by install-timer
+	timerUnit = "protect-wan"
+)
+
+// installSkipFlags are not carried into the scheduled command: one-shot
+// modes, the daemon and offline inputs
+var installSkipFlags = map[string]bool{
+	"check":          true,
+	"list":           true,
+	"auto":           true,
+	"disable":        true,
+	"set":            true,
+	"portal-bypass":  true,
+	"daemon":         true,
+	"monitor-only":   true,
+	"replay":         true,
+	"replay-latency": true,
+	"snapshot":       true,
+}
+
+// installSecretFlags are kept out of the scheduled command line, which
+// ends up in a world-readable unit, the crontab and the output. Their
+// values go to a private environment file as these variables instead.
+var installSecretFlags = map[string]string{
+	"smtp-password":  "PROTECT_WAN_SMTP_PASSWORD",
+	"ntfy-token":     "PROTECT_WAN_NTFY_TOKEN",
+	"ntfy-topic":     "PROTECT_WAN_NTFY_TOPIC",     // The topic is the only secret of a public one
+	"notify-webhook": "PROTECT_WAN_NOTIFY_WEBHOOK", // Webhook URLs are often bearer URLs
+	"alert-webhook":  "PROTECT_WAN_ALERT_WEBHOOK",
+	"proxy":          "PROTECT_WAN_PROXY", // May carry user:pass@
+}
+
+// installPathFlags take file paths, which are made absolute since the
+// scheduled command doesn't run in the current directory
+var installPathFlags = map[string]bool{
+	"config":        true,
+	"state":         true,
+	"log-file":      true,
+	"audit-log":     true,
+	"policy":        true,
+	"wireguard-dir": true,
+}
+
+// scheduledCommand returns the command line a scheduled run executes: this
+// binary with the flags given on the current command line. Secret flags
+// are returned separately, as the environment variables they fall back to.
+func scheduledCommand() ([]string, map[string]string, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to locate protect-wan: %w", err)
+	}
+	if resolved, err := filepath.EvalSymlinks(exe); err == nil {
+		exe = resolved
+	}
+
+	var names []string
+	for name := range commandLineFlags {
+		if !installSkipFlags[name] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	cmd := []string{exe}
+	secrets := make(map[string]string)
+	for _, name := range names {
+		value := flag.Lookup(name).Value.String()
+		if env, ok := installSecretFlags[name]; ok {
+			if value != "" {
+				secrets[env] = value
+			}
+			continue
+		}
+		if installPathFlags[name] && value != "" {
+			if abs, err := filepath.Abs(value); err == nil {
+				value = abs
+			}
+		}
+		cmd = append(cmd, fmt.Sprintf("--%s=%s", name, value))
+	}
+	return cmd, secrets, nil
+}
+
+// secretsPath returns the environment file holding the secret flags of
+// the runs scheduled by install-<kind>
+func secretsPath(kind string) (string, error) {
+	dir, err := configDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, kind+".env"), nil
+}
+
+// writeSecrets writes the secret flags of scheduled runs to path, readable
+// by the owner only, or removes it when there are none. The file is valid
+// both as a shell script and as a systemd EnvironmentFile=.
+func writeSecrets(path string, secrets map[string]string) error {
+	if len(secrets) == 0 {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+	names := make([]string, 0, len(secrets))
+	for name := range secrets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Written by protect-wan for its scheduled runs\n")
+	for _, name := range names {
+		if strings.ContainsAny(secrets[name], "'\n") {
+			return fmt.Errorf("%s can't be written to an environment file: it contains a quote or newline", name)
+		}
+		fmt.Fprintf(&b, "%s='%s'\n", name, secrets[name])
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	// Tighten an existing file before writing the secrets to it
+	if err := os.Chmod(path, 0o600); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if err := os.WriteFile(path, []byte(b.String()), 0o600); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return nil
+}
+
+// removeSecrets removes the environment file of install-<kind>
+func removeSecrets(kind string) error {
+	path, err := secretsPath(kind)
+	if err != nil {
+		return err
+	}
+	return writeSecrets(path, nil)
+}
+
+// printSecrets tells where the secret flags went, without their values
+func printSecrets(path string, secrets map[string]string) {
+	if len(secrets) == 0 {
+		return
+	}
+	names := make([]string, 0, len(secrets))
+	for name := range secrets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fmt.Printf("%s written to %s (readable by you only)\n", strings.Join(names, ", "), path)
+}
+
+var shellSafe = regexp.MustCompile(`^[A-Za-z0-9_@%+=:,./-]+$`)
+
+// shellQuote quotes s for a POSIX shell
+func shellQuote(s string) string {
+	if shellSafe.MatchString(s) {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// shellJoin quotes and joins a command line for a POSIX shell
+func shellJoin(args []string) string {
+	quoted := make([]string, len(args))
+	for i, a := range args {
+		quoted[i] = shellQuote(a)
+	}
+	return strings.Join(quoted, " ")
+}
+
+// cronLock returns the command prefix that skips a run while the previous
+// one is still going: flock on Linux, lockf on macOS and the BSDs
+func cronLock() []string {
+	dir, err := stateDir()
+	if err == nil {
+		err = os.MkdirAll(dir, 0o755)
+	}
+	if err != nil {
+		dir = os.TempDir()
+	}
+	lock := filepath.Join(dir, "cron.lock")
+	if path, err := exec.LookPath("flock"); err == nil {
+		return []string{path, "-n", lock}
+	}
+	if path, err := exec.LookPath("lockf"); err == nil {
+		return []string{path, "-t", "0", lock}
+	}
+	return nil
+}
+
+// runInstallCron adds (or replaces) the protect-wan line in the current
+// user's crontab. cron runs commands with a minimal PATH and mails their
+// output, so the line sets the current PATH and discards stdout: only
+// errors and warnings, on stderr, are mailed.
+func runInstallCron(ctx context.Context, lc LocalClient, args []string) error {
+	if runtime.GOOS == "windows" {
+		return errors.New("cron is not available on Windows; use the Task Scheduler, or run --daemon as a service")
+	}
+	if len(args) > 1 {
+		return fmt.Errorf("usage: %s install-cron ['<schedule>'|remove] [flags]", os.Args[0])
+	}
+
+	current, err := readCrontab(ctx)
+	if err != nil {
+		return err
+	}
+	kept := removeCronEntry(current)
+
+	if len(args) == 1 && args[0] == "remove" {
+		if kept == current {
+			fmt.Println("No protect-wan crontab entry found")
+			return nil
+		}
+		if err := writeCrontab(ctx, kept); err != nil {
+			return err
+		}
+		if err := removeSecrets("cron"); err != nil {
+			return err
+		}
+		fmt.Println("Removed the protect-wan crontab entry")
+		return nil
+	}
+
+	schedule := defaultCronSchedule
+	if len(args) == 1 {
+		schedule = args[0]
+	}
+	if fields := strings.Fields(schedule); len(fields) != 5 && !strings.HasPrefix(schedule, "@") {
+		return fmt.Errorf("invalid cron schedule %q (expected 5 fields, e.g. '*/5 * * * *', or @hourly)", schedule)
+	}
+
+	cmd, secrets, err := scheduledCommand()
+	if err != nil {
+		return err
+	}
+	secretsFile, err := secretsPath("cron")
+	if err != nil {
+		return err
+	}
+	if err := writeSecrets(secretsFile, secrets); err != nil {
+		return err
+	}
+	lock := cronLock()
+	if lock == nil {
+		fmt.Fprintln(os.Stderr, "Warning: neither flock nor lockf found, overlapping runs are not prevented")
+	}
+	env := []string{"env", "PATH=" + os.Getenv("PATH")}
+	if len(secrets) > 0 {
+		// Source the secrets rather than putting them in the crontab
+		env = append(env, "sh", "-c", `set -a && . "$0" && exec "$@"`, secretsFile)
+	}
+	line := fmt.Sprintf("%s %s %s >/dev/null", schedule, shellJoin(env), shellJoin(append(lock, cmd...)))
+	// cron turns an unescaped % into a newline
+	line = strings.ReplaceAll(line, "%", `\%`)
+
+	updated := kept
+	if updated != "" && !strings.HasSuffix(updated, "\n") {
+		updated += "\n"
+	}
+	updated += cronMarker + "\n" + line + "\n"
+	if err := writeCrontab(ctx, updated); err != nil {
+		return err
+	}
+	fmt.Printf("Installed crontab entry:\n%s\n", line)
+	printSecrets(secretsFile, secrets)
+	printScheduledUser()
+	return nil
+}
+
+// readCrontab returns the current user's crontab, "" if there is none
+func readCrontab(ctx context.Context) (string, error) {
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "crontab", "-l")
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if strings.Contains(strings.ToLower(stderr.String()), "no crontab") {
+			return "", nil
+		}
+		return "", fmt.Errorf("failed to read crontab: %v %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return string(out), nil
+}
+
+// writeCrontab replaces the current user's crontab
+func writeCrontab(ctx context.Context, content string) error {
+	cmd := exec.CommandContext(ctx, "crontab", "-")
+	cmd.Stdin = strings.NewReader(content)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to write crontab: %v %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// removeCronEntry drops the managed line and its marker from a crontab
+func removeCronEntry(crontab string) string {
+	lines := strings.SplitAfter(crontab, "\n")
+	var kept []string
+	for i := 0; i < len(lines); i++ {
+		if strings.TrimSpace(lines[i]) == cronMarker {
+			i++ // The managed line follows its marker
+			continue
+		}
+		kept = append(kept, lines[i])
+	}
+	return strings.Join(kept, "")
+}
+
+// printScheduledUser explains which user the scheduled runs act as
+func printScheduledUser() {
+	if os.Geteuid() == 0 {
+		fmt.Println("Runs as root")
+		return
+	}
+	fmt.Printf("Runs as %s: if it can't change the exit node, make that user the Tailscale operator with setup-operator\n", operatorCandidate(nil))
+}
+
+// systemdQuote quotes an ExecStart= argument. systemd expands % specifiers
+// and $ variables even inside quotes, so those are escaped by doubling.
+func systemdQuote(s string) string {
+	s = strings.ReplaceAll(s, "%", "%%")
+	s = strings.ReplaceAll(s, "$", "$$")
+	if strings.ContainsAny(s, " \t\"'\\;") {
+		s = `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+	}
+	return s
+}
+
+// runInstallTimer writes and enables a systemd service and timer running
+// protect-wan every interval: system units as root, user units otherwise.
+// systemd never starts a oneshot service that is still running, so runs
+// can't overlap, and their output goes to the journal.
+func runInstallTimer(ctx context.Context, lc LocalClient, args []string) error {
+	if runtime.GOOS != "linux" {
+		return errors.New("install-timer needs systemd (Linux); use install-cron instead")
+	}
+	if len(args) > 1 {
+		return fmt.Errorf("usage: %s install-timer [interval|remove] [flags]", os.Args[0])
+	}
+
+	dir := "/etc/systemd/system"
+	systemctl := []string{"systemctl"}
+	if os.Geteuid() != 0 {
+		config, err := os.UserConfigDir()
+		if err != nil {
+			return err
+		}
+		dir = filepath.Join(config, "systemd", "user")
+		systemctl = append(systemctl, "--user")
+	}
+	service := filepath.Join(dir, timerUnit+".service")
+	timer := filepath.Join(dir, timerUnit+".timer")
+
+	run := func(args ...string) error {
+		cmd := exec.CommandContext(ctx, systemctl[0], append(systemctl[1:], args...)...)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("%s %s failed: %v %s", strings.Join(systemctl, " "), strings.Join(args, " "), err, strings.TrimSpace(string(out)))
+		}
+		return nil
+	}
+
+	if len(args) == 1 && args[0] == "remove" {
+		_ = run("disable", "--now", timerUnit+".timer")
+		for _, path := range []string{service, timer} {
+			if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return err
+			}
+		}
+		if err := removeSecrets("timer"); err != nil {
+			return err
+		}
+		if err := run("daemon-reload"); err != nil {
+			return err
+		}
+		fmt.Printf("Removed %s and %s\n", service, timer)
+		return nil
+	}
+
+	interval := defaultTimerInterval
+	if len(args) == 1 {
+		d, err := time.ParseDuration(args[0])
+		if err != nil || d < time.Minute {
+			return fmt.Errorf("invalid interval %q (expected a duration of at least 1m, e.g. 5m)", args[0])
+		}
+		interval = d
+	}
+
+	cmd, secrets, err := scheduledCommand()
+	if err != nil {
+		return err
+	}
+	secretsFile, err := secretsPath("timer")
+	if err != nil {
+		return err
+	}
+	if err := writeSecrets(secretsFile, secrets); err != nil {
+		return err
+	}
+	quoted := make([]string, len(cmd))
+	for i, a := range cmd {
+		quoted[i] = systemdQuote(a)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Written by protect-wan install-timer\n")
+	fmt.Fprintf(&b, "[Unit]\n")
+	fmt.Fprintf(&b, "Description=Keep the WAN protected with a Mullvad exit node (protect-wan)\n")
+	fmt.Fprintf(&b, "Wants=network-online.target\n")
+	fmt.Fprintf(&b, "After=network-online.target tailscaled.service\n\n")
+	fmt.Fprintf(&b, "[Service]\n")
+	fmt.Fprintf(&b, "Type=oneshot\n")
+	fmt.Fprintf(&b, "Environment=%s\n", systemdQuote("PATH="+os.Getenv("PATH")))
+	if len(secrets) > 0 {
+		fmt.Fprintf(&b, "EnvironmentFile=%s\n", systemdQuote(secretsFile))
+	}
+	fmt.Fprintf(&b, "ExecStart=%s\n", strings.Join(quoted, " "))
+
+	var t strings.Builder
+	fmt.Fprintf(&t, "# Written by protect-wan install-timer\n")
+	fmt.Fprintf(&t, "[Unit]\n")
+	fmt.Fprintf(&t, "Description=Run protect-wan every %s\n\n", interval)
+	fmt.Fprintf(&t, "[Timer]\n")
+	fmt.Fprintf(&t, "OnBootSec=1min\n")
+	fmt.Fprintf(&t, "OnUnitActiveSec=%ds\n\n", int(interval.Seconds()))
+	fmt.Fprintf(&t, "[Install]\n")
+	fmt.Fprintf(&t, "WantedBy=timers.target\n")
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(service, []byte(b.String()), 0o644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", service, err)
+	}
+	if err := os.WriteFile(timer, []byte(t.String()), 0o644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", timer, err)
+	}
+	if err := run("daemon-reload"); err != nil {
+		return err
+	}
+	if err := run("enable", "--now", timerUnit+".timer"); err != nil {
+		return err
+	}
+
+	fmt.Printf("Installed %s and %s, running every %s:\n%s\n", service, timer, interval, strings.Join(quoted, " "))
+	printSecrets(secretsFile, secrets)
+	printScheduledUser()
+	if os.Geteuid() != 0 {
+		fmt.Println("User timers only run while you are logged in, unless lingering is enabled: loginctl enable-linger")
+	}
+	return nil
+}
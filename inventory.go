@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+func init() {
+	commands["diff"] = command{
+		Usage: "Show Mullvad nodes added, removed or gone offline since the last diff",
+		Run:   runDiff,
+	}
+}
+
+var nodeDropFlag = flag.Float64("node-drop-alert", 0.5, "Warn and notify when the Mullvad node count drops by more than this fraction since the last run (0 to disable)")
+
+// minNodeDropBase is the smallest previous node count a drop is judged
+// against; tiny tailnets fluctuate too much for a fraction to mean anything
+const minNodeDropBase = 10
+
+// recordNodes caches the nodes for offline use and compares their count
+// with the last recorded one. A sharp drop usually means an ACL change or
+// an account problem rather than a Mullvad outage, so it warns and notifies.
+func recordNodes(ctx context.Context, nodes []MullvadNode) {
+	if !stateEnabled() {
+		return
+	}
+
+	// The notification is sent after the state is saved: notify updates
+	// the state itself, and nothing should wait on the network under the
+	// state lock
+	var msg string
+	err := updateState(func(st *state) {
+		prev := st.NodeCount
+		st.NodeCount = len(nodes)
+		st.NodeCountTime = time.Now()
+		st.NodeCache = &nodeSnapshot{Time: st.NodeCountTime, Nodes: nodes}
+
+		if *nodeDropFlag <= 0 || prev < minNodeDropBase {
+			return
+		}
+		if drop := float64(prev-len(nodes)) / float64(prev); drop > *nodeDropFlag {
+			msg = fmt.Sprintf("Mullvad node count dropped from %d to %d (-%.0f%%), check your tailnet ACLs and Mullvad add-on", prev, len(nodes), drop*100)
+		}
+	})
+	if err != nil {
+		log.Printf("Warning: failed to update state: %v", err)
+	}
+	if msg != "" {
+		fmt.Fprintf(os.Stderr, "Warning: %s\n", msg)
+		notifyFailure(ctx, "node-count-drop", msg)
+	}
+}
+
+// runDiff compares the current Mullvad nodes with the snapshot taken by the
+// previous diff, then stores the current nodes as the new baseline
+func runDiff(ctx context.Context, lc LocalClient, args []string) error {
+	if len(args) > 0 {
+		return errors.New("diff takes no arguments")
+	}
+	if !stateEnabled() {
+		return errors.New("diff needs a state file, pass --state <path>")
+	}
+
+	nodes, err := getMullvadNodes(ctx, lc)
+	if err != nil {
+		return err
+	}
+
+	return updateState(func(st *state) {
+		if st.DiffBaseline == nil {
+			fmt.Printf("No previous snapshot. Recorded %d nodes in %d countries as the baseline for the next diff.\n",
+				len(nodes), len(groupByCountry(nodes)))
+		} else {
+			printNodeDiff(st.DiffBaseline, nodes)
+		}
+		st.DiffBaseline = &nodeSnapshot{Time: time.Now(), Nodes: nodes}
+	})
+}
+
+// printNodeDiff reports added, removed, gone offline and back online nodes
+// and new countries between a snapshot and the current nodes
+func printNodeDiff(prev *nodeSnapshot, nodes []MullvadNode) {
+	before := make(map[string]MullvadNode)
+	countries := make(map[string]bool)
+	for _, node := range prev.Nodes {
+		before[node.DNSName] = node
+		countries[node.CountryCode] = true
+	}
+
+	var added, removed, offline, online []MullvadNode
+	var newCountries []string
+	seen := make(map[string]bool)
+	for _, node := range nodes {
+		seen[node.DNSName] = true
+		old, ok := before[node.DNSName]
+		switch {
+		case !ok:
+			added = append(added, node)
+		case old.Online && !node.Online:
+			offline = append(offline, node)
+		case !old.Online && node.Online:
+			online = append(online, node)
+		}
+		if !countries[node.CountryCode] {
+			countries[node.CountryCode] = true
+			newCountries = append(newCountries, fmt.Sprintf("%s (%s)", node.Country, node.CountryCode))
+		}
+	}
+	for _, node := range prev.Nodes {
+		if !seen[node.DNSName] {
+			removed = append(removed, node)
+		}
+	}
+
+	fmt.Printf("Changes since %s (%d -> %d nodes):\n", prev.Time.Format("2006-01-02 15:04"), len(prev.Nodes), len(nodes))
+	if len(added)+len(removed)+len(offline)+len(online) == 0 {
+		fmt.Println("  No changes")
+		return
+	}
+
+	if len(newCountries) > 0 {
+		sort.Strings(newCountries)
+		fmt.Printf("\nNew countries: %s\n", strings.Join(newCountries, ", "))
+	}
+	printDiffSection("Added", "+", added)
+	printDiffSection("Removed", "-", removed)
+	printDiffSection("Went offline", "!", offline)
+	printDiffSection("Back online", "~", online)
+}
+
+// printDiffSection prints one group of changed nodes
+func printDiffSection(title, marker string, nodes []MullvadNode) {
+	if len(nodes) == 0 {
+		return
+	}
+	sort.Slice(nodes, func(i, j int) bool {
+		return nodes[i].DNSName < nodes[j].DNSName
+	})
+	fmt.Printf("\n%s (%d):\n", title, len(nodes))
+	for _, node := range nodes {
+		fmt.Printf("  %s %-40s %s, %s\n", marker, displayName(node), node.City, node.CountryCode)
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRecordNodesNotifiesDrop(t *testing.T) {
+	newTestClient(t)
+	var posts atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		posts.Add(1)
+	}))
+	t.Cleanup(srv.Close)
+	withFlag(t, "notify-webhook", srv.URL)
+
+	nodes := func(n int) []MullvadNode {
+		var nodes []MullvadNode
+		for i := range n {
+			nodes = append(nodes, MullvadNode{DNSName: fmt.Sprintf("se-sto-wg-%03d.mullvad.ts.net.", i)})
+		}
+		return nodes
+	}
+
+	// The notification updates the state too, so it must not be sent
+	// while recordNodes holds the state lock
+	done := make(chan struct{})
+	go func() {
+		recordNodes(context.Background(), nodes(20))
+		recordNodes(context.Background(), nodes(2))
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("recordNodes did not return")
+	}
+	if posts.Load() != 1 {
+		t.Errorf("webhook received %d notifications, want 1 for the drop", posts.Load())
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"os"
+	"time"
+
+	"tailscale.com/ipn/ipnstate"
+)
+
+// routeProbeV6 is the IPv6 counterpart of routeProbeV4
+var routeProbeV6 = netip.MustParseAddr("2606:4700:4700::1111")
+
+// ipv6LeakCheckTTL is how long the outcome of the external IPv6 leak
+// check is reused for the same exit node on the same network, so daemon
+// ticks don't each query it
+const ipv6LeakCheckTTL = 15 * time.Minute
+
+// ipv6LeakCheck is a remembered external IPv6 leak check
+type ipv6LeakCheck struct {
+	key  string // Exit node and network fingerprint
+	err  error  // *ipv6LeakError, or nil
+	time time.Time
+}
+
+var lastIPv6LeakCheck ipv6LeakCheck
+
+// ipv6LeakError reports IPv6 traffic leaving the host outside of the exit node
+type ipv6LeakError struct {
+	Reason string
+}
+
+func (e *ipv6LeakError) Error() string {
+	return "IPv6 traffic bypasses the exit node: " + e.Reason
+}
+
+// checkIPv6Egress verifies that IPv6 traffic also egresses via the exit node.
+// Hosts without native IPv6 connectivity pass trivially.
+// Returns a *ipv6LeakError if v6 traffic takes another path.
+func checkIPv6Egress(ctx context.Context, status *ipnstate.Status) error {
+	// Route inspection: does the OS send v6 default traffic into Tailscale?
+	if status.TUN {
+		if tsIface, err := tailscaleInterface(status.TailscaleIPs); err == nil {
+			iface, err := routeInterface(routeProbeV6)
+			switch {
+			case err != nil:
+				// No v6 route usually means no native v6 at all
+				if *verboseFlag {
+					fmt.Printf("No IPv6 route found (%v)\n", err)
+				}
+			case iface != tsIface:
+				return &ipv6LeakError{Reason: fmt.Sprintf("route to %s uses %s, not %s", routeProbeV6, iface, tsIface)}
+			case *verboseFlag:
+				fmt.Printf("IPv6 route to %s uses interface %s\n", routeProbeV6, iface)
+			}
+		}
+	}
+
+	// External check, reused for the same exit node and network
+	key := ""
+	if status.ExitNodeStatus != nil {
+		key = string(status.ExitNodeStatus.ID) + "|" + networkFingerprint(currentNetwork())
+	}
+	if key != "" && lastIPv6LeakCheck.key == key && time.Since(lastIPv6LeakCheck.time) < ipv6LeakCheckTTL {
+		if *verboseFlag {
+			fmt.Printf("IPv6 egress checked %s ago\n", time.Since(lastIPv6LeakCheck.time).Round(time.Second))
+		}
+		return lastIPv6LeakCheck.err
+	}
+	err := probePublicIPv6(ctx)
+	lastIPv6LeakCheck = ipv6LeakCheck{key: key, err: err, time: time.Now()}
+	return err
+}
+
+// probePublicIPv6 checks that the public IPv6 address, if any, is a
+// Mullvad exit
+func probePublicIPv6(ctx context.Context) error {
+	info, err := fetchPublicIP(ctx, mullvadCheckURLv6)
+	if err != nil {
+		// No v6 egress at all, so nothing can leak over it
+		if *verboseFlag {
+			fmt.Printf("No IPv6 egress detected (%v)\n", err)
+		}
+		return nil
+	}
+
+	if *verboseFlag {
+		fmt.Printf("Public IPv6: %s (%s, Mullvad: %v)\n", info.IP, info.Country, info.MullvadExitIP)
+	}
+
+	if !info.MullvadExitIP {
+		return &ipv6LeakError{Reason: fmt.Sprintf("public IPv6 %s (%s) is not a Mullvad exit", info.IP, info.Organization)}
+	}
+
+	return nil
+}
+
+// handleIPv6Leak applies --ipv6-leak to the result of checkIPv6Egress.
+// Returns an error only in fail mode.
+func handleIPv6Leak(err error) error {
+	if err == nil {
+		return nil
+	}
+	if *ipv6LeakFlag == "fail" {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+	return nil
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/netip"
+	"sort"
+	"time"
+
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/tailcfg"
+)
+
+var (
+	requireIPv6Flag = flag.Bool("require-ipv6", false, "Only use exit nodes with working IPv6 egress; with --trial, roll back nodes whose IPv6 fails")
+	preferIPv6Flag  = flag.Bool("prefer-ipv6", false, "Rank exit nodes with working IPv6 egress first")
+)
+
+// ipv6ProbeTTL is how long a failed IPv6 egress probe excludes a node
+const ipv6ProbeTTL = 7 * 24 * time.Hour
+
+// errNoIPv6 is the trial failure of an exit node without IPv6 egress
+var errNoIPv6 = errors.New("no IPv6 egress through the exit node")
+
+// ipv6Probe is the outcome of an IPv6 egress probe through a node
+type ipv6Probe struct {
+	OK   bool      `json:"ok"`
+	Time time.Time `json:"time"`
+}
+
+// advertisesIPv6 reports whether an exit node peer routes IPv6: its allowed
+// IPs include ::/0. Without the peer's allowed IPs (e.g. in snapshots) the
+// node gets the benefit of the doubt.
+func advertisesIPv6(peer *ipnstate.PeerStatus) bool {
+	if peer.AllowedIPs == nil {
+		return true
+	}
+	v6Default := netip.MustParsePrefix("::/0")
+	for i := range peer.AllowedIPs.Len() {
+		if peer.AllowedIPs.At(i) == v6Default {
+			return true
+		}
+	}
+	return false
+}
+
+// hasIPv6 reports whether a node is believed to provide IPv6 egress: it
+// routes ::/0 and no recent probe through it failed
+func hasIPv6(node MullvadNode, probes map[tailcfg.StableNodeID]ipv6Probe) bool {
+	if node.NoIPv6Route {
+		return false
+	}
+	p, ok := probes[node.ID]
+	return !ok || p.OK || time.Since(p.Time) >= ipv6ProbeTTL
+}
+
+// loadIPv6Probes returns the recorded probe outcomes, nil without state
+func loadIPv6Probes() map[tailcfg.StableNodeID]ipv6Probe {
+	if !stateEnabled() {
+		return nil
+	}
+	st, err := loadState()
+	if err != nil {
+		return nil
+	}
+	return st.IPv6Egress
+}
+
+// filterIPv6 keeps the nodes with IPv6 egress for --require-ipv6
+func filterIPv6(nodes []MullvadNode) ([]MullvadNode, error) {
+	if !*requireIPv6Flag {
+		return nodes, nil
+	}
+	probes := loadIPv6Probes()
+	filtered := make([]MullvadNode, 0, len(nodes))
+	for _, node := range nodes {
+		switch {
+		case node.NoIPv6Route:
+			explain.eliminate("does not route IPv6 (no ::/0)", node)
+		case !hasIPv6(node, probes):
+			explain.eliminate("IPv6 egress probe failed "+probes[node.ID].Time.Format(time.DateTime), node)
+		default:
+			filtered = append(filtered, node)
+		}
+	}
+	explain.filter("IPv6 egress", len(nodes), len(filtered))
+	if len(filtered) == 0 {
+		return nil, errors.New("no online Mullvad exit node with IPv6 egress found")
+	}
+	return filtered, nil
+}
+
+// preferIPv6 moves the candidates with IPv6 egress first for --prefer-ipv6,
+// keeping the strategy's order otherwise
+func preferIPv6(candidates []MullvadNode) []MullvadNode {
+	if !*preferIPv6Flag {
+		return candidates
+	}
+	probes := loadIPv6Probes()
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return hasIPv6(candidates[i], probes) && !hasIPv6(candidates[j], probes)
+	})
+	explain.note("Candidates with IPv6 egress ranked first (--prefer-ipv6)")
+	return candidates
+}
+
+// probeIPv6Egress checks that IPv6 traffic works through the new exit node
+// and records the outcome for later selections. Returns errNoIPv6 if not.
+func probeIPv6Egress(ctx context.Context, node MullvadNode) error {
+	info, err := fetchPublicIP(ctx, mullvadCheckURLv6)
+	ok := err == nil && info.MullvadExitIP
+	if *verboseFlag {
+		if ok {
+			fmt.Printf("IPv6 egress via %s works (%s)\n", displayName(node), info.IP)
+		} else {
+			fmt.Printf("IPv6 egress via %s failed\n", displayName(node))
+		}
+	}
+
+	if stateEnabled() {
+		err := updateState(func(st *state) {
+			if st.IPv6Egress == nil {
+				st.IPv6Egress = make(map[tailcfg.StableNodeID]ipv6Probe)
+			}
+			st.IPv6Egress[node.ID] = ipv6Probe{OK: ok, Time: time.Now()}
+		})
+		if err != nil {
+			log.Printf("Warning: failed to update state: %v", err)
+		}
+	}
+
+	switch {
+	case ok:
+		return nil
+	case err != nil:
+		return fmt.Errorf("%w: %w", errNoIPv6, err)
+	default:
+		return fmt.Errorf("%w: public IPv6 %s is not a Mullvad exit", errNoIPv6, info.IP)
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net/netip"
+	"slices"
+	"strings"
+
+	"tailscale.com/tailcfg"
+)
+
+var (
+	forbidCountryFlag, allowCountryFlag stringList
+
+	overridePolicyFlag = flag.Bool("override-policy", false, "Allow an exit node ruled out by --forbid-country or --allow-country for this run (command line only)")
+)
+
+func init() {
+	flag.Var(&forbidCountryFlag, "forbid-country", "Never use exit nodes in this country, not even with --set, unless --override-policy is given: code or name (repeatable)")
+	flag.Var(&allowCountryFlag, "allow-country", "Only ever use exit nodes in this country, not even --set picks others, unless --override-policy is given: code or name (repeatable)")
+}
+
+type overridePolicyKey struct{}
+
+// withPolicyOverride lets the selection under ctx use exit node id despite
+// --forbid-country and --allow-country, or any node if id is empty. Used by the daemon for a
+// set with --override-policy handed to it.
+func withPolicyOverride(ctx context.Context, id tailcfg.StableNodeID) context.Context {
+	return context.WithValue(ctx, overridePolicyKey{}, id)
+}
+
+// policyOverridden reports whether --forbid-country and --allow-country
+// are lifted for node. Never while the admin config locks them.
+func policyOverridden(ctx context.Context, node MullvadNode) bool {
+	if countriesLocked() {
+		return false
+	}
+	id, ok := ctx.Value(overridePolicyKey{}).(tailcfg.StableNodeID)
+	return *overridePolicyFlag || (ok && (id == "" || id == node.ID))
+}
+
+// forbiddenError is returned for an exit node in a forbidden country
+type forbiddenError struct {
+	Node MullvadNode
+	Rule string // The flag ruling it out, e.g. --forbid-country US
+}
+
+func (e *forbiddenError) Error() string {
+	msg := fmt.Sprintf("exit node %s is in %s (%s), ruled out by %s", displayName(e.Node), e.Node.Country, e.Node.CountryCode, e.Rule)
+	if countriesLocked() {
+		return msg + " (locked by the admin config)"
+	}
+	return msg + "; pass --override-policy to use it anyway"
+}
+
+// inCountry reports whether a --forbid-country or --allow-country entry
+// names the node's country. Entries are resolved against the node itself,
+// so a name only matches the node's own country.
+func inCountry(node MullvadNode, entry string) bool {
+	code, err := resolveCountry(entry, []MullvadNode{node})
+	return err == nil && node.CountryCode != "" && strings.EqualFold(code, node.CountryCode)
+}
+
+// forbiddenRule returns the rule the node's country breaks. A node without
+// a country is outside any --allow-country.
+func forbiddenRule(node MullvadNode) (string, bool) {
+	for _, entry := range forbidCountryFlag {
+		if inCountry(node, entry) {
+			return "--forbid-country " + entry, true
+		}
+	}
+	if len(allowCountryFlag) > 0 && !slices.ContainsFunc(allowCountryFlag, func(entry string) bool { return inCountry(node, entry) }) {
+		return "--allow-country " + allowCountryFlag.String(), true
+	}
+	return "", false
+}
+
+// checkForbidden fails for an exit node in a forbidden country, unless the
+// policy is overridden for it. Every path applying a Mullvad
+// node goes through here, so an explicit --set is refused too.
+func checkForbidden(ctx context.Context, node MullvadNode) error {
+	rule, ok := forbiddenRule(node)
+	if !ok {
+		return nil
+	}
+	if policyOverridden(ctx, node) {
+		explain.note("%s is ruled out by %s, allowed by --override-policy", displayName(node), rule)
+		return nil
+	}
+	return &forbiddenError{Node: node, Rule: rule}
+}
+
+// validateForbidden checks that every --forbid-country and --allow-country
+// entry names a country, resolving names against all nodes. A misspelled
+// entry would otherwise match nothing and forbid nothing.
+func validateForbidden(nodes []MullvadNode) error {
+	for _, entry := range forbidCountryFlag {
+		if _, err := resolveCountry(entry, nodes); err != nil {
+			return fmt.Errorf("invalid --forbid-country: %w", err)
+		}
+	}
+	for _, entry := range allowCountryFlag {
+		if _, err := resolveCountry(entry, nodes); err != nil {
+			return fmt.Errorf("invalid --allow-country: %w", err)
+		}
+	}
+	return nil
+}
+
+// filterForbidden drops the nodes in forbidden countries before ranking,
+// so selection never settles on one it may not apply
+func filterForbidden(ctx context.Context, nodes []MullvadNode) ([]MullvadNode, error) {
+	if len(forbidCountryFlag) == 0 && len(allowCountryFlag) == 0 {
+		return nodes, nil
+	}
+	filtered := make([]MullvadNode, 0, len(nodes))
+	for _, node := range nodes {
+		if rule, ok := forbiddenRule(node); ok && !policyOverridden(ctx, node) {
+			explain.eliminate("forbidden jurisdiction ("+rule+")", node)
+			continue
+		}
+		filtered = append(filtered, node)
+	}
+	explain.filter("forbidden countries", len(nodes), len(filtered))
+	if len(filtered) == 0 {
+		return nil, errors.New("no Mullvad exit nodes left in the countries allowed by --forbid-country and --allow-country")
+	}
+	return filtered, nil
+}
+
+// checkActiveForbidden fails if the active exit node, possibly set outside
+// protect-wan, is in a forbidden country
+func checkActiveForbidden(ctx context.Context, lc LocalClient) error {
+	if len(forbidCountryFlag) == 0 && len(allowCountryFlag) == 0 {
+		return nil
+	}
+	node, ok, err := currentExitNode(ctx, lc)
+	if err != nil || !ok {
+		return nil
+	}
+	return checkForbidden(ctx, node)
+}
+
+// checkPeerForbidden checks an exit node given by IP that isn't a Mullvad
+// node, e.g. a self-hosted one. The peer holding the IP is judged by the
+// country of its location; one without a known country is refused while the
+// admin config locks the countries, since it could be anywhere.
+func checkPeerForbidden(ctx context.Context, lc LocalClient, ip netip.Addr) error {
+	if len(forbidCountryFlag) == 0 && len(allowCountryFlag) == 0 {
+		return nil
+	}
+	status, err := lc.Status(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get status: %w", err)
+	}
+	for _, peer := range status.Peer {
+		if !slices.Contains(peer.TailscaleIPs, ip) {
+			continue
+		}
+		node := nodeFromPeer(peer)
+		if node.CountryCode == "" && countriesLocked() {
+			return fmt.Errorf("exit node %s has no known country, refused while the admin config locks the countries", ip)
+		}
+		return checkForbidden(ctx, node)
+	}
+	return fmt.Errorf("no peer has the IP %s to check against --forbid-country and --allow-country", ip)
+}
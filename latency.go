@@ -0,0 +1,721 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"math"
+	"net/netip"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"tailscale.com/tailcfg"
+)
+
+var (
+	preferPriorityFlag = flag.Bool("prefer-priority", false, "Same as --strategy priority: select by Tailscale priority instead of latency (faster but may not be optimal)")
+	pingTypeFlag       = flag.String("ping-type", "disco,icmp", "Ping types to try in order: disco, tsmp, icmp, peerapi (comma-separated fallback list)")
+	parallelFlag       = flag.Int("parallel", 8, "Maximum number of concurrent pings")
+	goodEnoughFlag     = flag.Duration("good-enough", 0, "Stop probing as soon as a node answers within this latency (e.g. 30ms)")
+	warmUpFlag         = flag.Bool("warm-up", true, "Send an unmeasured ping to each node first, so path setup (disco, DERP) doesn't count against its latency")
+	maxPPSFlag         = flag.Float64("max-pps", 20, "Maximum pings per second sent through tailscaled (0 for unlimited)")
+	minProbesFlag      = flag.Int("min-country-probes", 1, "Phase 1 pings a country must answer before its ranking is trusted; failed or outlying pings are retried on other nodes")
+)
+
+// errProbeSkipped marks nodes not probed because a good enough node was found
+var errProbeSkipped = errors.New("skipped, good enough node found")
+
+const (
+	// pingTimeout bounds a single ping attempt
+	pingTimeout = 3 * time.Second
+
+	// retryPingTimeout bounds the second chance pings of phase 1, which
+	// give a country whose node didn't answer in time a slow path's worth
+	// of patience before it is excluded
+	retryPingTimeout = 8 * time.Second
+
+	// exhaustiveNodeLimit is the candidate count up to which every node is
+	// tested in phase 2, e.g. after a --country filter
+	exhaustiveNodeLimit = 25
+
+	// Bounds for the number of countries deep-tested in phase 2
+	minPhase2Countries = 2
+	maxPhase2Countries = 8
+
+	// Bounds for the number of nodes tested per phase 2 country
+	minPhase2NodesPerCountry = 3
+	maxPhase2NodesPerCountry = 10
+
+	// phase2Band is the minimum latency gap to the fastest country within
+	// which a country is considered a contender; phase 1 is a single sample
+	// per country, so closer countries may well be faster in phase 2
+	phase2Band = 15 * time.Millisecond
+
+	// phase1ExtraProbes is how many nodes per country phase 1 may probe
+	// beyond --min-country-probes, to replace failed or outlying pings
+	phase1ExtraProbes = 2
+
+	// phase1OutlierFactor: a phase 1 ping more than this many times, and
+	// more than phase2Band, slower than its country's fastest one is an
+	// outlier, left out of the country's ranking
+	phase1OutlierFactor = 2
+)
+
+// pingTypes maps --ping-type names to LocalAPI ping types
+var pingTypes = map[string]tailcfg.PingType{
+	"disco":   tailcfg.PingDisco,
+	"tsmp":    tailcfg.PingTSMP,
+	"icmp":    tailcfg.PingICMP,
+	"peerapi": tailcfg.PingPeerAPI,
+}
+
+// parsePingTypes parses the ordered --ping-type fallback list
+func parsePingTypes(s string) ([]tailcfg.PingType, error) {
+	var types []tailcfg.PingType
+	for _, name := range strings.Split(s, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		t, ok := pingTypes[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown ping type %q (expected disco, tsmp, icmp or peerapi)", name)
+		}
+		types = append(types, t)
+	}
+	if len(types) == 0 {
+		return nil, errors.New("no ping types given")
+	}
+	return types, nil
+}
+
+// prober measures node latency, trying each configured ping type in order.
+// Peers differ in what they answer (Mullvad nodes are WireGuard-only and
+// don't speak disco), so the type that last worked is tried first.
+type prober struct {
+	lc    LocalClient
+	types []tailcfg.PingType
+	limit *rateLimiter
+
+	mu       sync.Mutex
+	working  tailcfg.PingType
+	warm     map[tailcfg.StableNodeID]bool        // Nodes past their warm-up ping
+	paths    map[tailcfg.StableNodeID]string      // Path of the last answered ping
+	outcomes map[tailcfg.StableNodeID]nodeOutcome // Of pingAll, for the quarantine
+}
+
+// newProber creates a prober using the --ping-type fallback list
+func newProber(lc LocalClient) (*prober, error) {
+	types, err := parsePingTypes(*pingTypeFlag)
+	if err != nil {
+		return nil, err
+	}
+	return &prober{lc: lc, types: types, limit: newRateLimiter(*maxPPSFlag),
+		warm: make(map[tailcfg.StableNodeID]bool), paths: make(map[tailcfg.StableNodeID]string)}, nil
+}
+
+// order returns the ping types to try, last working type first
+func (p *prober) order() []tailcfg.PingType {
+	p.mu.Lock()
+	working := p.working
+	p.mu.Unlock()
+
+	if working == "" {
+		return p.types
+	}
+	order := []tailcfg.PingType{working}
+	for _, t := range p.types {
+		if t != working {
+			order = append(order, t)
+		}
+	}
+	return order
+}
+
+// pingTimeoutKey is the context key of a longer ping timeout
+type pingTimeoutKey struct{}
+
+// withPingTimeout makes the pings under ctx wait up to d instead of
+// pingTimeout
+func withPingTimeout(ctx context.Context, d time.Duration) context.Context {
+	return context.WithValue(ctx, pingTimeoutKey{}, d)
+}
+
+// ping measures the latency to a node. The first ping to a node often
+// includes the disco handshake or a DERP fallback while the path is set up,
+// so with --warm-up it is sent unmeasured, and the next one is the sample.
+func (p *prober) ping(ctx context.Context, node MullvadNode) (time.Duration, error) {
+	p.mu.Lock()
+	first := !p.warm[node.ID]
+	p.warm[node.ID] = true
+	p.mu.Unlock()
+
+	if first && *warmUpFlag {
+		warmUp, err := p.pingOnce(ctx, node)
+		if err != nil {
+			return 0, err
+		}
+		latency, err := p.pingOnce(ctx, node)
+		if err != nil {
+			// It did answer; a lost sample doesn't make it unreachable
+			latency = warmUp
+		}
+		pingLatencyHistogram.observe(node.CountryCode, latency.Seconds())
+		return latency, nil
+	}
+
+	latency, err := p.pingOnce(ctx, node)
+	if err == nil {
+		pingLatencyHistogram.observe(node.CountryCode, latency.Seconds())
+	}
+	return latency, err
+}
+
+// pingOnce sends one ping to a node, with each ping type in turn until one
+// is answered
+func (p *prober) pingOnce(ctx context.Context, node MullvadNode) (time.Duration, error) {
+	timeout := pingTimeout
+	if d, ok := ctx.Value(pingTimeoutKey{}).(time.Duration); ok {
+		timeout = d
+	}
+
+	ip, ok := pingAddr(node)
+	if !ok {
+		return 0, fmt.Errorf("%s has no Tailscale IP", node.DNSName)
+	}
+
+	var errs []error
+	for _, t := range p.order() {
+		if err := p.limit.wait(ctx); err != nil {
+			return 0, err
+		}
+		pingCtx, cancel := context.WithTimeout(ctx, timeout)
+		res, err := p.lc.Ping(pingCtx, ip, t)
+		cancel()
+
+		if err == nil && res.Err != "" {
+			err = errors.New(res.Err)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s ping: %w", t, err))
+			continue
+		}
+
+		p.mu.Lock()
+		p.working = t
+		if path := pingPath(res); path != "" {
+			p.paths[node.ID] = path
+		}
+		p.mu.Unlock()
+
+		return time.Duration(res.LatencySeconds * float64(time.Second)), nil
+	}
+
+	return 0, errors.Join(errs...)
+}
+
+// path returns the path of the last ping a node answered, "" if unknown
+func (p *prober) path(node MullvadNode) string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.paths[node.ID]
+}
+
+// record remembers whether a node answered
+func (p *prober) record(node MullvadNode, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.outcomes == nil {
+		p.outcomes = make(map[tailcfg.StableNodeID]nodeOutcome)
+	}
+	p.outcomes[node.ID] = nodeOutcome{Node: node, Err: err}
+}
+
+// saveHealth records the ping outcomes for the quarantine. If no node
+// answered, our own connectivity is the likelier culprit, and failures
+// aren't counted against the nodes.
+func (p *prober) saveHealth() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, o := range p.outcomes {
+		if o.Err == nil {
+			recordHealth(p.outcomes)
+			return
+		}
+	}
+}
+
+// rateLimiter spaces out LocalAPI pings, so exhaustive or highly parallel
+// testing can't flood tailscaled and the disco path
+type rateLimiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	next     time.Time
+}
+
+// newRateLimiter allows pps calls per second; nil (unlimited) for pps <= 0
+func newRateLimiter(pps float64) *rateLimiter {
+	if pps <= 0 {
+		return nil
+	}
+	return &rateLimiter{interval: time.Duration(float64(time.Second) / pps)}
+}
+
+// wait blocks until the next call is allowed
+func (r *rateLimiter) wait(ctx context.Context) error {
+	if r == nil {
+		return nil
+	}
+
+	r.mu.Lock()
+	slot := time.Now()
+	if r.next.After(slot) {
+		slot = r.next
+	}
+	r.next = slot.Add(r.interval)
+	r.mu.Unlock()
+
+	d := time.Until(slot)
+	if d <= 0 {
+		return nil
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+// pingAddr picks the address to ping, preferring IPv4
+func pingAddr(node MullvadNode) (netip.Addr, bool) {
+	for _, ip := range node.TailscaleIPs {
+		if ip.Is4() {
+			return ip, true
+		}
+	}
+	if len(node.TailscaleIPs) > 0 {
+		return node.TailscaleIPs[0], true
+	}
+	return netip.Addr{}, false
+}
+
+// pingResult is the outcome of pinging one node
+type pingResult struct {
+	Node MullvadNode // Latency set on success
+	Err  error
+}
+
+// goodEnough reports whether latency meets the --good-enough budget
+func goodEnough(latency time.Duration) bool {
+	return *goodEnoughFlag > 0 && latency <= *goodEnoughFlag
+}
+
+// pingAll pings nodes concurrently, at most --parallel at a time, in order.
+// Once a node is good enough, pending and in-flight pings are abandoned
+// and reported with errProbeSkipped.
+// Results are returned in the order of nodes.
+func (p *prober) pingAll(ctx context.Context, nodes []MullvadNode) []pingResult {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make([]pingResult, len(nodes))
+	workers := max(1, *parallelFlag)
+	sem := make(chan struct{}, workers)
+
+	var wg sync.WaitGroup
+	for i, node := range nodes {
+		sem <- struct{}{}
+		if ctx.Err() != nil {
+			<-sem
+			results[i] = pingResult{Node: node, Err: errProbeSkipped}
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			latency, err := p.ping(ctx, node)
+			if err != nil && ctx.Err() != nil {
+				err = errProbeSkipped
+			}
+			if err == nil && goodEnough(latency) {
+				cancel()
+			}
+			node.Latency = latency
+			node.Path = p.path(node)
+			results[i] = pingResult{Node: node, Err: err}
+			if !errors.Is(err, errProbeSkipped) {
+				p.record(node, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	return results
+}
+
+// countryGroup is the set of candidate nodes in one country, in priority order
+type countryGroup struct {
+	Country     string
+	CountryCode string
+	Nodes       []MullvadNode
+	Latency     time.Duration // Median latency of the phase 1 samples
+	Answered    []MullvadNode // Phase 1 nodes that answered, with their latency
+	Probed      int           // Nodes probed in phase 1, a prefix of Nodes
+}
+
+// samples returns the phase 1 pings the country is ranked by: the answered
+// ones, less outliers
+func (g *countryGroup) samples() []MullvadNode {
+	if len(g.Answered) == 0 {
+		return nil
+	}
+	fastest := g.Answered[0].Latency
+	for _, n := range g.Answered {
+		fastest = min(fastest, n.Latency)
+	}
+	var samples []MullvadNode
+	for _, n := range g.Answered {
+		if n.Latency <= fastest*phase1OutlierFactor || n.Latency-fastest <= phase2Band {
+			samples = append(samples, n)
+		}
+	}
+	return samples
+}
+
+// medianLatency returns the median latency of nodes
+func medianLatency(nodes []MullvadNode) time.Duration {
+	l := make([]time.Duration, len(nodes))
+	for i, n := range nodes {
+		l[i] = n.Latency
+	}
+	sort.Slice(l, func(i, j int) bool { return l[i] < l[j] })
+	if len(l)%2 == 0 {
+		return (l[len(l)/2-1] + l[len(l)/2]) / 2
+	}
+	return l[len(l)/2]
+}
+
+// groupByCountry groups nodes by country, keeping priority order
+func groupByCountry(nodes []MullvadNode) []*countryGroup {
+	var groups []*countryGroup
+	byCode := make(map[string]*countryGroup)
+
+	for _, node := range nodes {
+		g, ok := byCode[node.CountryCode]
+		if !ok {
+			g = &countryGroup{Country: node.Country, CountryCode: node.CountryCode}
+			byCode[node.CountryCode] = g
+			groups = append(groups, g)
+		}
+		g.Nodes = append(g.Nodes, node)
+	}
+
+	return groups
+}
+
+// selectByLatency runs the two-phase latency selection over online nodes
+// (already in priority order). Returns every node that answered, fastest
+// first, so the winner is the first element.
+func selectByLatency(ctx context.Context, lc LocalClient, nodes []MullvadNode) ([]MullvadNode, error) {
+	p, err := newProber(lc)
+	if err != nil {
+		return nil, err
+	}
+
+	cache := openLatencyCache()
+	defer p.saveHealth()
+	geo := newGeoScorer(ctx, lc, nodes)
+
+	if tested, ok := cache.warmStart(ctx, p, nodes); ok {
+		cache.save(tested, true)
+		labelPaths(ctx, lc, tested)
+		geo.sort(tested)
+		return tested, nil
+	}
+
+	groups := geo.orderCountries(groupByCountry(nodes))
+
+	ranked := testCountryRepresentatives(ctx, p, groups)
+	if len(ranked) == 0 {
+		return nil, errors.New("no node answered a ping")
+	}
+
+	var tested []MullvadNode
+	if best, ok := phase1GoodEnough(ranked); ok {
+		if *verboseFlag {
+			fmt.Printf("\n%s is good enough (%dms <= %s), skipping Phase 2\n",
+				displayName(best), best.Latency.Milliseconds(), *goodEnoughFlag)
+		}
+		explain.note("Phase 1 found a node within --good-enough %s, Phase 2 skipped", *goodEnoughFlag)
+		for _, g := range ranked {
+			tested = append(tested, g.Answered...)
+			explain.eliminate("not probed, good enough node found in Phase 1", g.Nodes[g.Probed:]...)
+		}
+	} else {
+		tested = testTopCountriesInDepth(ctx, p, ranked)
+	}
+
+	cache.save(tested, false)
+	labelPaths(ctx, lc, tested)
+	geo.sort(tested)
+	return tested, nil
+}
+
+// phase1GoodEnough returns the fastest phase 1 node if it is within
+// --good-enough
+func phase1GoodEnough(ranked []*countryGroup) (MullvadNode, bool) {
+	var best MullvadNode
+	for _, g := range ranked {
+		for _, n := range g.Answered {
+			if best.ID == "" || n.Latency < best.Latency {
+				best = n
+			}
+		}
+	}
+	return best, best.ID != "" && goodEnough(best.Latency)
+}
+
+// testCountryRepresentatives is phase 1: ping the highest priority node of
+// every country. A country is ranked by the median of --min-country-probes
+// pings; when a ping fails or is an outlier, the country's next node is
+// probed, up to phase1ExtraProbes more, rather than the country being
+// judged on one bad sample. A country without any answer gets its second
+// chance with retryPingTimeout. Returns the countries that answered, fastest
+// first.
+func testCountryRepresentatives(ctx context.Context, p *prober, groups []*countryGroup) []*countryGroup {
+	if *verboseFlag {
+		fmt.Printf("\nPhase 1: Testing one node from each country (%d countries)...\n", len(groups))
+	}
+	need := *minProbesFlag
+	limit := need + phase1ExtraProbes
+
+	skipped := make(map[*countryGroup]bool)
+	pending := groups
+	for round := 0; len(pending) > 0; round++ {
+		batch := make([]MullvadNode, len(pending))
+		for i, g := range pending {
+			batch[i] = g.Nodes[g.Probed]
+			g.Probed++
+		}
+		roundCtx := ctx
+		if round == 0 {
+			emit(event{Event: "phase_started", Phase: 1, Nodes: len(batch)})
+		} else {
+			// A second chance for a country none of whose nodes answered
+			// yet comes with a longer timeout
+			codes := make([]string, len(pending))
+			for i, g := range pending {
+				codes[i] = g.CountryCode
+				if len(g.Answered) == 0 {
+					roundCtx = withPingTimeout(ctx, retryPingTimeout)
+				}
+			}
+			if *verboseFlag {
+				fmt.Printf("\nPhase 1: Probing another node in %s...\n", strings.Join(codes, ", "))
+			}
+		}
+
+		var next []*countryGroup
+		found := false
+		for i, res := range p.pingAll(roundCtx, batch) {
+			g := pending[i]
+			emitProbe(1, res)
+			switch {
+			case errors.Is(res.Err, errProbeSkipped):
+				found = true
+				skipped[g] = true
+				if len(g.Answered) == 0 {
+					explain.eliminate("not probed, good enough node found in Phase 1", g.Nodes[g.Probed-1:]...)
+				} else {
+					explain.eliminate("not probed, good enough node found in Phase 1", res.Node)
+				}
+				continue
+			case res.Err != nil:
+				if *verboseFlag {
+					fmt.Printf("  %s (%s): %s failed (%v)\n", g.Country, g.CountryCode, displayName(res.Node), res.Err)
+				}
+				explain.eliminate("Phase 1 ping failed: "+res.Err.Error(), res.Node)
+			default:
+				if *verboseFlag {
+					fmt.Printf("  %s (%s): %dms\n", g.Country, g.CountryCode, res.Node.Latency.Milliseconds())
+				}
+				g.Answered = append(g.Answered, res.Node)
+				found = found || goodEnough(res.Node.Latency)
+			}
+			if len(g.samples()) < need && g.Probed < min(limit, len(g.Nodes)) {
+				next = append(next, g)
+			}
+		}
+		// A good enough node ends phase 1 on whatever samples there are
+		if found {
+			break
+		}
+		pending = next
+	}
+
+	var ranked []*countryGroup
+	for _, g := range groups {
+		samples := g.samples()
+		if len(samples) == 0 {
+			if !skipped[g] {
+				explain.eliminate(fmt.Sprintf("country dropped, none of its %d Phase 1 probes answered", g.Probed), g.Nodes[g.Probed:]...)
+			}
+			continue
+		}
+		if outliers := len(g.Answered) - len(samples); outliers > 0 {
+			explain.note("%s: %d outlying Phase 1 ping(s) left out of its ranking", g.CountryCode, outliers)
+		}
+		if len(samples) < need && g.Probed < len(g.Nodes) {
+			explain.note("%s: ranked on %d of --min-country-probes %d Phase 1 pings", g.CountryCode, len(samples), need)
+		}
+		g.Latency = medianLatency(samples)
+		ranked = append(ranked, g)
+	}
+
+	sort.SliceStable(ranked, func(i, j int) bool {
+		return ranked[i].Latency < ranked[j].Latency
+	})
+
+	return ranked
+}
+
+// phaseSizes decides how broad phase 2 is. Small candidate sets are tested
+// exhaustively. Otherwise the contenders are the countries whose phase 1
+// latency is close to the fastest one: tightly clustered results mean the
+// single phase 1 samples can't separate them, so more countries are kept,
+// while a wide spread narrows phase 2 to the clear winners. The per-country
+// depth comes from a ping budget that grows with the square root of the
+// node count, so huge sets stay fast.
+func phaseSizes(ranked []*countryGroup) (countries, perCountry int) {
+	total, largest := 0, 0
+	for _, g := range ranked {
+		total += len(g.Nodes)
+		largest = max(largest, len(g.Nodes))
+	}
+
+	if total <= exhaustiveNodeLimit {
+		return len(ranked), largest
+	}
+
+	fastest := ranked[0].Latency
+	band := max(phase2Band, fastest*3/10)
+	for _, g := range ranked {
+		if g.Latency-fastest <= band {
+			countries++
+		}
+	}
+	countries = min(max(countries, minPhase2Countries), maxPhase2Countries, len(ranked))
+
+	budget := int(math.Ceil(2 * math.Sqrt(float64(total))))
+	perCountry = (budget + countries - 1) / countries
+	perCountry = min(max(perCountry, minPhase2NodesPerCountry), maxPhase2NodesPerCountry)
+
+	return countries, perCountry
+}
+
+// testTopCountriesInDepth is phase 2: ping the top priority nodes of the
+// fastest countries, all countries in one concurrent batch. Returns every
+// node that answered, phase 1 included.
+func testTopCountriesInDepth(ctx context.Context, p *prober, ranked []*countryGroup) []MullvadNode {
+	numCountries, perCountry := phaseSizes(ranked)
+	top := ranked[:numCountries]
+
+	if *verboseFlag {
+		fmt.Printf("\nPhase 2: Testing top %d nodes in each of the top %d countries...\n",
+			perCountry, len(top))
+	}
+	explain.note("Phase 2 sized to the top %d of %d countries, up to %d nodes each", len(top), len(ranked), perCountry)
+	for rank, g := range ranked[numCountries:] {
+		explain.eliminate(fmt.Sprintf("country %s ranked #%d in Phase 1 (%dms), outside the top %d",
+			g.CountryCode, numCountries+rank+1, g.Latency.Milliseconds(), numCountries), g.Nodes...)
+	}
+
+	// The batch interleaves the countries by node rank, so the pool works
+	// on every country's best nodes first, and a --good-enough cutoff
+	// abandons the least promising probes
+	candidates := make([][]MullvadNode, len(top))
+	var batch []MullvadNode
+	country := make(map[tailcfg.StableNodeID]int)
+	for i, g := range top {
+		end := max(min(perCountry, len(g.Nodes)), g.Probed)
+		candidates[i] = g.Nodes[g.Probed:end]
+		explain.eliminate(fmt.Sprintf("outside the top %d priority nodes of its country", perCountry),
+			g.Nodes[end:]...)
+	}
+	for rank := 0; rank < perCountry; rank++ {
+		for i := range top {
+			if rank < len(candidates[i]) {
+				batch = append(batch, candidates[i][rank])
+				country[candidates[i][rank].ID] = i
+			}
+		}
+	}
+
+	emit(event{Event: "phase_started", Phase: 2, Nodes: len(batch)})
+	results := make([][]pingResult, len(top))
+	found := false
+	for _, res := range p.pingAll(ctx, batch) {
+		emitProbe(2, res)
+		i := country[res.Node.ID]
+		results[i] = append(results[i], res)
+		found = found || (res.Err == nil && goodEnough(res.Node.Latency))
+	}
+
+	var tested []MullvadNode
+	for i, g := range top {
+		if *verboseFlag {
+			fmt.Printf("\nTesting nodes in %s (%s):\n", g.Country, g.CountryCode)
+			for _, n := range g.Answered {
+				fmt.Printf("  %s: %dms (from Phase 1)\n",
+					strings.TrimSuffix(n.DNSName, "."), n.Latency.Milliseconds())
+			}
+		}
+		tested = append(tested, g.Answered...)
+
+		for _, res := range results[i] {
+			name := displayName(res.Node)
+			if errors.Is(res.Err, errProbeSkipped) {
+				explain.eliminate("not probed, good enough node found", res.Node)
+				continue
+			}
+			if res.Err != nil {
+				if *verboseFlag {
+					fmt.Printf("  Ping to %s: failed (%v)\n", name, res.Err)
+				}
+				explain.eliminate("Phase 2 ping failed: "+res.Err.Error(), res.Node)
+				continue
+			}
+			if *verboseFlag {
+				fmt.Printf("  Ping to %s: %dms\n", name, res.Node.Latency.Milliseconds())
+			}
+			tested = append(tested, res.Node)
+		}
+	}
+
+	if found {
+		if *verboseFlag {
+			fmt.Printf("\nGood enough node found (<= %s), remaining probes skipped\n", *goodEnoughFlag)
+		}
+		explain.note("Found a node within --good-enough %s, remaining Phase 2 probes skipped", *goodEnoughFlag)
+	}
+
+	return tested
+}
+
+// emitProbe writes the node_probed event of a ping result
+func emitProbe(phase int, res pingResult) {
+	e := event{Phase: phase}
+	if res.Err != nil {
+		e.Error = res.Err.Error()
+	}
+	emitNode("node_probed", res.Node, e)
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"log"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"tailscale.com/tailcfg"
+)
+
+var latencyCacheTTLFlag = flag.Duration("latency-cache-ttl", 6*time.Hour, "Reuse latencies measured on the same network this recently to warm-start selection (0 to disable)")
+
+const (
+	// warmStartNodes is how many of the cached fastest nodes are re-tested
+	// on a known network before falling back to a full selection
+	warmStartNodes = 3
+
+	// warmStartTolerance is how much slower than its cached latency the
+	// best re-tested node may be for the cache to be trusted; the actual
+	// allowance is the larger of this and a quarter of the cached latency
+	warmStartTolerance = 10 * time.Millisecond
+
+	// maxCachedNetworks bounds the networks remembered in the state
+	maxCachedNetworks = 20
+)
+
+// networkLatencies is the latency cache of one network
+type networkLatencies struct {
+	Seen  time.Time                              `json:"seen"`
+	Nodes map[tailcfg.StableNodeID]cachedLatency `json:"nodes"`
+}
+
+// cachedLatency is the last latency measured to a node. Time is when a
+// full selection measured it; warm starts update the latency but keep the
+// time, so the entry still expires and a full selection runs again.
+type cachedLatency struct {
+	Latency time.Duration `json:"latency"`
+	Time    time.Time     `json:"time"`
+}
+
+// networkFingerprint identifies the network the host is attached to, so
+// latencies measured at home aren't reused on hotel Wi-Fi. It is derived
+// from the gateway's MAC where known, else the Wi-Fi access point, else the
+// gateway address and local subnets. Returns "" if none can be detected.
+func networkFingerprint(id NetworkIdentity) string {
+	var key string
+	switch {
+	case len(id.GatewayMAC) > 0:
+		key = "gateway-mac:" + id.GatewayMAC.String()
+	case id.BSSID != "":
+		key = "bssid:" + id.BSSID
+	case id.Gateway.IsValid():
+		parts := []string{"gateway:" + id.Gateway.String()}
+		for _, p := range id.Addrs {
+			parts = append(parts, p.Masked().String())
+		}
+		slices.Sort(parts[1:])
+		key = strings.Join(parts, ",")
+	default:
+		return ""
+	}
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:8])
+}
+
+// latencyCache is the cache of the current network for one selection
+type latencyCache struct {
+	fingerprint string
+	cached      map[tailcfg.StableNodeID]cachedLatency // Fresh entries only
+}
+
+// openLatencyCache loads the fresh cache entries of the current network.
+// Returns nil when caching is disabled or the network can't be identified.
+func openLatencyCache() *latencyCache {
+	if *latencyCacheTTLFlag <= 0 || !stateEnabled() {
+		return nil
+	}
+	fp := networkFingerprint(currentNetwork())
+	if fp == "" {
+		return nil
+	}
+
+	c := &latencyCache{fingerprint: fp, cached: make(map[tailcfg.StableNodeID]cachedLatency)}
+	st, err := loadState()
+	if err != nil {
+		return c
+	}
+	if nl := st.LatencyCache[fp]; nl != nil {
+		for id, l := range nl.Nodes {
+			if time.Since(l.Time) < *latencyCacheTTLFlag {
+				c.cached[id] = l
+			}
+		}
+	}
+	return c
+}
+
+// fastest returns the candidates with a cached latency, fastest first, at
+// most n
+func (c *latencyCache) fastest(nodes []MullvadNode, n int) []MullvadNode {
+	var known []MullvadNode
+	for _, node := range nodes {
+		if _, ok := c.cached[node.ID]; ok {
+			known = append(known, node)
+		}
+	}
+	sort.SliceStable(known, func(i, j int) bool {
+		return c.cached[known[i].ID].Latency < c.cached[known[j].ID].Latency
+	})
+	return known[:min(n, len(known))]
+}
+
+// warmStart re-tests the nodes that were fastest the last time this network
+// was seen. If the best of them is still about as fast as cached (or good
+// enough), selection ends there. Returns the answering nodes, fastest first,
+// or false to run a full selection.
+func (c *latencyCache) warmStart(ctx context.Context, p *prober, nodes []MullvadNode) ([]MullvadNode, bool) {
+	if c == nil {
+		return nil, false
+	}
+	candidates := c.fastest(nodes, warmStartNodes)
+	if len(candidates) == 0 {
+		return nil, false
+	}
+
+	if *verboseFlag {
+		fmt.Printf("\nWarm start: network seen before, re-testing the %d fastest cached nodes...\n", len(candidates))
+	}
+	var tested []MullvadNode
+	for _, res := range p.pingAll(ctx, candidates) {
+		if res.Err != nil {
+			continue
+		}
+		if *verboseFlag {
+			fmt.Printf("  %s: %dms (cached %dms)\n", displayName(res.Node),
+				res.Node.Latency.Milliseconds(), c.cached[res.Node.ID].Latency.Milliseconds())
+		}
+		tested = append(tested, res.Node)
+	}
+	if len(tested) == 0 {
+		return nil, false
+	}
+	sort.SliceStable(tested, func(i, j int) bool {
+		return tested[i].Latency < tested[j].Latency
+	})
+
+	best := tested[0]
+	cached := c.cached[best.ID].Latency
+	if !goodEnough(best.Latency) && best.Latency > cached+max(warmStartTolerance, cached/4) {
+		if *verboseFlag {
+			fmt.Println("  Slower than cached, running a full selection")
+		}
+		return nil, false
+	}
+
+	explain.note("Warm start from the latency cache of this network: %s re-tested at %dms (cached %dms)",
+		displayName(best), best.Latency.Milliseconds(), cached.Milliseconds())
+	for _, node := range nodes {
+		if nodeIndex(tested, node.ID) < 0 {
+			explain.eliminate("not probed, warm start from the latency cache", node)
+		}
+	}
+	return tested, true
+}
+
+// save records the latencies measured during the selection under the
+// current network, evicting the least recently seen networks beyond
+// maxCachedNetworks. The latencies of a warm start keep the measurement
+// time of the entries they re-tested.
+func (c *latencyCache) save(nodes []MullvadNode, warm bool) {
+	if c == nil {
+		return
+	}
+	now := time.Now()
+	err := updateState(func(st *state) {
+		if st.LatencyCache == nil {
+			st.LatencyCache = make(map[string]*networkLatencies)
+		}
+		nl := st.LatencyCache[c.fingerprint]
+		if nl == nil {
+			nl = &networkLatencies{Nodes: make(map[tailcfg.StableNodeID]cachedLatency)}
+			st.LatencyCache[c.fingerprint] = nl
+		}
+		nl.Seen = now
+		for _, node := range nodes {
+			if node.Latency <= 0 {
+				continue
+			}
+			measured := now
+			if l, ok := nl.Nodes[node.ID]; ok && warm {
+				measured = l.Time
+			}
+			nl.Nodes[node.ID] = cachedLatency{Latency: node.Latency, Time: measured}
+		}
+		for id, l := range nl.Nodes {
+			if now.Sub(l.Time) >= *latencyCacheTTLFlag {
+				delete(nl.Nodes, id)
+			}
+		}
+
+		for len(st.LatencyCache) > maxCachedNetworks {
+			oldest := ""
+			for fp, other := range st.LatencyCache {
+				if oldest == "" || other.Seen.Before(st.LatencyCache[oldest].Seen) {
+					oldest = fp
+				}
+			}
+			delete(st.LatencyCache, oldest)
+		}
+	})
+	if err != nil {
+		log.Printf("Warning: failed to update state: %v", err)
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"tailscale.com/tailcfg"
+)
+
+func TestLatencyCacheWarmStartKeepsMeasurementTime(t *testing.T) {
+	newTestClient(t)
+	measured := time.Now().Add(-5 * time.Hour)
+	err := updateState(func(st *state) {
+		st.LatencyCache = map[string]*networkLatencies{
+			"net": {Seen: measured, Nodes: map[tailcfg.StableNodeID]cachedLatency{"de1": {Latency: 18 * time.Millisecond, Time: measured}}},
+		}
+	})
+	if err != nil {
+		t.Fatalf("updateState: %v", err)
+	}
+
+	c := &latencyCache{fingerprint: "net"}
+	c.save([]MullvadNode{{ID: "de1", Latency: 20 * time.Millisecond}, {ID: "ch2", Latency: 22 * time.Millisecond}}, true)
+
+	st, err := loadState()
+	if err != nil {
+		t.Fatalf("loadState: %v", err)
+	}
+	nodes := st.LatencyCache["net"].Nodes
+	if l := nodes["de1"]; l.Latency != 20*time.Millisecond || !l.Time.Equal(measured) {
+		t.Errorf("re-tested entry = %+v, want the new latency at the first measurement time %v", l, measured)
+	}
+	if l := nodes["ch2"]; time.Since(l.Time) > time.Minute {
+		t.Errorf("new entry time = %v, want now", l.Time)
+	}
+
+	// A full selection measures afresh
+	c.save([]MullvadNode{{ID: "de1", Latency: 19 * time.Millisecond}}, false)
+	st, _ = loadState()
+	if l := st.LatencyCache["net"].Nodes["de1"]; time.Since(l.Time) > time.Minute {
+		t.Errorf("entry time after a full selection = %v, want now", l.Time)
+	}
+}
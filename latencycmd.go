@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+var samplesFlag = flag.Int("samples", 5, "Number of pings per node for the latency command")
+
+// latencyPlaceNodes is how many nodes of a city or country the latency
+// command probes, best Tailscale priority first
+const latencyPlaceNodes = 5
+
+func init() {
+	commands["latency"] = command{
+		Usage: "Ping a node, or the top nodes of a city or country, without running a selection: latency <node|place>",
+		Run:   runLatency,
+	}
+}
+
+// runLatency pings one node, or the top priority online nodes of a city or
+// country, --samples times each and prints the statistics
+func runLatency(ctx context.Context, lc LocalClient, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: %s latency <hostname|ID|IP|city|country>", os.Args[0])
+	}
+	nodes, err := getMullvadNodes(ctx, lc)
+	if err != nil {
+		return err
+	}
+	targets, err := latencyTargets(nodes, args[0])
+	if err != nil {
+		return err
+	}
+	p, err := newProber(lc)
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	samples := max(1, *samplesFlag)
+	single := len(targets) == 1
+	results := make([]*pingStats, 0, len(targets))
+	for _, node := range targets {
+		stats := &pingStats{window: samples}
+		if single {
+			fmt.Printf("Pinging %s (%s, %s), %d samples:\n", displayName(node), node.City, node.CountryCode, samples)
+		}
+		for i := 0; i < samples && ctx.Err() == nil; i++ {
+			latency, err := p.ping(ctx, node)
+			if ctx.Err() != nil {
+				break
+			}
+			stats.add(latency, err)
+			if !single {
+				continue
+			}
+			if err != nil {
+				fmt.Printf("  seq=%d lost: %v\n", i+1, err)
+			} else {
+				fmt.Printf("  seq=%d %dms\n", i+1, latency.Milliseconds())
+			}
+		}
+		results = append(results, stats)
+	}
+	if ctx.Err() != nil && len(results) == 0 {
+		return ctx.Err()
+	}
+
+	if single {
+		loss, _, avg, best, worst, jitter := results[0].summary()
+		fmt.Printf("\n--- %s: %d pings, %.1f%% loss, avg %s, best %s, worst %s, jitter %s ---\n",
+			displayName(targets[0]), results[0].sent, loss, ms(avg), ms(best), ms(worst), ms(jitter))
+		return nil
+	}
+
+	t := newTable("NODE", "LOCATION", "PRIO", "SENT", "LOSS%", "AVG", "BEST", "WORST", "JITTER")
+	for i := 2; i < len(t.headers); i++ {
+		t.right[i] = true
+	}
+	for i, stats := range results {
+		node := targets[i]
+		loss, _, avg, best, worst, jitter := stats.summary()
+		t.add(displayName(node), fmt.Sprintf("%s, %s", node.City, node.CountryCode), fmt.Sprint(node.Priority),
+			fmt.Sprint(stats.sent), fmt.Sprintf("%.1f", loss), ms(avg), ms(best), ms(worst), ms(jitter))
+	}
+	t.print(terminalWidth())
+	return nil
+}
+
+// latencyTargets resolves the latency command's argument: a node, or the
+// top priority online nodes of a city or country
+func latencyTargets(nodes []MullvadNode, name string) ([]MullvadNode, error) {
+	if node, ok := findNode(nodes, name); ok {
+		return []MullvadNode{node}, nil
+	}
+
+	var match func(MullvadNode) bool
+	if city := matchCity(name, nodes); city != "" {
+		match = func(n MullvadNode) bool { return n.City == city }
+	} else if code, err := resolveCountry(name, nodes); err == nil && hasCountry(nodes, code) {
+		match = func(n MullvadNode) bool { return n.CountryCode == code }
+	} else {
+		return nil, fmt.Errorf("node not found: %s (not a hostname, ID, IP, city or country)", name)
+	}
+
+	var targets []MullvadNode
+	for _, n := range nodes {
+		if n.Online && match(n) {
+			targets = append(targets, n)
+		}
+	}
+	if len(targets) == 0 {
+		return nil, errors.New("no online nodes in " + name)
+	}
+	// Nodes come sorted by priority
+	return targets[:min(len(targets), latencyPlaceNodes)], nil
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+
+	"tailscale.com/client/tailscale"
+	"tailscale.com/ipn"
+	"tailscale.com/tailcfg"
+)
+
+// fakeLocalAPI serves the LocalAPI endpoints protect-wan calls from a
+// fakeClient, so the real *tailscale.LocalClient runs against it. Unlike
+// using the fakeClient directly, this covers the HTTP requests tailscaled
+// receives.
+type fakeLocalAPI struct {
+	fake *fakeClient
+}
+
+// serveFakeLocalAPI starts a fake tailscaled for fake, stopped at the end
+// of the test
+func serveFakeLocalAPI(t *testing.T, fake *fakeClient) *httptest.Server {
+	srv := httptest.NewServer(&fakeLocalAPI{fake: fake})
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// localAPIClient returns a LocalClient talking to the fake tailscaled at
+// addr instead of tailscaled's socket
+func localAPIClient(addr string) *tailscale.LocalClient {
+	var d net.Dialer
+	return &tailscale.LocalClient{
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			return d.DialContext(ctx, network, addr)
+		},
+	}
+}
+
+func (api *fakeLocalAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	path := strings.TrimPrefix(r.URL.Path, "/localapi/v0/")
+
+	switch {
+	case path == "status" && r.Method == http.MethodGet:
+		getStatus := api.fake.Status
+		if r.URL.Query().Get("peers") == "false" {
+			getStatus = api.fake.StatusWithoutPeers
+		}
+		status, err := getStatus(ctx)
+		reply(w, status, err)
+
+	case path == "prefs" && r.Method == http.MethodGet:
+		prefs, err := api.fake.GetPrefs(ctx)
+		reply(w, prefs, err)
+
+	case path == "prefs" && r.Method == http.MethodPatch:
+		var mp ipn.MaskedPrefs
+		if err := json.NewDecoder(r.Body).Decode(&mp); err != nil {
+			writeLocalAPIError(w, http.StatusBadRequest, err)
+			return
+		}
+		prefs, err := api.fake.EditPrefs(ctx, &mp)
+		reply(w, prefs, err)
+
+	case path == "ping" && r.Method == http.MethodPost:
+		ip, err := netip.ParseAddr(r.URL.Query().Get("ip"))
+		if err != nil {
+			writeLocalAPIError(w, http.StatusBadRequest, err)
+			return
+		}
+		res, err := api.fake.Ping(ctx, ip, tailcfg.PingType(r.URL.Query().Get("type")))
+		reply(w, res, err)
+
+	case path == "suggest-exit-node" && r.Method == http.MethodGet:
+		suggestion, err := api.fake.SuggestExitNode(ctx)
+		reply(w, suggestion, err)
+
+	case path == "profiles/current" && r.Method == http.MethodGet:
+		current, _, err := api.fake.ProfileStatus(ctx)
+		reply(w, current, err)
+
+	case path == "profiles/" && r.Method == http.MethodGet:
+		_, all, err := api.fake.ProfileStatus(ctx)
+		reply(w, all, err)
+
+	case strings.HasPrefix(path, "profiles/") && r.Method == http.MethodPost:
+		if err := api.fake.SwitchProfile(ctx, ipn.ProfileID(strings.TrimPrefix(path, "profiles/"))); err != nil {
+			writeLocalAPIError(w, http.StatusInternalServerError, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		writeLocalAPIError(w, http.StatusNotFound, fmt.Errorf("%s %s not supported by the fake tailscaled", r.Method, r.URL.Path))
+	}
+}
+
+// reply writes a fakeClient result as tailscaled would: the value as
+// JSON, or the error as a JSON error body
+func reply(w http.ResponseWriter, v any, err error) {
+	if err != nil {
+		writeLocalAPIError(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeLocalAPIError writes err in the form the LocalClient decodes
+func writeLocalAPIError(w http.ResponseWriter, code int, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(struct {
+		Error string `json:"error"`
+	}{err.Error()})
+}
+
+func TestFakeLocalAPI(t *testing.T) {
+	ctx := context.Background()
+	fake := newTestClient(t, testTailnet...)
+	lc := localAPIClient(serveFakeLocalAPI(t, fake).Listener.Addr().String())
+
+	nodes, err := getMullvadNodes(ctx, lc)
+	if err != nil || len(nodes) != len(testTailnet) {
+		t.Fatalf("getMullvadNodes = %d nodes, %v; want %d nodes", len(nodes), err, len(testTailnet))
+	}
+	if err := setExitNode(ctx, lc, "ch2"); err != nil {
+		t.Fatalf("setExitNode: %v", err)
+	}
+	if node, ok, err := currentExitNode(ctx, lc); err != nil || !ok || node.ID != "ch2" {
+		t.Errorf("currentExitNode = %q, %v, %v; want ch2", node.ID, ok, err)
+	}
+	if _, err := lc.Ping(ctx, netip.MustParseAddr("100.100.0.4"), tailcfg.PingDisco); err == nil {
+		t.Errorf("Ping to an offline node succeeded, want the fake's error")
+	}
+}
@@ -0,0 +1,9 @@
+//go:build !darwin && !windows
+
+package main
+
+// systemLocale is not needed here: the locale variables are the system
+// locale
+func systemLocale() string {
+	return ""
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+// mullvadHostnamePattern matches Mullvad server names such as
+// ch-zrh-wg-001: country code, city code, protocol and number
+var mullvadHostnamePattern = regexp.MustCompile(`^([a-z]{2})-([a-z]{3})-[a-z0-9]+-[0-9]+`)
+
+// inferLocations fills in the location of nodes whose peer has no Location
+// (seen with some tailscale versions) from their Mullvad server name, so
+// they stay filterable and rankable. Country and city names come from a
+// node in the same place that has a location; without one, the codes
+// stand in. An inferred node gets the worst priority of its city, or of
+// all nodes, rather than 0, which would make it the closest.
+func inferLocations(nodes []MullvadNode) {
+	type place struct {
+		country, city string
+		priority      int
+	}
+	cities := make(map[string]place)     // By country and city code
+	countries := make(map[string]string) // Country names by code
+	worst := 0
+	for _, n := range nodes {
+		if n.CountryCode == "" {
+			continue
+		}
+		key := strings.ToLower(n.CountryCode + "-" + n.CityCode)
+		p := cities[key]
+		p.country, p.city = n.Country, n.City
+		p.priority = max(p.priority, n.Priority)
+		cities[key] = p
+		countries[strings.ToUpper(n.CountryCode)] = n.Country
+		worst = max(worst, n.Priority)
+	}
+
+	for i, n := range nodes {
+		if n.CountryCode != "" {
+			continue
+		}
+		m := mullvadHostnamePattern.FindStringSubmatch(strings.ToLower(mullvadHostname(n)))
+		if m == nil {
+			continue
+		}
+		n.CountryCode, n.CityCode = strings.ToUpper(m[1]), m[2]
+		n.Country, n.City, n.Priority = n.CountryCode, strings.ToUpper(m[2]), worst
+		if name, ok := countries[n.CountryCode]; ok {
+			n.Country = name
+		}
+		if p, ok := cities[m[1]+"-"+m[2]]; ok {
+			n.City, n.Priority = p.city, p.priority
+		}
+		n.LocationInferred = true
+		nodes[i] = n
+	}
+}
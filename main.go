@@ -2,118 +2,453 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
 	"net/netip"
 	"os"
+	"slices"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 
-	"tailscale.com/client/tailscale"
 	"tailscale.com/ipn"
+	"tailscale.com/ipn/ipnstate"
 	"tailscale.com/tailcfg"
 )
 
 var (
-	checkFlag       = flag.Bool("check", false, "Only check current exit node status and exit")
-	setFlag         = flag.String("set", "", "Set specific exit node by ID or hostname")
-	listFlag        = flag.Bool("list", false, "List all available Mullvad exit nodes")
-	countryFlag     = flag.String("country", "", "Filter Mullvad nodes by country code (e.g., US, CH, SE)")
-	autoFlag        = flag.Bool("auto", false, "Auto-select best Mullvad exit node")
-	disableFlag     = flag.Bool("disable", false, "Disable exit node")
-	verboseFlag     = flag.Bool("verbose", false, "Enable detailed logging")
+	checkFlag        = flag.Bool("check", false, "Only check current exit node status and exit")
+	setFlag          = flag.String("set", "", "Set specific exit node by ID, hostname or Tailscale IP, or the best node in a city or country")
+	listFlag         = flag.Bool("list", false, "List all available Mullvad exit nodes")
+	countryFlag      = flag.String("country", "", "Filter Mullvad nodes by country code or name (e.g., US, CH, UK, Netherlands)")
+	autoFlag         = flag.Bool("auto", false, "Auto-select best Mullvad exit node")
+	disableFlag      = flag.Bool("disable", false, "Disable exit node")
+	verboseFlag      = flag.Bool("verbose", false, "Enable detailed logging")
+	portalBypassFlag = flag.Duration("portal-bypass", 0, "Disable the exit node for up to this long to log in to a captive portal, then restore it")
+	topFlag          = flag.Int("top", 0, "After auto-selection, print the top N ranked candidates")
+	staleAfterFlag   = flag.Duration("stale-after", 24*time.Hour, "Show offline nodes last seen by control longer ago than this as stale, e.g. retired servers (0 to disable)")
+	ipv6LeakFlag     = flag.String("ipv6-leak", "warn", "How to treat IPv6 traffic bypassing the exit node: off, warn, fail")
 )
 
+// errMullvadMissing means Tailscale is running but no Mullvad peers exist,
+// which almost always is an expired or missing Mullvad add-on
+var errMullvadMissing = errors.New("no Mullvad exit nodes found although Tailscale is running: the Mullvad VPN add-on has likely expired or is not enabled for this device")
+
+// exitMullvadMissing is the exit code for errMullvadMissing
+const exitMullvadMissing = 3
+
+// exitTooSlow is the exit code of --check when the exit node is active but
+// slower than --max-latency, or doesn't answer pings
+const exitTooSlow = 4
+
 type MullvadNode struct {
-	ID           tailcfg.StableNodeID
-	DNSName      string
-	Country      string
-	CountryCode  string
-	City         string
-	CityCode     string
-	Priority     int
-	Online       bool
-	TailscaleIPs []netip.Addr // Tailscale IP addresses for pinging
-	Latency      time.Duration // Measured latency (0 if not tested)
+	ID               tailcfg.StableNodeID
+	DNSName          string
+	Country          string
+	CountryCode      string
+	City             string
+	CityCode         string
+	Priority         int
+	Online           bool
+	TailscaleIPs     []netip.Addr  // Tailscale IP addresses for pinging
+	Latency          time.Duration // Measured latency (0 if not tested)
+	LastSeen         time.Time     `json:",omitzero"` // Last seen by control, zero if unknown
+	NoIPv6Route      bool          `json:",omitzero"` // Exit node does not route ::/0
+	LocationInferred bool          `json:",omitzero"` // Location from the Mullvad server name, the peer had none
+	Path             string        `json:",omitzero"` // Path Latency was measured over: direct, derp:<region> or peer-relay, "" if unknown
 }
 
 func main() {
-	flag.Parse()
+	cmdName, cmdArgs := parseCommandLine()
+
+	// config init writes the config file, which needn't exist yet
+	if !isConfigInit(cmdName, cmdArgs) {
+		if err := loadConfig(); err != nil {
+			log.Fatalf("Error loading config: %v", err)
+		}
+	}
+	if err := checkCommandLineLocks(); err != nil {
+		log.Fatalf("Error loading admin config: %v", err)
+	}
+
+	if *logFileFlag != "" {
+		if err := openLogFile(*logFileFlag); err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+	}
+
+	if *policyFlag != "" {
+		p, err := loadPolicy(*policyFlag)
+		if err != nil {
+			log.Fatalf("Error loading policy: %v", err)
+		}
+		activePolicy = p
+	}
+
+	switch *ipv6LeakFlag {
+	case "off", "warn", "fail":
+	default:
+		log.Fatalf("Invalid --ipv6-leak value %q (expected off, warn or fail)", *ipv6LeakFlag)
+	}
+
+	switch *outputFlag {
+	case "text", "geojson":
+	case "ndjson":
+		startEvents()
+		emit(event{Event: "run_started", Command: cmdName})
+	default:
+		log.Fatalf("Invalid --output value %q (expected text, geojson or ndjson)", *outputFlag)
+	}
+
+	if *ntfyLevelFlag != severityFailure && *ntfyLevelFlag != severityInfo {
+		log.Fatalf("Invalid --ntfy-level value %q (expected failure or info)", *ntfyLevelFlag)
+	}
+
+	if _, err := parseRetention(retentionFlag); err != nil {
+		log.Fatalf("Invalid --retention: %v", err)
+	}
+	if *stateStoreFlag != storeJSON && *stateStoreFlag != storeBolt {
+		log.Fatalf("Invalid --state-store value %q (expected json or bolt)", *stateStoreFlag)
+	}
+	if _, err := parseNotifyLimits(notifyLimitFlag); err != nil {
+		log.Fatalf("Invalid --notify-limit: %v", err)
+	}
+
+	if err := validateSMTP(); err != nil {
+		log.Fatalf("Invalid SMTP settings: %v", err)
+	}
+
+	switch *tailscaleAutoFlag {
+	case "defer", "override", "reconcile":
+	default:
+		log.Fatalf("Invalid --tailscale-auto value %q (expected defer, override or reconcile)", *tailscaleAutoFlag)
+	}
+
+	switch *otherVPNFlag {
+	case "off", "warn", "skip-latency", "fail":
+	default:
+		log.Fatalf("Invalid --other-vpn value %q (expected off, warn, skip-latency or fail)", *otherVPNFlag)
+	}
+
+	if _, err := parsePingTypes(*pingTypeFlag); err != nil {
+		log.Fatalf("Invalid --ping-type: %v", err)
+	}
+
+	if _, _, err := selectedStrategy(); err != nil {
+		log.Fatalf("Invalid --strategy: %v", err)
+	}
+
+	if _, err := parseAttrRules(requireFlag); err != nil {
+		log.Fatalf("Invalid --require: %v", err)
+	}
+	if _, err := parseAttrRules(preferAttrFlag); err != nil {
+		log.Fatalf("Invalid --prefer-attr: %v", err)
+	}
+	if _, err := parseNodePatterns(includeNodeFlag); err != nil {
+		log.Fatalf("Invalid --include-node: %v", err)
+	}
+	if _, err := parseNodePatterns(excludeNodeFlag); err != nil {
+		log.Fatalf("Invalid --exclude-node: %v", err)
+	}
+
+	if *wideFlag && *shortFlag {
+		log.Fatalf("--wide and --short are mutually exclusive")
+	}
+	if err := configureProxy(); err != nil {
+		log.Fatalf("Invalid --proxy: %v", err)
+	}
+	if *locationFlag != "" && *locationFlag != "auto" {
+		if _, err := parseGeoPoint(*locationFlag); err != nil {
+			log.Fatalf("Invalid --location value %q (expected lat,lon or auto): %v", *locationFlag, err)
+		}
+	}
+	for _, target := range probeServiceFlag {
+		if err := parseServiceTarget(target); err != nil {
+			log.Fatalf("Invalid --probe-service: %v", err)
+		}
+	}
+	if _, err := parsePortProbes(probePortFlag); err != nil {
+		log.Fatalf("Invalid --probe-port: %v", err)
+	}
+	if len(probeServiceFlag) > 0 && !*trialFlag {
+		log.Fatalf("--probe-service requires --trial")
+	}
+	if *minProbesFlag < 1 {
+		log.Fatalf("Invalid --min-country-probes %d (expected 1 or more)", *minProbesFlag)
+	}
+	if *topIntervalFlag <= 0 {
+		log.Fatalf("Invalid --top-interval %v (expected more than 0)", *topIntervalFlag)
+	}
+	if *monitorIntervalFlag <= 0 {
+		log.Fatalf("Invalid --monitor-interval %v (expected more than 0)", *monitorIntervalFlag)
+	}
+	if *sameCountryFlag && *differentCountryFlag {
+		log.Fatalf("--same-country-as-me and --different-country-than-me are mutually exclusive")
+	}
+	if *monitorOnlyFlag && !*daemonFlag {
+		log.Fatalf("--monitor-only requires --daemon")
+	}
+	if err := setLanguage(); err != nil {
+		log.Fatalf("Invalid --lang: %v", err)
+	}
+
+	switch *subnetConflictsFlag {
+	case "off", "warn", "adjust", "fail":
+	default:
+		log.Fatalf("Invalid --subnet-conflicts value %q (expected off, warn, adjust or fail)", *subnetConflictsFlag)
+	}
+
+	switch *dbusFlag {
+	case "", "session", "system":
+	default:
+		log.Fatalf("Invalid --dbus value %q (expected session or system)", *dbusFlag)
+	}
+
+	if *namesFlag != "dns" && *namesFlag != "mullvad" {
+		log.Fatalf("Invalid --names value %q (expected dns or mullvad)", *namesFlag)
+	}
+
+	if *notifyLevelFlag != severityFailure && *notifyLevelFlag != severityInfo {
+		log.Fatalf("Invalid --notify-level value %q (expected failure or info)", *notifyLevelFlag)
+	}
 
 	ctx := context.Background()
-	lc := &tailscale.LocalClient{}
+	if *wireguardDirFlag != "" {
+		runWireGuard(ctx, cmdName, cmdArgs)
+	}
+	lc := newLocalClient()
+	if *auditLogFlag != "" {
+		lc = auditClient{LocalClient: lc, path: *auditLogFlag}
+	}
+
+	switch {
+	case *replayFlag != "":
+		fake, err := loadReplay(*replayFlag, *replayLatencyFlag)
+		if err != nil {
+			log.Fatalf("Error loading replay: %v", err)
+		}
+		lc = fake
+		stateReadOnly = true
+		if cmdName, err = offlineCommand(cmdName, "--replay"); err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+	case *snapshotFlag != "":
+		snap, err := readSnapshot(*snapshotFlag)
+		if err != nil {
+			log.Fatalf("Error loading snapshot: %v", err)
+		}
+		lc = snapshotClient(snap)
+		stateReadOnly = true
+		if cmdName, err = offlineCommand(cmdName, "--snapshot"); err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+		printOffline(snap)
+	case cmdName == "best" || cmdName == "list" || (cmdName == "" && *listFlag):
+		// Read-only commands degrade to the cached nodes without tailscaled
+		if snap, err := offlineNodes(ctx, lc); err == nil && snap != nil {
+			lc = snapshotClient(snap)
+			stateReadOnly = true
+			printOffline(snap)
+		}
+	}
+
+	if *monitorOnlyFlag {
+		lc = readOnlyClient{LocalClient: lc}
+	}
+
+	if *replayFlag == "" && *snapshotFlag == "" {
+		if err := useProfile(ctx, lc); err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+		warnUnnecessaryRoot(ctx, lc)
+	}
+
+	if err := applyPolicy(ctx, lc); err != nil {
+		log.Fatalf("Error applying policy: %v", err)
+	}
+
+	if cmdName != "" {
+		if cmd, ok := commands[cmdName]; ok {
+			if err := cmd.Run(ctx, lc, cmdArgs); err != nil {
+				fatal(ctx, trf("Error running %s", cmdName), err)
+			}
+			os.Exit(0)
+		}
+		if err := applyFlagCommand(cmdName, cmdArgs); err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+	}
 
 	// Handle explicit flags first
 	if *checkFlag {
 		exitNodeActive, err := checkExitNode(ctx, lc)
 		if err != nil {
-			log.Fatalf("Error checking exit node: %v", err)
+			fatal(ctx, "Error checking exit node", err)
+		}
+		if exitNodeActive && len(probePortFlag) > 0 {
+			reportPorts(ctx, lc)
+		}
+		if exitNodeActive && *maxLatencyFlag > 0 {
+			node, latency, err := exitLatency(ctx, lc)
+			if err != nil {
+				fmt.Println(trf("WAN is protected but the exit node is unreachable: %v", err))
+				os.Exit(exitTooSlow)
+			}
+			if latency > *maxLatencyFlag {
+				fmt.Println(trf("WAN is protected but slow: %s latency %dms exceeds --max-latency %s",
+					displayName(node), latency.Milliseconds(), *maxLatencyFlag))
+				os.Exit(exitTooSlow)
+			}
+			fmt.Println(trf("WAN is protected - Latency: %dms", latency.Milliseconds()))
+			os.Exit(0)
 		}
 		if exitNodeActive {
-			fmt.Println("WAN is protected")
+			fmt.Println(tr("WAN is protected"))
+			os.Exit(0)
+		} else if rule, trusted := onTrustedNetwork(); trusted {
+			fmt.Println(trf("No exit node active (trusted network: %s)", rule))
 			os.Exit(0)
 		} else {
-			fmt.Println("No exit node active")
+			fmt.Println(tr("No exit node active"))
 			os.Exit(1)
 		}
 	}
 
 	if *listFlag {
 		if err := listMullvadNodes(ctx, lc); err != nil {
-			log.Fatalf("Error listing Mullvad nodes: %v", err)
+			fatal(ctx, "Error listing Mullvad nodes", err)
 		}
 		os.Exit(0)
 	}
 
+	if *portalBypassFlag > 0 {
+		if err := bypassCaptivePortal(ctx, lc, *portalBypassFlag); err != nil {
+			log.Fatalf("Error bypassing captive portal: %v", err)
+		}
+		os.Exit(0)
+	}
+
+	if *disableFlag {
+		if err := checkEnforced(); err != nil {
+			log.Fatalf("Error disabling exit node: %v", err)
+		}
+	}
+
+	// A running daemon would undo a change made behind its back
+	if *disableFlag || *setFlag != "" || *autoFlag {
+		if delegated, err := delegateToDaemon(); delegated {
+			if err != nil {
+				log.Fatalf("Error: %v", err)
+			}
+			os.Exit(0)
+		}
+	}
+
 	if *disableFlag {
-		if err := clearExitNode(ctx, lc); err != nil {
+		if err := clearExitNode(withAuditReason(ctx, "--disable"), lc); err != nil {
 			log.Fatalf("Error disabling exit node: %v", err)
 		}
-		fmt.Println("Exit node disabled successfully")
+		fmt.Println(tr("Exit node disabled successfully"))
 		os.Exit(0)
 	}
 
 	if *setFlag != "" {
 		if err := setExitNodeByName(ctx, lc, *setFlag); err != nil {
-			log.Fatalf("Error setting exit node: %v", err)
+			fatal(ctx, "Error setting exit node", err)
 		}
-		fmt.Printf("Exit node set to: %s\n", *setFlag)
 		os.Exit(0)
 	}
 
 	if *autoFlag {
 		if err := autoSelectMullvad(ctx, lc); err != nil {
-			log.Fatalf("Error auto-selecting Mullvad node: %v", err)
+			fatalSelection(ctx, err)
+		}
+		os.Exit(0)
+	}
+
+	if *daemonFlag {
+		if err := runDaemon(ctx, lc); err != nil {
+			log.Fatalf("Error running daemon: %v", err)
 		}
 		os.Exit(0)
 	}
 
 	// Default behavior: check if exit node is active, if not, auto-select
 	exitNodeActive, err := checkExitNode(ctx, lc)
+	var forbidden *forbiddenError
+	if errors.As(err, &forbidden) {
+		// Replaced like an exit node that doesn't protect, rather than
+		// failing every scheduled run
+		fmt.Printf("Warning: %v, selecting another\n", err)
+		err = nil
+	}
 	if err != nil {
-		log.Fatalf("Error checking exit node: %v", err)
+		fatal(ctx, "Error checking exit node", err)
 	}
 
 	if exitNodeActive {
-		fmt.Println("WAN is protected")
+		fmt.Println(tr("WAN is protected"))
 		os.Exit(0)
 	}
 
+	// Trusted networks (e.g. home LAN) don't need an exit node forced
+	if rule, trusted := onTrustedNetwork(); trusted {
+		fmt.Println(trf("Trusted network (%s): exit node not enforced", rule))
+		os.Exit(0)
+	}
+
+	// Selecting an exit node behind a captive portal would only lock us out
+	// of the login page, so ask the user to log in first
+	if captive, location, err := detectCaptivePortal(ctx); err == nil && captive {
+		fmt.Println(tr("No exit node active: captive portal detected"))
+		if location != "" {
+			fmt.Println(trf("Log in at %s, then run %s again", location, os.Args[0]))
+		}
+		os.Exit(1)
+	}
+
 	// No exit node active, auto-select best Mullvad node
 	if *verboseFlag {
-		fmt.Println("No exit node active. Auto-selecting best Mullvad node...")
+		fmt.Println(tr("No exit node active. Auto-selecting best Mullvad node..."))
 	}
 
 	if err := autoSelectMullvad(ctx, lc); err != nil {
-		log.Fatalf("Error auto-selecting Mullvad node: %v", err)
+		fatalSelection(ctx, err)
+	}
+}
+
+// fatal logs err and exits. Failures that need a human (missing Mullvad
+// add-on, permission denied, failed verification) are notified, and a
+// missing add-on gets its own exit code, so it isn't mistaken for a
+// transient error.
+func fatal(ctx context.Context, msg string, err error) {
+	emit(event{Event: "run_failed", Error: err.Error()})
+	if event := failureEvent(err); event != "selection-failed" {
+		notifyFailure(ctx, event, err.Error())
+	}
+	if errors.Is(err, errMullvadMissing) {
+		log.Printf("%s: %v", tr(msg), err)
+		os.Exit(exitMullvadMissing)
 	}
+	log.Fatalf("%s: %v", tr(msg), err)
+}
+
+// fatalSelection is fatal for a failed exit node selection, which is
+// notified as such
+func fatalSelection(ctx context.Context, err error) {
+	if failureEvent(err) == "selection-failed" {
+		notifyFailure(ctx, "selection-failed", err.Error())
+	}
+	fatal(ctx, "Error auto-selecting Mullvad node", err)
 }
 
 // checkExitNode checks if an exit node is currently active
 // Returns true if active, false otherwise
-func checkExitNode(ctx context.Context, lc *tailscale.LocalClient) (bool, error) {
+// Returns an error if the exit node is set but the OS routes around it
+func checkExitNode(ctx context.Context, lc LocalClient) (bool, error) {
 	status, err := lc.StatusWithoutPeers(ctx)
 	if err != nil {
 		return false, fmt.Errorf("failed to get status: %w", err)
@@ -125,6 +460,36 @@ func checkExitNode(ctx context.Context, lc *tailscale.LocalClient) (bool, error)
 			fmt.Printf("  ID: %s\n", status.ExitNodeStatus.ID)
 			fmt.Printf("  Online: %v\n", status.ExitNodeStatus.Online)
 			fmt.Printf("  IPs: %v\n", status.ExitNodeStatus.TailscaleIPs)
+			fmt.Printf("  Selected: %s\n", describeSelection(status.ExitNodeStatus.ID))
+		}
+
+		// Prefs say protected; make sure the OS routing table agrees
+		if err := verifyDefaultRoute(status); err != nil {
+			var leak *routeLeakError
+			if errors.As(err, &leak) {
+				return false, err
+			}
+			if *verboseFlag {
+				fmt.Printf("Could not verify default route: %v\n", err)
+			}
+		}
+
+		if *probeRouteFlag {
+			if err := probeRoute(ctx, lc); err != nil {
+				return false, err
+			}
+		}
+
+		if *ipv6LeakFlag != "off" {
+			if err := handleIPv6Leak(checkIPv6Egress(ctx, status)); err != nil {
+				return false, err
+			}
+		}
+
+		// Set in the Tailscale client, say, an exit node may still be in a
+		// forbidden country
+		if err := checkActiveForbidden(ctx, lc); err != nil {
+			return false, err
 		}
 		return true, nil
 	}
@@ -133,7 +498,7 @@ func checkExitNode(ctx context.Context, lc *tailscale.LocalClient) (bool, error)
 }
 
 // listMullvadNodes lists all available Mullvad exit nodes
-func listMullvadNodes(ctx context.Context, lc *tailscale.LocalClient) error {
+func listMullvadNodes(ctx context.Context, lc LocalClient) error {
 	nodes, err := getMullvadNodes(ctx, lc)
 	if err != nil {
 		return err
@@ -144,41 +509,168 @@ func listMullvadNodes(ctx context.Context, lc *tailscale.LocalClient) error {
 		fmt.Println("Note: Mullvad VPN add-on requires a subscription ($5/month per 5 devices)")
 		return nil
 	}
+	recordNodes(ctx, nodes)
 
-	// Apply country filter if specified
-	if *countryFlag != "" {
-		filtered := make([]MullvadNode, 0)
+	nodes, err = filterByHomeCountry(ctx, lc, nodes)
+	if err != nil {
+		return err
+	}
+
+	// Apply region and country filters if specified
+	nodes, err = filterByLocation(nodes)
+	if err != nil {
+		return err
+	}
+	nodes, err = filterByNodePatterns(nodes)
+	if err != nil {
+		return err
+	}
+	nodes, err = filterByAttributes(ctx, nodes)
+	if err != nil {
+		return err
+	}
+
+	switch *outputFlag {
+	case "geojson":
+		return writeGeoJSON(ctx, nodes)
+	case "ndjson":
 		for _, node := range nodes {
-			if strings.EqualFold(node.CountryCode, *countryFlag) {
-				filtered = append(filtered, node)
-			}
+			emitNode("node_listed", node, event{})
 		}
-		nodes = filtered
+		return nil
 	}
 
 	fmt.Printf("Available Mullvad Exit Nodes (%d):\n", len(nodes))
-	fmt.Println(strings.Repeat("-", 80))
-	fmt.Printf("%-40s %-20s %-8s %s\n", "HOSTNAME", "LOCATION", "ONLINE", "PRIORITY")
-	fmt.Println(strings.Repeat("-", 80))
+	if *wideFlag {
+		if paths, err := peerPaths(ctx, lc); err == nil {
+			for i := range nodes {
+				nodes[i].Path = paths[nodes[i].ID]
+			}
+		}
+	}
+	printNodeTable(ctx, nodes)
+	if slices.ContainsFunc(nodes, func(n MullvadNode) bool { return n.LocationInferred }) {
+		fmt.Println("\n~ location inferred from the server name, Tailscale reported none")
+	}
+
+	if *exportFlag != "" {
+		if err := writeSnapshot(*exportFlag, nodes); err != nil {
+			return err
+		}
+		fmt.Printf("\nExported %d nodes to %s\n", len(nodes), *exportFlag)
+	}
+
+	return nil
+}
 
+// printNodeTable prints the nodes as a table fitted to the terminal.
+// --short drops to the essentials; --wide adds the latency cached for the
+// current network, the path to the node (direct or the DERP region) and
+// relay attributes from the Mullvad server list.
+func printNodeTable(ctx context.Context, nodes []MullvadNode) {
+	health := loadHealth()
+	now := time.Now()
+	status := func(node MullvadNode) string {
+		switch {
+		case isStale(node):
+			return "Stale"
+		case !node.Online:
+			return "No"
+		case health[node.ID].quarantined(now):
+			return "Quarantined"
+		}
+		return "Yes"
+	}
+
+	if *shortFlag {
+		t := newTable("NAME", "LOC", "ONLINE")
+		for _, node := range nodes {
+			t.add(mullvadHostname(node), strings.ToUpper(node.CityCode)+" "+node.CountryCode, status(node))
+		}
+		t.print(terminalWidth())
+		return
+	}
+
+	// Wide tables expand to their full width; otherwise DNS names fall
+	// back to Mullvad names, then get truncated, to fit the terminal
+	width := terminalWidth()
+	if *wideFlag {
+		width = 0
+	}
+	t := nodeTable(ctx, nodes, displayName, status, health, now)
+	if width > 0 && !t.fits(width) && *namesFlag == "dns" {
+		t = nodeTable(ctx, nodes, mullvadHostname, status, health, now)
+	}
+	t.print(width)
+}
+
+// nodeTable builds the normal or --wide node table
+func nodeTable(ctx context.Context, nodes []MullvadNode, name, status func(MullvadNode) string,
+	health map[tailcfg.StableNodeID]*nodeHealth, now time.Time) *table {
+	headers := []string{"HOSTNAME", "LOCATION", "ONLINE", "PRIORITY"}
+	var (
+		cache *latencyCache
+		attrs map[string]relayAttrs
+	)
+	if *wideFlag {
+		headers = append(headers, "LATENCY", "PATH", "PROVIDER", "ATTRIBUTES", "UNTIL")
+		cache = openLatencyCache()
+		var err error
+		if attrs, err = fetchRelayAttrs(ctx); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: %v, relay attributes unavailable\n", err)
+		}
+	}
+
+	t := newTable(headers...)
+	t.right[3] = true
+	t.right[4] = true
 	for _, node := range nodes {
 		location := fmt.Sprintf("%s, %s", node.City, node.CountryCode)
-		onlineStr := "Yes"
-		if !node.Online {
-			onlineStr = "No"
+		if node.LocationInferred {
+			location = "~" + location
 		}
-		fmt.Printf("%-40s %-20s %-8s %d\n",
-			strings.TrimSuffix(node.DNSName, "."),
+		row := []string{
+			name(node),
 			location,
-			onlineStr,
-			node.Priority)
+			status(node),
+			strconv.Itoa(node.Priority),
+		}
+		if *wideFlag {
+			latency := "-"
+			if cache != nil {
+				if l, ok := cache.cached[node.ID]; ok {
+					latency = fmt.Sprintf("%dms", l.Latency.Milliseconds())
+				}
+			}
+			provider, features := "-", "-"
+			if a, ok := attrs[mullvadHostname(node)]; ok {
+				provider = a.Provider
+				var f []string
+				for _, attr := range []struct {
+					has  bool
+					name string
+				}{{a.RAMOnly, "ram-only"}, {a.DAITA, "daita"}, {a.Owned, "owned"}} {
+					if attr.has {
+						f = append(f, attr.name)
+					}
+				}
+				if len(f) > 0 {
+					features = strings.Join(f, ",")
+				}
+			}
+			until := ""
+			if h := health[node.ID]; h.quarantined(now) {
+				until = h.Until.Format("Jan 2 15:04")
+			}
+			row = append(row, latency, pathLabel(node.Path), provider, features, until)
+		}
+		t.add(row...)
 	}
-
-	return nil
+	return t
 }
 
 // getMullvadNodes retrieves all Mullvad exit nodes from Tailscale status
-func getMullvadNodes(ctx context.Context, lc *tailscale.LocalClient) ([]MullvadNode, error) {
+func getMullvadNodes(ctx context.Context, lc LocalClient) ([]MullvadNode, error) {
 	status, err := lc.Status(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get status: %w", err)
@@ -188,25 +680,11 @@ func getMullvadNodes(ctx context.Context, lc *tailscale.LocalClient) ([]MullvadN
 
 	for _, peer := range status.Peer {
 		// Check if this is a Mullvad exit node
-		if peer.ExitNodeOption && strings.HasSuffix(peer.DNSName, ".mullvad.ts.net.") {
-			node := MullvadNode{
-				ID:           peer.ID,
-				DNSName:      peer.DNSName,
-				Online:       peer.Online,
-				TailscaleIPs: peer.TailscaleIPs,
-			}
-
-			if peer.Location != nil {
-				node.Country = peer.Location.Country
-				node.CountryCode = peer.Location.CountryCode
-				node.City = peer.Location.City
-				node.CityCode = peer.Location.CityCode
-				node.Priority = peer.Location.Priority
-			}
-
-			nodes = append(nodes, node)
+		if peer.ExitNodeOption && strings.HasSuffix(peer.DNSName, "."+mullvadDomain+".") {
+			nodes = append(nodes, nodeFromPeer(peer))
 		}
 	}
+	inferLocations(nodes)
 
 	// Sort by priority (lower is better), then by online status, then by name
 	sort.Slice(nodes, func(i, j int) bool {
@@ -219,44 +697,170 @@ func getMullvadNodes(ctx context.Context, lc *tailscale.LocalClient) ([]MullvadN
 		return nodes[i].DNSName < nodes[j].DNSName
 	})
 
+	if status.BackendState == "Running" {
+		noteProfileAccess(len(nodes))
+	}
+	if len(nodes) == 0 && status.BackendState == "Running" {
+		if err := requireTailscale(ctx, lc, featureMullvad); err != nil {
+			return nil, fmt.Errorf("no Mullvad exit nodes found: %w", err)
+		}
+		if hint := otherProfileHint(ctx, lc); hint != "" {
+			return nil, fmt.Errorf("%w; %s", errMullvadMissing, hint)
+		}
+		return nil, errMullvadMissing
+	}
+
 	return nodes, nil
 }
 
-// autoSelectMullvad automatically selects and sets the best Mullvad exit node
-func autoSelectMullvad(ctx context.Context, lc *tailscale.LocalClient) error {
+// nodeFromPeer converts a peer to a MullvadNode
+func nodeFromPeer(peer *ipnstate.PeerStatus) MullvadNode {
+	node := MullvadNode{
+		ID:           peer.ID,
+		DNSName:      peer.DNSName,
+		Online:       peer.Online,
+		TailscaleIPs: peer.TailscaleIPs,
+		LastSeen:     peer.LastSeen,
+		NoIPv6Route:  !advertisesIPv6(peer),
+	}
+
+	if peer.Location != nil {
+		node.Country = peer.Location.Country
+		node.CountryCode = peer.Location.CountryCode
+		node.City = peer.Location.City
+		node.CityCode = peer.Location.CityCode
+		node.Priority = peer.Location.Priority
+	}
+
+	return node
+}
+
+// isStale reports whether an offline node has been gone for longer than
+// --stale-after, e.g. a retired server. Control doesn't update LastSeen
+// while a node is online, so it says nothing about online nodes, which
+// Online alone judges. Nodes without a LastSeen are not judged.
+func isStale(node MullvadNode) bool {
+	return *staleAfterFlag > 0 && !node.Online && !node.LastSeen.IsZero() && time.Since(node.LastSeen) > *staleAfterFlag
+}
+
+// maxStaleChecks bounds the candidates confirmOnline pings
+const maxStaleChecks = 5
+
+// confirmOnline drops leading candidates that control reports online but
+// that don't answer a ping: zombie entries that would fail right after the
+// switch. LastSeen can't tell them apart, as control doesn't update it for
+// online nodes. Candidates the selection measured a latency to just
+// answered a ping and are kept as they are. If none of the checked ones
+// answers, pings are likely blocked and the candidates are kept.
+func confirmOnline(ctx context.Context, lc LocalClient, candidates []MullvadNode) []MullvadNode {
+	p, err := newProber(lc)
+	if err != nil {
+		return candidates
+	}
+	for i, node := range candidates[:min(len(candidates), maxStaleChecks)] {
+		if node.Latency > 0 {
+			return candidates[i:]
+		}
+		if _, err := p.ping(ctx, node); err == nil {
+			return candidates[i:]
+		}
+		if *verboseFlag {
+			fmt.Printf("%s is reported online but didn't answer a ping, skipping it\n", displayName(node))
+		}
+		explain.eliminate("stale, reported online but no answer to a ping before switching", node)
+	}
+	if *verboseFlag {
+		fmt.Println("No candidate answered a ping, keeping them in order")
+	}
+	return candidates
+}
+
+// currentExitNode returns the active exit node, which need not be a
+// Mullvad node. Returns false if no exit node is set.
+func currentExitNode(ctx context.Context, lc LocalClient) (MullvadNode, bool, error) {
+	status, err := lc.Status(ctx)
+	if err != nil {
+		return MullvadNode{}, false, fmt.Errorf("failed to get status: %w", err)
+	}
+	if status.ExitNodeStatus == nil {
+		return MullvadNode{}, false, nil
+	}
+	for _, peer := range status.Peer {
+		if peer.ID == status.ExitNodeStatus.ID {
+			return nodeFromPeer(peer), true, nil
+		}
+	}
+	return MullvadNode{}, false, fmt.Errorf("exit node %s not found among peers", status.ExitNodeStatus.ID)
+}
+
+// rankCandidates runs the selection pipeline without changing prefs.
+// Returns the online candidates best first, as ranked by the --strategy, or
+// by priority when the strategy fails (e.g. no node answers a ping).
+func rankCandidates(ctx context.Context, lc LocalClient) ([]MullvadNode, error) {
+	explain.reset()
+	selectionSeed = 0
+	start := time.Now()
+	emit(event{Event: "selection_started", Source: selectionSource})
+
 	nodes, err := getMullvadNodes(ctx, lc)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	if len(nodes) == 0 {
-		return fmt.Errorf("no Mullvad exit nodes found. Mullvad VPN add-on subscription required")
+		return nil, fmt.Errorf("no Mullvad exit nodes found. Mullvad VPN add-on subscription required")
 	}
+	recordNodes(ctx, nodes)
 
-	// Apply country filter if specified
-	if *countryFlag != "" {
-		filtered := make([]MullvadNode, 0)
-		for _, node := range nodes {
-			if strings.EqualFold(node.CountryCode, *countryFlag) {
-				filtered = append(filtered, node)
-			}
-		}
-		if len(filtered) == 0 {
-			return fmt.Errorf("no Mullvad exit nodes found for country: %s", *countryFlag)
-		}
-		nodes = filtered
+	if err := validateForbidden(nodes); err != nil {
+		return nil, err
+	}
+	nodes, err = filterByHomeCountry(ctx, lc, nodes)
+	if err != nil {
+		return nil, err
+	}
+
+	// Apply region and country filters if specified
+	nodes, err = filterByLocation(nodes)
+	if err != nil {
+		return nil, err
+	}
+	nodes, err = filterByNodePatterns(nodes)
+	if err != nil {
+		return nil, err
+	}
+	nodes, err = filterForbidden(ctx, nodes)
+	if err != nil {
+		return nil, err
+	}
+
+	nodes, err = filterByAttributes(ctx, nodes)
+	if err != nil {
+		return nil, err
 	}
 
 	// Filter for online nodes only
 	onlineNodes := make([]MullvadNode, 0)
 	for _, node := range nodes {
-		if node.Online {
+		switch {
+		case isStale(node):
+			explain.eliminate(fmt.Sprintf("offline, last seen %s ago", time.Since(node.LastSeen).Round(time.Minute)), node)
+		case !node.Online:
+			explain.eliminate("offline", node)
+		default:
 			onlineNodes = append(onlineNodes, node)
 		}
 	}
+	explain.filter("online", len(nodes), len(onlineNodes))
 
 	if len(onlineNodes) == 0 {
-		return fmt.Errorf("no online Mullvad exit nodes found")
+		return nil, fmt.Errorf("no online Mullvad exit nodes found")
+	}
+	onlineNodes = filterQuarantined(onlineNodes)
+	onlineNodes = filterServiceBlocked(onlineNodes)
+	onlineNodes, err = filterIPv6(onlineNodes)
+	if err != nil {
+		return nil, err
 	}
 
 	// Show top candidates if verbose
@@ -270,50 +874,200 @@ func autoSelectMullvad(ctx context.Context, lc *tailscale.LocalClient) error {
 			node := onlineNodes[i]
 			fmt.Printf("%2d. %s (%s, %s) - Priority: %d\n",
 				i+1,
-				strings.TrimSuffix(node.DNSName, "."),
+				displayName(node),
 				node.City,
 				node.CountryCode,
 				node.Priority)
 		}
 	}
 
-	// Use priority-based selection (Mullvad nodes don't respond to pings)
-	bestNode := onlineNodes[0]
+	// The strategy ranks the candidates; if it fails (e.g. no node answers
+	// a ping), fall back to priority order
+	name, strategy, err := selectedStrategy()
+	if err != nil {
+		return nil, err
+	}
+	skipLatency, err := checkOtherVPN(ctx, lc)
+	if err != nil {
+		return nil, err
+	}
+	if skipLatency && measuresLatency(name) {
+		name, strategy = "priority", strategies["priority"]
+	}
+	candidates, err := strategy.Rank(ctx, lc, onlineNodes)
+	if err != nil && name == "exec" {
+		// The scorer may encode policy, e.g. blocked countries, which a
+		// ranking without it would ignore
+		explain.note("exec strategy failed (%v), no fallback", err)
+		return nil, fmt.Errorf("--scorer failed, refusing to select without it: %w", err)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: %s strategy failed (%v), selecting by priority\n", name, err)
+		explain.note("%s strategy failed (%v), fell back to priority", name, err)
+		candidates = onlineNodes
+	}
+	candidates = preferAttributes(ctx, candidates)
+	candidates = preferIPv6(candidates)
+	candidates = preferDirect(ctx, candidates)
+	candidates, err = requireDirect(ctx, lc, candidates)
+	if err != nil {
+		return nil, err
+	}
+
+	if *explainFlag {
+		explain.print(candidates)
+	}
+	emitNode("candidates_ranked", candidates[0], event{Nodes: len(candidates), Reason: name + " strategy"})
+	selectionDurationHistogram.observe("", time.Since(start).Seconds())
+
+	return candidates, nil
+}
+
+// autoSelectMullvad automatically selects and sets the best Mullvad exit node
+func autoSelectMullvad(ctx context.Context, lc LocalClient) error {
+	candidates, err := rankCandidates(ctx, lc)
+	if err != nil {
+		return err
+	}
+	bestNode := candidates[0]
+
+	if deferred, reason := deferToTailscale(ctx, lc, candidates); deferred {
+		fmt.Printf("Leaving the exit node to Tailscale: %s\n", reason)
+		emit(event{Event: "selection_held", Reason: reason})
+		recordActiveLatency(ctx, lc, candidates)
+		return nil
+	}
+
+	if autoSwitching {
+		if hold, reason := churnGuard(ctx, lc, currentActive(ctx)); hold {
+			fmt.Printf("Keeping current exit node: %s\n", reason)
+			emit(event{Event: "selection_held", Reason: reason})
+			recordActiveLatency(ctx, lc, candidates)
+			return nil
+		}
+		if hold, reason := holdCurrent(ctx, lc, currentActive(ctx), bestNode, candidates); hold {
+			fmt.Printf("Keeping current exit node: %s\n", reason)
+			emit(event{Event: "selection_held", Reason: reason})
+			recordActiveLatency(ctx, lc, candidates)
+			return nil
+		}
+	}
+	candidates = confirmOnline(ctx, lc, candidates)
+	bestNode = candidates[0]
+	emitNode("node_selected", bestNode, event{Source: selectionSource})
 
 	if *verboseFlag {
 		fmt.Printf("\nSelected Mullvad node:\n")
-		fmt.Printf("  Hostname: %s\n", strings.TrimSuffix(bestNode.DNSName, "."))
+		fmt.Printf("  Hostname: %s\n", displayName(bestNode))
 		fmt.Printf("  Location: %s, %s\n", bestNode.City, bestNode.CountryCode)
 		fmt.Printf("  Priority: %d (lower is closer)\n", bestNode.Priority)
+		if bestNode.Latency > 0 {
+			fmt.Printf("  Latency: %dms\n", bestNode.Latency.Milliseconds())
+		}
 		fmt.Printf("  Online: %v\n", bestNode.Online)
 	}
 
 	// Set the exit node
-	if err := setExitNode(ctx, lc, bestNode.ID); err != nil {
+	previous, _, _ := currentExitNode(ctx, lc)
+	bestNode, err = applyBestCandidate(ctx, lc, candidates)
+	if err != nil {
 		return err
 	}
+	if previous.ID != bestNode.ID {
+		notifySwitched(ctx, previous, bestNode, selectionSource)
+	}
+	if (*requireIPv6Flag || *preferIPv6Flag) && !*trialFlag {
+		// Trials probe IPv6 as part of their verification
+		if err := waitForExitNode(ctx, lc, trialOnlineTimeout); err == nil {
+			if err := probeIPv6Egress(ctx, bestNode); err != nil {
+				fmt.Printf("Warning: %v\n", err)
+			}
+		}
+	}
+	if autoSwitching {
+		recordSwitch(bestNode.ID)
+	}
+	recordSelection(bestNode, selectionSource, true)
+	recordLatency(bestNode)
+
+	if bestNode.Latency > 0 {
+		fmt.Println(trf("WAN is now protected via %s (%s, %s) - Latency: %dms",
+			displayName(bestNode),
+			bestNode.City,
+			bestNode.CountryCode,
+			bestNode.Latency.Milliseconds()))
+	} else {
+		fmt.Println(trf("WAN is now protected via %s (%s, %s)",
+			displayName(bestNode),
+			bestNode.City,
+			bestNode.CountryCode))
+	}
 
-	fmt.Printf("WAN is now protected via %s (%s, %s)\n",
-		strings.TrimSuffix(bestNode.DNSName, "."),
-		bestNode.City,
-		bestNode.CountryCode)
+	if *topFlag > 0 {
+		printTopCandidates(candidates, *topFlag, "selected")
+	}
+
+	if *compareSuggestFlag {
+		compareWithSuggestion(ctx, lc, candidates)
+	}
 
 	return nil
 }
 
-// setExitNode sets the exit node by StableNodeID
-func setExitNode(ctx context.Context, lc *tailscale.LocalClient, nodeID tailcfg.StableNodeID) error {
+// printTopCandidates prints the first n ranked candidates, marking the winner
+func printTopCandidates(candidates []MullvadNode, n int, winner string) {
+	n = min(n, len(candidates))
+
+	fmt.Printf("\nTop %d candidates:\n", n)
+	for i, node := range candidates[:n] {
+		latency := "-"
+		if node.Latency > 0 {
+			latency = fmt.Sprintf("%dms", node.Latency.Milliseconds())
+		}
+		marker := ""
+		if i == 0 {
+			marker = "  <- " + winner
+		}
+		path := ""
+		if node.Latency > 0 {
+			path = pathLabel(node.Path)
+		}
+		line := fmt.Sprintf("%2d. %-40s %-20s %6s %-10s%s",
+			i+1,
+			displayName(node),
+			fmt.Sprintf("%s, %s", node.City, node.CountryCode),
+			latency,
+			path,
+			marker)
+		fmt.Println(strings.TrimRight(line, " "))
+	}
+}
+
+// setExitNode sets the exit node by StableNodeID. Tailscale's automatic
+// exit node is turned off, or tailscaled would replace the node.
+func setExitNode(ctx context.Context, lc LocalClient, nodeID tailcfg.StableNodeID) error {
 	mp := &ipn.MaskedPrefs{
 		Prefs: ipn.Prefs{
 			ExitNodeID: nodeID,
 		},
-		ExitNodeIDSet: true,
+		ExitNodeIDSet:   true,
+		AutoExitNodeSet: autoExitSupported(ctx, lc),
 	}
 
-	_, err := lc.EditPrefs(ctx, mp)
+	ctx = auditReasonOr(ctx, fmt.Sprintf("set exit node %s", nodeID))
+	prefs, err := lc.EditPrefs(ctx, mp)
 	if err != nil {
+		if isPolicyError(err) {
+			exitNodePinnedAt = time.Now()
+		}
 		return handlePermissionError(err, "set exit node")
 	}
+	if prefs.ExitNodeID != nodeID {
+		exitNodePinnedAt = time.Now()
+		return &pinnedError{Want: nodeID, Got: prefs.ExitNodeID}
+	}
+	exitNodePinnedAt = time.Time{}
+	emit(event{Event: "prefs_applied", Node: &eventNode{ID: string(nodeID)}})
 
 	if *verboseFlag {
 		fmt.Printf("Exit node set to ID: %s\n", nodeID)
@@ -322,14 +1076,38 @@ func setExitNode(ctx context.Context, lc *tailscale.LocalClient, nodeID tailcfg.
 	return nil
 }
 
-// setExitNodeByName sets the exit node by hostname or ID string
-func setExitNodeByName(ctx context.Context, lc *tailscale.LocalClient, name string) error {
-	nodes, err := getMullvadNodes(ctx, lc)
+// setExitNodeIP sets the exit node by Tailscale IP, leaving tailscaled to
+// resolve it to a peer like `tailscale set --exit-node` does. Used for IPs
+// that aren't a Mullvad node's, e.g. a self-hosted exit node.
+func setExitNodeIP(ctx context.Context, lc LocalClient, ip netip.Addr) error {
+	mp := &ipn.MaskedPrefs{
+		Prefs: ipn.Prefs{
+			ExitNodeID: "",
+			ExitNodeIP: ip,
+		},
+		ExitNodeIDSet:   true,
+		ExitNodeIPSet:   true,
+		AutoExitNodeSet: autoExitSupported(ctx, lc),
+	}
+
+	ctx = auditReasonOr(ctx, fmt.Sprintf("set exit node IP %s", ip))
+	_, err := lc.EditPrefs(ctx, mp)
 	if err != nil {
-		return err
+		return handlePermissionError(err, "set exit node")
 	}
+	emit(event{Event: "prefs_applied", Reason: "exit node IP " + ip.String()})
 
-	// Try to find by hostname (with or without trailing dot)
+	if *verboseFlag {
+		fmt.Printf("Exit node set to IP: %s\n", ip)
+	}
+
+	return nil
+}
+
+// findNode finds a node by hostname (with or without trailing dot),
+// Mullvad name, ID or Tailscale IP
+func findNode(nodes []MullvadNode, name string) (MullvadNode, bool) {
+	ip, ipErr := netip.ParseAddr(name)
 	nameWithDot := name
 	if !strings.HasSuffix(name, ".") {
 		nameWithDot = name + "."
@@ -337,31 +1115,96 @@ func setExitNodeByName(ctx context.Context, lc *tailscale.LocalClient, name stri
 	nameWithoutDot := strings.TrimSuffix(name, ".")
 
 	for _, node := range nodes {
-		if node.DNSName == nameWithDot || strings.TrimSuffix(node.DNSName, ".") == nameWithoutDot {
-			return setExitNode(ctx, lc, node.ID)
+		if node.DNSName == nameWithDot || strings.TrimSuffix(node.DNSName, ".") == nameWithoutDot ||
+			node.DNSName == mullvadDNSName(name) || string(node.ID) == name ||
+			(ipErr == nil && slices.Contains(node.TailscaleIPs, ip)) {
+			return node, true
 		}
-		// Also try matching by ID string
-		if string(node.ID) == name {
-			return setExitNode(ctx, lc, node.ID)
+	}
+	return MullvadNode{}, false
+}
+
+// setExitNodeByName sets the exit node by hostname, ID or Tailscale IP
+// string. A city or country instead selects the best node there.
+func setExitNodeByName(ctx context.Context, lc LocalClient, name string) error {
+	nodes, err := getMullvadNodes(ctx, lc)
+	if err != nil {
+		return err
+	}
+	if err := validateForbidden(nodes); err != nil {
+		return err
+	}
+	if node, ok := findNode(nodes, name); ok {
+		emitNode("node_selected", node, event{Source: sourceManual})
+		previous, _, _ := currentExitNode(ctx, lc)
+		if err := applyExitNode(ctx, lc, node); err != nil {
+			return err
 		}
+		recordSelection(node, sourceManual, false)
+		if previous.ID != node.ID {
+			notifySwitched(ctx, previous, node, sourceManual)
+		}
+		fmt.Println(trf("Exit node set to: %s", name))
+		return nil
+	}
+
+	// Not a Mullvad node's IP: any other peer offering to be an exit node
+	// will do
+	if ip, err := netip.ParseAddr(name); err == nil {
+		if err := checkPeerForbidden(ctx, lc, ip); err != nil {
+			return err
+		}
+		previous, _, _ := currentExitNode(ctx, lc)
+		if err := checkSubnetRoutes(ctx, lc); err != nil {
+			return err
+		}
+		if err := setExitNodeIP(ctx, lc, ip); err != nil {
+			return err
+		}
+		if node, ok, err := currentExitNode(ctx, lc); err == nil && ok {
+			recordSelection(node, sourceManual, false)
+			if previous.ID != node.ID {
+				notifySwitched(ctx, previous, node, sourceManual)
+			}
+		}
+		fmt.Println(trf("Exit node set to: %s", name))
+		return nil
 	}
 
-	return fmt.Errorf("exit node not found: %s", name)
+	// Not a node: scope the selection to a matching city or country
+	selectionSource = sourceManual
+	if city := matchCity(name, nodes); city != "" {
+		cityFilter = city
+		return autoSelectMullvad(ctx, lc)
+	}
+	if code, err := resolveCountry(name, nodes); err == nil && hasCountry(nodes, code) {
+		if err := flag.Set("country", code); err != nil {
+			return err
+		}
+		return autoSelectMullvad(ctx, lc)
+	}
+
+	return fmt.Errorf("exit node not found: %s (not a hostname, ID, IP, city or country)", name)
 }
 
-// clearExitNode disables the exit node
-func clearExitNode(ctx context.Context, lc *tailscale.LocalClient) error {
+// clearExitNode disables the exit node, and Tailscale's automatic one
+func clearExitNode(ctx context.Context, lc LocalClient) error {
 	mp := &ipn.MaskedPrefs{
 		Prefs: ipn.Prefs{
 			ExitNodeID: "",
+			ExitNodeIP: netip.Addr{},
 		},
-		ExitNodeIDSet: true,
+		ExitNodeIDSet:   true,
+		ExitNodeIPSet:   true,
+		AutoExitNodeSet: autoExitSupported(ctx, lc),
 	}
 
+	ctx = auditReasonOr(ctx, "clear exit node")
 	_, err := lc.EditPrefs(ctx, mp)
 	if err != nil {
 		return handlePermissionError(err, "clear exit node")
 	}
+	emit(event{Event: "prefs_applied", Reason: "exit node cleared"})
 
 	if *verboseFlag {
 		fmt.Println("Exit node preference cleared")
@@ -372,37 +1215,44 @@ func clearExitNode(ctx context.Context, lc *tailscale.LocalClient) error {
 
 // handlePermissionError checks if the error is permission-related and provides helpful guidance
 func handlePermissionError(err error, operation string) error {
-	errMsg := err.Error()
-
-	// Check for common permission-related error messages
-	if strings.Contains(errMsg, "Access denied") ||
-	   strings.Contains(errMsg, "permission denied") ||
-	   strings.Contains(errMsg, "prefs write access denied") {
+	if isPermissionDenied(err) {
 		return fmt.Errorf(`failed to %s: %w
 
 Permission denied. Tailscale preferences require elevated access.
 
 Try one of these solutions:
 
-1. Run with sudo:
+1. Make your user the Tailscale operator, once (Linux/macOS):
+   %s setup-operator
+   (asks for sudo once; later runs need no sudo)
+
+2. Run with sudo:
    sudo %s
 
-2. Run as the tailscale user (Linux):
+3. Run as the tailscale user (Linux):
    sudo -u tailscale %s
 
-3. Grant your user access to Tailscale (Linux):
+4. Grant your user access to Tailscale (Linux):
    sudo usermod -a -G tailscale $USER
    (then logout and login again)
 
-4. On macOS, ensure you're running as an admin user or use sudo
+5. On macOS, ensure you're running as an admin user or use sudo
 
-5. Use the tailscale CLI directly as an alternative:
+6. Use the tailscale CLI directly as an alternative:
    tailscale set --exit-node=<node-hostname>
 
 For more information, see: https://tailscale.com/kb/1103/exit-nodes`,
-			operation, err, os.Args[0], os.Args[0])
+			operation, err, os.Args[0], os.Args[0], os.Args[0])
 	}
 
 	// Return the original error with context if it's not a permission error
 	return fmt.Errorf("failed to %s: %w", operation, err)
 }
+
+// isPermissionDenied reports whether err is tailscaled refusing access
+func isPermissionDenied(err error) bool {
+	errMsg := err.Error()
+	return strings.Contains(errMsg, "Access denied") ||
+		strings.Contains(errMsg, "permission denied") ||
+		strings.Contains(errMsg, "prefs write access denied")
+}
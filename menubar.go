@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+func init() {
+	commands["menubar"] = command{
+		Usage: "Print the status and menu for a SwiftBar or xbar menu bar plugin (macOS)",
+		Run:   runMenuBar,
+	}
+}
+
+// menuBarPingTimeout bounds the latency shown in the menu bar, which the
+// plugin host waits for on every refresh
+const menuBarPingTimeout = 3 * time.Second
+
+// runMenuBar prints the menu in the SwiftBar/xbar plugin format: the first
+// line is the menu bar title, lines after "---" the dropdown, and "--"
+// marks submenu entries. Picking an entry runs this binary again.
+func runMenuBar(ctx context.Context, lc LocalClient, args []string) error {
+	if len(args) > 0 {
+		return errors.New("menubar takes no arguments")
+	}
+
+	node, ok, err := currentExitNode(ctx, lc)
+	if err != nil {
+		fmt.Println("⚠️ | color=red")
+		fmt.Println("---")
+		fmt.Println(trf("Tailscale unavailable: %s", menuText(err.Error())))
+		return nil
+	}
+	paused := currentPause()
+
+	// Menu bar title
+	switch {
+	case paused != nil:
+		fmt.Println("⏸ " + tr("Paused"))
+	case !ok || !node.Online:
+		fmt.Printf("⚠️ %s | color=red\n", tr("Unprotected"))
+	default:
+		title := countryFlagEmoji(node.CountryCode)
+		if latency := menuBarLatency(ctx, lc, node); latency > 0 {
+			title += fmt.Sprintf(" %dms", latency.Milliseconds())
+		}
+		fmt.Println(title)
+	}
+	fmt.Println("---")
+
+	switch {
+	case ok:
+		fmt.Println(trf("Exit node: %s (%s, %s)", mullvadHostname(node), node.City, node.Country))
+	default:
+		fmt.Println(tr("No exit node active"))
+	}
+	if paused != nil {
+		fmt.Println(trf("Protection paused %s", paused))
+	}
+	fmt.Println("---")
+
+	fmt.Println(tr("Country"))
+	fmt.Printf("--🌐 %s | %s\n", tr("Best anywhere"), menuAction("auto"))
+	countries, err := onlineCountries(ctx, lc)
+	if err != nil {
+		fmt.Printf("--%s\n", menuText(err.Error()))
+	}
+	for _, c := range countries {
+		check := ""
+		if ok && c.Code == node.CountryCode {
+			check = " checked=true"
+		}
+		fmt.Printf("--%s %s | %s%s\n", countryFlagEmoji(c.Code), menuText(c.Name), menuAction("set", c.Code), check)
+	}
+
+	if paused != nil {
+		fmt.Printf("%s | %s\n", tr("Resume protection"), menuAction("resume"))
+	} else {
+		fmt.Printf("%s | %s\n", tr("Pause protection"), menuAction("pause"))
+		fmt.Printf("%s | %s\n", tr("Pause for 1 hour"), menuAction("pause", "1h"))
+	}
+	fmt.Println("---")
+	fmt.Printf("%s | refresh=true\n", tr("Refresh"))
+	return nil
+}
+
+// menuBarLatency pings the exit node once, 0 if it doesn't answer in time
+func menuBarLatency(ctx context.Context, lc LocalClient, node MullvadNode) time.Duration {
+	p, err := newProber(lc)
+	if err != nil {
+		return 0
+	}
+	ctx, cancel := context.WithTimeout(ctx, menuBarPingTimeout)
+	defer cancel()
+	latency, err := p.ping(ctx, node)
+	if err != nil {
+		return 0
+	}
+	return latency
+}
+
+// menuAction returns the plugin parameters that run this binary with args
+// in the background and refresh the menu afterwards
+func menuAction(args ...string) string {
+	exe, err := os.Executable()
+	if err != nil {
+		exe = os.Args[0]
+	}
+	if *stateFlag != "" {
+		args = append(args, "--state", *stateFlag)
+	}
+	if *configFlag != "" {
+		args = append(args, "--config", *configFlag)
+	}
+
+	parts := []string{fmt.Sprintf("shell=%q", exe)}
+	for i, a := range args {
+		parts = append(parts, fmt.Sprintf("param%d=%q", i+1, a))
+	}
+	return strings.Join(append(parts, "terminal=false", "refresh=true"), " ")
+}
+
+// menuText keeps text from being read as plugin parameters
+func menuText(s string) string {
+	return strings.ReplaceAll(s, "|", "/")
+}
+
+// countryFlagEmoji returns the flag emoji for an ISO country code, made of
+// the two regional indicator symbols
+func countryFlagEmoji(code string) string {
+	code = strings.ToUpper(code)
+	if len(code) != 2 || code[0] < 'A' || code[0] > 'Z' || code[1] < 'A' || code[1] > 'Z' {
+		return "🌐"
+	}
+	return string([]rune{0x1F1E6 + rune(code[0]-'A'), 0x1F1E6 + rune(code[1]-'A')})
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var metricsListenFlag = flag.String("metrics-listen", "", "Daemon: serve OpenMetrics on this address at /metrics (e.g. 127.0.0.1:9171)")
+
+// metric is one metric family in the OpenMetrics exposition
+type metric interface {
+	write(w io.Writer)
+}
+
+// registry holds all exposed metric families, in exposition order
+var registry []metric
+
+var (
+	pingLatencyHistogram = newHistogram("protect_wan_ping_latency_seconds",
+		"Latency of successful pings to Mullvad exit nodes", "country",
+		[]float64{.005, .01, .02, .03, .05, .075, .1, .15, .2, .3, .5, 1, 2.5})
+	selectionDurationHistogram = newHistogram("protect_wan_selection_duration_seconds",
+		"Duration of the exit node selection pipeline", "",
+		[]float64{.5, 1, 2, 5, 10, 20, 30, 60, 120})
+)
+
+// histogram is an OpenMetrics histogram with at most one label
+type histogram struct {
+	name    string
+	help    string
+	label   string    // Label name, "" for an unlabeled histogram
+	buckets []float64 // Upper bounds, ascending, without +Inf
+
+	mu     sync.Mutex
+	series map[string]*histogramSeries // By label value
+}
+
+// histogramSeries is the state of one label value
+type histogramSeries struct {
+	counts []uint64 // Per bucket, non-cumulative; the last is +Inf
+	sum    float64
+	count  uint64
+}
+
+// newHistogram creates a histogram and registers it for exposition
+func newHistogram(name, help, label string, buckets []float64) *histogram {
+	h := &histogram{name: name, help: help, label: label, buckets: buckets, series: make(map[string]*histogramSeries)}
+	registry = append(registry, h)
+	return h
+}
+
+// observe records v for the label value (ignored if unlabeled)
+func (h *histogram) observe(labelValue string, v float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	s, ok := h.series[labelValue]
+	if !ok {
+		s = &histogramSeries{counts: make([]uint64, len(h.buckets)+1)}
+		h.series[labelValue] = s
+	}
+	s.counts[sort.SearchFloat64s(h.buckets, v)]++
+	s.sum += v
+	s.count++
+}
+
+// write prints the histogram in the OpenMetrics text format
+func (h *histogram) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	fmt.Fprintf(w, "# TYPE %s histogram\n# HELP %s %s\n", h.name, h.name, h.help)
+
+	values := make([]string, 0, len(h.series))
+	for v := range h.series {
+		values = append(values, v)
+	}
+	sort.Strings(values)
+
+	for _, v := range values {
+		s := h.series[v]
+		labels := func(extra string) string {
+			var parts []string
+			if h.label != "" {
+				parts = append(parts, fmt.Sprintf("%s=%q", h.label, v))
+			}
+			if extra != "" {
+				parts = append(parts, extra)
+			}
+			if len(parts) == 0 {
+				return ""
+			}
+			return "{" + strings.Join(parts, ",") + "}"
+		}
+
+		var cumulative uint64
+		for i, c := range s.counts {
+			cumulative += c
+			le := "+Inf"
+			if i < len(h.buckets) {
+				le = strconv.FormatFloat(h.buckets[i], 'g', -1, 64)
+			}
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, labels(fmt.Sprintf("le=%q", le)), cumulative)
+		}
+		fmt.Fprintf(w, "%s_sum%s %g\n", h.name, labels(""), s.sum)
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, labels(""), s.count)
+	}
+}
+
+// gaugeFunc is an unlabeled gauge whose value is computed at scrape time
+type gaugeFunc struct {
+	name  string
+	help  string
+	value func() float64
+}
+
+// newGaugeFunc creates a gauge and registers it for exposition
+func newGaugeFunc(name, help string, value func() float64) *gaugeFunc {
+	g := &gaugeFunc{name: name, help: help, value: value}
+	registry = append(registry, g)
+	return g
+}
+
+func (g *gaugeFunc) write(w io.Writer) {
+	fmt.Fprintf(w, "# TYPE %s gauge\n# HELP %s %s\n%s %g\n", g.name, g.name, g.help, g.name, g.value())
+}
+
+// counter is an unlabeled monotonic counter
+type counter struct {
+	name  string // Without the _total suffix
+	help  string
+	mu    sync.Mutex
+	value float64
+}
+
+// newCounter creates a counter and registers it for exposition
+func newCounter(name, help string) *counter {
+	c := &counter{name: name, help: help}
+	registry = append(registry, c)
+	return c
+}
+
+func (c *counter) inc() {
+	c.mu.Lock()
+	c.value++
+	c.mu.Unlock()
+}
+
+func (c *counter) write(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	fmt.Fprintf(w, "# TYPE %s counter\n# HELP %s %s\n%s_total %g\n", c.name, c.name, c.help, c.name, c.value)
+}
+
+// serveMetrics serves /metrics on --metrics-listen until ctx is done
+func serveMetrics(ctx context.Context) {
+	if *metricsListenFlag == "" {
+		return
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/openmetrics-text; version=1.0.0; charset=utf-8")
+		for _, m := range registry {
+			m.write(w)
+		}
+		io.WriteString(w, "# EOF\n")
+	})
+	srv := &http.Server{Addr: *metricsListenFlag, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+
+	go func() {
+		<-ctx.Done()
+		srv.Close()
+	}()
+	go func() {
+		log.Printf("Serving metrics on http://%s/metrics", *metricsListenFlag)
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("Error serving metrics: %v", err)
+		}
+	}()
+}
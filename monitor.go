@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+var (
+	monitorIntervalFlag = flag.Duration("monitor-interval", time.Second, "Ping interval of the monitor command")
+	monitorWindowFlag   = flag.Int("monitor-window", 60, "Number of recent pings the monitor and top statistics cover")
+	monitorExternalFlag = flag.Duration("monitor-external", 0, "With monitor, also measure the HTTPS round trip through the exit this often (e.g. 30s)")
+)
+
+func init() {
+	commands["monitor"] = command{
+		Usage: "Continuously ping the active exit node and show rolling latency and loss",
+		Run:   runMonitor,
+	}
+}
+
+// pingStats keeps rolling statistics over the last samples of a target
+type pingStats struct {
+	window  int
+	samples []time.Duration // 0 marks a lost ping
+	sent    int
+	lost    int
+}
+
+// add records one ping result, lost if err is non-nil
+func (s *pingStats) add(latency time.Duration, err error) {
+	s.sent++
+	if err != nil {
+		s.lost++
+		latency = 0
+	}
+	s.samples = append(s.samples, latency)
+	if len(s.samples) > s.window {
+		s.samples = s.samples[1:]
+	}
+}
+
+// summary returns loss, last, average, best, worst and jitter (standard
+// deviation) over the window
+func (s *pingStats) summary() (loss float64, last, avg, best, worst, jitter time.Duration) {
+	if len(s.samples) == 0 {
+		return
+	}
+	last = s.samples[len(s.samples)-1]
+
+	var n int
+	var sum, sumSq float64
+	for _, d := range s.samples {
+		if d == 0 {
+			continue
+		}
+		n++
+		sum += float64(d)
+		sumSq += float64(d) * float64(d)
+		if best == 0 || d < best {
+			best = d
+		}
+		worst = max(worst, d)
+	}
+	loss = float64(len(s.samples)-n) / float64(len(s.samples)) * 100
+	if n > 0 {
+		mean := sum / float64(n)
+		avg = time.Duration(mean)
+		jitter = time.Duration(math.Sqrt(max(sumSq/float64(n)-mean*mean, 0)))
+	}
+	return
+}
+
+// runMonitor pings the active exit node until interrupted, redrawing
+// mtr-style statistics on a terminal and printing one line per ping otherwise
+func runMonitor(ctx context.Context, lc LocalClient, args []string) error {
+	if len(args) > 0 {
+		return errors.New("monitor takes no arguments")
+	}
+
+	node, ok, err := currentExitNode(ctx, lc)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errors.New("no exit node active")
+	}
+	p, err := newProber(lc)
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	stats := &pingStats{window: max(1, *monitorWindowFlag)}
+	external := &pingStats{window: max(1, *monitorWindowFlag)}
+	tty := isTerminal(os.Stdout)
+	start := time.Now()
+
+	ticker := time.NewTicker(*monitorIntervalFlag)
+	defer ticker.Stop()
+	var lastExternal time.Time
+
+monitor:
+	for {
+		latency, err := p.ping(ctx, node)
+		if ctx.Err() != nil {
+			break
+		}
+		stats.add(latency, err)
+
+		if *monitorExternalFlag > 0 && time.Since(lastExternal) >= *monitorExternalFlag {
+			lastExternal = time.Now()
+			external.add(probeThroughExit(ctx))
+		}
+
+		if tty {
+			renderMonitor(node, stats, external, start)
+		} else if err != nil {
+			fmt.Printf("%s seq=%d lost: %v\n", time.Now().Format("15:04:05"), stats.sent, err)
+		} else {
+			fmt.Printf("%s seq=%d %dms\n", time.Now().Format("15:04:05"), stats.sent, latency.Milliseconds())
+		}
+
+		select {
+		case <-ctx.Done():
+			break monitor
+		case <-ticker.C:
+		}
+	}
+
+	loss, _, avg, best, worst, jitter := stats.summary()
+	fmt.Printf("\n--- %s: %d pings, %d lost; last %d: %.1f%% loss, avg %s, best %s, worst %s, jitter %s ---\n",
+		displayName(node), stats.sent, stats.lost, len(stats.samples), loss, ms(avg), ms(best), ms(worst), ms(jitter))
+	return nil
+}
+
+// renderMonitor redraws the statistics screen
+func renderMonitor(node MullvadNode, stats, external *pingStats, start time.Time) {
+	fmt.Print("\033[H\033[2J")
+	fmt.Printf("protect-wan monitor: %s (%s, %s)  running %s, Ctrl-C to stop\n\n",
+		displayName(node), node.City, node.CountryCode, time.Since(start).Round(time.Second))
+	fmt.Printf("%-14s %6s %6s %8s %8s %8s %8s %8s\n", "TARGET", "SENT", "LOSS%", "LAST", "AVG", "BEST", "WORST", "JITTER")
+
+	row := func(name string, s *pingStats) {
+		loss, last, avg, best, worst, jitter := s.summary()
+		fmt.Printf("%-14s %6d %6.1f %8s %8s %8s %8s %8s\n", name, s.sent, loss, ms(last), ms(avg), ms(best), ms(worst), ms(jitter))
+	}
+	row("exit node", stats)
+	if external.sent > 0 {
+		row("through exit", external)
+	}
+
+	fmt.Printf("\nStatistics cover the last %d samples\n", stats.window)
+}
+
+// ms formats a duration in whole milliseconds, "-" for none
+func ms(d time.Duration) string {
+	if d == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%dms", d.Milliseconds())
+}
+
+// isTerminal reports whether f is a character device (a terminal)
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
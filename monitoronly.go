@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"log"
+
+	"tailscale.com/ipn"
+)
+
+var monitorOnlyFlag = flag.Bool("monitor-only", false, "Daemon: only observe protection, measure latency, record history and alert; never change Tailscale prefs")
+
+// errMonitorOnly is returned by every prefs edit in --monitor-only mode
+var errMonitorOnly = errors.New("Tailscale prefs are not changed in --monitor-only mode")
+
+// readOnlyClient refuses prefs edits, so that no code path (policy, tray,
+// captive portal bypass) can slip a change past --monitor-only
+type readOnlyClient struct {
+	LocalClient
+}
+
+func (readOnlyClient) EditPrefs(ctx context.Context, mp *ipn.MaskedPrefs) (*ipn.Prefs, error) {
+	return nil, errMonitorOnly
+}
+
+func (readOnlyClient) SwitchProfile(ctx context.Context, profile ipn.ProfileID) error {
+	return errMonitorOnly
+}
+
+// observe is the --monitor-only check: it verifies protection, measures
+// and records the exit node's latency and traffic, and alerts when the WAN
+// is unprotected, but leaves selecting an exit node to someone else
+func (d *daemon) observe(ctx context.Context) {
+	active, err := checkExitNode(ctx, d.lc)
+	if err != nil {
+		log.Printf("Error checking exit node: %v", err)
+		if failureEvent(err) == "verification-failed" {
+			d.failed(ctx, err)
+		}
+	}
+	sampleProtection(active, d.checkInterval())
+
+	if !active {
+		if d.failing == "" {
+			d.failing = "exit-unprotected"
+			notifyFailure(ctx, d.failing, "No exit node active, the WAN is unprotected (monitor only, not selecting one)")
+		}
+		d.result("unprotected, monitor only", err)
+		return
+	}
+	if d.failing != "" {
+		log.Printf("WAN is protected again")
+	}
+	d.failing = ""
+	d.result("protected", nil)
+
+	sampleTraffic(ctx, d.lc)
+	node, latency, err := exitLatency(ctx, d.lc)
+	if err != nil {
+		log.Printf("Error measuring exit node latency: %v", err)
+		return
+	}
+	node.Latency = latency
+	recordLatency(node)
+	if *verboseFlag {
+		log.Printf("WAN is protected - Exit node: %s, latency %s", displayName(node), ms(latency))
+	}
+	emitNode("node_probed", node, event{})
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"flag"
+	"strings"
+)
+
+var namesFlag = flag.String("names", "dns", "How to show node names: dns (ch-zrh-wg-001.mullvad.ts.net) or mullvad (ch-zrh-wg-001)")
+
+// mullvadDomain is the MagicDNS domain of Mullvad exit nodes
+const mullvadDomain = "mullvad.ts.net"
+
+// mullvadHostname returns the Mullvad server name of a node, which is the
+// first label of its DNS name (e.g. ch-zrh-wg-001)
+func mullvadHostname(node MullvadNode) string {
+	name, _, _ := strings.Cut(node.DNSName, ".")
+	return name
+}
+
+// mullvadDNSName maps a Mullvad server name to its Tailscale DNS name
+func mullvadDNSName(name string) string {
+	return strings.ToLower(name) + "." + mullvadDomain + "."
+}
+
+// displayName is the node name shown in output, as chosen by --names
+func displayName(node MullvadNode) string {
+	if *namesFlag == "mullvad" {
+		return mullvadHostname(node)
+	}
+	return strings.TrimSuffix(node.DNSName, ".")
+}
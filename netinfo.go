@@ -0,0 +1,171 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+)
+
+var trustedFlag stringList
+
+func init() {
+	flag.Var(&trustedFlag, "trusted", "Trusted network where no exit node is enforced: gateway MAC, subnet (CIDR), DNS suffix, ssid:<name> or bssid:<MAC> (repeatable)")
+}
+
+// NetworkIdentity describes the physical network the host is attached to.
+// All fields are best-effort; detection failures leave them empty.
+type NetworkIdentity struct {
+	Gateway     netip.Addr
+	GatewayMAC  net.HardwareAddr
+	Addrs       []netip.Prefix // Local (non-Tailscale, non-loopback) addresses
+	DNSSuffixes []string
+	SSID        string // Wi-Fi network name, where discoverable
+	BSSID       string // Wi-Fi access point MAC, lower case
+}
+
+// currentNetwork gathers the identity of the current network
+func currentNetwork() NetworkIdentity {
+	var id NetworkIdentity
+
+	if gw, err := defaultGateway(); err == nil {
+		id.Gateway = gw
+		if mac, err := gatewayMAC(gw); err == nil {
+			id.GatewayMAC = mac
+		}
+	}
+
+	id.Addrs = localAddrs()
+
+	if suffixes, err := dnsSuffixes(); err == nil {
+		id.DNSSuffixes = suffixes
+	}
+
+	if ssid, bssid, err := wifiNetwork(); err == nil {
+		id.SSID, id.BSSID = ssid, bssid
+	}
+
+	return id
+}
+
+// localAddrs returns the addresses of all up, non-loopback interfaces,
+// excluding Tailscale's own CGNAT and ULA ranges
+func localAddrs() []netip.Prefix {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil
+	}
+
+	var prefixes []netip.Prefix
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			ipNet, ok := addr.(*net.IPNet)
+			if !ok {
+				continue
+			}
+			ip, ok := netip.AddrFromSlice(ipNet.IP)
+			if !ok {
+				continue
+			}
+			ip = ip.Unmap()
+			if isTailscaleIP(ip) || ip.IsLinkLocalUnicast() {
+				continue
+			}
+			bits, _ := ipNet.Mask.Size()
+			prefixes = append(prefixes, netip.PrefixFrom(ip, bits))
+		}
+	}
+
+	return prefixes
+}
+
+var (
+	tailscaleCGNAT = netip.MustParsePrefix("100.64.0.0/10")
+	tailscaleULA   = netip.MustParsePrefix("fd7a:115c:a1e0::/48")
+)
+
+// isTailscaleIP reports whether ip is in one of Tailscale's address ranges
+func isTailscaleIP(ip netip.Addr) bool {
+	return tailscaleCGNAT.Contains(ip) || tailscaleULA.Contains(ip)
+}
+
+// matchTrustedNetwork checks the network against the trusted rules.
+// Rules are gateway MACs, CIDR subnets, DNS suffixes, or ssid:<name> and
+// bssid:<MAC> for Wi-Fi networks.
+// Returns the first matching rule.
+func matchTrustedNetwork(id NetworkIdentity, rules []string) (string, bool) {
+	for _, rule := range rules {
+		if ssid, ok := strings.CutPrefix(rule, "ssid:"); ok {
+			if id.SSID != "" && id.SSID == ssid {
+				return rule, true
+			}
+			continue
+		}
+		if bssid, ok := strings.CutPrefix(rule, "bssid:"); ok {
+			if mac, err := net.ParseMAC(bssid); err == nil && id.BSSID != "" && strings.EqualFold(id.BSSID, mac.String()) {
+				return rule, true
+			}
+			continue
+		}
+
+		if mac, err := net.ParseMAC(rule); err == nil {
+			if id.GatewayMAC != nil && strings.EqualFold(id.GatewayMAC.String(), mac.String()) {
+				return rule, true
+			}
+			continue
+		}
+
+		if subnet, err := netip.ParsePrefix(rule); err == nil {
+			for _, addr := range id.Addrs {
+				if subnet.Contains(addr.Addr()) {
+					return rule, true
+				}
+			}
+			continue
+		}
+
+		suffix := strings.Trim(strings.ToLower(rule), ".")
+		for _, s := range id.DNSSuffixes {
+			s = strings.Trim(strings.ToLower(s), ".")
+			if s == suffix || strings.HasSuffix(s, "."+suffix) {
+				return rule, true
+			}
+		}
+	}
+
+	return "", false
+}
+
+// onTrustedNetwork reports whether the host is on a network from --trusted.
+// A --policy rule setting enforce overrides it either way, and neither
+// counts while the admin config enforces protection.
+func onTrustedNetwork() (string, bool) {
+	if adminEnforce {
+		return "", false
+	}
+	switch policyEnforce {
+	case "off":
+		return "policy " + policyEnforceRule, true
+	case "on":
+		return "", false
+	}
+	if len(trustedFlag) == 0 {
+		return "", false
+	}
+
+	id := currentNetwork()
+	if *verboseFlag {
+		fmt.Printf("Network: gateway %v (%v), addrs %v, DNS suffixes %v, Wi-Fi %q (%s)\n",
+			id.Gateway, id.GatewayMAC, id.Addrs, id.DNSSuffixes, id.SSID, id.BSSID)
+	}
+
+	return matchTrustedNetwork(id, trustedFlag)
+}
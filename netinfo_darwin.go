@@ -0,0 +1,107 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"net/netip"
+	"os/exec"
+	"strings"
+)
+
+// defaultGateway returns the IPv4 default gateway from `route -n get default`
+func defaultGateway() (netip.Addr, error) {
+	out, err := exec.Command("route", "-n", "get", "default").Output()
+	if err != nil {
+		return netip.Addr{}, err
+	}
+
+	for _, line := range strings.Split(string(out), "\n") {
+		if gw, ok := strings.CutPrefix(strings.TrimSpace(line), "gateway:"); ok {
+			return netip.ParseAddr(strings.TrimSpace(gw))
+		}
+	}
+
+	return netip.Addr{}, errors.New("no default gateway")
+}
+
+// gatewayMAC looks up the gateway's hardware address with `arp -n`
+func gatewayMAC(gw netip.Addr) (net.HardwareAddr, error) {
+	out, err := exec.Command("arp", "-n", gw.String()).Output()
+	if err != nil {
+		return nil, err
+	}
+
+	// ? (192.168.1.1) at aa:bb:cc:dd:ee:ff on en0 ifscope [ethernet]
+	fields := strings.Fields(string(out))
+	for i := 0; i < len(fields)-1; i++ {
+		if fields[i] == "at" {
+			return parseLooseMAC(fields[i+1])
+		}
+	}
+
+	return nil, errors.New("gateway not in ARP cache")
+}
+
+// parseLooseMAC parses MACs as printed by BSD arp, which drops leading
+// zeros (e.g. a:b:c:d:e:f)
+func parseLooseMAC(s string) (net.HardwareAddr, error) {
+	parts := strings.Split(s, ":")
+	for i, p := range parts {
+		if len(p) == 1 {
+			parts[i] = "0" + p
+		}
+	}
+	return net.ParseMAC(strings.Join(parts, ":"))
+}
+
+// wifiNetwork returns the SSID and BSSID of the Wi-Fi network en0 is
+// connected to, from `ipconfig getsummary`. Recent macOS versions redact
+// them unless Location Services access is granted.
+func wifiNetwork() (ssid, bssid string, err error) {
+	out, err := exec.Command("ipconfig", "getsummary", "en0").Output()
+	if err != nil {
+		return "", "", err
+	}
+	for _, line := range strings.Split(string(out), "\n") {
+		key, value, ok := strings.Cut(strings.TrimSpace(line), " : ")
+		switch {
+		case !ok:
+		case key == "SSID":
+			ssid = value
+		case key == "BSSID":
+			bssid = strings.ToLower(value)
+		}
+	}
+	if ssid == "" || ssid == "<redacted>" {
+		return "", "", errors.New("no Wi-Fi SSID")
+	}
+	return ssid, bssid, nil
+}
+
+// dnsSuffixes returns the search domains known to the system resolver
+func dnsSuffixes() ([]string, error) {
+	out, err := exec.Command("scutil", "--dns").Output()
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	var suffixes []string
+	for _, line := range strings.Split(string(out), "\n") {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, "search domain") && !strings.HasPrefix(line, "domain") {
+			continue
+		}
+		_, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		if value != "" && !seen[value] {
+			seen[value] = true
+			suffixes = append(suffixes, value)
+		}
+	}
+
+	return suffixes, nil
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// defaultGateway reads the IPv4 default gateway from the main routing table.
+// Tailscale puts exit node routes in table 52, so this still reports the
+// physical network's gateway while an exit node is active.
+func defaultGateway() (netip.Addr, error) {
+	f, err := os.Open("/proc/net/route")
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Scan() // header
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 8 || fields[1] != "00000000" || fields[7] != "00000000" {
+			continue
+		}
+		raw, err := hex.DecodeString(fields[2])
+		if err != nil || len(raw) != 4 {
+			continue
+		}
+		// /proc/net/route prints addresses in host (little-endian) order
+		var b [4]byte
+		binary.BigEndian.PutUint32(b[:], binary.LittleEndian.Uint32(raw))
+		gw := netip.AddrFrom4(b)
+		if gw.IsUnspecified() {
+			continue
+		}
+		return gw, nil
+	}
+
+	return netip.Addr{}, errors.New("no default gateway")
+}
+
+// gatewayMAC looks up the gateway's hardware address in the ARP cache
+func gatewayMAC(gw netip.Addr) (net.HardwareAddr, error) {
+	f, err := os.Open("/proc/net/arp")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Scan() // header
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 4 && fields[0] == gw.String() {
+			return net.ParseMAC(fields[3])
+		}
+	}
+
+	return nil, fmt.Errorf("%s not in ARP cache", gw)
+}
+
+// dnsSuffixes returns the search domains from /etc/resolv.conf
+func dnsSuffixes() ([]string, error) {
+	return resolvConfSuffixes("/etc/resolv.conf")
+}
+
+// resolvConfSuffixes parses `search` and `domain` lines of a resolv.conf
+func resolvConfSuffixes(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var suffixes []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		if fields[0] == "search" || fields[0] == "domain" {
+			suffixes = append(suffixes, fields[1:]...)
+		}
+	}
+
+	return suffixes, scanner.Err()
+}
+
+// wifiNetwork returns the SSID and BSSID of the connected Wi-Fi network,
+// from NetworkManager or, without it, wireless-tools
+func wifiNetwork() (ssid, bssid string, err error) {
+	out, err := exec.Command("nmcli", "-t", "-f", "ACTIVE,SSID,BSSID", "dev", "wifi").Output()
+	if err == nil {
+		// yes:HomeNet:AA\:BB\:CC\:DD\:EE\:FF, colons in values escaped
+		for _, line := range strings.Split(string(out), "\n") {
+			fields := splitEscaped(line, ':')
+			if len(fields) == 3 && fields[0] == "yes" {
+				return fields[1], strings.ToLower(fields[2]), nil
+			}
+		}
+		return "", "", errors.New("not connected to Wi-Fi")
+	}
+
+	out, err = exec.Command("iwgetid", "-r").Output()
+	if err != nil {
+		return "", "", err
+	}
+	ssid = strings.TrimSpace(string(out))
+	if out, err := exec.Command("iwgetid", "-a", "-r").Output(); err == nil {
+		bssid = strings.ToLower(strings.TrimSpace(string(out)))
+	}
+	return ssid, bssid, nil
+}
+
+// splitEscaped splits s at sep, honoring backslash escapes
+func splitEscaped(s string, sep byte) []string {
+	var fields []string
+	var cur strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\' && i+1 < len(s):
+			i++
+			cur.WriteByte(s[i])
+		case s[i] == sep:
+			fields = append(fields, cur.String())
+			cur.Reset()
+		default:
+			cur.WriteByte(s[i])
+		}
+	}
+	return append(fields, cur.String())
+}
@@ -0,0 +1,28 @@
+//go:build !linux && !darwin && !windows
+
+package main
+
+import (
+	"net"
+	"net/netip"
+)
+
+// defaultGateway is not implemented on this platform
+func defaultGateway() (netip.Addr, error) {
+	return netip.Addr{}, errUnsupportedPlatform
+}
+
+// gatewayMAC is not implemented on this platform
+func gatewayMAC(gw netip.Addr) (net.HardwareAddr, error) {
+	return nil, errUnsupportedPlatform
+}
+
+// wifiNetwork is not implemented on this platform
+func wifiNetwork() (ssid, bssid string, err error) {
+	return "", "", errUnsupportedPlatform
+}
+
+// dnsSuffixes is not implemented on this platform
+func dnsSuffixes() ([]string, error) {
+	return nil, errUnsupportedPlatform
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"net/netip"
+	"os/exec"
+	"strings"
+)
+
+// powershell runs a PowerShell snippet and returns its trimmed output
+func powershell(script string) (string, error) {
+	out, err := exec.Command("powershell", "-NoProfile", "-NonInteractive", "-Command", script).Output()
+	return strings.TrimSpace(string(out)), err
+}
+
+// defaultGateway returns the next hop of the lowest-metric IPv4 default route
+func defaultGateway() (netip.Addr, error) {
+	out, err := powershell("Get-NetRoute -DestinationPrefix 0.0.0.0/0 | Where-Object NextHop -ne '0.0.0.0' | " +
+		"Sort-Object RouteMetric | Select-Object -First 1 -ExpandProperty NextHop")
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	if out == "" {
+		return netip.Addr{}, errors.New("no default gateway")
+	}
+	return netip.ParseAddr(out)
+}
+
+// gatewayMAC looks up the gateway's hardware address in the neighbor cache
+func gatewayMAC(gw netip.Addr) (net.HardwareAddr, error) {
+	out, err := powershell("Get-NetNeighbor -IPAddress " + gw.String() + " | Select-Object -First 1 -ExpandProperty LinkLayerAddress")
+	if err != nil {
+		return nil, err
+	}
+	if out == "" {
+		return nil, errors.New("gateway not in neighbor cache")
+	}
+	return net.ParseMAC(out)
+}
+
+// wifiNetwork returns the SSID and BSSID of the connected Wi-Fi network
+// from `netsh wlan show interfaces`
+func wifiNetwork() (ssid, bssid string, err error) {
+	out, err := exec.Command("netsh", "wlan", "show", "interfaces").Output()
+	if err != nil {
+		return "", "", err
+	}
+	for _, line := range strings.Split(string(out), "\n") {
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		switch strings.TrimSpace(key) {
+		case "SSID":
+			ssid = strings.TrimSpace(value)
+		case "BSSID":
+			bssid = strings.ToLower(strings.TrimSpace(value))
+		}
+	}
+	if ssid == "" {
+		return "", "", errors.New("not connected to Wi-Fi")
+	}
+	return ssid, bssid, nil
+}
+
+// dnsSuffixes returns the connection-specific DNS suffixes of all adapters
+func dnsSuffixes() ([]string, error) {
+	out, err := powershell("Get-DnsClient | Where-Object ConnectionSpecificSuffix | Select-Object -ExpandProperty ConnectionSpecificSuffix")
+	if err != nil {
+		return nil, err
+	}
+	return strings.Fields(out), nil
+}
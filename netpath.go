@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/tailcfg"
+)
+
+var (
+	preferDirectFlag  = flag.Bool("prefer-direct", false, "Rank candidates reached over a direct path before those relayed through DERP or a peer relay, whatever their latency")
+	requireDirectFlag = flag.Bool("require-direct", false, "Only select exit nodes reached over a direct path, waiting briefly for NAT traversal; relayed exit traffic is much slower")
+)
+
+// Paths a node's latency was measured over
+const (
+	pathDirect    = "direct"
+	pathPeerRelay = "peer-relay"
+	pathDERP      = "derp:" // Followed by the DERP region code
+)
+
+const (
+	// directWait is how long --require-direct keeps pinging relayed
+	// candidates for NAT traversal to find a direct path
+	directWait = 5 * time.Second
+
+	// directRechecks is how many of the best relayed candidates
+	// --require-direct gives that chance
+	directRechecks = 5
+)
+
+// pingPath returns the path a ping went over, "" if the ping type doesn't
+// tell (only disco pings report it)
+func pingPath(res *ipnstate.PingResult) string {
+	switch {
+	case res.Endpoint != "":
+		return pathDirect
+	case res.PeerRelay != "":
+		return pathPeerRelay
+	case res.DERPRegionCode != "":
+		return pathDERP + res.DERPRegionCode
+	case res.DERPRegionID != 0:
+		return pathDERP + strconv.Itoa(res.DERPRegionID)
+	}
+	return ""
+}
+
+// peerPath returns the path tailscaled currently uses to reach a peer: a
+// direct address, a peer relay, or else the peer's home DERP region
+func peerPath(peer *ipnstate.PeerStatus) string {
+	switch {
+	case peer.CurAddr != "":
+		return pathDirect
+	case peer.PeerRelay != "":
+		return pathPeerRelay
+	case peer.Relay != "":
+		return pathDERP + peer.Relay
+	}
+	return ""
+}
+
+// peerPaths returns the path tailscaled uses to each peer
+func peerPaths(ctx context.Context, lc LocalClient) (map[tailcfg.StableNodeID]string, error) {
+	status, err := lc.Status(ctx)
+	if err != nil {
+		return nil, err
+	}
+	paths := make(map[tailcfg.StableNodeID]string)
+	for _, peer := range status.Peer {
+		paths[peer.ID] = peerPath(peer)
+	}
+	return paths, nil
+}
+
+// labelPaths fills in the path of measured nodes whose pings didn't report
+// one, from the peer status right after the measurement
+func labelPaths(ctx context.Context, lc LocalClient, nodes []MullvadNode) {
+	missing := false
+	for _, node := range nodes {
+		missing = missing || (node.Path == "" && node.Latency > 0)
+	}
+	if !missing {
+		return
+	}
+	paths, err := peerPaths(ctx, lc)
+	if err != nil {
+		return
+	}
+	for i, node := range nodes {
+		if node.Path == "" && node.Latency > 0 {
+			nodes[i].Path = paths[node.ID]
+		}
+	}
+}
+
+// relayed reports whether a node's latency was measured over a relay
+func relayed(node MullvadNode) bool {
+	return relayedPath(node.Path)
+}
+
+// relayedPath reports whether a path goes through DERP or a peer relay
+func relayedPath(path string) bool {
+	return path != "" && path != pathDirect
+}
+
+// pathLabel describes a path for people: direct, DERP fra, peer relay or -
+func pathLabel(path string) string {
+	switch {
+	case path == "":
+		return "-"
+	case path == pathPeerRelay:
+		return "peer relay"
+	case strings.HasPrefix(path, pathDERP):
+		return "DERP " + strings.TrimPrefix(path, pathDERP)
+	}
+	return path
+}
+
+// preferDirect moves the candidates measured over a relay last for
+// --prefer-direct or a daemon reselection away from a relayed exit node
+// (see relayWatch), keeping the strategy's order otherwise. A relayed
+// path's low latency is often a fluke of the moment, and the path may not
+// be the one traffic takes.
+func preferDirect(ctx context.Context, candidates []MullvadNode) []MullvadNode {
+	if !*preferDirectFlag && ctx.Value(preferDirectKey{}) == nil {
+		return candidates
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return !relayed(candidates[i]) && relayed(candidates[j])
+	})
+	explain.note("Candidates measured over a direct path ranked before relayed ones")
+	return candidates
+}
+
+// requireDirect keeps the candidates reached over a direct path for
+// --require-direct. A path often starts out relayed through DERP until NAT
+// traversal succeeds, so the best relayed candidates are pinged for up to
+// directWait first, and kept if a direct path turns up. Fails if no
+// candidate is direct.
+func requireDirect(ctx context.Context, lc LocalClient, candidates []MullvadNode) ([]MullvadNode, error) {
+	if !*requireDirectFlag {
+		return candidates, nil
+	}
+	paths, err := peerPaths(ctx, lc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check paths for --require-direct: %w", err)
+	}
+	var recheck []int
+	for i := range candidates {
+		if candidates[i].Path == "" {
+			candidates[i].Path = paths[candidates[i].ID]
+		}
+		if candidates[i].Path != pathDirect && len(recheck) < directRechecks {
+			recheck = append(recheck, i)
+		}
+	}
+
+	if len(recheck) > 0 {
+		if *verboseFlag {
+			fmt.Printf("\nWaiting up to %s for a direct path to %d candidates...\n", directWait, len(recheck))
+		}
+		waitForDirect(ctx, lc, candidates, recheck)
+	}
+
+	filtered := make([]MullvadNode, 0, len(candidates))
+	for _, node := range candidates {
+		if node.Path != pathDirect {
+			reason := "path unknown"
+			if node.Path != "" {
+				reason = "reached only over " + pathLabel(node.Path)
+			}
+			explain.eliminate(reason+" (--require-direct)", node)
+			continue
+		}
+		filtered = append(filtered, node)
+	}
+	explain.filter("direct path", len(candidates), len(filtered))
+	if len(filtered) == 0 {
+		return nil, errors.New("no exit node is reachable over a direct path (--require-direct): NAT traversal fails on this network, try without it")
+	}
+	return filtered, nil
+}
+
+// waitForDirect pings the candidates at the indexes until each has a
+// direct path or directWait is up, updating their path and latency
+func waitForDirect(ctx context.Context, lc LocalClient, candidates []MullvadNode, indexes []int) {
+	p, err := newProber(lc)
+	if err != nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, directWait)
+	defer cancel()
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		pending := false
+		for _, i := range indexes {
+			if candidates[i].Path == pathDirect {
+				continue
+			}
+			if latency, err := p.ping(ctx, candidates[i]); err == nil {
+				if path := p.path(candidates[i]); path != "" {
+					candidates[i].Path = path
+				}
+				if candidates[i].Path == pathDirect {
+					candidates[i].Latency = latency
+				}
+			}
+		}
+		if paths, err := peerPaths(ctx, lc); err == nil {
+			for _, i := range indexes {
+				if candidates[i].Path != pathDirect && paths[candidates[i].ID] == pathDirect {
+					candidates[i].Path = pathDirect
+				}
+			}
+		}
+		for _, i := range indexes {
+			pending = pending || candidates[i].Path != pathDirect
+		}
+		if !pending {
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// networkSettleDelay lets bursts of interface/route events finish before
+// the network is compared, e.g. while DHCP configures a new Wi-Fi link
+const networkSettleDelay = 2 * time.Second
+
+// watchNetworkChanges returns a channel that receives a value whenever the
+// physical network the host is attached to changes. Changes to Tailscale's
+// own interface and routes (e.g. switching exit nodes) are ignored.
+func watchNetworkChanges(ctx context.Context) <-chan struct{} {
+	changes := make(chan struct{}, 1)
+	events := networkEvents(ctx)
+
+	go func() {
+		last := watchFingerprint()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-events:
+			}
+
+			// Wait for the burst to settle, then drain it
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(networkSettleDelay):
+			}
+		drain:
+			for {
+				select {
+				case <-events:
+				default:
+					break drain
+				}
+			}
+
+			fp := watchFingerprint()
+			if fp == last {
+				continue
+			}
+			if *verboseFlag {
+				fmt.Printf("Network changed: %s -> %s\n", last, fp)
+			}
+			last = fp
+
+			select {
+			case changes <- struct{}{}:
+			default:
+			}
+		}
+	}()
+
+	return changes
+}
+
+// addrFingerprint summarizes the local non-Tailscale addresses
+func addrFingerprint() string {
+	addrs := localAddrs()
+	parts := make([]string, len(addrs))
+	for i, addr := range addrs {
+		parts[i] = addr.String()
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, ",")
+}
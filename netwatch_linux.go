@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"syscall"
+	"time"
+)
+
+// rtnetlink multicast groups (linux/rtnetlink.h), not exported by syscall
+const (
+	rtmgrpLink       = 0x1
+	rtmgrpIPv4IfAddr = 0x10
+	rtmgrpIPv4Route  = 0x40
+	rtmgrpIPv6IfAddr = 0x100
+	rtmgrpIPv6Route  = 0x400
+)
+
+// networkEvents subscribes to rtnetlink link, address and route
+// notifications. Falls back to polling if the socket cannot be opened.
+func networkEvents(ctx context.Context) <-chan struct{} {
+	events := make(chan struct{}, 1)
+
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC, syscall.NETLINK_ROUTE)
+	if err == nil {
+		err = syscall.Bind(fd, &syscall.SockaddrNetlink{
+			Family: syscall.AF_NETLINK,
+			Groups: rtmgrpLink | rtmgrpIPv4IfAddr | rtmgrpIPv6IfAddr | rtmgrpIPv4Route | rtmgrpIPv6Route,
+		})
+	}
+	if err == nil {
+		// Wake up regularly so the context can be honored
+		tv := syscall.NsecToTimeval(time.Second.Nanoseconds())
+		err = syscall.SetsockoptTimeval(fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &tv)
+	}
+	if err != nil {
+		if fd >= 0 {
+			syscall.Close(fd)
+		}
+		if *verboseFlag {
+			fmt.Printf("netlink unavailable (%v), polling for network changes\n", err)
+		}
+		return pollNetworkEvents(ctx)
+	}
+
+	go func() {
+		defer syscall.Close(fd)
+		buf := make([]byte, 64*1024)
+		for ctx.Err() == nil {
+			n, _, err := syscall.Recvfrom(fd, buf, 0)
+			if err != nil || n <= 0 {
+				continue
+			}
+			select {
+			case events <- struct{}{}:
+			default:
+			}
+		}
+	}()
+
+	return events
+}
+
+// watchFingerprint identifies the physical network: local addresses plus
+// the default gateway, which are both cheap to read on Linux
+func watchFingerprint() string {
+	fp := addrFingerprint()
+	if gw, err := defaultGateway(); err == nil {
+		fp += " via " + gw.String()
+	}
+	return fp
+}
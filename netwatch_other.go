@@ -0,0 +1,16 @@
+//go:build !linux
+
+package main
+
+import "context"
+
+// networkEvents polls for changes; there is no portable notification API
+func networkEvents(ctx context.Context) <-chan struct{} {
+	return pollNetworkEvents(ctx)
+}
+
+// watchFingerprint identifies the physical network by its local addresses.
+// Gateway lookups shell out on these platforms and are too slow to poll.
+func watchFingerprint() string {
+	return addrFingerprint()
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// networkPollInterval is how often interfaces are compared on platforms
+// without change notifications
+const networkPollInterval = 10 * time.Second
+
+// pollNetworkEvents emits an event every networkPollInterval; the caller
+// compares fingerprints to decide whether anything changed
+func pollNetworkEvents(ctx context.Context) <-chan struct{} {
+	events := make(chan struct{}, 1)
+
+	go func() {
+		ticker := time.NewTicker(networkPollInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				select {
+				case events <- struct{}{}:
+				default:
+				}
+			}
+		}
+	}()
+
+	return events
+}
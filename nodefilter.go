@@ -0,0 +1,117 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+var includeNodeFlag, excludeNodeFlag stringList
+
+func init() {
+	flag.Var(&includeNodeFlag, "include-node", "Only use nodes whose name matches this glob (e.g. ch-zrh-*) or /regex/ (repeatable)")
+	flag.Var(&excludeNodeFlag, "exclude-node", "Never use nodes whose name matches this glob (e.g. us-*) or /regex/ (repeatable)")
+}
+
+// nodePattern is one --include-node or --exclude-node entry
+type nodePattern struct {
+	text string
+	glob string         // Lowercased glob, if not a regex
+	re   *regexp.Regexp // Case-insensitive regex written as /.../
+}
+
+// parseNodePatterns parses globs and /regex/ entries
+func parseNodePatterns(values []string) ([]nodePattern, error) {
+	var patterns []nodePattern
+	for _, v := range values {
+		p := nodePattern{text: v}
+		if len(v) > 2 && strings.HasPrefix(v, "/") && strings.HasSuffix(v, "/") {
+			re, err := regexp.Compile("(?i)" + v[1:len(v)-1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid regex %s: %w", v, err)
+			}
+			p.re = re
+		} else {
+			p.glob = strings.ToLower(v)
+			if _, err := path.Match(p.glob, ""); err != nil {
+				return nil, fmt.Errorf("invalid glob %q: %w", v, err)
+			}
+		}
+		patterns = append(patterns, p)
+	}
+	return patterns, nil
+}
+
+// matches reports whether the pattern matches the node's Mullvad server
+// name (us-nyc-wg-301) or its full Tailscale DNS name. Globs must match the
+// whole name, regexes anywhere in it.
+func (p nodePattern) matches(node MullvadNode) bool {
+	names := []string{mullvadHostname(node), strings.TrimSuffix(node.DNSName, ".")}
+	for _, name := range names {
+		if p.re != nil {
+			if p.re.MatchString(name) {
+				return true
+			}
+			continue
+		}
+		if ok, _ := path.Match(p.glob, strings.ToLower(name)); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// matchingPattern returns the first pattern matching the node
+func matchingPattern(patterns []nodePattern, node MullvadNode) (nodePattern, bool) {
+	for _, p := range patterns {
+		if p.matches(node) {
+			return p, true
+		}
+	}
+	return nodePattern{}, false
+}
+
+// filterByNodePatterns keeps the nodes matching an --include-node pattern,
+// if any are given, and drops those matching an --exclude-node pattern
+func filterByNodePatterns(nodes []MullvadNode) ([]MullvadNode, error) {
+	if len(includeNodeFlag) == 0 && len(excludeNodeFlag) == 0 {
+		return nodes, nil
+	}
+	include, err := parseNodePatterns(includeNodeFlag)
+	if err != nil {
+		return nil, err
+	}
+	exclude, err := parseNodePatterns(excludeNodeFlag)
+	if err != nil {
+		return nil, err
+	}
+
+	filtered := make([]MullvadNode, 0)
+	for _, node := range nodes {
+		if _, ok := matchingPattern(include, node); len(include) > 0 && !ok {
+			explain.eliminate("matches no --include-node pattern", node)
+			continue
+		}
+		if p, ok := matchingPattern(exclude, node); ok {
+			explain.eliminate("matches --exclude-node "+p.text, node)
+			continue
+		}
+		filtered = append(filtered, node)
+	}
+
+	var desc []string
+	if len(include) > 0 {
+		desc = append(desc, "include "+includeNodeFlag.String())
+	}
+	if len(exclude) > 0 {
+		desc = append(desc, "exclude "+excludeNodeFlag.String())
+	}
+	explain.filter("node names "+strings.Join(desc, ", "), len(nodes), len(filtered))
+	if len(filtered) == 0 {
+		return nil, errors.New("no Mullvad exit nodes left after --include-node/--exclude-node")
+	}
+	return filtered, nil
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"time"
+)
+
+var (
+	notifyWebhookFlag = flag.String("notify-webhook", "", "URL to POST a JSON notification to when protection fails (or on every event with --notify-level info; or set PROTECT_WAN_NOTIFY_WEBHOOK)")
+	notifyLevelFlag   = flag.String("notify-level", "failure", "Events sent to --notify-webhook: failure, or info to include routine events such as switches")
+	alertWebhookFlag  = flag.String("alert-webhook", "", "URL to POST failures only (e.g. a pager), separate from --notify-webhook (or set PROTECT_WAN_ALERT_WEBHOOK)")
+)
+
+// Notification severities
+const (
+	severityInfo    = "info"    // Routine events: switches, recoveries
+	severityFailure = "failure" // Protection failures that need a human
+)
+
+// notification is an event sent to the configured channels
+type notification struct {
+	Event    string    `json:"event"`
+	Severity string    `json:"severity"`
+	Message  string    `json:"message"`
+	Host     string    `json:"host"`
+	Time     time.Time `json:"time"`
+}
+
+// notifier delivers notifications to one channel
+type notifier interface {
+	Notify(ctx context.Context, n notification) error
+}
+
+// webhookNotifier POSTs the notification as JSON
+type webhookNotifier struct {
+	url string
+}
+
+func (w webhookNotifier) Notify(ctx context.Context, n notification) error {
+	body, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// channel is a configured notifier and the least severe event it receives,
+// or the only events it receives if events is set
+type channel struct {
+	notifier
+	failuresOnly bool
+	events       []string
+}
+
+// notifiers returns the channels configured by flags
+func notifiers() []channel {
+	var chs []channel
+	if url := flagOrEnv(*notifyWebhookFlag, "PROTECT_WAN_NOTIFY_WEBHOOK"); url != "" {
+		chs = append(chs, channel{webhookNotifier{url: url}, *notifyLevelFlag != severityInfo, nil})
+	}
+	if url := flagOrEnv(*alertWebhookFlag, "PROTECT_WAN_ALERT_WEBHOOK"); url != "" {
+		chs = append(chs, channel{webhookNotifier{url: url}, true, nil})
+	}
+	if *smtpServerFlag != "" {
+		chs = append(chs, channel{newSMTPNotifier(), *smtpLevelFlag != severityInfo, nil})
+	}
+	if flagOrEnv(*ntfyTopicFlag, "PROTECT_WAN_NTFY_TOPIC") != "" {
+		chs = append(chs, channel{newNTFYNotifier(), *ntfyLevelFlag != severityInfo, ntfyEventsFlag})
+	}
+	return chs
+}
+
+// notifyFailure sends a failure notification to every configured channel
+func notifyFailure(ctx context.Context, event, message string) {
+	notify(ctx, severityFailure, event, message)
+}
+
+// notifyEvent sends a routine event to the channels that want them
+func notifyEvent(ctx context.Context, event, message string) {
+	notify(ctx, severityInfo, event, message)
+}
+
+// notify delivers a notification, unless it duplicates a recent one or
+// its event type is over its rate limit. Delivery errors are logged, never
+// returned: a broken webhook must not turn into a protection failure of
+// its own.
+func notify(ctx context.Context, severity, event, message string) {
+	chs := notifiers()
+	if len(chs) == 0 {
+		return
+	}
+	ok, suppressed := admitNotification(event, message)
+	if !ok {
+		return
+	}
+	if suppressed > 0 {
+		message += fmt.Sprintf(" (%d similar notifications suppressed)", suppressed)
+	}
+
+	host, _ := os.Hostname()
+	n := notification{Event: event, Severity: severity, Message: message, Host: host, Time: time.Now()}
+	for _, ch := range chs {
+		if len(ch.events) > 0 {
+			if !slices.Contains(ch.events, event) {
+				continue
+			}
+		} else if ch.failuresOnly && severity != severityFailure {
+			continue
+		}
+		if err := ch.Notify(ctx, n); err != nil {
+			log.Printf("Warning: failed to send %s notification: %v", event, err)
+		}
+	}
+}
+
+// failureEvent classifies an error into the failure event to notify
+func failureEvent(err error) string {
+	var routeLeak *routeLeakError
+	var probeLeak *probeLeakError
+	var forbidden *forbiddenError
+	switch {
+	case errors.Is(err, errMullvadMissing):
+		return "mullvad-missing"
+	case isPermissionDenied(err):
+		return "permission-denied"
+	case errors.Is(err, errTrialFailed), errors.As(err, &routeLeak), errors.As(err, &probeLeak), errors.As(err, &forbidden):
+		return "verification-failed"
+	default:
+		return "selection-failed"
+	}
+}
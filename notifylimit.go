@@ -0,0 +1,136 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	notifyDedupFlag = flag.Duration("notify-dedup", 15*time.Minute, "Drop a notification identical to one sent this recently (0 to disable)")
+	notifyLimitFlag stringList
+)
+
+func init() {
+	flag.Var(&notifyLimitFlag, "notify-limit", "Rate limit per event type as event=N/period, e.g. exit-switched=4/1h; * for all others (repeatable, default *=10/1h)")
+}
+
+// defaultNotifyLimit applies to events without a --notify-limit
+var defaultNotifyLimit = notifyLimit{Count: 10, Period: time.Hour}
+
+// notifyLimit allows Count notifications of an event type per Period
+type notifyLimit struct {
+	Count  int
+	Period time.Duration
+}
+
+// notifyHistory is what was recently sent of one event type, kept in the
+// state file so one-shot runs from cron are limited too
+type notifyHistory struct {
+	Sent        []time.Time `json:"sent,omitempty"` // Within the limit period
+	LastMessage string      `json:"last_message,omitempty"`
+	LastTime    time.Time   `json:"last_time,omitzero"`
+	Suppressed  int         `json:"suppressed,omitempty"` // Dropped since the last one sent
+}
+
+// parseNotifyLimits parses the --notify-limit rules by event type
+func parseNotifyLimits(rules []string) (map[string]notifyLimit, error) {
+	limits := make(map[string]notifyLimit)
+	for _, rule := range rules {
+		event, value, ok := strings.Cut(rule, "=")
+		count, period, ok2 := strings.Cut(value, "/")
+		if !ok || !ok2 || strings.TrimSpace(event) == "" {
+			return nil, fmt.Errorf("invalid limit %q (expected event=N/period, e.g. exit-switched=4/1h)", rule)
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(count))
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid count in limit %q", rule)
+		}
+		d, err := time.ParseDuration(strings.TrimSpace(period))
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid period in limit %q", rule)
+		}
+		limits[strings.TrimSpace(event)] = notifyLimit{Count: n, Period: d}
+	}
+	return limits, nil
+}
+
+// limitFor returns the limit of an event type
+func limitFor(event string) notifyLimit {
+	limits, _ := parseNotifyLimits(notifyLimitFlag)
+	if l, ok := limits[event]; ok {
+		return l
+	}
+	if l, ok := limits["*"]; ok {
+		return l
+	}
+	return defaultNotifyLimit
+}
+
+// memoryNotified stands in for the state file when state is disabled
+var memoryNotified = struct {
+	sync.Mutex
+	m map[string]*notifyHistory
+}{m: make(map[string]*notifyHistory)}
+
+// admitNotification decides whether a notification may be sent, and
+// records it if so. Returns how many of its type were suppressed since the
+// last one sent, so the message can say so.
+func admitNotification(event, message string) (ok bool, suppressed int) {
+	admit := func(history map[string]*notifyHistory) {
+		ok, suppressed = admitIn(history, event, message, time.Now())
+	}
+
+	if !stateEnabled() {
+		memoryNotified.Lock()
+		defer memoryNotified.Unlock()
+		admit(memoryNotified.m)
+		return ok, suppressed
+	}
+	err := updateState(func(st *state) {
+		if st.Notifications == nil {
+			st.Notifications = make(map[string]*notifyHistory)
+		}
+		admit(st.Notifications)
+	})
+	if err != nil {
+		log.Printf("Warning: failed to update state: %v", err)
+	}
+	return ok, suppressed
+}
+
+// admitIn applies --notify-dedup and the event's rate limit to history
+func admitIn(history map[string]*notifyHistory, event, message string, now time.Time) (bool, int) {
+	h := history[event]
+	if h == nil {
+		h = &notifyHistory{}
+		history[event] = h
+	}
+	limit := limitFor(event)
+
+	kept := h.Sent[:0]
+	for _, t := range h.Sent {
+		if now.Sub(t) < limit.Period {
+			kept = append(kept, t)
+		}
+	}
+	h.Sent = kept
+
+	duplicate := *notifyDedupFlag > 0 && message == h.LastMessage && now.Sub(h.LastTime) < *notifyDedupFlag
+	if duplicate || len(h.Sent) >= limit.Count {
+		h.Suppressed++
+		if *verboseFlag {
+			log.Printf("Notification %s suppressed (duplicate or over its rate limit)", event)
+		}
+		return false, 0
+	}
+
+	suppressed := h.Suppressed
+	h.Sent = append(h.Sent, now)
+	h.LastMessage, h.LastTime, h.Suppressed = message, now, 0
+	return true, suppressed
+}
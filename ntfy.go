@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+var (
+	ntfyTopicFlag  = flag.String("ntfy-topic", "", "ntfy topic to push notifications to: a name on --ntfy-server, or a full topic URL (or set PROTECT_WAN_NTFY_TOPIC)")
+	ntfyServerFlag = flag.String("ntfy-server", "https://ntfy.sh", "ntfy (or compatible) server for --ntfy-topic")
+	ntfyTokenFlag  = flag.String("ntfy-token", "", "ntfy access token for protected topics (or set PROTECT_WAN_NTFY_TOKEN)")
+	ntfyLevelFlag  = flag.String("ntfy-level", "failure", "Events pushed: failure, or info to include routine events such as switches")
+	ntfyEventsFlag stringList
+)
+
+func init() {
+	flag.Var(&ntfyEventsFlag, "ntfy-events", "Push only these events, e.g. exit-unprotected,egress-country-changed (repeatable, overrides --ntfy-level)")
+}
+
+// ntfyNotifier publishes the notification to an ntfy topic
+type ntfyNotifier struct {
+	url, token string
+}
+
+// newNTFYNotifier configures ntfy from the --ntfy-* flags
+func newNTFYNotifier() ntfyNotifier {
+	url := flagOrEnv(*ntfyTopicFlag, "PROTECT_WAN_NTFY_TOPIC")
+	if !strings.Contains(url, "://") {
+		url = strings.TrimSuffix(*ntfyServerFlag, "/") + "/" + url
+	}
+	return ntfyNotifier{url: url, token: flagOrEnv(*ntfyTokenFlag, "PROTECT_WAN_NTFY_TOKEN")}
+}
+
+func (t ntfyNotifier) Notify(ctx context.Context, n notification) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.url, strings.NewReader(n.Message))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Title", fmt.Sprintf("protect-wan on %s: %s", n.Host, n.Event))
+	if n.Severity == severityFailure {
+		req.Header.Set("Priority", "high")
+		req.Header.Set("Tags", "warning")
+	} else {
+		req.Header.Set("Tags", "globe_with_meridians")
+	}
+	if t.token != "" {
+		req.Header.Set("Authorization", "Bearer "+t.token)
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("ntfy returned %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"os/user"
+	"runtime"
+
+	"tailscale.com/ipn"
+)
+
+func init() {
+	commands["setup-operator"] = command{
+		Usage: "Make a user the Tailscale operator (one-time sudo) so later runs need no sudo: setup-operator [user]",
+		Run:   runSetupOperator,
+	}
+}
+
+// runSetupOperator is the equivalent of `tailscale set --operator=$USER`.
+// Without the access to do so, it re-runs itself once under sudo.
+func runSetupOperator(ctx context.Context, lc LocalClient, args []string) error {
+	if runtime.GOOS == "windows" {
+		return errors.New("Windows has no Tailscale operator; tailscaled grants access to the logged-in user")
+	}
+	if len(args) > 1 {
+		return errors.New("usage: setup-operator [user]")
+	}
+
+	name := operatorCandidate(args)
+	if name == "" {
+		return errors.New("cannot determine the user, pass it: setup-operator <user>")
+	}
+	if name == "root" {
+		return errors.New("root needs no operator access, pass the unprivileged user: sudo protect-wan setup-operator <user>")
+	}
+
+	mp := &ipn.MaskedPrefs{
+		Prefs:           ipn.Prefs{OperatorUser: name},
+		OperatorUserSet: true,
+	}
+	_, err := lc.EditPrefs(withAuditReason(ctx, "setup-operator"), mp)
+	if err == nil {
+		fmt.Printf("%s is now the Tailscale operator; protect-wan no longer needs sudo for that user\n", name)
+		return nil
+	}
+	if !isPermissionDenied(err) || os.Geteuid() == 0 {
+		return handlePermissionError(err, "set the Tailscale operator")
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to locate protect-wan for sudo: %w", err)
+	}
+	fmt.Println("Setting the Tailscale operator needs root once, running with sudo...")
+	cmd := exec.CommandContext(ctx, "sudo", exe, "setup-operator", name)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("sudo setup-operator failed: %w", err)
+	}
+	return nil
+}
+
+// operatorCandidate returns the user to make operator: the argument, else
+// the user who invoked sudo, else the current user
+func operatorCandidate(args []string) string {
+	if len(args) == 1 {
+		return args[0]
+	}
+	if name := os.Getenv("SUDO_USER"); name != "" {
+		return name
+	}
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	return ""
+}
+
+// warnUnnecessaryRoot warns when protect-wan runs under sudo although the
+// invoking user is already the Tailscale operator
+func warnUnnecessaryRoot(ctx context.Context, lc LocalClient) {
+	name := os.Getenv("SUDO_USER")
+	if os.Geteuid() != 0 || name == "" || name == "root" {
+		return
+	}
+	prefs, err := lc.GetPrefs(ctx)
+	if err != nil || prefs.OperatorUser != name {
+		return
+	}
+	log.Printf("Warning: running as root is unnecessary, %s is the Tailscale operator; run protect-wan without sudo", name)
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+)
+
+var otherVPNFlag = flag.String("other-vpn", "warn", "When another VPN is active under Tailscale: off, warn, skip-latency (rank by priority) or fail")
+
+// vpnInterfaceNames are name fragments of interfaces created by common VPN
+// clients. Windows names adapters after the product, Unix after the driver.
+var vpnInterfaceNames = []string{
+	"tun", "wg", "ppp", "ipsec", "cscotun", "gpd", "nordlynx", "proton", "mullvad",
+	"warp", "openvpn", "wireguard", "vpn",
+}
+
+// otherVPNWarned is the interface already warned about, to warn once per
+// process rather than on every daemon selection
+var otherVPNWarned string
+
+// detectOtherVPN looks for another VPN the host is connected through,
+// besides Tailscale: an interface that is up, has a global address and is
+// either a point-to-point tunnel or named like a known VPN client's.
+// Returns the interface name, or "" if none was found.
+func detectOtherVPN(ctx context.Context, lc LocalClient) (string, error) {
+	status, err := lc.StatusWithoutPeers(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to get status: %w", err)
+	}
+	tsIface, _ := tailscaleInterface(status.TailscaleIPs)
+
+	// A default route through a tunnel that isn't Tailscale's is the
+	// clearest sign; it only shows while no exit node is set
+	if iface, err := routeInterface(routeProbeV4); err == nil && iface != tsIface && isVPNInterface(iface) {
+		return iface, nil
+	}
+
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return "", fmt.Errorf("failed to list interfaces: %w", err)
+	}
+	for _, iface := range ifaces {
+		if iface.Name == tsIface || iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		if iface.Flags&net.FlagPointToPoint == 0 && !vpnNamed(iface.Name) {
+			continue
+		}
+		if hasGlobalAddress(iface) {
+			return iface.Name, nil
+		}
+	}
+	return "", nil
+}
+
+// isVPNInterface reports whether the named interface looks like a VPN tunnel
+func isVPNInterface(name string) bool {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return vpnNamed(name)
+	}
+	return (iface.Flags&net.FlagPointToPoint != 0 || vpnNamed(name)) && hasGlobalAddress(*iface)
+}
+
+// vpnNamed reports whether an interface name matches a known VPN client's
+func vpnNamed(name string) bool {
+	name = strings.ToLower(name)
+	for _, n := range vpnInterfaceNames {
+		if strings.Contains(name, n) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasGlobalAddress reports whether an interface holds a global unicast
+// address. Rules out the link-local-only utun interfaces macOS keeps up for
+// its own services.
+func hasGlobalAddress(iface net.Interface) bool {
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return false
+	}
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.IsGlobalUnicast() {
+			return true
+		}
+	}
+	return false
+}
+
+// checkOtherVPN applies --other-vpn before the strategy runs. Latency
+// measured through another VPN reflects that VPN's server rather than the
+// host, so the ranking would be misleading. Returns whether latency-based
+// strategies should be skipped.
+func checkOtherVPN(ctx context.Context, lc LocalClient) (bool, error) {
+	if *otherVPNFlag == "off" {
+		return false, nil
+	}
+	iface, err := detectOtherVPN(ctx, lc)
+	if err != nil {
+		if *verboseFlag {
+			fmt.Printf("Could not check for another VPN: %v\n", err)
+		}
+		return false, nil
+	}
+	if iface == "" {
+		return false, nil
+	}
+
+	switch *otherVPNFlag {
+	case "fail":
+		return false, fmt.Errorf("another VPN is active on %s; refusing to select an exit node (--other-vpn fail)", iface)
+	case "skip-latency":
+		explain.note("Another VPN is active on %s, latency not measured (--other-vpn skip-latency)", iface)
+		if otherVPNWarned != iface {
+			fmt.Fprintf(os.Stderr, "Warning: another VPN is active on %s, selecting by priority instead of latency\n", iface)
+			otherVPNWarned = iface
+		}
+		return true, nil
+	}
+	explain.note("Another VPN is active on %s, latencies include its detour", iface)
+	if otherVPNWarned != iface {
+		fmt.Fprintf(os.Stderr, "Warning: another VPN is active on %s; latency measurements go through it and may be misleading\n", iface)
+		otherVPNWarned = iface
+	}
+	return false, nil
+}
+
+// measuresLatency reports whether a strategy ranks by measured latency
+func measuresLatency(name string) bool {
+	switch name {
+	case "latency", "weighted":
+		return true
+	case "exec":
+		return *scorerLatencyFlag
+	}
+	return false
+}
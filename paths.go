@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+var logFileFlag = flag.String("log-file", "", "Also append log output to this file (tray default: <user log dir>/protect-wan/protect-wan.log)")
+
+func init() {
+	commands["paths"] = command{
+		Usage: "Print where the config, state, cache and logs are stored",
+		Run:   runPaths,
+	}
+}
+
+// appDir is the directory name under each base directory
+const appDir = "protect-wan"
+
+// configDir returns the config directory: $XDG_CONFIG_HOME (default
+// ~/.config) on Linux and BSD, ~/Library/Application Support on macOS and
+// %AppData% on Windows
+func configDir() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, appDir), nil
+}
+
+// stateDir returns the directory for data that should survive restarts
+// but isn't worth backing up: $XDG_STATE_HOME (default ~/.local/state) on
+// Linux and BSD, ~/Library/Application Support on macOS and %LocalAppData%
+// on Windows
+func stateDir() (string, error) {
+	switch runtime.GOOS {
+	case "windows":
+		dir := os.Getenv("LocalAppData")
+		if dir == "" {
+			return "", errors.New("%LocalAppData% is not defined")
+		}
+		return filepath.Join(dir, appDir), nil
+	case "darwin":
+		return configDir()
+	}
+	if dir := os.Getenv("XDG_STATE_HOME"); filepath.IsAbs(dir) {
+		return filepath.Join(dir, appDir), nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".local", "state", appDir), nil
+}
+
+// cacheDir returns the directory for data that may be deleted at any time:
+// $XDG_CACHE_HOME (default ~/.cache) on Linux and BSD, ~/Library/Caches on
+// macOS and %LocalAppData% on Windows
+func cacheDir() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, appDir), nil
+}
+
+// logDir returns the log directory: the state directory on Linux and BSD,
+// as the XDG spec suggests, ~/Library/Logs on macOS and a logs folder in
+// the state directory on Windows
+func logDir() (string, error) {
+	switch runtime.GOOS {
+	case "darwin":
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		return filepath.Join(home, "Library", "Logs", appDir), nil
+	case "windows":
+		dir, err := stateDir()
+		if err != nil {
+			return "", err
+		}
+		return filepath.Join(dir, "logs"), nil
+	}
+	return stateDir()
+}
+
+// defaultLogFile returns the log file location used by the tray
+func defaultLogFile() string {
+	dir, err := logDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "protect-wan.log")
+}
+
+// openLogFile appends the log output to path as well as stderr
+func openLogFile(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("failed to create log directory: %w", err)
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open log file: %w", err)
+	}
+	log.SetOutput(io.MultiWriter(os.Stderr, f))
+	return nil
+}
+
+var legacyState struct {
+	once sync.Once
+	path string // State file to use, set if moving it failed
+}
+
+// migrateStatePath moves a state file from the cache directory, where
+// older versions kept it, to path. Returns the path to use, which is the
+// old one if it couldn't be moved.
+func migrateStatePath(path string) string {
+	legacyState.once.Do(func() {
+		dir, err := cacheDir()
+		if err != nil {
+			return
+		}
+		old := filepath.Join(dir, "state.json")
+		if old == path {
+			return
+		}
+		if _, err := os.Stat(path); err == nil {
+			return
+		}
+		if _, err := os.Stat(old); err != nil {
+			return
+		}
+
+		err = os.MkdirAll(filepath.Dir(path), 0o755)
+		if err == nil {
+			err = os.Rename(old, path)
+		}
+		if err != nil {
+			log.Printf("Warning: failed to move state from %s to %s: %v", old, path, err)
+			legacyState.path = old
+			return
+		}
+		log.Printf("Moved state from %s to %s", old, path)
+	})
+	if legacyState.path != "" {
+		return legacyState.path
+	}
+	return path
+}
+
+// runPaths prints the resolved locations, marking files that don't exist
+// and locations overridden by flags
+func runPaths(ctx context.Context, lc LocalClient, args []string) error {
+	if len(args) > 0 {
+		return errors.New("paths takes no arguments")
+	}
+
+	show := func(label, path, flagName string, file bool) {
+		if path == "" {
+			fmt.Printf("%-13s (unavailable)\n", label)
+			return
+		}
+		var notes []string
+		if flagName != "" {
+			if f := flag.Lookup(flagName); f != nil && f.Value.String() != "" {
+				notes = append(notes, "--"+flagName)
+			}
+		}
+		if _, err := os.Stat(path); err != nil {
+			if file {
+				notes = append(notes, "not created yet")
+			} else {
+				notes = append(notes, "empty")
+			}
+		}
+		if len(notes) > 0 {
+			fmt.Printf("%-13s %s (%s)\n", label, path, strings.Join(notes, ", "))
+			return
+		}
+		fmt.Printf("%-13s %s\n", label, path)
+	}
+
+	config := *configFlag
+	if config == "" {
+		config = defaultConfigPath()
+	}
+	logFile := *logFileFlag
+	if logFile == "" {
+		logFile = defaultLogFile()
+	}
+	cache, _ := cacheDir()
+
+	show("Config:", config, "config", true)
+	if admin := adminConfigPath(); admin != "" {
+		show("Admin config:", admin, "", true)
+	}
+	show("State:", statePath(), "state", true)
+	if *stateStoreFlag == storeBolt {
+		show("State store:", storePath(), "", true)
+	}
+	show("Cache:", cache, "", false)
+	show("Log file:", logFile, "log-file", true)
+	show("Socket:", controlSocketPath(), "control-socket", true)
+	show("PID file:", pidFilePath(), "", true)
+	return nil
+}
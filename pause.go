@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+func init() {
+	commands["pause"] = command{
+		Usage: "Pause protection by the daemon, until resume or for a while: pause [duration]",
+		Run:   runPause,
+	}
+	commands["resume"] = command{
+		Usage: "Resume protection by the daemon after pause",
+		Run:   runResume,
+	}
+}
+
+// pause is a pause of protection. It is kept in the state file, so a
+// running daemon or tray picks it up.
+type pause struct {
+	Since time.Time `json:"since"`
+	Until time.Time `json:"until,omitzero"` // Zero until resumed
+}
+
+func (p *pause) String() string {
+	if p.Until.IsZero() {
+		return "until resumed"
+	}
+	return "until " + p.Until.Format(time.DateTime)
+}
+
+// currentPause returns the pause in effect, nil if there is none or the
+// admin config enforces protection
+func currentPause() *pause {
+	if !stateEnabled() || adminEnforce {
+		return nil
+	}
+	st, err := loadState()
+	if err != nil || st.Pause == nil {
+		return nil
+	}
+	if !st.Pause.Until.IsZero() && time.Now().After(st.Pause.Until) {
+		return nil
+	}
+	return st.Pause
+}
+
+// setPause pauses protection for d, or until resumed if d is 0
+func setPause(d time.Duration) (*pause, error) {
+	if err := checkEnforced(); err != nil {
+		return nil, err
+	}
+	if !stateEnabled() {
+		return nil, errors.New("pausing needs a state file (see --state)")
+	}
+	p := &pause{Since: time.Now()}
+	if d > 0 {
+		p.Until = p.Since.Add(d)
+	}
+	if err := updateState(func(st *state) { st.Pause = p }); err != nil {
+		return nil, fmt.Errorf("failed to update state: %w", err)
+	}
+	return p, nil
+}
+
+// clearPause resumes protection. Returns false if it wasn't paused.
+func clearPause() (bool, error) {
+	if !stateEnabled() {
+		return false, nil
+	}
+	paused := currentPause() != nil
+	if err := updateState(func(st *state) { st.Pause = nil }); err != nil {
+		return false, fmt.Errorf("failed to update state: %w", err)
+	}
+	return paused, nil
+}
+
+// runPause pauses protection. The exit node is left as it is; use disable
+// as well to turn it off.
+func runPause(ctx context.Context, lc LocalClient, args []string) error {
+	var d time.Duration
+	switch len(args) {
+	case 0:
+	case 1:
+		var err error
+		if d, err = time.ParseDuration(args[0]); err != nil || d <= 0 {
+			return fmt.Errorf("invalid duration %q (e.g. 30m, 2h)", args[0])
+		}
+	default:
+		return errors.New("usage: pause [duration]")
+	}
+
+	p, err := setPause(d)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Protection paused %s: the daemon won't enforce an exit node\n", p)
+	return nil
+}
+
+// runResume ends a pause
+func runResume(ctx context.Context, lc LocalClient, args []string) error {
+	if len(args) > 0 {
+		return errors.New("resume takes no arguments")
+	}
+	paused, err := clearPause()
+	if err != nil {
+		return err
+	}
+	if !paused {
+		fmt.Println("Protection is not paused")
+		return nil
+	}
+	fmt.Println("Protection resumed")
+	return nil
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// pidFilePath returns where a running daemon records its PID, next to the
+// state file, or "" if there is no state file
+func pidFilePath() string {
+	if path := statePath(); path != "" {
+		return filepath.Join(filepath.Dir(path), "daemon.pid")
+	}
+	return ""
+}
+
+// readPIDFile returns the PID recorded in a PID file
+func readPIDFile(path string) (int, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil || pid <= 0 {
+		return 0, fmt.Errorf("%s holds no PID", path)
+	}
+	return pid, nil
+}
+
+// acquirePIDFile records this process as the running daemon. It fails if
+// another daemon for the same state file is alive; a PID file left behind
+// by one that didn't exit cleanly is replaced. The returned func removes
+// the file again.
+func acquirePIDFile() (func(), error) {
+	path := pidFilePath()
+	if path == "" {
+		return func() {}, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create PID file directory: %w", err)
+	}
+
+	for attempt := 0; ; attempt++ {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+		if err == nil {
+			_, err = fmt.Fprintf(f, "%d\n", os.Getpid())
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+			if err != nil {
+				os.Remove(path)
+				return nil, fmt.Errorf("failed to write PID file: %w", err)
+			}
+			return func() { os.Remove(path) }, nil
+		}
+		if !errors.Is(err, fs.ErrExist) || attempt > 0 {
+			return nil, fmt.Errorf("failed to create PID file: %w", err)
+		}
+
+		pid, rerr := readPIDFile(path)
+		if rerr == nil && pid != os.Getpid() && processAlive(pid) {
+			return nil, fmt.Errorf("another daemon is already running (PID %d, see daemon status); if it isn't, remove %s", pid, path)
+		}
+		if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("failed to remove stale PID file: %w", err)
+		}
+	}
+}
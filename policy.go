@@ -0,0 +1,376 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+var policyFlag = flag.String("policy", "", "Rules file evaluated on every run and daemon check (conditions -> settings)")
+
+// policyRule is one [section] of the policy file. All of its conditions
+// must hold for its settings to apply.
+type policyRule struct {
+	Name string
+
+	Networks []string // Any of these --trusted style rules
+	Window   *timeWindow
+	Battery  *bool    // On battery (true) or AC (false)
+	Location []string // Country codes the host appears to be in
+	VPN      *bool    // Another VPN is active (true) or not (false)
+
+	Settings [][2]string // Flag name, value; "enforce" is on or off
+}
+
+// timeWindow is a daily time range, optionally on some weekdays only
+type timeWindow struct {
+	From, To time.Duration  // Since midnight; From > To wraps past midnight
+	Days     []time.Weekday // Empty for every day
+}
+
+// policy is the loaded --policy file and the flag values it overrides
+type policy struct {
+	Rules    []*policyRule
+	baseline map[string]string // Flag values before any rule applied
+	active   string            // Name of the rule in effect, "" for none
+}
+
+// activePolicy is the loaded policy, nil without --policy
+var activePolicy *policy
+
+// policyEnforce is "on" or "off" while a rule sets enforce, overriding
+// --trusted, and policyEnforceRule names that rule
+var policyEnforce, policyEnforceRule string
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// loadPolicy parses the --policy file. The format follows the config file:
+//
+//	[office]
+//	when network = 10.20.0.0/16
+//	when time = 09:00-18:00 mon-fri
+//	set country = DE
+//	set enforce = off
+func loadPolicy(path string) (*policy, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open policy: %w", err)
+	}
+	defer f.Close()
+
+	p := &policy{baseline: make(map[string]string)}
+	var rule *policyRule
+	scanner := bufio.NewScanner(f)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			rule = &policyRule{Name: strings.TrimSpace(line[1 : len(line)-1])}
+			p.Rules = append(p.Rules, rule)
+			continue
+		}
+		if rule == nil {
+			return nil, fmt.Errorf("%s:%d: expected a [rule] before settings", path, lineNum)
+		}
+
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("%s:%d: expected `when <condition> = value` or `set <flag> = value`", path, lineNum)
+		}
+		verb, name, _ := strings.Cut(strings.TrimSpace(key), " ")
+		name = strings.TrimPrefix(strings.TrimSpace(name), "--")
+		value = strings.Trim(strings.TrimSpace(value), `"`)
+
+		switch verb {
+		case "when":
+			err = rule.addCondition(name, value)
+		case "set":
+			err = p.addSetting(rule, name, value)
+		default:
+			err = fmt.Errorf("unknown keyword %q (expected when or set)", verb)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, lineNum, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read policy: %w", err)
+	}
+	return p, nil
+}
+
+// addCondition parses one `when` line
+func (r *policyRule) addCondition(name, value string) error {
+	switch name {
+	case "network":
+		var l stringList
+		l.Set(value)
+		r.Networks = append(r.Networks, l...)
+	case "time":
+		w, err := parseTimeWindow(value)
+		if err != nil {
+			return err
+		}
+		r.Window = w
+	case "battery":
+		b := value == "true" || value == "yes" || value == "on"
+		if !b && value != "false" && value != "no" && value != "off" {
+			return fmt.Errorf("invalid battery condition %q (expected true or false)", value)
+		}
+		r.Battery = &b
+	case "vpn":
+		b := value == "true" || value == "yes" || value == "on"
+		if !b && value != "false" && value != "no" && value != "off" {
+			return fmt.Errorf("invalid vpn condition %q (expected true or false)", value)
+		}
+		r.VPN = &b
+	case "location":
+		for _, c := range strings.Split(value, ",") {
+			r.Location = append(r.Location, strings.ToUpper(strings.TrimSpace(c)))
+		}
+	default:
+		return fmt.Errorf("unknown condition %q (expected network, time, battery, location or vpn)", name)
+	}
+	return nil
+}
+
+// addSetting parses one `set` line and remembers the flag's value to
+// restore when no rule sets it
+func (p *policy) addSetting(r *policyRule, name, value string) error {
+	if name == "enforce" {
+		if value != "on" && value != "off" {
+			return fmt.Errorf("invalid enforce %q (expected on or off)", value)
+		}
+	} else {
+		f := flag.Lookup(name)
+		if f == nil || name == "config" || name == "policy" || name == "daemon" || name == "forbid-country" || name == "allow-country" || name == "override-policy" {
+			return fmt.Errorf("unknown or unsupported setting %q", name)
+		}
+		if _, locked := adminLocked[name]; locked {
+			return fmt.Errorf("setting %q is locked by the admin config", name)
+		}
+		if _, ok := p.baseline[name]; !ok {
+			p.baseline[name] = f.Value.String()
+		}
+	}
+	r.Settings = append(r.Settings, [2]string{name, value})
+	return nil
+}
+
+// parseTimeWindow parses "HH:MM-HH:MM [days]", days being e.g. mon-fri or
+// sat,sun
+func parseTimeWindow(s string) (*timeWindow, error) {
+	fields := strings.Fields(s)
+	if len(fields) == 0 || len(fields) > 2 {
+		return nil, fmt.Errorf("invalid time window %q (expected e.g. 09:00-18:00 mon-fri)", s)
+	}
+
+	from, to, ok := strings.Cut(fields[0], "-")
+	if !ok {
+		return nil, fmt.Errorf("invalid time window %q (expected e.g. 09:00-18:00 mon-fri)", s)
+	}
+	w := &timeWindow{}
+	for _, t := range []struct {
+		s string
+		d *time.Duration
+	}{{from, &w.From}, {to, &w.To}} {
+		clock, err := time.Parse("15:04", t.s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid time %q (expected HH:MM)", t.s)
+		}
+		*t.d = time.Duration(clock.Hour())*time.Hour + time.Duration(clock.Minute())*time.Minute
+	}
+
+	if len(fields) == 2 {
+		for _, part := range strings.Split(strings.ToLower(fields[1]), ",") {
+			first, last, isRange := strings.Cut(part, "-")
+			a, okFirst := weekdays[first]
+			b, okLast := a, true
+			if isRange {
+				b, okLast = weekdays[last]
+			}
+			if !okFirst || !okLast {
+				return nil, fmt.Errorf("invalid days %q (expected e.g. mon-fri or sat,sun)", fields[1])
+			}
+			for d := a; ; d = (d + 1) % 7 {
+				w.Days = append(w.Days, d)
+				if d == b {
+					break
+				}
+			}
+		}
+	}
+	return w, nil
+}
+
+// contains reports whether t falls in the window
+func (w *timeWindow) contains(t time.Time) bool {
+	day := t.Weekday()
+	since := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute
+	if w.From > w.To && since < w.To {
+		// After midnight in a window that started the day before
+		day = (day + 6) % 7
+	}
+	if len(w.Days) > 0 && !slices.Contains(w.Days, day) {
+		return false
+	}
+	if w.From <= w.To {
+		return since >= w.From && since < w.To
+	}
+	return since >= w.From || since < w.To
+}
+
+// matches reports whether all of the rule's conditions hold
+func (r *policyRule) matches(ctx context.Context, lc LocalClient, env *policyEnv) bool {
+	if len(r.Networks) > 0 {
+		if _, ok := matchTrustedNetwork(env.network(), r.Networks); !ok {
+			return false
+		}
+	}
+	if r.Window != nil && !r.Window.contains(time.Now()) {
+		return false
+	}
+	if r.Battery != nil && *r.Battery != onBattery() {
+		return false
+	}
+	if len(r.Location) > 0 && !slices.Contains(r.Location, env.location(ctx, lc)) {
+		return false
+	}
+	if r.VPN != nil && *r.VPN != env.otherVPN(ctx, lc) {
+		return false
+	}
+	return true
+}
+
+// policyEnv detects the network, location and other VPNs once per
+// evaluation, and only if a rule asks for them
+type policyEnv struct {
+	id          *NetworkIdentity
+	country     string
+	countryDone bool
+	vpn         *bool
+}
+
+func (e *policyEnv) network() NetworkIdentity {
+	if e.id == nil {
+		id := currentNetwork()
+		e.id = &id
+	}
+	return *e.id
+}
+
+// otherVPN reports whether another VPN is active besides Tailscale
+func (e *policyEnv) otherVPN(ctx context.Context, lc LocalClient) bool {
+	if e.vpn == nil {
+		iface, _ := detectOtherVPN(ctx, lc)
+		active := iface != ""
+		e.vpn = &active
+	}
+	return *e.vpn
+}
+
+// location returns the country the host appears to be in: that of the
+// Mullvad node Tailscale ranks closest (lowest priority)
+func (e *policyEnv) location(ctx context.Context, lc LocalClient) string {
+	if e.countryDone {
+		return e.country
+	}
+	e.countryDone = true
+	nodes, err := getMullvadNodes(ctx, lc)
+	if err != nil || len(nodes) == 0 {
+		return ""
+	}
+	closest := nodes[0]
+	for _, node := range nodes[1:] {
+		if node.Priority < closest.Priority {
+			closest = node
+		}
+	}
+	e.country = closest.CountryCode
+	return e.country
+}
+
+// apply evaluates the rules and applies the settings of the first matching
+// one on top of the baseline flag values. Settings of a rule that no
+// longer matches are reverted.
+func (p *policy) apply(ctx context.Context, lc LocalClient) error {
+	var matched *policyRule
+	env := &policyEnv{}
+	for _, r := range p.Rules {
+		if r.matches(ctx, lc, env) {
+			matched = r
+			break
+		}
+	}
+
+	if err := p.reset(); err != nil {
+		return err
+	}
+
+	name := ""
+	if matched != nil {
+		name = matched.Name
+		for _, s := range matched.Settings {
+			if s[0] == "enforce" {
+				policyEnforce, policyEnforceRule = s[1], matched.Name
+				continue
+			}
+			if err := replaceFlag(s[0], s[1]); err != nil {
+				return fmt.Errorf("policy rule %s: invalid value for %s: %w", matched.Name, s[0], err)
+			}
+		}
+	}
+
+	if name != p.active {
+		switch {
+		case name == "":
+			log.Printf("Policy: no rule applies, using defaults")
+		default:
+			log.Printf("Policy: rule %q applies", name)
+		}
+		p.active = name
+	}
+	return nil
+}
+
+// reset reverts the flags to their values before any rule applied
+func (p *policy) reset() error {
+	for name, value := range p.baseline {
+		if err := replaceFlag(name, value); err != nil {
+			return err
+		}
+	}
+	policyEnforce, policyEnforceRule = "", ""
+	return nil
+}
+
+// replaceFlag replaces a flag's value; repeatable flags are cleared first
+func replaceFlag(name, value string) error {
+	f := flag.Lookup(name)
+	if l, ok := f.Value.(*stringList); ok {
+		*l = nil
+	}
+	return f.Value.Set(value)
+}
+
+// applyPolicy re-evaluates the --policy rules, if any
+func applyPolicy(ctx context.Context, lc LocalClient) error {
+	if activePolicy == nil {
+		return nil
+	}
+	return activePolicy.apply(ctx, lc)
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPolicyApply(t *testing.T) {
+	// The fake tailnet's closest node (lowest priority) is in CH, which
+	// the location condition takes as the host's country
+	tests := []struct {
+		name        string
+		policy      string
+		wantRule    string
+		wantCountry string
+		wantEnforce string
+	}{
+		{
+			name:        "first matching rule applies",
+			policy:      "[abroad]\nwhen location = US\nset country = US\n\n[home]\nwhen location = CH,LI\nset country = DE\nset enforce = off\n",
+			wantRule:    "home",
+			wantCountry: "DE",
+			wantEnforce: "off",
+		},
+		{
+			name:        "rule without conditions always applies",
+			policy:      "[always]\nset country = SE\n",
+			wantRule:    "always",
+			wantCountry: "SE",
+		},
+		{
+			name:        "no rule applies",
+			policy:      "[abroad]\nwhen location = US\nset country = US\nset enforce = on\n",
+			wantCountry: "NL",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := newTestClient(t, testTailnet...)
+			withFlag(t, "country", "NL")
+			t.Cleanup(func() { policyEnforce, policyEnforceRule = "", "" })
+
+			p := loadTestPolicy(t, tt.policy)
+			if err := p.apply(context.Background(), fake); err != nil {
+				t.Fatalf("apply: %v", err)
+			}
+			if p.active != tt.wantRule {
+				t.Errorf("active rule = %q, want %q", p.active, tt.wantRule)
+			}
+			if *countryFlag != tt.wantCountry {
+				t.Errorf("--country = %q, want %q", *countryFlag, tt.wantCountry)
+			}
+			if policyEnforce != tt.wantEnforce {
+				t.Errorf("enforce = %q, want %q", policyEnforce, tt.wantEnforce)
+			}
+
+			// Reverting restores the flags from before the rules
+			if err := p.reset(); err != nil {
+				t.Fatalf("reset: %v", err)
+			}
+			if *countryFlag != "NL" || policyEnforce != "" {
+				t.Errorf("after reset: --country = %q, enforce = %q; want NL and none", *countryFlag, policyEnforce)
+			}
+		})
+	}
+}
+
+func TestLoadPolicyErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  string
+		wantErr string
+	}{
+		{"setting before a rule", "set country = DE\n", "expected a [rule]"},
+		{"unknown keyword", "[r]\nunless network = 10.0.0.0/8\n", "unknown keyword"},
+		{"unknown condition", "[r]\nwhen weather = rain\n", "unknown condition"},
+		{"unknown setting", "[r]\nset no-such-flag = 1\n", "unsupported setting"},
+		{"guardrail setting", "[r]\nset forbid-country = US\n", "unsupported setting"},
+		{"invalid enforce", "[r]\nset enforce = maybe\n", "invalid enforce"},
+		{"invalid time", "[r]\nwhen time = 25:00-26:00\n", "invalid time"},
+		{"invalid days", "[r]\nwhen time = 09:00-18:00 mon-fry\n", "invalid days"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := loadPolicy(writeTestFile(t, tt.policy))
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("loadPolicy error = %v, want one containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// loadTestPolicy loads a policy file with the given contents
+func loadTestPolicy(t *testing.T, contents string) *policy {
+	t.Helper()
+	p, err := loadPolicy(writeTestFile(t, contents))
+	if err != nil {
+		t.Fatalf("loadPolicy: %v", err)
+	}
+	return p
+}
+
+// writeTestFile writes contents to a file in the test's temporary
+// directory and returns its path
+func writeTestFile(t *testing.T, contents string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(path, []byte(contents), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
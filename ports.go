@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+var probePortFlag stringList
+
+func init() {
+	flag.Var(&probePortFlag, "probe-port", "With --check or doctor, test this port through the exit node and report if the relay blocks it: tcp/25, udp/host:51820, ... (repeatable)")
+}
+
+const (
+	// portProbeHost listens on every TCP port, so a failed connection
+	// means the port is blocked on the way
+	portProbeHost = "portquiz.net"
+
+	// portProbeTimeout bounds one port probe
+	portProbeTimeout = 5 * time.Second
+)
+
+// portProbe is one --probe-port entry
+type portProbe struct {
+	Proto  string // tcp or udp
+	Target string // host:port
+}
+
+func (p portProbe) String() string {
+	return p.Proto + "/" + p.Target
+}
+
+// portResult is the outcome of a port probe
+type portResult struct {
+	Probe   portProbe
+	Status  string // open, closed, blocked, no reply or error
+	Blocked bool
+	Detail  string
+}
+
+// parsePortProbes parses --probe-port entries: tcp/PORT, tcp/HOST:PORT or
+// udp/HOST:PORT. UDP needs a host that answers on the port, since silence
+// can't tell a blocked port from a quiet service.
+func parsePortProbes(values []string) ([]portProbe, error) {
+	var probes []portProbe
+	for _, v := range values {
+		proto, target, ok := strings.Cut(strings.ToLower(v), "/")
+		if !ok || (proto != "tcp" && proto != "udp") {
+			return nil, fmt.Errorf("%q: expected tcp/PORT, tcp/HOST:PORT or udp/HOST:PORT", v)
+		}
+		host, port, err := net.SplitHostPort(target)
+		if err != nil {
+			if proto == "udp" {
+				return nil, fmt.Errorf("%q: UDP probes need a host that answers, e.g. udp/1.1.1.1:53", v)
+			}
+			host, port = portProbeHost, target
+		}
+		if n, err := strconv.ParseUint(port, 10, 16); err != nil || n == 0 {
+			return nil, fmt.Errorf("%q: invalid port %q", v, port)
+		}
+		probes = append(probes, portProbe{Proto: proto, Target: net.JoinHostPort(host, port)})
+	}
+	return probes, nil
+}
+
+// refusedDetail explains a closed port: reachable through the relay, but
+// nothing listens on it
+const refusedDetail = "reachable: the host refused, but the relay let the probe through"
+
+// dnsQuery is a DNS query for the root NS records, which any resolver
+// answers; UDP probes of port 53 send it so resolvers reply
+var dnsQuery = []byte{0x70, 0x77, 0x01, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0x00, 0x01}
+
+// probePort tests one port through the current route, i.e. the exit node
+func probePort(ctx context.Context, p portProbe) portResult {
+	res := portResult{Probe: p}
+	d := net.Dialer{Timeout: portProbeTimeout}
+	conn, err := d.DialContext(ctx, p.Proto, p.Target)
+	var dnsErr *net.DNSError
+	switch {
+	case errors.As(err, &dnsErr):
+		res.Status, res.Detail = "error", err.Error()
+		return res
+	case errors.Is(err, syscall.ECONNREFUSED) && !strings.HasPrefix(p.Target, portProbeHost+":"):
+		// Your own host answered with a reset, so the relay let the
+		// probe through. portquiz.net accepts every port, so a reset
+		// from it came from the way there.
+		res.Status, res.Detail = "closed", refusedDetail
+		return res
+	case err != nil:
+		res.Status, res.Blocked, res.Detail = "blocked", true, err.Error()
+		return res
+	}
+	defer conn.Close()
+	if p.Proto == "tcp" {
+		res.Status = "open"
+		return res
+	}
+
+	payload := []byte("protect-wan")
+	if strings.HasSuffix(p.Target, ":53") {
+		payload = dnsQuery
+	}
+	conn.SetDeadline(time.Now().Add(portProbeTimeout))
+	if _, err := conn.Write(payload); err != nil {
+		res.Status, res.Blocked, res.Detail = "blocked", true, err.Error()
+		return res
+	}
+	_, err = conn.Read(make([]byte, 512))
+	var netErr net.Error
+	switch {
+	case err == nil:
+		res.Status = "open"
+	case errors.Is(err, syscall.ECONNREFUSED):
+		// An ICMP port unreachable from the far end made it back through
+		// the relay, so the path is open
+		res.Status, res.Detail = "closed", refusedDetail
+	case errors.As(err, &netErr) && netErr.Timeout():
+		res.Status, res.Blocked, res.Detail = "no reply", true, "blocked, or the host ignores the probe"
+	default:
+		res.Status, res.Blocked, res.Detail = "blocked", true, err.Error()
+	}
+	return res
+}
+
+// probePorts runs the --probe-port probes concurrently, in the given order
+func probePorts(ctx context.Context) ([]portResult, error) {
+	probes, err := parsePortProbes(probePortFlag)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]portResult, len(probes))
+	var wg sync.WaitGroup
+	for i, p := range probes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = probePort(ctx, p)
+		}()
+	}
+	wg.Wait()
+	return results, nil
+}
+
+// reportPorts prints which --probe-port ports the active exit node's relay
+// lets through
+func reportPorts(ctx context.Context, lc LocalClient) {
+	results, err := probePorts(ctx)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: port probes failed: %v\n", err)
+		return
+	}
+	exit := "the exit node"
+	if node, ok, err := currentExitNode(ctx, lc); err == nil && ok {
+		exit = displayName(node)
+	}
+
+	fmt.Printf("Ports through %s:\n", exit)
+	t := newTable("PROBE", "RESULT", "DETAIL")
+	for _, r := range results {
+		t.add(r.Probe.String(), r.Status, r.Detail)
+	}
+	t.print(terminalWidth())
+}
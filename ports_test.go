@@ -0,0 +1,22 @@
+package main
+
+import (
+	"context"
+	"net"
+	"testing"
+)
+
+func TestProbePortRefused(t *testing.T) {
+	// A port nothing listens on any more
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	res := probePort(context.Background(), portProbe{Proto: "tcp", Target: addr})
+	if res.Status != "closed" || res.Blocked {
+		t.Errorf("probePort(%s) = %s (blocked %v), want closed and not blocked", addr, res.Status, res.Blocked)
+	}
+}
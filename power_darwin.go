@@ -0,0 +1,16 @@
+package main
+
+import (
+	"os/exec"
+	"strings"
+)
+
+// onBattery reports whether the machine runs on battery power, based on
+// `pmset -g ps` ("Now drawing from 'Battery Power'")
+func onBattery() bool {
+	out, err := exec.Command("pmset", "-g", "ps").Output()
+	if err != nil {
+		return false
+	}
+	return strings.Contains(string(out), "'Battery Power'")
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// onBattery reports whether the machine runs on battery power, based on
+// /sys/class/power_supply. Machines without a battery are never on battery.
+func onBattery() bool {
+	supplies, err := filepath.Glob("/sys/class/power_supply/*")
+	if err != nil {
+		return false
+	}
+
+	hasBattery := false
+	for _, dir := range supplies {
+		kind := readSysfs(filepath.Join(dir, "type"))
+		switch kind {
+		case "Mains", "USB":
+			if readSysfs(filepath.Join(dir, "online")) == "1" {
+				return false
+			}
+		case "Battery":
+			// Ignore peripheral batteries (mice, keyboards)
+			if readSysfs(filepath.Join(dir, "scope")) != "Device" {
+				hasBattery = true
+			}
+		}
+	}
+
+	return hasBattery
+}
+
+// readSysfs reads a single-value sysfs attribute
+func readSysfs(path string) string {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(b))
+}
@@ -0,0 +1,8 @@
+//go:build !linux && !darwin && !windows
+
+package main
+
+// onBattery is not implemented on this platform; assume AC power
+func onBattery() bool {
+	return false
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"tailscale.com/ipn"
+)
+
+var profileFlag = flag.String("profile", "", "Tailscale login profile to run against (ID, name, login or tailnet); tailscaled is switched to it if another is active")
+
+func init() {
+	commands["profiles"] = command{
+		Usage: "List the Tailscale login profiles and which have Mullvad exit nodes",
+		Run:   runProfiles,
+	}
+}
+
+// currentProfile is the login profile tailscaled is running, once known
+var currentProfile ipn.LoginProfile
+
+// profileAccess is whether a login profile had Mullvad exit nodes when
+// protect-wan last ran against it. tailscaled only reports the peers of the
+// active profile, so this is all there is to know about the others.
+type profileAccess struct {
+	MullvadNodes int       `json:"mullvad_nodes"`
+	Time         time.Time `json:"time"`
+}
+
+// profileLabel names a profile the way tailscale switch --list does
+func profileLabel(p ipn.LoginProfile) string {
+	name := p.Name
+	if name == "" {
+		name = p.UserProfile.LoginName
+	}
+	if p.NetworkProfile.DomainName != "" && p.NetworkProfile.DomainName != name {
+		return fmt.Sprintf("%s (%s)", name, p.NetworkProfile.DomainName)
+	}
+	return name
+}
+
+// findProfile matches a profile by ID, name, login name or tailnet
+func findProfile(profiles []ipn.LoginProfile, name string) (ipn.LoginProfile, error) {
+	var matches []ipn.LoginProfile
+	for _, p := range profiles {
+		if string(p.ID) == name {
+			return p, nil
+		}
+		if strings.EqualFold(p.Name, name) || strings.EqualFold(p.UserProfile.LoginName, name) ||
+			strings.EqualFold(p.NetworkProfile.DomainName, name) {
+			matches = append(matches, p)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return ipn.LoginProfile{}, fmt.Errorf("no Tailscale login profile matches %q (see the profiles command)", name)
+	case 1:
+		return matches[0], nil
+	}
+	var ids []string
+	for _, p := range matches {
+		ids = append(ids, string(p.ID))
+	}
+	return ipn.LoginProfile{}, fmt.Errorf("%q matches several login profiles, use an ID: %s", name, strings.Join(ids, ", "))
+}
+
+// useProfile learns the active login profile and, with --profile, switches
+// tailscaled to the requested one. The switch is not undone on exit: like
+// tailscale switch, it changes the profile for every client of tailscaled.
+func useProfile(ctx context.Context, lc LocalClient) error {
+	if *profileFlag != "" {
+		if err := requireTailscale(ctx, lc, featureProfiles); err != nil {
+			return err
+		}
+	}
+	current, all, err := lc.ProfileStatus(ctx)
+	if err != nil {
+		if *profileFlag != "" {
+			return fmt.Errorf("failed to get login profiles: %w", err)
+		}
+		return nil
+	}
+	currentProfile = current
+	if *profileFlag == "" {
+		return nil
+	}
+
+	want, err := findProfile(all, *profileFlag)
+	if err != nil {
+		return err
+	}
+	if want.ID == current.ID {
+		return nil
+	}
+	if err := lc.SwitchProfile(ctx, want.ID); err != nil {
+		return handlePermissionError(err, "switch login profile")
+	}
+	log.Printf("Switched Tailscale login profile from %s to %s", profileLabel(current), profileLabel(want))
+	currentProfile = want
+	return nil
+}
+
+// profileNoted is the Mullvad node count last recorded for the active
+// profile by this process, so that repeated lookups don't rewrite state
+var profileNoted = struct {
+	sync.Mutex
+	id    ipn.ProfileID
+	nodes int
+}{nodes: -1}
+
+// noteProfileAccess records how many Mullvad exit nodes the active profile
+// has, when that changed
+func noteProfileAccess(nodes int) {
+	if currentProfile.ID == "" || !stateEnabled() {
+		return
+	}
+	profileNoted.Lock()
+	defer profileNoted.Unlock()
+	if profileNoted.id == currentProfile.ID && profileNoted.nodes == nodes {
+		return
+	}
+	profileNoted.id, profileNoted.nodes = currentProfile.ID, nodes
+
+	err := updateState(func(st *state) {
+		if st.Profiles == nil {
+			st.Profiles = make(map[ipn.ProfileID]*profileAccess)
+		}
+		st.Profiles[currentProfile.ID] = &profileAccess{MullvadNodes: nodes, Time: time.Now()}
+	})
+	if err != nil {
+		log.Printf("Warning: failed to update state: %v", err)
+	}
+}
+
+// otherProfileHint points at another login profile that had Mullvad exit
+// nodes, for when the active one has none
+func otherProfileHint(ctx context.Context, lc LocalClient) string {
+	if !stateEnabled() {
+		return ""
+	}
+	_, all, err := lc.ProfileStatus(ctx)
+	if err != nil {
+		return ""
+	}
+	st, err := loadState()
+	if err != nil {
+		return ""
+	}
+	for _, p := range all {
+		a := st.Profiles[p.ID]
+		if p.ID == currentProfile.ID || a == nil || a.MullvadNodes == 0 {
+			continue
+		}
+		return fmt.Sprintf("login profile %s had %d Mullvad exit nodes on %s, switch with --profile %s",
+			profileLabel(p), a.MullvadNodes, a.Time.Format(time.DateOnly), p.ID)
+	}
+	return ""
+}
+
+// runProfiles lists the login profiles. Mullvad access is measured for the
+// active profile and remembered from earlier runs for the others.
+func runProfiles(ctx context.Context, lc LocalClient, args []string) error {
+	if err := requireTailscale(ctx, lc, featureProfiles); err != nil {
+		return err
+	}
+	current, all, err := lc.ProfileStatus(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get login profiles: %w", err)
+	}
+	if len(all) == 0 {
+		return errors.New("no Tailscale login profiles found")
+	}
+
+	// Refreshes the active profile's entry; on error it stays as it was.
+	// A profile without Mullvad is recorded too, so that isn't an error here.
+	if _, err := getMullvadNodes(ctx, lc); err != nil && !errors.Is(err, errMullvadMissing) {
+		fmt.Fprintf(os.Stderr, "Warning: Mullvad access of the active profile not refreshed: %v\n", err)
+	}
+	var st *state
+	if stateEnabled() {
+		st, _ = loadState()
+	}
+
+	t := newTable("", "ID", "PROFILE", "MULLVAD")
+	for _, p := range all {
+		marker := ""
+		if p.ID == current.ID {
+			marker = "*"
+		}
+		access := "unknown"
+		if st != nil && st.Profiles[p.ID] != nil {
+			a := st.Profiles[p.ID]
+			access = "no"
+			if a.MullvadNodes > 0 {
+				access = fmt.Sprintf("%d nodes", a.MullvadNodes)
+			}
+			if p.ID != current.ID {
+				access += fmt.Sprintf(" (%s)", a.Time.Format(time.DateOnly))
+			}
+		}
+		t.add(marker, string(p.ID), profileLabel(p), access)
+	}
+	t.print(terminalWidth())
+	fmt.Println("\n* active profile. Mullvad access of the others is as of the last run against them")
+	return nil
+}
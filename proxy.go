@@ -0,0 +1,52 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+var proxyFlag = flag.String("proxy", "", "Proxy URL (http, https or socks5) for external calls: verification, Mullvad API, captive portal probe, webhooks and ntfy; default from PROTECT_WAN_PROXY, else HTTPS_PROXY/HTTP_PROXY/NO_PROXY, direct to ignore those")
+
+// proxy picks the proxy of a request, nil for direct connections
+var proxy = http.ProxyFromEnvironment
+
+// parseProxy returns the proxy selection of a --proxy value
+func parseProxy(value string) (func(*http.Request) (*url.URL, error), error) {
+	switch value {
+	case "":
+		return http.ProxyFromEnvironment, nil
+	case "direct":
+		return nil, nil
+	}
+	u, err := url.Parse(value)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("%q is not a proxy URL (e.g. http://proxy.example.com:3128)", value)
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return nil, fmt.Errorf("unsupported proxy scheme %q (expected http, https or socks5)", u.Scheme)
+	}
+	return http.ProxyURL(u), nil
+}
+
+// newTransport returns a fresh HTTP transport that uses the proxy
+func newTransport() *http.Transport {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.Proxy = proxy
+	return t
+}
+
+// configureProxy applies --proxy to the clients of all external calls
+func configureProxy() error {
+	p, err := parseProxy(flagOrEnv(*proxyFlag, "PROTECT_WAN_PROXY"))
+	if err != nil {
+		return err
+	}
+	proxy = p
+	httpClient.Transport = newTransport()
+	captiveClient.Transport = newTransport()
+	return nil
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Mullvad's connection check endpoints. The ipv4/ipv6 variants only resolve
+// to a single address family, so they reveal which path each family takes.
+const (
+	mullvadCheckURL   = "https://am.i.mullvad.net/json"
+	mullvadCheckURLv4 = "https://ipv4.am.i.mullvad.net/json"
+	mullvadCheckURLv6 = "https://ipv6.am.i.mullvad.net/json"
+)
+
+// httpClient is used for all external verification calls
+var httpClient = &http.Client{Timeout: 10 * time.Second}
+
+// PublicIPInfo is the response from Mullvad's connection check API
+type PublicIPInfo struct {
+	IP                    string  `json:"ip"`
+	Country               string  `json:"country"`
+	City                  string  `json:"city"`
+	Latitude              float64 `json:"latitude"`
+	Longitude             float64 `json:"longitude"`
+	Organization          string  `json:"organization"`
+	MullvadExitIP         bool    `json:"mullvad_exit_ip"`
+	MullvadExitIPHostname string  `json:"mullvad_exit_ip_hostname"`
+}
+
+// fetchPublicIP queries a Mullvad connection check endpoint
+func fetchPublicIP(ctx context.Context, url string) (*PublicIPInfo, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("public IP check failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("public IP check failed: %s", resp.Status)
+	}
+
+	var info PublicIPInfo
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return nil, fmt.Errorf("failed to decode public IP response: %w", err)
+	}
+
+	return &info, nil
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"time"
+
+	"tailscale.com/tailcfg"
+)
+
+var (
+	quarantineAfterFlag   = flag.Int("quarantine-after", 3, "Quarantine a node after this many consecutive runs where it failed pings or verification (0 to disable)")
+	quarantineBackoffFlag = flag.Duration("quarantine-backoff", time.Hour, "How long a node is first quarantined; doubles each time it is quarantined again")
+)
+
+// maxQuarantine caps the quarantine backoff
+const maxQuarantine = 24 * time.Hour
+
+// nodeHealth tracks the failures of one node across runs
+type nodeHealth struct {
+	Name      string    `json:"name"`
+	Failures  int       `json:"failures"`          // Consecutive failed runs
+	Strikes   int       `json:"strikes,omitempty"` // Quarantines since the node last worked
+	Until     time.Time `json:"until,omitzero"`    // End of the quarantine
+	LastError string    `json:"last_error,omitempty"`
+}
+
+// quarantined reports whether the node is quarantined at t
+func (h *nodeHealth) quarantined(t time.Time) bool {
+	return h != nil && t.Before(h.Until)
+}
+
+// recordHealth updates the failure counts from the outcome of each node
+// probed or verified in a run (nil for success), quarantining nodes that
+// failed --quarantine-after runs in a row
+func recordHealth(outcomes map[tailcfg.StableNodeID]nodeOutcome) {
+	if *quarantineAfterFlag <= 0 || !stateEnabled() || len(outcomes) == 0 {
+		return
+	}
+
+	now := time.Now()
+	err := updateState(func(st *state) {
+		if st.Health == nil {
+			st.Health = make(map[tailcfg.StableNodeID]*nodeHealth)
+		}
+		for id, o := range outcomes {
+			h := st.Health[id]
+			if o.Err == nil {
+				delete(st.Health, id)
+				continue
+			}
+			if h == nil {
+				h = &nodeHealth{}
+				st.Health[id] = h
+			}
+			h.Name = displayName(o.Node)
+			h.Failures++
+			h.LastError = o.Err.Error()
+			if h.Failures >= *quarantineAfterFlag {
+				backoff := min(*quarantineBackoffFlag<<h.Strikes, maxQuarantine)
+				h.Until = now.Add(backoff)
+				h.Strikes++
+				h.Failures = 0
+				log.Printf("Quarantined %s for %s after %d failed runs: %s", h.Name, backoff, *quarantineAfterFlag, h.LastError)
+			}
+		}
+	})
+	if err != nil {
+		log.Printf("Warning: failed to update state: %v", err)
+	}
+}
+
+// nodeOutcome is whether a node worked in a run
+type nodeOutcome struct {
+	Node MullvadNode
+	Err  error
+}
+
+// loadHealth returns the recorded node health, nil without state
+func loadHealth() map[tailcfg.StableNodeID]*nodeHealth {
+	if !stateEnabled() {
+		return nil
+	}
+	st, err := loadState()
+	if err != nil {
+		return nil
+	}
+	return st.Health
+}
+
+// filterQuarantined drops quarantined nodes. If that would leave none, the
+// quarantine is ignored rather than leaving the WAN unprotected.
+func filterQuarantined(nodes []MullvadNode) []MullvadNode {
+	if *quarantineAfterFlag <= 0 {
+		return nodes
+	}
+	health := loadHealth()
+	now := time.Now()
+
+	filtered := make([]MullvadNode, 0, len(nodes))
+	var dropped []MullvadNode
+	for _, node := range nodes {
+		if h := health[node.ID]; h.quarantined(now) {
+			dropped = append(dropped, node)
+			continue
+		}
+		filtered = append(filtered, node)
+	}
+	if len(dropped) == 0 {
+		return nodes
+	}
+	if len(filtered) == 0 {
+		fmt.Println("Warning: every candidate is quarantined, ignoring the quarantine")
+		explain.note("Every candidate is quarantined, quarantine ignored")
+		return nodes
+	}
+
+	for _, node := range dropped {
+		h := health[node.ID]
+		explain.eliminate(fmt.Sprintf("quarantined until %s (%s)", h.Until.Format(time.DateTime), h.LastError), node)
+	}
+	explain.filter("not quarantined", len(nodes), len(filtered))
+	return filtered
+}
+
+// verifyOutcome records a post-switch verification. A failed trial counts
+// as a failure of the node unless the node merely was slower, lacked IPv6
+// or was blocked by a service; other errors are inconclusive.
+func verifyOutcome(node MullvadNode, err error) {
+	if err != nil && (!errors.Is(err, errTrialFailed) || errors.Is(err, errTrialSlower) || errors.Is(err, errNoIPv6) ||
+		errors.Is(err, errServiceBlocked)) {
+		return
+	}
+	recordHealth(map[tailcfg.StableNodeID]nodeOutcome{node.ID: {Node: node, Err: err}})
+}
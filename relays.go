@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// mullvadRelaysURL is Mullvad's public relay list, as used by its apps
+const mullvadRelaysURL = "https://api.mullvad.net/app/v1/relays"
+
+// relayLocation is a city in the Mullvad relay list
+type relayLocation struct {
+	Country   string  `json:"country"`
+	City      string  `json:"city"`
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+}
+
+// relay is a WireGuard server in the Mullvad relay list
+type relay struct {
+	Hostname string `json:"hostname"` // e.g. ch-zrh-wg-001
+	Location string `json:"location"` // Key into the locations, e.g. ch-zrh
+	Active   bool   `json:"active"`
+	Owned    bool   `json:"owned"`
+	Provider string `json:"provider"`
+}
+
+// relayList is the subset of the relay list protect-wan uses
+type relayList struct {
+	Locations map[string]relayLocation `json:"locations"`
+	WireGuard struct {
+		Relays []relay `json:"relays"`
+	} `json:"wireguard"`
+}
+
+// fetchRelays downloads the Mullvad relay list
+func fetchRelays(ctx context.Context) (*relayList, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, mullvadRelaysURL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch Mullvad relay list: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch Mullvad relay list: %s", resp.Status)
+	}
+
+	var list relayList
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return nil, fmt.Errorf("failed to decode Mullvad relay list: %w", err)
+	}
+	return &list, nil
+}
+
+// relayFor returns the relay list entry of a node, if any
+func (l *relayList) relayFor(node MullvadNode) (relay, bool) {
+	name := mullvadHostname(node)
+	for _, r := range l.WireGuard.Relays {
+		if strings.EqualFold(r.Hostname, name) {
+			return r, true
+		}
+	}
+	return relay{}, false
+}
+
+// locationFor returns the city of a node: that of its relay, or else the
+// one matching its country and city code
+func (l *relayList) locationFor(node MullvadNode) (relayLocation, bool) {
+	key := strings.ToLower(node.CountryCode + "-" + node.CityCode)
+	if r, ok := l.relayFor(node); ok {
+		key = r.Location
+	}
+	loc, ok := l.Locations[key]
+	return loc, ok
+}
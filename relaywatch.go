@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"time"
+
+	"tailscale.com/tailcfg"
+)
+
+var relayGraceFlag = flag.Duration("relay-grace", 0, "Daemon: when the exit node has been reached through a DERP or peer relay for this long, try to get a direct path back and otherwise select another exit node (e.g. 5m, 0 to disable)")
+
+// relayWatch tracks the path to the active exit node for the daemon, so a
+// fallback to a relay doesn't go unnoticed. Relayed exit traffic has much
+// lower throughput, but the exit node still works, so nothing else would
+// switch away from it.
+type relayWatch struct {
+	node       tailcfg.StableNodeID // Node a direct path was last retried to
+	path       string               // Relayed path last seen
+	since      time.Time            // Start of the relayed period, zero while direct
+	reselected bool                 // Selection already re-run in this period
+	alerted    bool
+}
+
+type preferDirectKey struct{}
+
+// withPreferDirect makes the selection under ctx rank candidates reached
+// over a direct path first, as with --prefer-direct
+func withPreferDirect(ctx context.Context) context.Context {
+	return context.WithValue(ctx, preferDirectKey{}, true)
+}
+
+// check looks at the path to the active exit node and reports whether the
+// daemon should select another one. Once the node has been relayed for
+// --relay-grace, it is pinged for up to directWait to let NAT traversal
+// find a direct path again; if that fails, selection is re-run once,
+// unless keep is set. A relay that persists after that is notified, since
+// nothing more can be done about it.
+func (w *relayWatch) check(ctx context.Context, lc LocalClient, keep bool) bool {
+	if *relayGraceFlag <= 0 {
+		return false
+	}
+	node, ok, err := currentExitNode(ctx, lc)
+	if err != nil || !ok {
+		return false
+	}
+	paths, err := peerPaths(ctx, lc)
+	if err != nil {
+		return false
+	}
+	node.Path = paths[node.ID]
+
+	if !relayedPath(node.Path) {
+		if w.alerted {
+			msg := fmt.Sprintf("Exit node %s is reached over a direct path again", displayName(node))
+			log.Print(msg)
+			notifyEvent(ctx, "exit-direct", msg)
+		} else if !w.since.IsZero() && node.Path == pathDirect {
+			log.Printf("Exit node %s is reached over a direct path again", displayName(node))
+		}
+		*w = relayWatch{}
+		return false
+	}
+
+	if w.since.IsZero() || w.path != node.Path {
+		if w.since.IsZero() {
+			w.since = time.Now()
+		}
+		w.path = node.Path
+		log.Printf("Exit node %s is relayed through %s, throughput suffers", displayName(node), pathLabel(node.Path))
+	}
+	relayedFor := time.Since(w.since).Round(time.Second)
+	if relayedFor < *relayGraceFlag {
+		return false
+	}
+
+	if w.node != node.ID {
+		w.node = node.ID
+		if *verboseFlag {
+			log.Printf("Waiting up to %s for a direct path to %s", directWait, displayName(node))
+		}
+		nodes := []MullvadNode{node}
+		waitForDirect(ctx, lc, nodes, []int{0})
+		if nodes[0].Path == pathDirect {
+			log.Printf("Direct path to exit node %s re-established after %s relayed", displayName(node), relayedFor)
+			*w = relayWatch{}
+			return false
+		}
+	}
+
+	if !w.reselected && !keep {
+		w.reselected = true
+		log.Printf("Exit node %s relayed through %s for %s, selecting another", displayName(node), pathLabel(node.Path), relayedFor)
+		return true
+	}
+
+	if !w.alerted {
+		w.alerted = true
+		msg := fmt.Sprintf("Exit node %s still relayed through %s after %s, exit throughput is limited", displayName(node), pathLabel(node.Path), relayedFor)
+		log.Print(msg)
+		notifyFailure(ctx, "exit-relayed", msg)
+	}
+	return false
+}
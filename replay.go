@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"tailscale.com/ipn/ipnstate"
+)
+
+var (
+	replayFlag        = flag.String("replay", "", "Run the selection offline against a recorded `tailscale status --json` file")
+	replayLatencyFlag = flag.String("replay-latency", "", "Latency fixtures for --replay: JSON object of hostname or Tailscale IP to milliseconds")
+)
+
+// loadReplay builds a fake tailscaled from a recorded status and optional
+// latency fixtures. Nodes without a fixture don't answer pings.
+func loadReplay(statusPath, latencyPath string) (*fakeClient, error) {
+	data, err := os.ReadFile(statusPath)
+	if err != nil {
+		return nil, err
+	}
+	var status ipnstate.Status
+	if err := json.Unmarshal(data, &status); err != nil {
+		return nil, fmt.Errorf("failed to parse status %s: %w", statusPath, err)
+	}
+
+	// The recorded host's routes and public IP are not ours to inspect
+	status.TUN = false
+
+	fake := newFakeClient(&status)
+	if status.ExitNodeStatus != nil {
+		fake.prefs.ExitNodeID = status.ExitNodeStatus.ID
+	}
+
+	if latencyPath == "" {
+		return fake, nil
+	}
+
+	data, err = os.ReadFile(latencyPath)
+	if err != nil {
+		return nil, err
+	}
+	var fixtures map[string]float64
+	if err := json.Unmarshal(data, &fixtures); err != nil {
+		return nil, fmt.Errorf("failed to parse latency fixtures %s: %w", latencyPath, err)
+	}
+
+	matched := make(map[string]bool)
+	for _, peer := range status.Peer {
+		name := strings.TrimSuffix(peer.DNSName, ".")
+		short, _, _ := strings.Cut(name, ".")
+		for _, ip := range peer.TailscaleIPs {
+			for _, k := range []string{name, short, ip.String()} {
+				if ms, ok := fixtures[k]; ok {
+					fake.setLatency(ip, time.Duration(ms*float64(time.Millisecond)))
+					matched[k] = true
+				}
+			}
+		}
+	}
+	for k := range fixtures {
+		if !matched[k] {
+			fmt.Fprintf(os.Stderr, "Warning: latency fixture %q matches no peer\n", k)
+		}
+	}
+
+	return fake, nil
+}
+
+// offlineCommand maps the requested command onto what runs without
+// tailscaled (--replay, --snapshot): the read-only best and list.
+// Without a command it runs best.
+func offlineCommand(name, mode string) (string, error) {
+	switch name {
+	case "best", "list":
+		return name, nil
+	case "":
+		if *listFlag {
+			return "", nil
+		}
+		if *checkFlag || *autoFlag || *disableFlag || *setFlag != "" || *portalBypassFlag > 0 || *daemonFlag {
+			return "", fmt.Errorf("%s only supports the best and list commands", mode)
+		}
+		return "best", nil
+	}
+	return "", fmt.Errorf("%s does not support the %s command, only best and list", mode, name)
+}
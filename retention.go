@@ -0,0 +1,151 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+)
+
+var retentionFlag stringList
+
+func init() {
+	flag.Var(&retentionFlag, "retention", "How long the state file keeps history, usage and latency data, as an age for all or kind=age, e.g. 90d or latency=30d; 0 keeps it forever (repeatable, default 90d)")
+}
+
+// Kinds of data --retention applies to
+const (
+	retainHistory = "history" // Protection history per day (stats)
+	retainUsage   = "usage"   // Data per exit node and day (stats)
+	retainLatency = "latency" // Latency samples per day and cached latencies
+)
+
+// defaultRetention is how long data is kept without a --retention
+const defaultRetention = 90 * 24 * time.Hour
+
+// pruneInterval is how often state updates prune data past its retention
+const pruneInterval = 24 * time.Hour
+
+// parseRetention parses the --retention rules by kind of data. A rule
+// without a kind applies to all kinds; later rules override earlier ones.
+func parseRetention(rules []string) (map[string]time.Duration, error) {
+	retention := map[string]time.Duration{
+		retainHistory: defaultRetention,
+		retainUsage:   defaultRetention,
+		retainLatency: defaultRetention,
+	}
+	for _, rule := range rules {
+		kind, age, ok := strings.Cut(rule, "=")
+		if !ok {
+			kind, age = "", rule
+		}
+		kind = strings.TrimSpace(kind)
+		if _, known := retention[kind]; ok && !known {
+			return nil, fmt.Errorf("invalid retention %q (expected history, usage or latency)", rule)
+		}
+		d, err := parseAge(strings.TrimSpace(age))
+		if err != nil {
+			return nil, fmt.Errorf("invalid retention %q: %w", rule, err)
+		}
+		if ok {
+			retention[kind] = d
+			continue
+		}
+		for k := range retention {
+			retention[k] = d
+		}
+	}
+	return retention, nil
+}
+
+// pruneResult counts what a prune dropped
+type pruneResult struct {
+	Days           int // Days of protection history
+	UsageRecords   int // Usage records whose data was dropped
+	LatencySamples int
+	Networks       int // Latency caches of networks not seen since
+}
+
+// prune drops the data older than its retention at now. A retention of 0
+// keeps data forever. Usage records are removed once neither their data
+// nor their latency samples are kept.
+func (st *state) prune(now time.Time, retention map[string]time.Duration) pruneResult {
+	var res pruneResult
+	cutoff := func(kind string) string {
+		if d := retention[kind]; d > 0 {
+			return now.Add(-d).Format(time.DateOnly)
+		}
+		return ""
+	}
+
+	if first := cutoff(retainHistory); first != "" {
+		kept := st.Days[:0]
+		for _, d := range st.Days {
+			if d.Day >= first {
+				kept = append(kept, d)
+			}
+		}
+		res.Days = len(st.Days) - len(kept)
+		st.Days = kept
+	}
+
+	usageFirst, latencyFirst := cutoff(retainUsage), cutoff(retainLatency)
+	kept := st.Usage[:0]
+	for _, u := range st.Usage {
+		if usageFirst != "" && u.Day < usageFirst && u.RxBytes+u.TxBytes > 0 {
+			u.RxBytes, u.TxBytes = 0, 0
+			res.UsageRecords++
+		}
+		if latencyFirst != "" && u.Day < latencyFirst && u.LatencySamples > 0 {
+			res.LatencySamples += u.LatencySamples
+			u.LatencySamples, u.LatencySum, u.LatencyMin, u.LatencyMax = 0, 0, 0, 0
+		}
+		if u.RxBytes+u.TxBytes > 0 || u.LatencySamples > 0 {
+			kept = append(kept, u)
+		}
+	}
+	st.Usage = kept
+
+	if d := retention[retainLatency]; d > 0 {
+		for fp, nl := range st.LatencyCache {
+			if now.Sub(nl.Seen) > d {
+				delete(st.LatencyCache, fp)
+				res.Networks++
+			}
+		}
+	}
+	st.Pruned = now
+	return res
+}
+
+// pruneDue prunes st if it wasn't pruned within pruneInterval, so state
+// files of long-running installs don't grow without bound
+func pruneDue(st *state) {
+	now := time.Now()
+	if now.Sub(st.Pruned) < pruneInterval {
+		return
+	}
+	if retention, err := parseRetention(retentionFlag); err == nil {
+		st.prune(now, retention)
+	}
+}
+
+// statePrune prunes the state file right away for state prune
+func statePrune() error {
+	retention, err := parseRetention(retentionFlag)
+	if err != nil {
+		return err
+	}
+	var res pruneResult
+	err = updateState(func(st *state) {
+		res = st.prune(time.Now(), retention)
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Pruned %s:\n", statePath())
+	fmt.Printf("  Protection history: %d days\n", res.Days)
+	fmt.Printf("  Usage: data of %d records\n", res.UsageRecords)
+	fmt.Printf("  Latency: %d samples, cache of %d networks\n", res.LatencySamples, res.Networks)
+	return nil
+}
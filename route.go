@@ -0,0 +1,93 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+
+	"tailscale.com/ipn/ipnstate"
+)
+
+// routeProbeV4 is the destination used to ask the OS which interface
+// carries default-route traffic. Any public address works; nothing is sent.
+var routeProbeV4 = netip.MustParseAddr("1.1.1.1")
+
+// errUnsupportedPlatform is returned by OS inspection helpers (routes,
+// gateways, DNS) on platforms where they are not implemented.
+var errUnsupportedPlatform = errors.New("not supported on this platform")
+
+// routeLeakError reports that the OS routes traffic outside of Tailscale
+// even though an exit node is configured.
+type routeLeakError struct {
+	Dest      netip.Addr
+	Interface string
+	Tailscale string
+}
+
+func (e *routeLeakError) Error() string {
+	return fmt.Sprintf("exit node is set but traffic to %s is routed via %s, not the Tailscale interface %s",
+		e.Dest, e.Interface, e.Tailscale)
+}
+
+// verifyDefaultRoute checks that the host routing table sends default-route
+// traffic through the Tailscale interface.
+// Returns a *routeLeakError if the OS disagrees with the exit node prefs.
+func verifyDefaultRoute(status *ipnstate.Status) error {
+	if !status.TUN {
+		return errors.New("tailscaled is using userspace networking, route cannot be verified")
+	}
+
+	tsIface, err := tailscaleInterface(status.TailscaleIPs)
+	if err != nil {
+		return err
+	}
+
+	iface, err := routeInterface(routeProbeV4)
+	if err != nil {
+		return err
+	}
+
+	if *verboseFlag {
+		fmt.Printf("Route to %s uses interface %s (Tailscale interface: %s)\n", routeProbeV4, iface, tsIface)
+	}
+
+	if iface != tsIface {
+		return &routeLeakError{Dest: routeProbeV4, Interface: iface, Tailscale: tsIface}
+	}
+
+	return nil
+}
+
+// tailscaleInterface finds the name of the local interface holding one of
+// the given Tailscale IPs
+func tailscaleInterface(ips []netip.Addr) (string, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return "", fmt.Errorf("failed to list interfaces: %w", err)
+	}
+
+	for _, iface := range ifaces {
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			ipNet, ok := addr.(*net.IPNet)
+			if !ok {
+				continue
+			}
+			ip, ok := netip.AddrFromSlice(ipNet.IP)
+			if !ok {
+				continue
+			}
+			for _, tsIP := range ips {
+				if ip.Unmap() == tsIP {
+					return iface.Name, nil
+				}
+			}
+		}
+	}
+
+	return "", errors.New("no local interface holds a Tailscale IP")
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"fmt"
+	"net/netip"
+	"os/exec"
+	"strings"
+)
+
+// routeInterface returns the interface the kernel would use to reach dst,
+// as reported by `route -n get`
+func routeInterface(dst netip.Addr) (string, error) {
+	args := []string{"-n", "get"}
+	if dst.Is6() {
+		args = append(args, "-inet6")
+	}
+	args = append(args, dst.String())
+
+	out, err := exec.Command("route", args...).Output()
+	if err != nil {
+		return "", fmt.Errorf("route get %s: %w", dst, err)
+	}
+
+	for _, line := range strings.Split(string(out), "\n") {
+		line = strings.TrimSpace(line)
+		if name, ok := strings.CutPrefix(line, "interface:"); ok {
+			return strings.TrimSpace(name), nil
+		}
+	}
+
+	return "", fmt.Errorf("no interface in route for %s", dst)
+}
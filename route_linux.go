@@ -0,0 +1,32 @@
+package main
+
+import (
+	"fmt"
+	"net/netip"
+	"os/exec"
+	"strings"
+)
+
+// routeInterface returns the interface the kernel would use to reach dst.
+// Uses `ip route get`, which follows policy routing (Tailscale installs its
+// exit node routes in table 52, not the main table).
+func routeInterface(dst netip.Addr) (string, error) {
+	args := []string{"route", "get", dst.String()}
+	if dst.Is6() {
+		args = append([]string{"-6"}, args...)
+	}
+
+	out, err := exec.Command("ip", args...).Output()
+	if err != nil {
+		return "", fmt.Errorf("ip route get %s: %w", dst, err)
+	}
+
+	fields := strings.Fields(string(out))
+	for i := 0; i < len(fields)-1; i++ {
+		if fields[i] == "dev" {
+			return fields[i+1], nil
+		}
+	}
+
+	return "", fmt.Errorf("no device in route for %s: %q", dst, strings.TrimSpace(string(out)))
+}
@@ -0,0 +1,10 @@
+//go:build !linux && !darwin && !windows
+
+package main
+
+import "net/netip"
+
+// routeInterface is not implemented on this platform
+func routeInterface(dst netip.Addr) (string, error) {
+	return "", errUnsupportedPlatform
+}
@@ -0,0 +1,22 @@
+package main
+
+import (
+	"fmt"
+	"net/netip"
+)
+
+// routeInterface returns the alias of the interface Windows would use to
+// reach dst, as reported by Find-NetRoute
+func routeInterface(dst netip.Addr) (string, error) {
+	script := fmt.Sprintf("Find-NetRoute -RemoteIPAddress %s | Select-Object -First 1 -ExpandProperty InterfaceAlias", dst)
+
+	name, err := powershell(script)
+	if err != nil {
+		return "", fmt.Errorf("Find-NetRoute %s: %w", dst, err)
+	}
+	if name == "" {
+		return "", fmt.Errorf("no interface in route for %s", dst)
+	}
+
+	return name, nil
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/netip"
+	"strings"
+	"time"
+)
+
+var probeRouteFlag = flag.Bool("probe-route", false, "With --check, send active probes to confirm traffic really leaves via the exit node")
+
+// firstHopTimeout bounds the wait for the TTL-limited probe's ICMP reply
+const firstHopTimeout = 2 * time.Second
+
+// probeLeakError reports that an active probe left outside the exit node
+type probeLeakError struct {
+	Probe  string
+	Reason string
+}
+
+func (e *probeLeakError) Error() string {
+	return fmt.Sprintf("%s probe: traffic does not leave via the exit node: %s", e.Probe, e.Reason)
+}
+
+// probeRoute confirms with real packets what verifyDefaultRoute reads from
+// the routing table:
+//   - a TTL-limited probe (tracepath-style) toward routeProbeV4 must not be
+//     answered by the LAN gateway, which would be the first hop of a leak
+//   - the public egress address must be a Mullvad exit, and if the exit
+//     node's Mullvad server name is known, that very server
+//
+// Returns a *probeLeakError on a leak. Inconclusive probes (no ICMP reply,
+// unsupported platform) are reported in verbose mode only.
+func probeRoute(ctx context.Context, lc LocalClient) error {
+	hop, err := firstHop(routeProbeV4, firstHopTimeout)
+	switch {
+	case err != nil:
+		if *verboseFlag {
+			fmt.Printf("First hop probe inconclusive: %v\n", err)
+		}
+	default:
+		if *verboseFlag {
+			fmt.Printf("First hop toward %s: %s\n", routeProbeV4, hop)
+		}
+		if onLAN(hop) {
+			return &probeLeakError{Probe: "TTL=1", Reason: fmt.Sprintf("first hop %s is on the local network", hop)}
+		}
+	}
+
+	info, err := fetchPublicIP(ctx, mullvadCheckURLv4)
+	if err != nil {
+		if *verboseFlag {
+			fmt.Printf("Egress probe inconclusive: %v\n", err)
+		}
+		return nil
+	}
+	if *verboseFlag {
+		fmt.Printf("Egress: %s (%s, Mullvad server: %s)\n", info.IP, info.Organization, info.MullvadExitIPHostname)
+	}
+	if !info.MullvadExitIP {
+		return &probeLeakError{Probe: "egress", Reason: fmt.Sprintf("public IP %s (%s) is not a Mullvad exit", info.IP, info.Organization)}
+	}
+
+	if name := exitNodeMullvadName(ctx, lc); name != "" && info.MullvadExitIPHostname != "" &&
+		!strings.EqualFold(name, info.MullvadExitIPHostname) {
+		return &probeLeakError{Probe: "egress", Reason: fmt.Sprintf("traffic exits via Mullvad server %s, not the exit node %s", info.MullvadExitIPHostname, name)}
+	}
+
+	return nil
+}
+
+// onLAN reports whether addr is the default gateway or in a local subnet
+func onLAN(addr netip.Addr) bool {
+	id := currentNetwork()
+	if id.Gateway.IsValid() && id.Gateway == addr {
+		return true
+	}
+	for _, prefix := range id.Addrs {
+		if prefix.Masked().Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// exitNodeMullvadName returns the Mullvad server name of the active exit
+// node, or "" if it isn't a Mullvad node
+func exitNodeMullvadName(ctx context.Context, lc LocalClient) string {
+	node, ok, err := currentExitNode(ctx, lc)
+	if err != nil || !ok || !strings.HasSuffix(node.DNSName, "."+mullvadDomain+".") {
+		return ""
+	}
+	return mullvadHostname(node)
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"syscall"
+	"time"
+)
+
+// soEEOriginICMP is SO_EE_ORIGIN_ICMP from linux/errqueue.h
+const soEEOriginICMP = 2
+
+// firstHop sends a UDP datagram with TTL 1 toward dst and returns the
+// address of the router that answers with ICMP time exceeded. Like
+// tracepath, it reads the reply from the socket error queue (IP_RECVERR),
+// which needs no privileges.
+func firstHop(dst netip.Addr, timeout time.Duration) (netip.Addr, error) {
+	fd, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_DGRAM, 0)
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	defer syscall.Close(fd)
+
+	if err := syscall.SetsockoptInt(fd, syscall.IPPROTO_IP, syscall.IP_TTL, 1); err != nil {
+		return netip.Addr{}, err
+	}
+	if err := syscall.SetsockoptInt(fd, syscall.IPPROTO_IP, syscall.IP_RECVERR, 1); err != nil {
+		return netip.Addr{}, err
+	}
+
+	// 33434 is the traditional traceroute port
+	sa := &syscall.SockaddrInet4{Port: 33434, Addr: dst.As4()}
+	if err := syscall.Sendto(fd, []byte("protect-wan"), 0, sa); err != nil {
+		return netip.Addr{}, fmt.Errorf("failed to send probe: %w", err)
+	}
+
+	buf := make([]byte, 512)
+	oob := make([]byte, 512)
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		_, oobn, _, _, err := syscall.Recvmsg(fd, buf, oob, syscall.MSG_ERRQUEUE|syscall.MSG_DONTWAIT)
+		if errors.Is(err, syscall.EAGAIN) {
+			time.Sleep(50 * time.Millisecond)
+			continue
+		}
+		if err != nil {
+			return netip.Addr{}, err
+		}
+
+		msgs, err := syscall.ParseSocketControlMessage(oob[:oobn])
+		if err != nil {
+			return netip.Addr{}, err
+		}
+		for _, m := range msgs {
+			if m.Header.Level != syscall.SOL_IP || m.Header.Type != syscall.IP_RECVERR {
+				continue
+			}
+			// struct sock_extended_err (16 bytes), then the offender's
+			// struct sockaddr_in: family, port, 4 address bytes
+			if len(m.Data) < 24 || m.Data[4] != soEEOriginICMP {
+				continue
+			}
+			return netip.AddrFrom4([4]byte(m.Data[20:24])), nil
+		}
+	}
+
+	return netip.Addr{}, errors.New("no ICMP reply to the TTL-limited probe")
+}
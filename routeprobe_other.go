@@ -0,0 +1,14 @@
+//go:build !linux
+
+package main
+
+import (
+	"net/netip"
+	"time"
+)
+
+// firstHop needs the Linux socket error queue to read ICMP replies
+// without privileges
+func firstHop(dst netip.Addr, timeout time.Duration) (netip.Addr, error) {
+	return netip.Addr{}, errUnsupportedPlatform
+}
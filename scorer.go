@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"time"
+)
+
+var (
+	scorerFlag        = flag.String("scorer", "", "Command for --strategy exec: reads candidates as JSON on stdin, writes scores or an order as JSON")
+	scorerLatencyFlag = flag.Bool("scorer-latency", true, "With --strategy exec, measure latency first and pass only the measured candidates")
+)
+
+// scorerTimeout bounds a run of the --scorer command
+const scorerTimeout = 30 * time.Second
+
+// scorerCandidate is one node as the scorer sees it
+type scorerCandidate struct {
+	ID          string   `json:"id"`
+	Name        string   `json:"name"`         // Tailscale DNS name, no trailing dot
+	MullvadName string   `json:"mullvad_name"` // e.g. ch-zrh-wg-001
+	Country     string   `json:"country"`
+	CountryCode string   `json:"country_code"`
+	City        string   `json:"city"`
+	CityCode    string   `json:"city_code"`
+	Priority    int      `json:"priority"`
+	LatencyMs   float64  `json:"latency_ms,omitempty"`
+	IPs         []string `json:"tailscale_ips"`
+}
+
+// scorerInput is written to the scorer's stdin
+type scorerInput struct {
+	Candidates []scorerCandidate `json:"candidates"`
+}
+
+// scorerOutput is read from the scorer's stdout. Either scores (higher is
+// better) or an explicit order, keyed by id, name or Mullvad name. Nodes
+// the scorer leaves out are excluded.
+type scorerOutput struct {
+	Scores map[string]float64 `json:"scores"`
+	Order  []string           `json:"order"`
+}
+
+// rankByScorer hands the candidates to the --scorer command and ranks them
+// by its answer, so custom policies (e.g. an internal geo-blocking
+// database) need no fork
+func rankByScorer(ctx context.Context, lc LocalClient, nodes []MullvadNode) ([]MullvadNode, error) {
+	if *scorerLatencyFlag {
+		if !*verboseFlag {
+			fmt.Println("Testing latency to find the fastest node...")
+		}
+		measured, err := selectByLatency(ctx, lc, nodes)
+		if err != nil {
+			return nil, err
+		}
+		nodes = measured
+	}
+
+	out, err := runScorer(ctx, nodes)
+	if err != nil {
+		return nil, err
+	}
+
+	// Index every name a scorer may use for a node
+	byKey := make(map[string]int)
+	for i, node := range nodes {
+		byKey[string(node.ID)] = i
+		byKey[strings.TrimSuffix(node.DNSName, ".")] = i
+		byKey[mullvadHostname(node)] = i
+	}
+
+	var ranked []MullvadNode
+	switch {
+	case len(out.Order) > 0:
+		seen := make(map[int]bool)
+		for _, key := range out.Order {
+			if i, ok := byKey[key]; ok && !seen[i] {
+				seen[i] = true
+				ranked = append(ranked, nodes[i])
+			}
+		}
+	case len(out.Scores) > 0:
+		scores := make(map[int]float64)
+		for key, score := range out.Scores {
+			if i, ok := byKey[key]; ok {
+				scores[i] = score
+			}
+		}
+		idx := make([]int, 0, len(scores))
+		for i := range scores {
+			idx = append(idx, i)
+		}
+		// Ties keep the input (latency) order
+		sort.Ints(idx)
+		sort.SliceStable(idx, func(a, b int) bool { return scores[idx[a]] > scores[idx[b]] })
+		for _, i := range idx {
+			ranked = append(ranked, nodes[i])
+		}
+	}
+
+	if len(ranked) == 0 {
+		return nil, errors.New("scorer kept no candidate")
+	}
+	explain.note("Ranked by --scorer %s: %d of %d candidates kept", *scorerFlag, len(ranked), len(nodes))
+	for _, node := range nodes {
+		if nodeIndex(ranked, node.ID) < 0 {
+			explain.eliminate("excluded by scorer", node)
+		}
+	}
+	return ranked, nil
+}
+
+// runScorer runs the --scorer command with the candidates on stdin. Its
+// stderr is passed through for debugging.
+func runScorer(ctx context.Context, nodes []MullvadNode) (*scorerOutput, error) {
+	in := scorerInput{Candidates: make([]scorerCandidate, 0, len(nodes))}
+	for _, node := range nodes {
+		c := scorerCandidate{
+			ID:          string(node.ID),
+			Name:        strings.TrimSuffix(node.DNSName, "."),
+			MullvadName: mullvadHostname(node),
+			Country:     node.Country,
+			CountryCode: node.CountryCode,
+			City:        node.City,
+			CityCode:    node.CityCode,
+			Priority:    node.Priority,
+			LatencyMs:   msOf(node.Latency),
+		}
+		for _, ip := range node.TailscaleIPs {
+			c.IPs = append(c.IPs, ip.String())
+		}
+		in.Candidates = append(in.Candidates, c)
+	}
+	body, err := json.Marshal(in)
+	if err != nil {
+		return nil, err
+	}
+
+	args, err := splitCommand(*scorerFlag)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --scorer: %w", err)
+	}
+	ctx, cancel := context.WithTimeout(ctx, scorerTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Stdin = bytes.NewReader(body)
+	cmd.Stderr = os.Stderr
+	stdout, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("scorer %s failed: %w", args[0], err)
+	}
+
+	out := &scorerOutput{}
+	if err := json.Unmarshal(stdout, out); err != nil {
+		return nil, fmt.Errorf("failed to parse scorer output: %w", err)
+	}
+	return out, nil
+}
+
+// splitCommand splits a command line into arguments the way a POSIX shell
+// does, minus expansions: 'single' and "double" quotes group words, and a
+// backslash escapes the next character (in double quotes, only \, ", $
+// and `)
+func splitCommand(s string) ([]string, error) {
+	var args []string
+	var word strings.Builder
+	inWord := false
+	var quote rune
+	escaped := false
+	for _, r := range s {
+		switch {
+		case escaped:
+			if quote == '"' && !strings.ContainsRune("\\\"$`", r) {
+				word.WriteRune('\\')
+			}
+			word.WriteRune(r)
+			escaped = false
+		case quote == '\'':
+			if r == '\'' {
+				quote = 0
+			} else {
+				word.WriteRune(r)
+			}
+		case r == '\\' && quote != '\'':
+			escaped, inWord = true, true
+		case quote == '"':
+			if r == '"' {
+				quote = 0
+			} else {
+				word.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote, inWord = r, true
+		case r == ' ' || r == '\t' || r == '\n':
+			if inWord {
+				args = append(args, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+	if escaped || quote != 0 {
+		return nil, fmt.Errorf("unterminated quote or escape in %q", s)
+	}
+	if inWord {
+		args = append(args, word.String())
+	}
+	if len(args) == 0 {
+		return nil, errors.New("empty command")
+	}
+	return args, nil
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestSplitCommand(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    []string
+		wantErr bool
+	}{
+		{in: "/usr/local/bin/wan-policy", want: []string{"/usr/local/bin/wan-policy"}},
+		{in: "  policy  --db /etc/geo.db ", want: []string{"policy", "--db", "/etc/geo.db"}},
+		{in: `"/opt/My Scripts/policy" --mode 'strict mode'`, want: []string{"/opt/My Scripts/policy", "--mode", "strict mode"}},
+		{in: `/opt/My\ Scripts/policy`, want: []string{"/opt/My Scripts/policy"}},
+		{in: `policy "a \"b\" \c" 'd\e' ''`, want: []string{"policy", `a "b" \c`, `d\e`, ""}},
+		{in: `policy "unterminated`, wantErr: true},
+		{in: `policy trailing\`, wantErr: true},
+		{in: "   ", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := splitCommand(tt.in)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("splitCommand(%q) = %q, want an error", tt.in, got)
+			}
+			continue
+		}
+		if err != nil || !slices.Equal(got, tt.want) {
+			t.Errorf("splitCommand(%q) = %q, %v; want %q", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+func TestRankCandidatesScorerFailure(t *testing.T) {
+	fake := newTestClient(t, testTailnet...)
+	withFlag(t, "strategy", "exec")
+	withFlag(t, "scorer", "false")
+
+	// No fallback to priority order: the scorer may be what rules nodes out
+	if candidates, err := rankCandidates(t.Context(), fake); err == nil {
+		t.Errorf("rankCandidates = %v, want the scorer's failure", nodeIDs(candidates))
+	}
+}
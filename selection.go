@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"tailscale.com/tailcfg"
+)
+
+// Selection sources, recorded with every exit node protect-wan applies
+const (
+	sourceManual   = "manual"   // --set with a node name or ID
+	sourceAuto     = "auto"     // One-shot auto-selection
+	sourceDaemon   = "daemon"   // Daemon selection or re-selection
+	sourceFailover = "failover" // Daemon replaced an exit node that stopped working
+	sourceTray     = "tray"     // Country picked in the tray
+)
+
+// selectionSource is the source recorded for the next auto-selection
+var selectionSource = sourceAuto
+
+// selection records why an exit node was chosen
+type selection struct {
+	Node        tailcfg.StableNodeID `json:"node"`
+	Name        string               `json:"name"`
+	Time        time.Time            `json:"time"`
+	Source      string               `json:"source"`
+	Strategy    string               `json:"strategy,omitempty"`
+	Constraints []string             `json:"constraints,omitempty"`
+	Latency     time.Duration        `json:"latency,omitempty"` // Measured at selection time
+	Seed        uint64               `json:"seed,omitempty"`    // Of a random or weighted ranking
+}
+
+// selectionConstraints returns the filters that scoped the current selection
+func selectionConstraints() []string {
+	var c []string
+	if *regionFlag != "" {
+		c = append(c, "region="+*regionFlag)
+	}
+	if *countryFlag != "" {
+		c = append(c, "country="+*countryFlag)
+	}
+	if cityFilter != "" {
+		c = append(c, "city="+cityFilter)
+	}
+	if len(preferFlag) > 0 {
+		c = append(c, "prefer="+strings.Join(preferFlag, ","))
+	}
+	return c
+}
+
+// recordSelection stores why node was applied. ranked is false when the
+// node was named explicitly rather than picked by a strategy.
+func recordSelection(node MullvadNode, source string, ranked bool) {
+	if !stateEnabled() {
+		return
+	}
+
+	sel := &selection{
+		Node:    node.ID,
+		Name:    strings.TrimSuffix(node.DNSName, "."),
+		Time:    time.Now(),
+		Source:  source,
+		Latency: node.Latency,
+	}
+	if ranked {
+		sel.Strategy, _, _ = selectedStrategy()
+		sel.Constraints = selectionConstraints()
+		sel.Seed = selectionSeed
+	}
+
+	err := updateState(func(st *state) {
+		st.Selection = sel
+		if sel.Latency > 0 {
+			day := st.dayFor(sel.Time)
+			day.Selections++
+			day.SelectionLatencySum += sel.Latency
+		}
+	})
+	if err != nil {
+		log.Printf("Warning: failed to update state: %v", err)
+	}
+}
+
+// notifySwitched sends the routine exit-switched event, and
+// egress-country-changed if the switch changed the country
+func notifySwitched(ctx context.Context, previous, node MullvadNode, source string) {
+	from := "none"
+	if previous.ID != "" {
+		from = displayName(previous)
+	}
+	notifyEvent(ctx, "exit-switched", fmt.Sprintf("Exit node switched from %s to %s (%s, %s), %s",
+		from, displayName(node), node.City, node.CountryCode, source))
+	if previous.ID != "" && previous.CountryCode != node.CountryCode {
+		notifyEvent(ctx, "egress-country-changed", fmt.Sprintf("Egress country changed from %s to %s (%s via %s)",
+			previous.Country, node.Country, node.CountryCode, displayName(node)))
+	}
+}
+
+// lastSelection returns the recorded selection if it is for node id
+func lastSelection(id tailcfg.StableNodeID) *selection {
+	if !stateEnabled() {
+		return nil
+	}
+	st, err := loadState()
+	if err != nil || st.Selection == nil || st.Selection.Node != id {
+		return nil
+	}
+	return st.Selection
+}
+
+// describeSelection explains in one line why the exit node id is active
+func describeSelection(id tailcfg.StableNodeID) string {
+	sel := lastSelection(id)
+	if sel == nil {
+		return "not selected by protect-wan (set in Tailscale or by another tool)"
+	}
+
+	s := fmt.Sprintf("%s, %s (%s ago)", sel.Source, sel.Time.Format("2006-01-02 15:04"), time.Since(sel.Time).Round(time.Minute))
+	if sel.Strategy != "" {
+		s += ", strategy " + sel.Strategy
+	}
+	if sel.Seed != 0 {
+		s += fmt.Sprintf(" (--seed %d)", sel.Seed)
+	}
+	if len(sel.Constraints) > 0 {
+		s += ", " + strings.Join(sel.Constraints, " ")
+	}
+	if sel.Latency > 0 {
+		s += fmt.Sprintf(", %dms at selection", sel.Latency.Milliseconds())
+	}
+	return s
+}
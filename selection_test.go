@@ -0,0 +1,279 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/netip"
+	"testing"
+	"time"
+
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/tailcfg"
+)
+
+func TestRankCandidates(t *testing.T) {
+	tests := []struct {
+		name    string
+		flags   map[string]string
+		suggest tailcfg.StableNodeID   // Tailscale's exit node suggestion
+		want    []tailcfg.StableNodeID // Ranked candidates, best first
+		wantErr bool
+	}{
+		{
+			name: "latency ranks fastest first and drops offline nodes",
+			want: []tailcfg.StableNodeID{"de1", "ch2", "ch1", "se1"},
+		},
+		{
+			name:  "priority strategy keeps priority order",
+			flags: map[string]string{"strategy": "priority"},
+			want:  []tailcfg.StableNodeID{"ch1", "ch2", "de1", "se1"},
+		},
+		{
+			name:  "country filter",
+			flags: map[string]string{"country": "CH"},
+			want:  []tailcfg.StableNodeID{"ch2", "ch1"},
+		},
+		{
+			name:  "excluded node",
+			flags: map[string]string{"exclude-node": "de-fra-wg-001"},
+			want:  []tailcfg.StableNodeID{"ch2", "ch1", "se1"},
+		},
+		{
+			name:  "forbidden country",
+			flags: map[string]string{"forbid-country": "DE,CH"},
+			want:  []tailcfg.StableNodeID{"se1"},
+		},
+		{
+			name:  "allowed country",
+			flags: map[string]string{"allow-country": "SE"},
+			want:  []tailcfg.StableNodeID{"se1"},
+		},
+		{
+			name:  "preferred list",
+			flags: map[string]string{"strategy": "preferred-list", "prefer": "se,zrh"},
+			want:  []tailcfg.StableNodeID{"se1", "ch2", "ch1", "de1"},
+		},
+		{
+			name:    "tailscale suggestion first",
+			flags:   map[string]string{"strategy": "suggest"},
+			suggest: "se1",
+			want:    []tailcfg.StableNodeID{"se1", "ch1", "ch2", "de1"},
+		},
+		{
+			name:    "ineligible suggestion falls back to priority",
+			flags:   map[string]string{"strategy": "suggest"},
+			suggest: "de2",
+			want:    []tailcfg.StableNodeID{"ch1", "ch2", "de1", "se1"},
+		},
+		{
+			name:    "only offline nodes left",
+			flags:   map[string]string{"country": "DE", "exclude-node": "de-fra-wg-001"},
+			wantErr: true,
+		},
+		{
+			name:    "unknown forbidden country",
+			flags:   map[string]string{"forbid-country": "Atlantis"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := newTestClient(t, testTailnet...)
+			for name, value := range tt.flags {
+				withFlag(t, name, value)
+			}
+			fake.setSuggestion(tt.suggest)
+
+			candidates, err := rankCandidates(context.Background(), fake)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("rankCandidates = %v, want an error", nodeIDs(candidates))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("rankCandidates: %v", err)
+			}
+			if got := nodeIDs(candidates); !equalIDs(got, tt.want) {
+				t.Errorf("rankCandidates = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRankByPreferredListKeepsUnansweredNodes(t *testing.T) {
+	// se1 doesn't answer pings, yet stays ahead of the nodes not preferred
+	fake := newTestClient(t,
+		testPeer{id: "ch1", host: "ch-zrh-wg-001", country: "CH", city: "zrh", priority: 1, latency: 25 * time.Millisecond},
+		testPeer{id: "de1", host: "de-fra-wg-001", country: "DE", city: "fra", priority: 2, latency: 18 * time.Millisecond},
+		testPeer{id: "se1", host: "se-sto-wg-001", country: "SE", city: "sto", priority: 3},
+	)
+	withFlag(t, "strategy", "preferred-list")
+	withFlag(t, "prefer", "se,zrh")
+
+	candidates, err := rankCandidates(context.Background(), fake)
+	if err != nil {
+		t.Fatalf("rankCandidates: %v", err)
+	}
+	want := []tailcfg.StableNodeID{"ch1", "se1", "de1"}
+	if got := nodeIDs(candidates); !equalIDs(got, want) {
+		t.Errorf("rankCandidates = %v, want %v", got, want)
+	}
+}
+
+func TestRankCandidatesPingFailure(t *testing.T) {
+	fake := newTestClient(t, testTailnet...)
+	fake.failWith("Ping", errors.New("no route"))
+
+	// The latency strategy fails and falls back to priority order
+	candidates, err := rankCandidates(context.Background(), fake)
+	if err != nil {
+		t.Fatalf("rankCandidates: %v", err)
+	}
+	want := []tailcfg.StableNodeID{"ch1", "ch2", "de1", "se1"}
+	if got := nodeIDs(candidates); !equalIDs(got, want) {
+		t.Errorf("rankCandidates = %v, want %v", got, want)
+	}
+}
+
+func TestSetExitNodeByName(t *testing.T) {
+	tests := []struct {
+		name    string
+		arg     string
+		flags   map[string]string
+		want    tailcfg.StableNodeID
+		wantErr bool
+	}{
+		{name: "hostname", arg: "de-fra-wg-001", want: "de1"},
+		{name: "full hostname", arg: "ch-zrh-wg-002.mullvad.ts.net", want: "ch2"},
+		{name: "unknown", arg: "us-nyc-wg-001", wantErr: true},
+		{name: "forbidden", arg: "de-fra-wg-001", flags: map[string]string{"forbid-country": "DE"}, wantErr: true},
+		{name: "forbidden with override", arg: "de-fra-wg-001", flags: map[string]string{"forbid-country": "DE", "override-policy": "true"}, want: "de1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			fake := newTestClient(t, testTailnet...)
+			for name, value := range tt.flags {
+				withFlag(t, name, value)
+			}
+
+			err := setExitNodeByName(ctx, fake, tt.arg)
+			prefs, _ := fake.GetPrefs(ctx)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("setExitNodeByName(%q) succeeded, want an error", tt.arg)
+				}
+				if !prefs.ExitNodeID.IsZero() {
+					t.Errorf("exit node = %q after an error, want none", prefs.ExitNodeID)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("setExitNodeByName(%q): %v", tt.arg, err)
+			}
+			if prefs.ExitNodeID != tt.want {
+				t.Errorf("exit node = %q, want %q", prefs.ExitNodeID, tt.want)
+			}
+		})
+	}
+}
+
+// nodeIDs returns the IDs of nodes, in order
+func nodeIDs(nodes []MullvadNode) []tailcfg.StableNodeID {
+	ids := make([]tailcfg.StableNodeID, len(nodes))
+	for i, node := range nodes {
+		ids[i] = node.ID
+	}
+	return ids
+}
+
+func equalIDs(a, b []tailcfg.StableNodeID) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestSetExitNodeByIPForbidden(t *testing.T) {
+	tests := []struct {
+		name    string
+		flags   map[string]string
+		locked  bool // Whether the admin config locks the countries
+		country string
+		wantErr bool
+	}{
+		{name: "allowed country", flags: map[string]string{"allow-country": "US"}, country: "US"},
+		{name: "outside --allow-country", flags: map[string]string{"allow-country": "CH"}, country: "US", wantErr: true},
+		{name: "forbidden country", flags: map[string]string{"forbid-country": "US"}, country: "US", wantErr: true},
+		{name: "unknown country", flags: map[string]string{"forbid-country": "US"}},
+		{name: "unknown country while locked", flags: map[string]string{"forbid-country": "US"}, locked: true, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			fake := newTestClient(t, testTailnet...)
+			for name, value := range tt.flags {
+				withFlag(t, name, value)
+			}
+			if tt.locked {
+				adminLocked["forbid-country"] = tt.flags["forbid-country"]
+				t.Cleanup(func() { delete(adminLocked, "forbid-country") })
+			}
+			// A self-hosted exit node, not a Mullvad one
+			peer := &ipnstate.PeerStatus{
+				ID:             "vps",
+				DNSName:        "vps.example.ts.net.",
+				TailscaleIPs:   []netip.Addr{netip.MustParseAddr("100.100.9.9")},
+				Online:         true,
+				ExitNodeOption: true,
+			}
+			if tt.country != "" {
+				peer.Location = &tailcfg.Location{Country: tt.country, CountryCode: tt.country}
+			}
+			fake.addPeer(peer)
+
+			err := setExitNodeByName(ctx, fake, "100.100.9.9")
+			prefs, _ := fake.GetPrefs(ctx)
+			switch {
+			case tt.wantErr && err == nil:
+				t.Errorf("setExitNodeByName succeeded, want an error")
+			case !tt.wantErr && err != nil:
+				t.Errorf("setExitNodeByName: %v", err)
+			case tt.wantErr && !prefs.ExitNodeID.IsZero():
+				t.Errorf("exit node = %q after an error, want none", prefs.ExitNodeID)
+			}
+		})
+	}
+}
+
+func TestAutoSelectSkipsZombieNode(t *testing.T) {
+	ctx := context.Background()
+	// ch1 is reported online but doesn't answer pings
+	fake := newTestClient(t,
+		testPeer{id: "ch1", host: "ch-zrh-wg-001", country: "CH", city: "zrh", priority: 1},
+		testPeer{id: "ch2", host: "ch-zrh-wg-002", country: "CH", city: "zrh", priority: 2, latency: 22 * time.Millisecond},
+	)
+	withFlag(t, "strategy", "priority")
+
+	if err := autoSelectMullvad(ctx, fake); err != nil {
+		t.Fatalf("autoSelectMullvad: %v", err)
+	}
+	if prefs, _ := fake.GetPrefs(ctx); prefs.ExitNodeID != "ch2" {
+		t.Errorf("exit node = %q, want ch2 past the node that doesn't answer", prefs.ExitNodeID)
+	}
+
+	// Pings blocked altogether say nothing about the nodes
+	fake.failWith("Ping", errors.New("no route"))
+	if got := nodeIDs(confirmOnline(ctx, fake, []MullvadNode{{ID: "ch1"}, {ID: "ch2"}})); !equalIDs(got, []tailcfg.StableNodeID{"ch1", "ch2"}) {
+		t.Errorf("confirmOnline without pings = %v, want both kept", got)
+	}
+}
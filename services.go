@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"tailscale.com/tailcfg"
+)
+
+var probeServiceFlag stringList
+
+func init() {
+	flag.Var(&probeServiceFlag, "probe-service", "With --trial, a URL or host:port that must be reachable through a new exit node, else it is rolled back and avoided (repeatable)")
+}
+
+const (
+	// serviceProbeTTL is how long a node that blocked a service is avoided
+	serviceProbeTTL = 24 * time.Hour
+
+	// serviceCandidates is how many top candidates one selection trials
+	// before giving up on finding a node that reaches every service
+	serviceCandidates = 3
+)
+
+// errServiceBlocked is the trial failure of an exit node through which a
+// --probe-service target is unreachable
+var errServiceBlocked = errors.New("service unreachable through the exit node")
+
+// serviceProbe is the outcome of the service probes through a node
+type serviceProbe struct {
+	Blocked []string  `json:"blocked,omitempty"` // Unreachable --probe-service targets
+	Time    time.Time `json:"time"`
+}
+
+// parseServiceTarget checks a --probe-service entry: an http(s) URL or a
+// host:port
+func parseServiceTarget(target string) error {
+	if strings.Contains(target, "://") {
+		u, err := url.Parse(target)
+		if err != nil {
+			return err
+		}
+		if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("%q is not an http or https URL", target)
+		}
+		return nil
+	}
+	_, port, err := net.SplitHostPort(target)
+	if err != nil {
+		return fmt.Errorf("%q is neither a URL nor host:port", target)
+	}
+	if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+		return fmt.Errorf("invalid port in %q", target)
+	}
+	return nil
+}
+
+// probeService checks one target through the current exit: a URL must
+// answer with a status below 400, since services that block VPN exits
+// tend to answer 403; a host:port must accept a TCP connection
+func probeService(ctx context.Context, target string) error {
+	if !strings.Contains(target, "://") {
+		d := net.Dialer{Timeout: httpClient.Timeout}
+		conn, err := d.DialContext(ctx, "tcp", target)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+
+	transport := newTransport()
+	defer transport.CloseIdleConnections()
+	client := &http.Client{Timeout: httpClient.Timeout, Transport: transport}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return errors.New(resp.Status)
+	}
+	return nil
+}
+
+// probeServices checks every --probe-service target through the new exit
+// node and records the outcome for later selections. Returns
+// errServiceBlocked if any is unreachable.
+func probeServices(ctx context.Context, node MullvadNode) error {
+	if len(probeServiceFlag) == 0 || ctx.Value(skipServiceProbesKey{}) != nil {
+		return nil
+	}
+
+	var blocked, failures []string
+	for _, target := range probeServiceFlag {
+		err := probeService(ctx, target)
+		if *verboseFlag {
+			if err == nil {
+				fmt.Printf("Service %s reachable via %s\n", target, displayName(node))
+			} else {
+				fmt.Printf("Service %s unreachable via %s: %v\n", target, displayName(node), err)
+			}
+		}
+		if err != nil {
+			blocked = append(blocked, target)
+			failures = append(failures, fmt.Sprintf("%s (%v)", target, err))
+		}
+	}
+
+	if stateEnabled() {
+		err := updateState(func(st *state) {
+			if st.Services == nil {
+				st.Services = make(map[tailcfg.StableNodeID]serviceProbe)
+			}
+			st.Services[node.ID] = serviceProbe{Blocked: blocked, Time: time.Now()}
+		})
+		if err != nil {
+			log.Printf("Warning: failed to update state: %v", err)
+		}
+	}
+
+	if len(blocked) > 0 {
+		return fmt.Errorf("%w: %s", errServiceBlocked, strings.Join(failures, ", "))
+	}
+	return nil
+}
+
+// blockedServices returns the configured targets a recent probe found
+// unreachable through a node
+func blockedServices(node MullvadNode, probes map[tailcfg.StableNodeID]serviceProbe) []string {
+	p, ok := probes[node.ID]
+	if !ok || time.Since(p.Time) >= serviceProbeTTL {
+		return nil
+	}
+	var blocked []string
+	for _, target := range p.Blocked {
+		if slices.Contains(probeServiceFlag, target) {
+			blocked = append(blocked, target)
+		}
+	}
+	return blocked
+}
+
+// filterServiceBlocked drops the nodes through which a --probe-service
+// target was recently unreachable. If that would leave none, the probes are
+// ignored rather than leaving the WAN unprotected.
+func filterServiceBlocked(nodes []MullvadNode) []MullvadNode {
+	if len(probeServiceFlag) == 0 || !stateEnabled() {
+		return nodes
+	}
+	st, err := loadState()
+	if err != nil {
+		return nodes
+	}
+
+	filtered := make([]MullvadNode, 0, len(nodes))
+	var dropped []MullvadNode
+	for _, node := range nodes {
+		if len(blockedServices(node, st.Services)) > 0 {
+			dropped = append(dropped, node)
+			continue
+		}
+		filtered = append(filtered, node)
+	}
+	if len(dropped) == 0 {
+		return nodes
+	}
+	if len(filtered) == 0 {
+		fmt.Println("Warning: every candidate recently blocked a --probe-service target, ignoring the probes")
+		explain.note("Every candidate recently blocked a --probe-service target, probes ignored")
+		return nodes
+	}
+
+	for _, node := range dropped {
+		p := st.Services[node.ID]
+		explain.eliminate(fmt.Sprintf("%s unreachable through it at %s",
+			strings.Join(blockedServices(node, st.Services), ", "), p.Time.Format(time.DateTime)), node)
+	}
+	explain.filter("services reachable", len(nodes), len(filtered))
+	return filtered
+}
+
+// applyBestCandidate applies the first candidate. When a --probe-service
+// target is unreachable through it, the trial rolls it back and the next
+// candidates are tried, up to serviceCandidates in all. If every one of
+// them blocks a service, the first is applied without the service probes
+// rather than leaving the WAN unprotected. Returns the node that was
+// applied.
+func applyBestCandidate(ctx context.Context, lc LocalClient, candidates []MullvadNode) (MullvadNode, error) {
+	var err error
+	for i, node := range candidates[:min(len(candidates), serviceCandidates)] {
+		if i > 0 {
+			fmt.Printf("Trying the next candidate, %s\n", displayName(node))
+			emitNode("node_selected", node, event{Source: selectionSource, Reason: "previous candidate blocked a service"})
+		}
+		err = applyExitNode(ctx, lc, node)
+		if !errors.Is(err, errServiceBlocked) {
+			return node, err
+		}
+	}
+	if err == nil {
+		return MullvadNode{}, nil
+	}
+
+	node := candidates[0]
+	fmt.Printf("Warning: every candidate tried blocked a --probe-service target, applying %s without the service probes\n", displayName(node))
+	explain.note("Every candidate tried blocked a --probe-service target, best applied without the probes")
+	emitNode("node_selected", node, event{Source: selectionSource, Reason: "every candidate blocked a service"})
+	if err := applyExitNode(withoutServiceProbes(ctx), lc, node); err != nil {
+		return MullvadNode{}, err
+	}
+	return node, nil
+}
+
+type skipServiceProbesKey struct{}
+
+// withoutServiceProbes makes trials run with ctx skip the --probe-service
+// targets
+func withoutServiceProbes(ctx context.Context) context.Context {
+	return context.WithValue(ctx, skipServiceProbesKey{}, true)
+}
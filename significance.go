@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"math"
+	"time"
+)
+
+var switchSignificanceFlag = flag.Float64("switch-significance", 2, "Daemon: only leave a working exit node when the latency gain exceeds this many standard errors of repeated pings to both nodes (0 to disable)")
+
+// significanceSamples is how many times the current and the best node are
+// each pinged to tell a real latency gain from noise
+const significanceSamples = 5
+
+// latencySamples pings node n times and returns the mean and sample
+// variance, in nanoseconds, of the pings that answered
+func latencySamples(ctx context.Context, p *prober, node MullvadNode, n int) (mean, variance float64, answered int) {
+	var samples []float64
+	for range n {
+		if latency, err := p.ping(ctx, node); err == nil {
+			samples = append(samples, float64(latency))
+		}
+	}
+	answered = len(samples)
+	if answered == 0 {
+		return 0, 0, 0
+	}
+	for _, s := range samples {
+		mean += s
+	}
+	mean /= float64(answered)
+	if answered > 1 {
+		for _, s := range samples {
+			variance += (s - mean) * (s - mean)
+		}
+		variance /= float64(answered - 1)
+	}
+	return mean, variance, answered
+}
+
+// noisyImprovement reports whether best's latency gain over current is
+// within measurement noise, and why. Both nodes are pinged
+// significanceSamples times; the gain in mean latency must exceed
+// --switch-significance standard errors of the difference, so a 2ms edge
+// from one sample each doesn't cost the open connections a switch.
+func noisyImprovement(ctx context.Context, lc LocalClient, current, best MullvadNode) (bool, string) {
+	// Without a measured latency (e.g. --strategy priority) there is
+	// nothing to compare
+	if *switchSignificanceFlag <= 0 || best.Latency <= 0 {
+		return false, ""
+	}
+	p, err := newProber(lc)
+	if err != nil {
+		return false, ""
+	}
+	curMean, curVar, curN := latencySamples(ctx, p, current, significanceSamples)
+	bestMean, bestVar, bestN := latencySamples(ctx, p, best, significanceSamples)
+	// Too few answers to estimate the noise: the other checks decide
+	if curN < 2 || bestN < 2 {
+		return false, ""
+	}
+
+	gain := time.Duration(curMean - bestMean)
+	noise := time.Duration(*switchSignificanceFlag * math.Sqrt(curVar/float64(curN)+bestVar/float64(bestN)))
+	if gain <= 0 {
+		return true, fmt.Sprintf("%s is not faster over %d pings each (%s vs %s)",
+			displayName(best), significanceSamples, ms(time.Duration(bestMean)), ms(time.Duration(curMean)))
+	}
+	if gain <= noise {
+		return true, fmt.Sprintf("%s is only %s faster over %d pings each, within the measurement noise of %s (--switch-significance %g)",
+			displayName(best), gain.Round(100*time.Microsecond), significanceSamples, noise.Round(100*time.Microsecond), *switchSignificanceFlag)
+	}
+	return false, ""
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"flag"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+var (
+	smtpServerFlag   = flag.String("smtp-server", "", "SMTP server (host:port) to email notifications through")
+	smtpFromFlag     = flag.String("smtp-from", "", "Sender address of notification emails")
+	smtpUserFlag     = flag.String("smtp-user", "", "SMTP username, if the server requires authentication")
+	smtpPasswordFlag = flag.String("smtp-password", "", "SMTP password (or set PROTECT_WAN_SMTP_PASSWORD)")
+	smtpTLSFlag      = flag.String("smtp-tls", "starttls", "SMTP encryption: starttls, tls (implicit, usually port 465) or none")
+	smtpLevelFlag    = flag.String("smtp-level", "failure", "Events emailed: failure, or info to include routine events such as switches")
+	smtpToFlag       stringList
+)
+
+func init() {
+	flag.Var(&smtpToFlag, "smtp-to", "Recipient of notification emails (repeatable)")
+}
+
+// smtpTimeout bounds delivery of one email
+const smtpTimeout = 30 * time.Second
+
+// smtpNotifier emails the notification
+type smtpNotifier struct {
+	server, from, user, password, tlsMode string
+	to                                    []string
+}
+
+// newSMTPNotifier configures email from the --smtp-* flags
+func newSMTPNotifier() smtpNotifier {
+	password := flagOrEnv(*smtpPasswordFlag, "PROTECT_WAN_SMTP_PASSWORD")
+	return smtpNotifier{
+		server:   *smtpServerFlag,
+		from:     *smtpFromFlag,
+		user:     *smtpUserFlag,
+		password: password,
+		tlsMode:  *smtpTLSFlag,
+		to:       smtpToFlag,
+	}
+}
+
+// validateSMTP checks the --smtp-* flags
+func validateSMTP() error {
+	switch *smtpTLSFlag {
+	case "starttls", "tls", "none":
+	default:
+		return fmt.Errorf("invalid --smtp-tls value %q (expected starttls, tls or none)", *smtpTLSFlag)
+	}
+	if *smtpLevelFlag != severityFailure && *smtpLevelFlag != severityInfo {
+		return fmt.Errorf("invalid --smtp-level value %q (expected failure or info)", *smtpLevelFlag)
+	}
+	if *smtpServerFlag == "" {
+		return nil
+	}
+	if _, _, err := net.SplitHostPort(*smtpServerFlag); err != nil {
+		return fmt.Errorf("invalid --smtp-server %q (expected host:port)", *smtpServerFlag)
+	}
+	if *smtpFromFlag == "" || len(smtpToFlag) == 0 {
+		return errors.New("--smtp-server requires --smtp-from and --smtp-to")
+	}
+	return nil
+}
+
+func (s smtpNotifier) Notify(ctx context.Context, n notification) error {
+	host, _, _ := net.SplitHostPort(s.server)
+	ctx, cancel := context.WithTimeout(ctx, smtpTimeout)
+	defer cancel()
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", s.server)
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	tlsConfig := &tls.Config{ServerName: host}
+	if s.tlsMode == "tls" {
+		conn = tls.Client(conn, tlsConfig)
+	}
+
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if s.tlsMode == "starttls" {
+		if err := c.StartTLS(tlsConfig); err != nil {
+			return fmt.Errorf("STARTTLS: %w", err)
+		}
+	}
+	if s.user != "" {
+		if err := c.Auth(smtp.PlainAuth("", s.user, s.password, host)); err != nil {
+			return fmt.Errorf("authentication: %w", err)
+		}
+	}
+	if err := c.Mail(s.from); err != nil {
+		return err
+	}
+	for _, to := range s.to {
+		if err := c.Rcpt(to); err != nil {
+			return fmt.Errorf("recipient %s: %w", to, err)
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(s.message(n)); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// message formats the notification as a plain text email
+func (s smtpNotifier) message(n notification) []byte {
+	subject := fmt.Sprintf("[protect-wan] %s: %s", n.Host, n.Event)
+	if n.Severity == severityFailure {
+		subject = fmt.Sprintf("[protect-wan] %s: %s (failure)", n.Host, n.Event)
+	}
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", s.from)
+	fmt.Fprintf(&b, "To: %s\r\n", strings.Join(s.to, ", "))
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", n.Time.Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("\r\n")
+	fmt.Fprintf(&b, "%s\r\n\r\n", n.Message)
+	fmt.Fprintf(&b, "Event:    %s\r\n", n.Event)
+	fmt.Fprintf(&b, "Severity: %s\r\n", n.Severity)
+	fmt.Fprintf(&b, "Host:     %s\r\n", n.Host)
+	fmt.Fprintf(&b, "Time:     %s\r\n", n.Time.Format(time.RFC3339))
+	return b.Bytes()
+}
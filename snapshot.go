@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/tailcfg"
+)
+
+var (
+	exportFlag   = flag.String("export", "", "With list: also write the listed nodes to this JSON snapshot file")
+	snapshotFlag = flag.String("snapshot", "", "Run best or list offline from a node snapshot written by list --export")
+)
+
+// writeSnapshot saves nodes as a JSON snapshot file
+func writeSnapshot(path string, nodes []MullvadNode) error {
+	data, err := json.MarshalIndent(nodeSnapshot{Time: time.Now(), Nodes: nodes}, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		return fmt.Errorf("failed to write snapshot: %w", err)
+	}
+	return nil
+}
+
+// readSnapshot loads a snapshot written by writeSnapshot
+func readSnapshot(path string) (*nodeSnapshot, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var snap nodeSnapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return nil, fmt.Errorf("failed to parse snapshot %s: %w", path, err)
+	}
+	return &snap, nil
+}
+
+// snapshotClient serves a node snapshot as a fake tailscaled. Nothing can
+// be pinged, so latency strategies fall back to priority.
+func snapshotClient(snap *nodeSnapshot) *fakeClient {
+	fake := newFakeClient(&ipnstate.Status{BackendState: "Running"})
+	for _, node := range snap.Nodes {
+		fake.addPeer(&ipnstate.PeerStatus{
+			ID:             node.ID,
+			DNSName:        node.DNSName,
+			TailscaleIPs:   node.TailscaleIPs,
+			Online:         node.Online,
+			ExitNodeOption: true,
+			Location: &tailcfg.Location{
+				Country:     node.Country,
+				CountryCode: node.CountryCode,
+				City:        node.City,
+				CityCode:    node.CityCode,
+				Priority:    node.Priority,
+			},
+		})
+	}
+	return fake
+}
+
+// offlineNodes returns the snapshot to run from when tailscaled can't be
+// reached: the node list cached by the last successful run
+func offlineNodes(ctx context.Context, lc LocalClient) (*nodeSnapshot, error) {
+	_, err := lc.StatusWithoutPeers(ctx)
+	if err == nil || !stateEnabled() {
+		return nil, err
+	}
+	st, serr := loadState()
+	if serr != nil || st.NodeCache == nil {
+		return nil, err
+	}
+	fmt.Fprintf(os.Stderr, "Warning: tailscaled unreachable (%v)\n", err)
+	return st.NodeCache, nil
+}
+
+// printOffline announces that results come from a snapshot
+func printOffline(snap *nodeSnapshot) {
+	fmt.Printf("Offline: using the node snapshot from %s (%d nodes). Latency can't be measured, nodes are ranked by priority.\n",
+		snap.Time.Format("2006-01-02 15:04"), len(snap.Nodes))
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"tailscale.com/ipn"
+	"tailscale.com/tailcfg"
+)
+
+var stateFlag = flag.String("state", "", "Path to state file (default: <user state dir>/protect-wan/state.json)")
+
+// stateVersion is the schema version of the state file written by this
+// build. Older files are upgraded by stateMigrations on load.
+const stateVersion = 1
+
+// stateMigrations[i] upgrades a state from version i to i+1
+var stateMigrations = []func(st *state){
+	// 0 -> 1: unversioned files already have the version 1 layout
+	func(st *state) {},
+}
+
+// state is what protect-wan remembers between runs
+type state struct {
+	Version int `json:"version"`
+
+	NodeCount     int           `json:"node_count,omitempty"`
+	NodeCountTime time.Time     `json:"node_count_time,omitzero"`
+	DiffBaseline  *nodeSnapshot `json:"diff_baseline,omitempty"` // Updated by the diff command only
+	NodeCache     *nodeSnapshot `json:"node_cache,omitempty"`    // Nodes seen by the last run, for offline use
+
+	ExitNode      tailcfg.StableNodeID `json:"exit_node,omitempty"`
+	ExitNodeSince time.Time            `json:"exit_node_since,omitzero"`
+	LastSwitch    time.Time            `json:"last_switch,omitzero"` // Last automatic switch
+	Switches      []time.Time          `json:"switches,omitempty"`   // Automatic switches in the last 24 hours
+	Selection     *selection           `json:"selection,omitempty"`  // Why the exit node was chosen
+	Traffic       *trafficSample       `json:"traffic,omitempty"`    // Last daemon byte counter sample
+	Usage         []usageRecord        `json:"usage,omitempty"`      // Data per day and exit node
+	Days          []dayRecord          `json:"days,omitempty"`       // Protection, switches and selections per day
+	Pause         *pause               `json:"pause,omitempty"`      // Protection paused by pause or the tray
+
+	ProtectionSample time.Time `json:"protection_sample,omitzero"` // Last daemon check, for the time protected
+	Pruned           time.Time `json:"pruned,omitzero"`            // Last drop of data past --retention
+
+	LatencyCache map[string]*networkLatencies          `json:"latency_cache,omitempty"` // By network fingerprint
+	Health       map[tailcfg.StableNodeID]*nodeHealth  `json:"health,omitempty"`        // Failing and quarantined nodes
+	IPv6Egress   map[tailcfg.StableNodeID]ipv6Probe    `json:"ipv6_egress,omitempty"`   // Last IPv6 probe per node
+	Services     map[tailcfg.StableNodeID]serviceProbe `json:"services,omitempty"`      // Last --probe-service outcome per node
+	Locations    map[string]locationEstimate           `json:"locations,omitempty"`     // Public IP location, by network fingerprint
+
+	Notifications map[string]*notifyHistory        `json:"notifications,omitempty"` // Recently sent, by event type
+	Profiles      map[ipn.ProfileID]*profileAccess `json:"profiles,omitempty"`      // Mullvad access by login profile
+
+	stored map[string][]byte // Records as read from the bolt store, to write only changes
+}
+
+// nodeSnapshot is the Mullvad node inventory at one point in time
+type nodeSnapshot struct {
+	Time  time.Time     `json:"time"`
+	Nodes []MullvadNode `json:"nodes"`
+}
+
+// statePath returns the state file location, or "" if there is none
+func statePath() string {
+	if *stateFlag != "" {
+		return *stateFlag
+	}
+	dir, err := stateDir()
+	if err != nil {
+		return ""
+	}
+	return migrateStatePath(filepath.Join(dir, "state.json"))
+}
+
+// stateReadOnly is set for replayed and offline runs, whose nodes must not
+// be recorded as ours
+var stateReadOnly bool
+
+// stateEnabled reports whether runs may read and record state
+func stateEnabled() bool {
+	return !stateReadOnly && statePath() != ""
+}
+
+// loadState reads the state file, and the records in the bolt store with
+// --state-store bolt. A missing file is an empty state.
+func loadState() (*state, error) {
+	st := &state{Version: stateVersion}
+	data, err := os.ReadFile(statePath())
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, st); err != nil {
+			return nil, fmt.Errorf("failed to parse state %s: %w", statePath(), err)
+		}
+		if err := st.migrate(statePath()); err != nil {
+			return nil, err
+		}
+	}
+	if *stateStoreFlag == storeBolt {
+		if err := st.loadStore(); err != nil {
+			return nil, err
+		}
+	}
+	return st, nil
+}
+
+// migrate upgrades a state read from source to stateVersion
+func (st *state) migrate(source string) error {
+	if st.Version > stateVersion {
+		return fmt.Errorf("state %s has version %d, newer than this protect-wan supports (%d)", source, st.Version, stateVersion)
+	}
+	for ; st.Version < stateVersion; st.Version++ {
+		stateMigrations[st.Version](st)
+	}
+	return nil
+}
+
+// saveState writes the state file atomically. With --state-store bolt,
+// the day and usage records go to the store instead.
+func saveState(st *state) error {
+	path := statePath()
+	out := st
+	if *stateStoreFlag == storeBolt {
+		if err := st.saveStore(); err != nil {
+			return err
+		}
+		rest := *st
+		rest.Days, rest.Usage = nil, nil
+		out = &rest
+	}
+	data, err := json.MarshalIndent(out, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("failed to create state directory: %w", err)
+	}
+
+	// A temporary file of its own, so concurrent writers never interleave
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to write state: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Chmod(0o644)
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write state: %w", err)
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// updateState loads the state, applies fn and saves it again, pruning
+// data past its retention once a day. The state stays locked throughout,
+// so a daemon and a scheduled run or command don't lose each other's
+// updates.
+func updateState(fn func(st *state)) error {
+	unlock, err := lockState()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	st, err := loadState()
+	if err != nil {
+		return err
+	}
+	fn(st)
+	pruneDue(st)
+	return saveState(st)
+}
+
+// lockState takes the state's lock file, waiting for other protect-wan
+// processes to release it, and returns the function releasing it
+func lockState() (func(), error) {
+	path := statePath() + ".lock"
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create state directory: %w", err)
+	}
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open state lock: %w", err)
+	}
+	if err := lockFile(f); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to lock state: %w", err)
+	}
+	// Closing the file releases the lock
+	return func() { f.Close() }, nil
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestUpdateStateConcurrent(t *testing.T) {
+	newTestClient(t)
+
+	// Each update builds on the previous one, so none may be lost
+	const updates = 20
+	var wg sync.WaitGroup
+	for range updates {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := updateState(func(st *state) { st.NodeCount++ }); err != nil {
+				t.Errorf("updateState: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	st, err := loadState()
+	if err != nil {
+		t.Fatalf("loadState: %v", err)
+	}
+	if st.NodeCount != updates {
+		t.Errorf("NodeCount = %d after %d updates, want %d", st.NodeCount, updates, updates)
+	}
+}
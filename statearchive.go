@@ -0,0 +1,251 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+func init() {
+	commands["state"] = command{
+		Usage: "Manage the state file: state prune drops data past --retention, state export/import move it to another machine",
+		Run:   runState,
+	}
+}
+
+// stateArchiveFormat identifies a state export
+const stateArchiveFormat = "protect-wan-state"
+
+// stateArchive is what protect-wan learned on one machine, in a form that
+// another machine can import, along with the config file holding the
+// user's exclusions and preferences. It leaves out what only holds for the
+// machine and its tailscaled: the byte counters, pause, the exit node last
+// applied, notification rate limits and login profiles.
+type stateArchive struct {
+	Format   string    `json:"format"`
+	Exported time.Time `json:"exported"`
+	Host     string    `json:"host,omitempty"`
+	State    *state    `json:"state"`
+	Config   string    `json:"config,omitempty"` // The config file, secrets left out
+}
+
+// portable returns the parts of st that carry over to another machine
+func (st *state) portable() *state {
+	return &state{
+		Version:       st.Version,
+		NodeCount:     st.NodeCount,
+		NodeCountTime: st.NodeCountTime,
+		DiffBaseline:  st.DiffBaseline,
+		NodeCache:     st.NodeCache,
+		Usage:         st.Usage,
+		Days:          st.Days,
+		LatencyCache:  st.LatencyCache,
+		Health:        st.Health,
+		IPv6Egress:    st.IPv6Egress,
+		Services:      st.Services,
+		Locations:     st.Locations,
+	}
+}
+
+// runState dispatches state subcommands
+func runState(ctx context.Context, lc LocalClient, args []string) error {
+	usage := fmt.Errorf("usage: %s state prune [--retention 90d] | state export [file] | state import <file>", os.Args[0])
+	if len(args) == 0 {
+		return usage
+	}
+	if !stateEnabled() {
+		return errors.New("no state file (see --state)")
+	}
+	switch {
+	case args[0] == "prune" && len(args) == 1:
+		return statePrune()
+	case args[0] == "export" && len(args) <= 2:
+		path := "-"
+		if len(args) == 2 {
+			path = args[1]
+		}
+		return stateExport(path)
+	case args[0] == "import" && len(args) == 2:
+		return stateImport(args[1])
+	}
+	return usage
+}
+
+// stateExport writes the portable state to path, - for stdout
+func stateExport(path string) error {
+	st, err := loadState()
+	if err != nil {
+		return err
+	}
+	config, err := exportConfig()
+	if err != nil {
+		return err
+	}
+	archive := stateArchive{Format: stateArchiveFormat, Exported: time.Now(), State: st.portable(), Config: config}
+	archive.Host, _ = os.Hostname()
+	data, err := json.MarshalIndent(archive, "", "  ")
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	if path == "-" {
+		_, err = os.Stdout.Write(data)
+		return err
+	}
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		return fmt.Errorf("failed to write state export: %w", err)
+	}
+	fmt.Fprintf(os.Stderr, "State exported to %s\n", path)
+	return nil
+}
+
+// stateImport merges an export into the state file. What this machine
+// already recorded wins: records of the same day and node, and entries of
+// the same node or network, are only added where missing, so importing an
+// export twice changes nothing.
+func stateImport(path string) error {
+	if daemonRunning() {
+		return errors.New("the daemon is running and would overwrite the import, stop it first")
+	}
+
+	var data []byte
+	var err error
+	if path == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read state export: %w", err)
+	}
+	var archive stateArchive
+	if err := json.Unmarshal(data, &archive); err != nil || archive.Format != stateArchiveFormat || archive.State == nil {
+		return fmt.Errorf("%s is not a protect-wan state export", path)
+	}
+	if err := archive.State.migrate(path); err != nil {
+		return err
+	}
+
+	var added int
+	err = updateState(func(st *state) { added = st.merge(archive.State) })
+	if err != nil {
+		return err
+	}
+	from := archive.Host
+	if from == "" {
+		from = "another machine"
+	}
+	fmt.Printf("Imported %d records from %s (exported %s) into %s\n", added, from, archive.Exported.Format("2006-01-02 15:04"), statePath())
+	return importConfig(archive.Config)
+}
+
+// exportConfig returns the config file for an export, "" if there is none.
+// Secrets are left out: they don't belong in a file that gets copied
+// around.
+func exportConfig() (string, error) {
+	path := configFilePath()
+	if path == "" {
+		return "", nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", nil
+	} else if err != nil {
+		return "", fmt.Errorf("failed to read config: %w", err)
+	}
+
+	lines := strings.SplitAfter(string(data), "\n")
+	for i, line := range lines {
+		name, _, ok := strings.Cut(line, "=")
+		name = strings.TrimPrefix(strings.TrimSpace(name), "--")
+		if _, secret := installSecretFlags[name]; ok && secret {
+			lines[i] = "# " + name + " left out of the export\n"
+		}
+	}
+	return strings.Join(lines, ""), nil
+}
+
+// importConfig installs the config file of an export. An existing config
+// file is kept, and the exported one written next to it for review.
+func importConfig(config string) error {
+	path := configFilePath()
+	if config == "" || path == "" {
+		return nil
+	}
+	current, err := os.ReadFile(path)
+	msg := "Config file written to %s\n"
+	switch {
+	case err == nil && string(current) == config:
+		return nil
+	case err == nil:
+		path += ".imported"
+		msg = "Kept the existing config file; the exported one is in %s\n"
+	case !errors.Is(err, fs.ErrNotExist):
+		return fmt.Errorf("failed to read config: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, []byte(config), 0o600); err != nil {
+		return fmt.Errorf("failed to write config: %w", err)
+	}
+	fmt.Printf(msg, path)
+	return nil
+}
+
+// merge adds the entries of other that st lacks and returns how many
+func (st *state) merge(other *state) int {
+	added := 0
+	for _, d := range other.Days {
+		if !slices.ContainsFunc(st.Days, func(own dayRecord) bool { return own.Day == d.Day }) {
+			st.Days = append(st.Days, d)
+			added++
+		}
+	}
+	for _, u := range other.Usage {
+		if !slices.ContainsFunc(st.Usage, func(own usageRecord) bool { return own.Day == u.Day && own.Node == u.Node }) {
+			st.Usage = append(st.Usage, u)
+			added++
+		}
+	}
+
+	added += mergeMap(&st.Health, other.Health)
+	added += mergeMap(&st.Services, other.Services)
+	added += mergeMap(&st.IPv6Egress, other.IPv6Egress)
+	added += mergeMap(&st.LatencyCache, other.LatencyCache)
+	added += mergeMap(&st.Locations, other.Locations)
+
+	if st.NodeCache == nil && other.NodeCache != nil {
+		st.NodeCache, st.NodeCount, st.NodeCountTime = other.NodeCache, other.NodeCount, other.NodeCountTime
+	}
+	if st.DiffBaseline == nil {
+		st.DiffBaseline = other.DiffBaseline
+	}
+	return added
+}
+
+// mergeMap adds the entries of other missing from *m, returning how many
+func mergeMap[K comparable, V any](m *map[K]V, other map[K]V) int {
+	added := 0
+	for k, v := range other {
+		if _, ok := (*m)[k]; ok {
+			continue
+		}
+		if *m == nil {
+			*m = make(map[K]V)
+		}
+		(*m)[k] = v
+		added++
+	}
+	return added
+}
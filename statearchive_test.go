@@ -0,0 +1,49 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestExportImportConfig(t *testing.T) {
+	newTestClient(t)
+	path := writeTestFile(t, "exclude-node = us-*\nforbid-country = RU\nsmtp-password = hunter2\n--ntfy-token = tk\n")
+	withFlag(t, "config", path)
+
+	config, err := exportConfig()
+	if err != nil {
+		t.Fatalf("exportConfig: %v", err)
+	}
+	if !strings.Contains(config, "exclude-node = us-*") || !strings.Contains(config, "forbid-country = RU") {
+		t.Errorf("export lacks the settings:\n%s", config)
+	}
+	if strings.Contains(config, "hunter2") || strings.Contains(config, "tk") {
+		t.Errorf("export carries a secret:\n%s", config)
+	}
+
+	// A new machine without a config file gets the exported one
+	fresh := filepath.Join(t.TempDir(), "config")
+	withFlag(t, "config", fresh)
+	if err := importConfig(config); err != nil {
+		t.Fatalf("importConfig: %v", err)
+	}
+	if data, err := os.ReadFile(fresh); err != nil || string(data) != config {
+		t.Errorf("imported config = %q, %v; want the export", data, err)
+	}
+
+	// An existing config file is kept
+	if err := os.WriteFile(fresh, []byte("country = SE\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := importConfig(config); err != nil {
+		t.Fatalf("importConfig: %v", err)
+	}
+	if data, _ := os.ReadFile(fresh); string(data) != "country = SE\n" {
+		t.Errorf("existing config overwritten with %q", data)
+	}
+	if data, err := os.ReadFile(fresh + ".imported"); err != nil || string(data) != config {
+		t.Errorf("config.imported = %q, %v; want the export", data, err)
+	}
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	formatFlag = flag.String("format", "csv", "Output format of stats export: csv or json")
+	sinceFlag  = flag.String("since", "30d", "With stats export, only include days since this long ago (e.g. 30d, 12h, 2w) or a date (2006-01-02)")
+)
+
+// statsRow aggregates usage and latency of one exit node or country on one
+// day
+type statsRow struct {
+	Day         string  `json:"day"`
+	Scope       string  `json:"scope"` // node or country
+	Node        string  `json:"node,omitempty"`
+	CountryCode string  `json:"country_code"`
+	RxBytes     int64   `json:"rx_bytes"`
+	TxBytes     int64   `json:"tx_bytes"`
+	Samples     int     `json:"latency_samples"`
+	AvgMs       float64 `json:"latency_avg_ms,omitempty"`
+	MinMs       float64 `json:"latency_min_ms,omitempty"`
+	MaxMs       float64 `json:"latency_max_ms,omitempty"`
+}
+
+// parseSince parses --since: an age (see parseAge) or a date. Returns the
+// first day to include.
+func parseSince(s string) (string, error) {
+	if t, err := time.ParseInLocation(time.DateOnly, s, time.Local); err == nil {
+		return t.Format(time.DateOnly), nil
+	}
+	d, err := parseAge(s)
+	if err != nil {
+		return "", fmt.Errorf("invalid --since %q (e.g. 30d, 12h or 2026-01-31)", s)
+	}
+	return time.Now().Add(-d).Format(time.DateOnly), nil
+}
+
+// parseAge parses a duration with d and w units allowed as well
+func parseAge(s string) (time.Duration, error) {
+	if !strings.HasSuffix(s, "d") && !strings.HasSuffix(s, "w") {
+		d, err := time.ParseDuration(s)
+		if err != nil || d < 0 {
+			return 0, fmt.Errorf("invalid age %q (e.g. 90d, 2w or 12h)", s)
+		}
+		return d, nil
+	}
+	n, err := strconv.Atoi(s[:len(s)-1])
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid age %q (e.g. 90d, 2w or 12h)", s)
+	}
+	d := time.Duration(n) * 24 * time.Hour
+	if strings.HasSuffix(s, "w") {
+		d *= 7
+	}
+	return d, nil
+}
+
+// statsRows aggregates the usage records since the first day into per-node
+// and per-country rows, sorted by day
+func statsRows(records []usageRecord, first string) []statsRow {
+	var rows []statsRow
+	countries := make(map[string]*statsRow)
+	latency := make(map[*statsRow]time.Duration)
+
+	add := func(row *statsRow, u usageRecord) {
+		row.RxBytes += u.RxBytes
+		row.TxBytes += u.TxBytes
+		if u.LatencySamples == 0 {
+			return
+		}
+		if row.Samples == 0 || msOf(u.LatencyMin) < row.MinMs {
+			row.MinMs = msOf(u.LatencyMin)
+		}
+		row.MaxMs = max(row.MaxMs, msOf(u.LatencyMax))
+		row.Samples += u.LatencySamples
+		latency[row] += u.LatencySum
+	}
+
+	nodeRows := make([]*statsRow, 0, len(records))
+	for _, u := range records {
+		if u.Day < first {
+			continue
+		}
+		row := &statsRow{Day: u.Day, Scope: "node", Node: u.Name, CountryCode: u.CountryCode}
+		add(row, u)
+		nodeRows = append(nodeRows, row)
+
+		key := u.Day + "/" + u.CountryCode
+		c, ok := countries[key]
+		if !ok {
+			c = &statsRow{Day: u.Day, Scope: "country", CountryCode: u.CountryCode}
+			countries[key] = c
+		}
+		add(c, u)
+	}
+
+	for _, row := range nodeRows {
+		rows = append(rows, *withAvg(row, latency[row]))
+	}
+	for _, row := range countries {
+		rows = append(rows, *withAvg(row, latency[row]))
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		a, b := rows[i], rows[j]
+		if a.Day != b.Day {
+			return a.Day < b.Day
+		}
+		if a.Scope != b.Scope {
+			return a.Scope > b.Scope // Nodes before their countries
+		}
+		if a.CountryCode != b.CountryCode {
+			return a.CountryCode < b.CountryCode
+		}
+		return a.Node < b.Node
+	})
+	return rows
+}
+
+// withAvg sets the row's average latency from the sum of its samples
+func withAvg(row *statsRow, sum time.Duration) *statsRow {
+	if row.Samples > 0 {
+		row.AvgMs = msOf(sum / time.Duration(row.Samples))
+	}
+	return row
+}
+
+// msOf converts d to fractional milliseconds, rounded to 0.1ms
+func msOf(d time.Duration) float64 {
+	return float64(d.Round(100*time.Microsecond)) / float64(time.Millisecond)
+}
+
+// statsExport writes the per-day aggregates to stdout as CSV or JSON
+func statsExport() error {
+	if *formatFlag != "csv" && *formatFlag != "json" {
+		return fmt.Errorf("invalid --format %q (expected csv or json)", *formatFlag)
+	}
+	first, err := parseSince(*sinceFlag)
+	if err != nil {
+		return err
+	}
+	if !stateEnabled() {
+		return errors.New("no state file (see --state)")
+	}
+	st, err := loadState()
+	if err != nil {
+		return err
+	}
+	rows := statsRows(st.Usage, first)
+
+	if *formatFlag == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(rows)
+	}
+
+	w := csv.NewWriter(os.Stdout)
+	w.Write([]string{"day", "scope", "node", "country_code", "rx_bytes", "tx_bytes", "latency_samples", "latency_avg_ms", "latency_min_ms", "latency_max_ms"})
+	for _, r := range rows {
+		w.Write([]string{
+			r.Day, r.Scope, r.Node, r.CountryCode,
+			strconv.FormatInt(r.RxBytes, 10),
+			strconv.FormatInt(r.TxBytes, 10),
+			strconv.Itoa(r.Samples),
+			csvMs(r.AvgMs), csvMs(r.MinMs), csvMs(r.MaxMs),
+		})
+	}
+	w.Flush()
+	return w.Error()
+}
+
+// csvMs formats milliseconds for CSV, empty for no samples
+func csvMs(v float64) string {
+	if v == 0 {
+		return ""
+	}
+	return strconv.FormatFloat(v, 'f', 1, 64)
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"tailscale.com/tailcfg"
+)
+
+var jsonFlag = flag.Bool("json", false, "With status, print JSON instead of text")
+
+func init() {
+	commands["status"] = command{
+		Usage: "Show the active exit node, its traffic and why it was chosen",
+		Run:   runStatus,
+	}
+}
+
+// statusReport is the status command's output
+type statusReport struct {
+	Active      bool                 `json:"active"`
+	ID          tailcfg.StableNodeID `json:"id,omitempty"`
+	Name        string               `json:"name,omitempty"`
+	City        string               `json:"city,omitempty"`
+	CountryCode string               `json:"country_code,omitempty"`
+	Online      bool                 `json:"online"`
+	Path        string               `json:"path,omitempty"` // direct, derp:<region> or peer-relay
+	RxBytes     int64                `json:"rx_bytes"`
+	TxBytes     int64                `json:"tx_bytes"`
+	Rate        *trafficSample       `json:"rate,omitempty"` // Last daemon sample
+	Selection   *selection           `json:"selection,omitempty"`
+	AutoExit    string               `json:"auto_exit_node,omitempty"` // Tailscale's automatic exit node expression
+}
+
+// runStatus prints the active exit node, the traffic through it and the
+// recorded reason for it
+func runStatus(ctx context.Context, lc LocalClient, args []string) error {
+	if len(args) > 0 {
+		return errors.New("status takes no arguments")
+	}
+
+	node, ok, err := currentExitNode(ctx, lc)
+	if err != nil {
+		return err
+	}
+
+	report := statusReport{Active: ok, AutoExit: string(tailscaleAuto(ctx, lc))}
+	if ok {
+		report.ID = node.ID
+		report.Name = strings.TrimSuffix(node.DNSName, ".")
+		report.City, report.CountryCode, report.Online = node.City, node.CountryCode, node.Online
+		if report.RxBytes, report.TxBytes, err = peerCounters(ctx, lc, node.ID); err != nil {
+			return err
+		}
+		report.Selection = lastSelection(node.ID)
+		if paths, err := peerPaths(ctx, lc); err == nil {
+			report.Path = paths[node.ID]
+		}
+		if st, err := loadState(); err == nil && stateEnabled() && st.Traffic != nil && st.Traffic.Node == node.ID {
+			report.Rate = st.Traffic
+		}
+	}
+
+	if *jsonFlag {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(report)
+	}
+
+	if !ok {
+		fmt.Println(tr("No exit node active"))
+		if report.AutoExit != "" {
+			fmt.Printf("Tailscale selects the exit node automatically (%s)\n", report.AutoExit)
+		}
+		return nil
+	}
+	if *shortFlag {
+		online := "online"
+		if !node.Online {
+			online = "offline"
+		}
+		fmt.Printf("%s %s %s rx %s tx %s\n", mullvadHostname(node), node.CountryCode, online,
+			formatBytes(float64(report.RxBytes)), formatBytes(float64(report.TxBytes)))
+		return nil
+	}
+	fmt.Printf("Exit node: %s (%s, %s)\n", displayName(node), node.City, node.CountryCode)
+	if *wideFlag {
+		fmt.Printf("  ID: %s\n", node.ID)
+		fmt.Printf("  Tailscale IPs: %v\n", node.TailscaleIPs)
+		if attrs, err := fetchRelayAttrs(ctx); err == nil {
+			if a, ok := attrs[mullvadHostname(node)]; ok {
+				fmt.Printf("  Provider: %s (owned %v, ram-only %v, daita %v)\n", a.Provider, a.Owned, a.RAMOnly, a.DAITA)
+			}
+		}
+	}
+	fmt.Printf("  Online: %v\n", node.Online)
+	switch {
+	case relayedPath(report.Path):
+		fmt.Printf("  Path: %s (relayed, latency and throughput suffer)\n", pathLabel(report.Path))
+	case report.Path != "":
+		fmt.Printf("  Path: %s\n", pathLabel(report.Path))
+	}
+	fmt.Printf("  Traffic: %s received, %s sent\n", formatBytes(float64(report.RxBytes)), formatBytes(float64(report.TxBytes)))
+	if r := report.Rate; r != nil {
+		fmt.Printf("  Rate: %s/s down, %s/s up (daemon sample %s ago)\n",
+			formatBytes(r.RxRate), formatBytes(r.TxRate), time.Since(r.Time).Round(time.Second))
+	}
+	fmt.Printf("  Selected: %s\n", describeSelection(node.ID))
+	if report.AutoExit != "" {
+		fmt.Printf("  Tailscale auto exit node: %s (--tailscale-auto %s)\n", report.AutoExit, *tailscaleAutoFlag)
+	}
+	return nil
+}
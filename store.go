@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var stateStoreFlag = flag.String("state-store", storeJSON, "Where the state keeps protection history, usage and latency samples: json (in the state file) or bolt (a database next to it, for daemons running for months)")
+
+// State stores for the records that grow with time
+const (
+	storeJSON = "json" // Days and Usage in the state file, rewritten on every update
+	storeBolt = "bolt" // Days and Usage in a bbolt database, written record by record
+)
+
+// storeVersion is the schema version of the bolt store written by this
+// build. Older stores are upgraded by storeMigrations when opened.
+const storeVersion = 1
+
+var (
+	bucketMeta  = []byte("meta")
+	bucketDays  = []byte("days")  // dayRecord by day
+	bucketUsage = []byte("usage") // usageRecord by day/node
+)
+
+// storeMigrations[i] upgrades a store from version i to i+1
+var storeMigrations = []func(tx *bolt.Tx) error{
+	// 0 -> 1: buckets for the day and usage records
+	func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{bucketDays, bucketUsage} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+}
+
+// storePath returns the bolt store location: the state file's, with a
+// .db extension
+func storePath() string {
+	path := statePath()
+	return strings.TrimSuffix(path, filepath.Ext(path)) + ".db"
+}
+
+// openStore opens the bolt store, creating and migrating it as needed.
+// bbolt locks the file, so processes sharing it take turns.
+func openStore() (*bolt.DB, error) {
+	path := storePath()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create state directory: %w", err)
+	}
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: 10 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open state store %s: %w", path, err)
+	}
+	if err := db.Update(migrateStore); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to migrate state store %s: %w", path, err)
+	}
+	return db, nil
+}
+
+// migrateStore upgrades the store to storeVersion
+func migrateStore(tx *bolt.Tx) error {
+	meta, err := tx.CreateBucketIfNotExists(bucketMeta)
+	if err != nil {
+		return err
+	}
+	version := 0
+	if v := meta.Get([]byte("version")); v != nil {
+		if version, err = strconv.Atoi(string(v)); err != nil {
+			return fmt.Errorf("invalid version %q", v)
+		}
+	}
+	if version > storeVersion {
+		return fmt.Errorf("version %d is newer than this protect-wan supports (%d)", version, storeVersion)
+	}
+	for ; version < storeVersion; version++ {
+		if err := storeMigrations[version](tx); err != nil {
+			return err
+		}
+	}
+	return meta.Put([]byte("version"), []byte(strconv.Itoa(version)))
+}
+
+// dayKey and usageKey return the bucket and key of a record
+func dayKey(d dayRecord) (bucket []byte, key string) {
+	return bucketDays, d.Day
+}
+
+func usageKey(u usageRecord) (bucket []byte, key string) {
+	return bucketUsage, u.Day + "/" + string(u.Node)
+}
+
+// storedKey joins a bucket and key for state.stored
+func storedKey(bucket []byte, key string) string {
+	return string(bucket) + "/" + key
+}
+
+// loadStore reads the day and usage records from the store into st.
+// Records still in the state file, from before --state-store bolt, are
+// kept and move to the store with the next save.
+func (st *state) loadStore() error {
+	db, err := openStore()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	stored := make(map[string][]byte)
+	var days []dayRecord
+	var usage []usageRecord
+	err = db.View(func(tx *bolt.Tx) error {
+		err := tx.Bucket(bucketDays).ForEach(func(k, v []byte) error {
+			var d dayRecord
+			if err := json.Unmarshal(v, &d); err != nil {
+				return fmt.Errorf("invalid day %s: %w", k, err)
+			}
+			days = append(days, d)
+			stored[storedKey(bucketDays, string(k))] = bytes.Clone(v)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		return tx.Bucket(bucketUsage).ForEach(func(k, v []byte) error {
+			var u usageRecord
+			if err := json.Unmarshal(v, &u); err != nil {
+				return fmt.Errorf("invalid usage %s: %w", k, err)
+			}
+			usage = append(usage, u)
+			stored[storedKey(bucketUsage, string(k))] = bytes.Clone(v)
+			return nil
+		})
+	})
+	if err != nil {
+		return fmt.Errorf("failed to read state store %s: %w", storePath(), err)
+	}
+
+	for _, d := range st.Days {
+		if _, ok := stored[storedKey(dayKey(d))]; !ok {
+			days = append(days, d)
+		}
+	}
+	for _, u := range st.Usage {
+		if _, ok := stored[storedKey(usageKey(u))]; !ok {
+			usage = append(usage, u)
+		}
+	}
+	st.Days, st.Usage, st.stored = days, usage, stored
+	return nil
+}
+
+// saveStore writes the day and usage records of st that changed since
+// loadStore, and deletes the ones dropped, e.g. by pruning
+func (st *state) saveStore() error {
+	records := make(map[string][]byte, len(st.Days)+len(st.Usage))
+	for _, d := range st.Days {
+		data, err := json.Marshal(d)
+		if err != nil {
+			return err
+		}
+		records[storedKey(dayKey(d))] = data
+	}
+	for _, u := range st.Usage {
+		data, err := json.Marshal(u)
+		if err != nil {
+			return err
+		}
+		records[storedKey(usageKey(u))] = data
+	}
+
+	db, err := openStore()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	err = db.Update(func(tx *bolt.Tx) error {
+		for k, data := range records {
+			if old, ok := st.stored[k]; ok && bytes.Equal(old, data) {
+				continue
+			}
+			bucket, key, _ := strings.Cut(k, "/")
+			if err := tx.Bucket([]byte(bucket)).Put([]byte(key), data); err != nil {
+				return err
+			}
+		}
+		for k := range st.stored {
+			if _, ok := records[k]; ok {
+				continue
+			}
+			bucket, key, _ := strings.Cut(k, "/")
+			if err := tx.Bucket([]byte(bucket)).Delete([]byte(key)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write state store %s: %w", storePath(), err)
+	}
+	st.stored = records
+	return nil
+}
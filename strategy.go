@@ -0,0 +1,280 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"math/rand/v2"
+	"sort"
+	"strings"
+)
+
+var (
+	strategyFlag = flag.String("strategy", "latency", "Selection strategy: latency, priority, suggest, random, preferred-list, weighted, exec")
+	seedFlag     = flag.Uint64("seed", 0, "Seed for the random and weighted strategies, for reproducible choices (0 for a random seed)")
+	preferFlag   stringList
+
+	compareSuggestFlag = flag.Bool("compare-suggest", false, "After selection, report whether Tailscale's suggested exit node agrees, with both latencies")
+)
+
+func init() {
+	flag.Var(&preferFlag, "prefer", "Preferred node for --strategy preferred-list: hostname, city code or country code, in order (repeatable)")
+}
+
+// Strategy ranks the online candidate nodes, best first
+type Strategy interface {
+	Rank(ctx context.Context, lc LocalClient, nodes []MullvadNode) ([]MullvadNode, error)
+}
+
+// strategyFunc adapts a function to the Strategy interface
+type strategyFunc func(ctx context.Context, lc LocalClient, nodes []MullvadNode) ([]MullvadNode, error)
+
+func (f strategyFunc) Rank(ctx context.Context, lc LocalClient, nodes []MullvadNode) ([]MullvadNode, error) {
+	return f(ctx, lc, nodes)
+}
+
+// strategies holds all selection strategies by --strategy name
+var strategies = map[string]Strategy{
+	"latency":        strategyFunc(rankByLatency),
+	"priority":       strategyFunc(rankByPriority),
+	"suggest":        strategyFunc(rankBySuggestion),
+	"random":         strategyFunc(rankRandom),
+	"preferred-list": strategyFunc(rankByPreferredList),
+	"weighted":       strategyFunc(rankWeighted),
+	"exec":           strategyFunc(rankByScorer),
+}
+
+// selectedStrategy returns the Strategy chosen by --strategy
+// --prefer-priority is kept as a shorthand for --strategy priority
+func selectedStrategy() (string, Strategy, error) {
+	name := strings.ToLower(*strategyFlag)
+	if *preferPriorityFlag {
+		name = "priority"
+	}
+	s, ok := strategies[name]
+	if !ok {
+		return "", nil, fmt.Errorf("unknown strategy %q", *strategyFlag)
+	}
+	if name == "preferred-list" && len(preferFlag) == 0 {
+		return "", nil, fmt.Errorf("--strategy preferred-list requires at least one --prefer")
+	}
+	if name == "exec" && strings.TrimSpace(*scorerFlag) == "" {
+		return "", nil, fmt.Errorf("--strategy exec requires --scorer")
+	}
+	if name == "exec" {
+		if _, err := splitCommand(*scorerFlag); err != nil {
+			return "", nil, fmt.Errorf("invalid --scorer: %w", err)
+		}
+	}
+	return name, s, nil
+}
+
+// rankByLatency measures latency and ranks the fastest node first
+func rankByLatency(ctx context.Context, lc LocalClient, nodes []MullvadNode) ([]MullvadNode, error) {
+	if !*verboseFlag {
+		fmt.Println("Testing latency to find the fastest node...")
+	}
+	explain.note("Ranked by measured latency (two-phase, ping types %s)", *pingTypeFlag)
+	return selectByLatency(ctx, lc, nodes)
+}
+
+// rankByPriority keeps the Tailscale priority order, no probing
+func rankByPriority(ctx context.Context, lc LocalClient, nodes []MullvadNode) ([]MullvadNode, error) {
+	explain.note("Ranked by Tailscale priority only, no latency testing")
+	return nodes, nil
+}
+
+// rankBySuggestion puts the node suggested by tailscaled first, followed
+// by the rest in priority order
+func rankBySuggestion(ctx context.Context, lc LocalClient, nodes []MullvadNode) ([]MullvadNode, error) {
+	if err := requireTailscale(ctx, lc, featureSuggest); err != nil {
+		return nil, err
+	}
+	suggestion, err := lc.SuggestExitNode(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get exit node suggestion: %w", err)
+	}
+
+	for i, node := range nodes {
+		if node.ID == suggestion.ID {
+			explain.note("Ranked by Tailscale's exit node suggestion (%s), rest by priority", suggestion.Name)
+			ranked := append([]MullvadNode{node}, nodes[:i]...)
+			return append(ranked, nodes[i+1:]...), nil
+		}
+	}
+	return nil, fmt.Errorf("suggested exit node %s is not an eligible Mullvad node", suggestion.Name)
+}
+
+// selectionSeed is the seed of the last random or weighted ranking, 0 if
+// none was made
+var selectionSeed uint64
+
+// seededRand returns the random source of a ranking, seeded with --seed or
+// else a fresh random seed. The seed is remembered in selectionSeed so the
+// choice can be replayed.
+func seededRand() *rand.Rand {
+	seed := *seedFlag
+	for seed == 0 {
+		seed = rand.Uint64()
+	}
+	selectionSeed = seed
+	return rand.New(rand.NewPCG(seed, seed))
+}
+
+// rankRandom shuffles the nodes, spreading load without any probing
+func rankRandom(ctx context.Context, lc LocalClient, nodes []MullvadNode) ([]MullvadNode, error) {
+	rng := seededRand()
+	explain.note("Ranked in random order (seed %d)", selectionSeed)
+	ranked := append([]MullvadNode(nil), nodes...)
+	rng.Shuffle(len(ranked), func(i, j int) {
+		ranked[i], ranked[j] = ranked[j], ranked[i]
+	})
+	return ranked, nil
+}
+
+// rankByPreferredList ranks nodes matching a --prefer entry first, in the
+// order of the entries and by latency within an entry. The remaining nodes
+// follow in priority order as a fallback.
+func rankByPreferredList(ctx context.Context, lc LocalClient, nodes []MullvadNode) ([]MullvadNode, error) {
+	entry := make(map[string]int)
+	var preferred, rest []MullvadNode
+	for _, node := range nodes {
+		i := preferenceIndex(node)
+		if i < 0 {
+			rest = append(rest, node)
+			continue
+		}
+		entry[node.DNSName] = i
+		preferred = append(preferred, node)
+	}
+
+	if len(preferred) == 0 {
+		explain.note("No online node matches --prefer %s, ranked by priority", strings.Join(preferFlag, ","))
+		return nodes, nil
+	}
+
+	byEntry := func(nodes []MullvadNode) {
+		sort.SliceStable(nodes, func(i, j int) bool {
+			return entry[nodes[i].DNSName] < entry[nodes[j].DNSName]
+		})
+	}
+
+	explain.note("Ranked by --prefer %s, latency within each entry", strings.Join(preferFlag, ","))
+	if ranked, err := selectByLatency(ctx, lc, preferred); err == nil {
+		// Preferred nodes that didn't answer, or weren't probed, still
+		// come before the rest, in the order of the entries
+		var unranked []MullvadNode
+		for _, node := range preferred {
+			if nodeIndex(ranked, node.ID) < 0 {
+				unranked = append(unranked, node)
+			}
+		}
+		byEntry(ranked)
+		byEntry(unranked)
+		preferred = append(ranked, unranked...)
+	} else {
+		explain.note("Latency testing failed (%v), preferred nodes kept in priority order", err)
+		byEntry(preferred)
+	}
+
+	return append(preferred, rest...), nil
+}
+
+// preferenceIndex returns the index of the first --prefer entry matching
+// the node's hostname, city code or country code, or -1
+func preferenceIndex(node MullvadNode) int {
+	name := strings.TrimSuffix(node.DNSName, ".")
+	for i, p := range preferFlag {
+		if strings.EqualFold(p, name) ||
+			strings.EqualFold(p, mullvadHostname(node)) ||
+			strings.EqualFold(p, node.CityCode) ||
+			strings.EqualFold(p, node.CountryCode) {
+			return i
+		}
+	}
+	return -1
+}
+
+// rankWeighted measures latency, then orders the nodes by weighted random
+// draws where faster nodes are proportionally more likely to come first.
+// Spreads many clients over the good nodes instead of piling onto one.
+func rankWeighted(ctx context.Context, lc LocalClient, nodes []MullvadNode) ([]MullvadNode, error) {
+	ranked, err := rankByLatency(ctx, lc, nodes)
+	if err != nil {
+		return nil, err
+	}
+	rng := seededRand()
+	explain.note("Weighted random order, weight 1/latency (seed %d)", selectionSeed)
+
+	weights := make([]float64, len(ranked))
+	total := 0.0
+	for i, node := range ranked {
+		weights[i] = 1 / max(float64(node.Latency.Milliseconds()), 1)
+		total += weights[i]
+	}
+
+	var result []MullvadNode
+	for len(ranked) > 0 {
+		r := rng.Float64() * total
+		i := 0
+		for ; i < len(ranked)-1; i++ {
+			r -= weights[i]
+			if r < 0 {
+				break
+			}
+		}
+		result = append(result, ranked[i])
+		total -= weights[i]
+		ranked = append(ranked[:i], ranked[i+1:]...)
+		weights = append(weights[:i], weights[i+1:]...)
+	}
+	return result, nil
+}
+
+// compareWithSuggestion reports how the selected node compares with the
+// exit node tailscaled suggests, to build confidence in (or catch
+// regressions of) our own selection
+func compareWithSuggestion(ctx context.Context, lc LocalClient, candidates []MullvadNode) {
+	if err := requireTailscale(ctx, lc, featureSuggest); err != nil {
+		fmt.Printf("Tailscale suggestion unavailable: %v\n", err)
+		return
+	}
+	suggestion, err := lc.SuggestExitNode(ctx)
+	if err != nil {
+		fmt.Printf("Tailscale suggestion unavailable: %v\n", err)
+		return
+	}
+	ours := candidates[0]
+	if suggestion.ID == ours.ID {
+		fmt.Printf("Tailscale suggests the same node (%s)\n", displayName(ours))
+		return
+	}
+
+	var theirs MullvadNode
+	found := false
+	for _, node := range candidates {
+		if node.ID == suggestion.ID {
+			theirs, found = node, true
+			break
+		}
+	}
+	if !found {
+		fmt.Printf("Tailscale suggests %s, which is not among our candidates\n", strings.TrimSuffix(suggestion.Name, "."))
+		return
+	}
+
+	// Candidates not probed during selection get one ping now
+	if theirs.Latency == 0 && ours.Latency > 0 {
+		if p, err := newProber(lc); err == nil {
+			if latency, err := p.ping(ctx, theirs); err == nil {
+				theirs.Latency = latency
+			}
+		}
+	}
+
+	fmt.Printf("Tailscale suggests %s (%s, %s) instead", displayName(theirs), theirs.City, theirs.CountryCode)
+	if ours.Latency > 0 && theirs.Latency > 0 {
+		fmt.Printf(" - Latency: %dms vs. our %dms", theirs.Latency.Milliseconds(), ours.Latency.Milliseconds())
+	}
+	fmt.Println()
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/netip"
+	"os"
+	"strings"
+
+	"tailscale.com/ipn"
+)
+
+var subnetConflictsFlag = flag.String("subnet-conflicts", "warn", "When subnet routes conflict with using an exit node: off, warn, adjust (allow LAN access where that fixes it) or fail")
+
+// routeConflict is a combination of subnet routes and exit node use that
+// breaks connectivity
+type routeConflict struct {
+	msg       string
+	lanAccess bool // Fixed by ExitNodeAllowLANAccess
+}
+
+// subnetConflictsWarned are the conflicts already warned about, to warn
+// once per process rather than on every daemon selection
+var subnetConflictsWarned = make(map[string]bool)
+
+// subnetRouteConflicts finds subnet routes this device advertises or
+// accepts that don't mix with an exit node:
+//   - advertising an exit node route, which tailscaled refuses while using one
+//   - advertising a local network without LAN access: the exit node takes
+//     the traffic to it, so this subnet router can't reach its own subnet
+//   - accepting a route that overlaps a local network, which it takes over
+//   - accepting a route that overlaps an advertised one, a routing loop
+//     between the two subnet routers
+func subnetRouteConflicts(ctx context.Context, lc LocalClient) ([]routeConflict, error) {
+	prefs, err := lc.GetPrefs(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get prefs: %w", err)
+	}
+	local := localAddrs()
+
+	var conflicts []routeConflict
+	var advertised []netip.Prefix
+	for _, r := range prefs.AdvertiseRoutes {
+		if r.Bits() == 0 {
+			conflicts = append(conflicts, routeConflict{msg: fmt.Sprintf(
+				"this device advertises itself as an exit node (%s), and tailscaled won't use an exit node at the same time", r)})
+			continue
+		}
+		advertised = append(advertised, r)
+		if l, ok := overlapping(r, local); ok && !prefs.ExitNodeAllowLANAccess {
+			conflicts = append(conflicts, routeConflict{lanAccess: true, msg: fmt.Sprintf(
+				"advertised subnet route %s covers the local network %s, which an exit node without LAN access makes unreachable for this device and its subnet clients", r, l.Masked())})
+		}
+	}
+
+	if !prefs.RouteAll {
+		return conflicts, nil
+	}
+	status, err := lc.Status(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get status: %w", err)
+	}
+	for _, peer := range status.Peer {
+		if peer.PrimaryRoutes == nil {
+			continue
+		}
+		for i := range peer.PrimaryRoutes.Len() {
+			r := peer.PrimaryRoutes.At(i)
+			if r.Bits() == 0 {
+				continue
+			}
+			name := strings.TrimSuffix(peer.DNSName, ".")
+			if l, ok := overlapping(r, local); ok {
+				conflicts = append(conflicts, routeConflict{msg: fmt.Sprintf(
+					"subnet route %s accepted from %s overlaps the local network %s, which becomes unreachable", r, name, l.Masked())})
+			}
+			if a, ok := overlapping(r, advertised); ok {
+				conflicts = append(conflicts, routeConflict{msg: fmt.Sprintf(
+					"subnet route %s accepted from %s overlaps the advertised route %s, traffic may loop between the subnet routers", r, name, a)})
+			}
+		}
+	}
+	return conflicts, nil
+}
+
+// overlapping returns the first prefix of list that overlaps r
+func overlapping(r netip.Prefix, list []netip.Prefix) (netip.Prefix, bool) {
+	for _, p := range list {
+		if r.Overlaps(p.Masked()) {
+			return p, true
+		}
+	}
+	return netip.Prefix{}, false
+}
+
+// allowLANAccess turns on ExitNodeAllowLANAccess
+func allowLANAccess(ctx context.Context, lc LocalClient) error {
+	mp := &ipn.MaskedPrefs{
+		Prefs:                     ipn.Prefs{ExitNodeAllowLANAccess: true},
+		ExitNodeAllowLANAccessSet: true,
+	}
+	if _, err := lc.EditPrefs(withAuditReason(ctx, "--subnet-conflicts adjust"), mp); err != nil {
+		return handlePermissionError(err, "allow LAN access")
+	}
+	return nil
+}
+
+// checkSubnetRoutes applies --subnet-conflicts before an exit node is set
+func checkSubnetRoutes(ctx context.Context, lc LocalClient) error {
+	if *subnetConflictsFlag == "off" {
+		return nil
+	}
+	conflicts, err := subnetRouteConflicts(ctx, lc)
+	if err != nil {
+		if *verboseFlag {
+			fmt.Printf("Could not check subnet routes: %v\n", err)
+		}
+		return nil
+	}
+	if len(conflicts) == 0 {
+		return nil
+	}
+
+	if *subnetConflictsFlag == "fail" {
+		var msgs []string
+		for _, c := range conflicts {
+			msgs = append(msgs, c.msg)
+		}
+		return fmt.Errorf("subnet routes conflict with using an exit node (--subnet-conflicts fail): %s", strings.Join(msgs, "; "))
+	}
+
+	adjusted := false
+	for _, c := range conflicts {
+		if c.lanAccess && *subnetConflictsFlag == "adjust" {
+			if !adjusted {
+				if err := allowLANAccess(ctx, lc); err != nil {
+					return err
+				}
+				adjusted = true
+			}
+			fmt.Fprintf(os.Stderr, "Allowed LAN access for the exit node: %s\n", c.msg)
+			continue
+		}
+		if !subnetConflictsWarned[c.msg] {
+			fmt.Fprintf(os.Stderr, "Warning: %s\n", c.msg)
+			subnetConflictsWarned[c.msg] = true
+		}
+	}
+	return nil
+}
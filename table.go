@@ -0,0 +1,118 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+var (
+	wideFlag  = flag.Bool("wide", false, "With list and status, show more columns and details (latency, relay attributes, IPs)")
+	shortFlag = flag.Bool("short", false, "With list and status, compact output for narrow terminals")
+)
+
+// defaultWidth is assumed when stdout is not a terminal
+const defaultWidth = 80
+
+// minColumnWidth is how narrow a truncated column may get
+const minColumnWidth = 12
+
+// terminalWidth returns the width to lay out tables for: $COLUMNS, else
+// the terminal's, else defaultWidth
+func terminalWidth() int {
+	if n, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil && n > 0 {
+		return n
+	}
+	if n, ok := ttyWidth(); ok {
+		return n
+	}
+	return defaultWidth
+}
+
+// table is a text table whose first column is truncated when it doesn't
+// fit the terminal. Columns are sized to their contents.
+type table struct {
+	headers []string
+	rows    [][]string
+	right   map[int]bool // Right-aligned columns
+}
+
+// newTable creates a table with translated headers
+func newTable(headers ...string) *table {
+	translated := make([]string, len(headers))
+	for i, h := range headers {
+		translated[i] = tr(h)
+	}
+	return &table{headers: translated, right: make(map[int]bool)}
+}
+
+func (t *table) add(cells ...string) {
+	t.rows = append(t.rows, cells)
+}
+
+// widths returns the natural column widths and the total line width
+func (t *table) widths() ([]int, int) {
+	widths := make([]int, len(t.headers))
+	for _, row := range append([][]string{t.headers}, t.rows...) {
+		for i, cell := range row {
+			widths[i] = max(widths[i], utf8.RuneCountInString(cell))
+		}
+	}
+	total := 2 * (len(widths) - 1)
+	for _, w := range widths {
+		total += w
+	}
+	return widths, total
+}
+
+// fits reports whether the table fits into width untruncated
+func (t *table) fits(width int) bool {
+	_, total := t.widths()
+	return total <= width
+}
+
+// print writes the table with a dashed rule under the headers, fitting it
+// into width by truncating the first column. A width of 0 never truncates.
+func (t *table) print(width int) {
+	widths, total := t.widths()
+	if over := total - width; width > 0 && over > 0 && widths[0] > minColumnWidth {
+		shrunk := max(widths[0]-over, minColumnWidth)
+		total -= widths[0] - shrunk
+		widths[0] = shrunk
+	}
+
+	line := func(cells []string) {
+		parts := make([]string, len(cells))
+		for i, cell := range cells {
+			cell = truncate(cell, widths[i])
+			pad := strings.Repeat(" ", widths[i]-utf8.RuneCountInString(cell))
+			if t.right[i] {
+				parts[i] = pad + cell
+			} else if i < len(cells)-1 {
+				parts[i] = cell + pad
+			} else {
+				parts[i] = cell
+			}
+		}
+		fmt.Println(strings.TrimRight(strings.Join(parts, "  "), " "))
+	}
+
+	fmt.Println(strings.Repeat("-", total))
+	line(t.headers)
+	fmt.Println(strings.Repeat("-", total))
+	for _, row := range t.rows {
+		line(row)
+	}
+}
+
+// truncate shortens s to n runes, marking the cut with an ellipsis
+func truncate(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	r := []rune(s)
+	return string(r[:n-1]) + "…"
+}
@@ -0,0 +1,8 @@
+//go:build !linux && !darwin && !windows
+
+package main
+
+// ttyWidth is not implemented on this platform
+func ttyWidth() (int, bool) {
+	return 0, false
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+
+	"tailscale.com/tailcfg"
+)
+
+var topIntervalFlag = flag.Duration("top-interval", 2*time.Second, "Refresh interval of the top command")
+
+// topDefaultNodes is how many nodes top watches without places or
+// location filters, closest by Tailscale priority first
+const topDefaultNodes = 20
+
+func init() {
+	commands["top"] = command{
+		Usage: "Live table of candidate nodes and their rolling latencies: top [place...]",
+		Run:   runTop,
+	}
+}
+
+// topRow is one watched node
+type topRow struct {
+	node  MullvadNode
+	stats *pingStats
+}
+
+// runTop pings the watched nodes every --top-interval until interrupted and
+// redraws a table sorted by average latency. Places (countries or cities)
+// pick the nodes; without them, --country and --region apply.
+func runTop(ctx context.Context, lc LocalClient, args []string) error {
+	nodes, err := getMullvadNodes(ctx, lc)
+	if err != nil {
+		return err
+	}
+	watched, err := topNodes(nodes, args)
+	if err != nil {
+		return err
+	}
+	p, err := newProber(lc)
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	rows := make([]*topRow, len(watched))
+	for i, node := range watched {
+		rows[i] = &topRow{node: node, stats: &pingStats{window: max(1, *monitorWindowFlag)}}
+	}
+	tty := isTerminal(os.Stdout)
+	start := time.Now()
+
+	ticker := time.NewTicker(*topIntervalFlag)
+	defer ticker.Stop()
+	for {
+		current, _, _ := currentExitNode(ctx, lc)
+		pingRows(ctx, p, rows)
+		if ctx.Err() != nil {
+			return nil
+		}
+		renderTop(rows, current.ID, start, tty)
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// topNodes resolves the nodes to watch: the top priority online nodes of
+// each place, or of the --country/--region selection
+func topNodes(nodes []MullvadNode, places []string) ([]MullvadNode, error) {
+	if len(places) == 0 {
+		filtered, err := filterByLocation(nodes)
+		if err != nil {
+			return nil, err
+		}
+		var online []MullvadNode
+		for _, n := range filtered {
+			if n.Online {
+				online = append(online, n)
+			}
+		}
+		if len(online) == 0 {
+			return nil, errors.New("no online Mullvad exit nodes found")
+		}
+		return online[:min(len(online), topDefaultNodes)], nil
+	}
+
+	var watched []MullvadNode
+	seen := make(map[tailcfg.StableNodeID]bool)
+	for _, place := range places {
+		targets, err := latencyTargets(nodes, place)
+		if err != nil {
+			return nil, err
+		}
+		for _, n := range targets {
+			if !seen[n.ID] {
+				seen[n.ID] = true
+				watched = append(watched, n)
+			}
+		}
+	}
+	return watched, nil
+}
+
+// pingRows pings every row once, at most --parallel at a time. Unlike
+// pingAll, it never stops early and leaves the quarantine alone.
+func pingRows(ctx context.Context, p *prober, rows []*topRow) {
+	sem := make(chan struct{}, max(1, *parallelFlag))
+	var wg sync.WaitGroup
+	for _, r := range rows {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			latency, err := p.ping(ctx, r.node)
+			if ctx.Err() != nil {
+				return
+			}
+			r.stats.add(latency, err)
+		}()
+	}
+	wg.Wait()
+}
+
+// renderTop redraws the table on a terminal, or prints it once per round
+// otherwise. Rows are sorted by average latency, unanswered nodes last.
+func renderTop(rows []*topRow, current tailcfg.StableNodeID, start time.Time, tty bool) {
+	type line struct {
+		r                              *topRow
+		loss                           float64
+		last, avg, best, worst, jitter time.Duration
+	}
+	lines := make([]line, len(rows))
+	for i, r := range rows {
+		l := line{r: r}
+		l.loss, l.last, l.avg, l.best, l.worst, l.jitter = r.stats.summary()
+		lines[i] = l
+	}
+	sort.SliceStable(lines, func(i, j int) bool {
+		a, b := lines[i].avg, lines[j].avg
+		if (a == 0) != (b == 0) {
+			return b == 0
+		}
+		return a < b
+	})
+
+	if tty {
+		fmt.Print("\033[H\033[2J")
+	} else {
+		fmt.Println()
+	}
+	fmt.Printf("protect-wan top: %d nodes, every %s, running %s, Ctrl-C to stop\n\n",
+		len(rows), *topIntervalFlag, time.Since(start).Round(time.Second))
+
+	t := newTable("NODE", "LOCATION", "SENT", "LOSS%", "LAST", "AVG", "BEST", "WORST", "JITTER")
+	for i := 2; i < len(t.headers); i++ {
+		t.right[i] = true
+	}
+	for _, l := range lines {
+		name := displayName(l.r.node)
+		if l.r.node.ID == current {
+			name = "* " + name
+		}
+		t.add(name, fmt.Sprintf("%s, %s", l.r.node.City, l.r.node.CountryCode), fmt.Sprint(l.r.stats.sent),
+			fmt.Sprintf("%.1f", l.loss), ms(l.last), ms(l.avg), ms(l.best), ms(l.worst), ms(l.jitter))
+	}
+	t.print(terminalWidth())
+	fmt.Printf("\n* active exit node. Statistics cover the last %d rounds\n", max(1, *monitorWindowFlag))
+}
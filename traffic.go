@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"tailscale.com/tailcfg"
+)
+
+// trafficSample is a reading of the exit node's byte counters, taken by
+// the daemon on every check
+type trafficSample struct {
+	Node    tailcfg.StableNodeID `json:"node"`
+	Time    time.Time            `json:"time"`
+	RxBytes int64                `json:"rx_bytes"`
+	TxBytes int64                `json:"tx_bytes"`
+	RxRate  float64              `json:"rx_rate"` // Bytes per second since the previous sample
+	TxRate  float64              `json:"tx_rate"`
+}
+
+// peerCounters returns the bytes received from and sent to peer id
+func peerCounters(ctx context.Context, lc LocalClient, id tailcfg.StableNodeID) (rx, tx int64, err error) {
+	status, err := lc.Status(ctx)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to get status: %w", err)
+	}
+	for _, peer := range status.Peer {
+		if peer.ID == id {
+			return peer.RxBytes, peer.TxBytes, nil
+		}
+	}
+	return 0, 0, fmt.Errorf("peer %s not found", id)
+}
+
+// sampleTraffic records the active exit node's byte counters, the rates
+// since the previous sample and the node's data usage. Counters restart
+// with tailscaled, so a decrease leaves the rates unknown (0) for one
+// sample and counts the new counters as usage.
+func sampleTraffic(ctx context.Context, lc LocalClient) {
+	if !stateEnabled() {
+		return
+	}
+	node, ok, err := currentExitNode(ctx, lc)
+	if err != nil || !ok {
+		return
+	}
+	rx, tx, err := peerCounters(ctx, lc, node.ID)
+	if err != nil {
+		return
+	}
+
+	sample := &trafficSample{Node: node.ID, Time: time.Now(), RxBytes: rx, TxBytes: tx}
+	err = updateState(func(st *state) {
+		prev := st.Traffic
+		switch {
+		case prev == nil || prev.Node != node.ID:
+			// Traffic before the first sample of a node is unattributed
+		case rx < prev.RxBytes || tx < prev.TxBytes:
+			st.addUsage(node, sample.Time, rx, tx)
+		default:
+			st.addUsage(node, sample.Time, rx-prev.RxBytes, tx-prev.TxBytes)
+			if elapsed := sample.Time.Sub(prev.Time).Seconds(); elapsed > 0 {
+				sample.RxRate = float64(rx-prev.RxBytes) / elapsed
+				sample.TxRate = float64(tx-prev.TxBytes) / elapsed
+			}
+		}
+		st.Traffic = sample
+	})
+	if err != nil {
+		log.Printf("Warning: failed to update state: %v", err)
+	}
+}
+
+// formatBytes formats a byte count with a binary unit
+func formatBytes(n float64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%.0f B", n)
+	}
+	div, exp := float64(unit), 0
+	for n/div >= unit && exp < 4 {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", n/div, "KMGTP"[exp])
+}
@@ -0,0 +1,222 @@
+package main
+
+// translations maps a language to the translations of user-facing
+// messages, keyed by the English message. A message missing from a
+// language is shown in English.
+var translations = map[string]map[string]string{
+	"de": {
+		// Protection status
+		"WAN is protected":                                                    "WAN ist geschützt",
+		"WAN is protected - Latency: %dms":                                    "WAN ist geschützt - Latenz: %dms",
+		"WAN is protected but the exit node is unreachable: %v":               "WAN ist geschützt, aber der Exit-Node ist nicht erreichbar: %v",
+		"WAN is protected but slow: %s latency %dms exceeds --max-latency %s": "WAN ist geschützt, aber langsam: %s Latenz %dms überschreitet --max-latency %s",
+		"WAN is protected (WireGuard %s)":                                     "WAN ist geschützt (WireGuard %s)",
+		"WAN is now protected via %s (%s, %s)":                                "WAN ist jetzt geschützt über %s (%s, %s)",
+		"WAN is now protected via %s (%s, %s) - Latency: %dms":                "WAN ist jetzt geschützt über %s (%s, %s) - Latenz: %dms",
+		"No exit node active":                                                 "Kein Exit-Node aktiv",
+		"No exit node active (trusted network: %s)":                           "Kein Exit-Node aktiv (vertrauenswürdiges Netzwerk: %s)",
+		"No exit node active: captive portal detected":                        "Kein Exit-Node aktiv: Captive Portal erkannt",
+		"No exit node active. Auto-selecting best Mullvad node...":            "Kein Exit-Node aktiv. Bester Mullvad-Node wird ausgewählt...",
+		"Trusted network (%s): exit node not enforced":                        "Vertrauenswürdiges Netzwerk (%s): Exit-Node nicht erzwungen",
+		"Log in at %s, then run %s again":                                     "Melde dich unter %s an und führe %s dann erneut aus",
+		"Exit node set to: %s":                                                "Exit-Node gesetzt: %s",
+		"Exit node disabled successfully":                                     "Exit-Node deaktiviert",
+		"Exit node: %s (%s, %s)":                                              "Exit-Node: %s (%s, %s)",
+		"Protection paused %s":                                                "Schutz pausiert %s",
+		"Tailscale unavailable: %s":                                           "Tailscale nicht verfügbar: %s",
+
+		// Errors
+		"Error auto-selecting Mullvad node": "Fehler bei der Auswahl eines Mullvad-Nodes",
+		"Error checking exit node":          "Fehler beim Prüfen des Exit-Nodes",
+		"Error listing Mullvad nodes":       "Fehler beim Auflisten der Mullvad-Nodes",
+		"Error setting exit node":           "Fehler beim Setzen des Exit-Nodes",
+		"Error checking WireGuard tunnel":   "Fehler beim Prüfen des WireGuard-Tunnels",
+		"Error setting WireGuard tunnel":    "Fehler beim Setzen des WireGuard-Tunnels",
+		"Error running %s":                  "Fehler bei %s",
+
+		// Tray and menu bar
+		"protect-wan: unprotected":           "protect-wan: ungeschützt",
+		"protect-wan: paused %s":             "protect-wan: pausiert %s",
+		"protect-wan: protected via %s (%s)": "protect-wan: geschützt über %s (%s)",
+		"Paused":                             "Pausiert",
+		"Unprotected":                        "Ungeschützt",
+		"Country":                            "Land",
+		"Country: automatic":                 "Land: automatisch",
+		"Country: %s":                        "Land: %s",
+		"Automatic":                          "Automatisch",
+		"Best anywhere":                      "Bester weltweit",
+		"Pause protection":                   "Schutz pausieren",
+		"Pause for 1 hour":                   "1 Stunde pausieren",
+		"Resume protection":                  "Schutz fortsetzen",
+		"Disable exit node":                  "Exit-Node deaktivieren",
+		"Refresh":                            "Aktualisieren",
+		"Quit":                               "Beenden",
+
+		// Table headers
+		"LOCATION":   "STANDORT",
+		"LOC":        "ORT",
+		"PROBE":      "TEST",
+		"PROFILE":    "PROFIL",
+		"RESULT":     "ERGEBNIS",
+		"DETAIL":     "DETAILS",
+		"LAST":       "LETZTE",
+		"BEST":       "BESTE",
+		"WORST":      "SCHLECHTESTE",
+		"AVG":        "MITTEL",
+		"SENT":       "GESENDET",
+		"LOSS%":      "VERLUST%",
+		"PRIORITY":   "PRIORITÄT",
+		"LATENCY":    "LATENZ",
+		"PATH":       "PFAD",
+		"PROVIDER":   "ANBIETER",
+		"ATTRIBUTES": "MERKMALE",
+		"UNTIL":      "BIS",
+	},
+
+	"es": {
+		// Protection status
+		"WAN is protected":                                                    "La WAN está protegida",
+		"WAN is protected - Latency: %dms":                                    "La WAN está protegida - Latencia: %dms",
+		"WAN is protected but the exit node is unreachable: %v":               "La WAN está protegida, pero el nodo de salida no responde: %v",
+		"WAN is protected but slow: %s latency %dms exceeds --max-latency %s": "La WAN está protegida, pero lenta: la latencia de %s, %dms, supera --max-latency %s",
+		"WAN is protected (WireGuard %s)":                                     "La WAN está protegida (WireGuard %s)",
+		"WAN is now protected via %s (%s, %s)":                                "La WAN está protegida ahora a través de %s (%s, %s)",
+		"WAN is now protected via %s (%s, %s) - Latency: %dms":                "La WAN está protegida ahora a través de %s (%s, %s) - Latencia: %dms",
+		"No exit node active":                                                 "Ningún nodo de salida activo",
+		"No exit node active (trusted network: %s)":                           "Ningún nodo de salida activo (red de confianza: %s)",
+		"No exit node active: captive portal detected":                        "Ningún nodo de salida activo: portal cautivo detectado",
+		"No exit node active. Auto-selecting best Mullvad node...":            "Ningún nodo de salida activo. Seleccionando el mejor nodo de Mullvad...",
+		"Trusted network (%s): exit node not enforced":                        "Red de confianza (%s): no se impone un nodo de salida",
+		"Log in at %s, then run %s again":                                     "Inicia sesión en %s y vuelve a ejecutar %s",
+		"Exit node set to: %s":                                                "Nodo de salida establecido: %s",
+		"Exit node disabled successfully":                                     "Nodo de salida desactivado",
+		"Exit node: %s (%s, %s)":                                              "Nodo de salida: %s (%s, %s)",
+		"Protection paused %s":                                                "Protección en pausa %s",
+		"Tailscale unavailable: %s":                                           "Tailscale no disponible: %s",
+
+		// Errors
+		"Error auto-selecting Mullvad node": "Error al seleccionar un nodo de Mullvad",
+		"Error checking exit node":          "Error al comprobar el nodo de salida",
+		"Error listing Mullvad nodes":       "Error al listar los nodos de Mullvad",
+		"Error setting exit node":           "Error al establecer el nodo de salida",
+		"Error checking WireGuard tunnel":   "Error al comprobar el túnel WireGuard",
+		"Error setting WireGuard tunnel":    "Error al establecer el túnel WireGuard",
+		"Error running %s":                  "Error al ejecutar %s",
+
+		// Tray and menu bar
+		"protect-wan: unprotected":           "protect-wan: sin protección",
+		"protect-wan: paused %s":             "protect-wan: en pausa %s",
+		"protect-wan: protected via %s (%s)": "protect-wan: protegido a través de %s (%s)",
+		"Paused":                             "En pausa",
+		"Unprotected":                        "Sin protección",
+		"Country":                            "País",
+		"Country: automatic":                 "País: automático",
+		"Country: %s":                        "País: %s",
+		"Automatic":                          "Automático",
+		"Best anywhere":                      "El mejor en cualquier país",
+		"Pause protection":                   "Pausar la protección",
+		"Pause for 1 hour":                   "Pausar 1 hora",
+		"Resume protection":                  "Reanudar la protección",
+		"Disable exit node":                  "Desactivar el nodo de salida",
+		"Refresh":                            "Actualizar",
+		"Quit":                               "Salir",
+
+		// Table headers
+		"NAME":       "NOMBRE",
+		"NODE":       "NODO",
+		"LOCATION":   "UBICACIÓN",
+		"LOC":        "UBIC",
+		"ONLINE":     "EN LÍNEA",
+		"PROBE":      "PRUEBA",
+		"PROFILE":    "PERFIL",
+		"RESULT":     "RESULTADO",
+		"DETAIL":     "DETALLE",
+		"LAST":       "ÚLTIMA",
+		"BEST":       "MEJOR",
+		"WORST":      "PEOR",
+		"AVG":        "MEDIA",
+		"SENT":       "ENVIADOS",
+		"LOSS%":      "PÉRDIDA%",
+		"HOSTNAME":   "NOMBRE DE HOST",
+		"PRIORITY":   "PRIORIDAD",
+		"LATENCY":    "LATENCIA",
+		"PATH":       "RUTA",
+		"PROVIDER":   "PROVEEDOR",
+		"ATTRIBUTES": "ATRIBUTOS",
+		"UNTIL":      "HASTA",
+	},
+
+	"fr": {
+		// Protection status
+		"WAN is protected":                                                    "Le WAN est protégé",
+		"WAN is protected - Latency: %dms":                                    "Le WAN est protégé - Latence : %dms",
+		"WAN is protected but the exit node is unreachable: %v":               "Le WAN est protégé, mais le nœud de sortie est injoignable : %v",
+		"WAN is protected but slow: %s latency %dms exceeds --max-latency %s": "Le WAN est protégé, mais lent : la latence de %s, %dms, dépasse --max-latency %s",
+		"WAN is protected (WireGuard %s)":                                     "Le WAN est protégé (WireGuard %s)",
+		"WAN is now protected via %s (%s, %s)":                                "Le WAN est maintenant protégé via %s (%s, %s)",
+		"WAN is now protected via %s (%s, %s) - Latency: %dms":                "Le WAN est maintenant protégé via %s (%s, %s) - Latence : %dms",
+		"No exit node active":                                                 "Aucun nœud de sortie actif",
+		"No exit node active (trusted network: %s)":                           "Aucun nœud de sortie actif (réseau de confiance : %s)",
+		"No exit node active: captive portal detected":                        "Aucun nœud de sortie actif : portail captif détecté",
+		"No exit node active. Auto-selecting best Mullvad node...":            "Aucun nœud de sortie actif. Sélection du meilleur nœud Mullvad...",
+		"Trusted network (%s): exit node not enforced":                        "Réseau de confiance (%s) : nœud de sortie non imposé",
+		"Log in at %s, then run %s again":                                     "Connectez-vous sur %s, puis relancez %s",
+		"Exit node set to: %s":                                                "Nœud de sortie défini : %s",
+		"Exit node disabled successfully":                                     "Nœud de sortie désactivé",
+		"Exit node: %s (%s, %s)":                                              "Nœud de sortie : %s (%s, %s)",
+		"Protection paused %s":                                                "Protection en pause %s",
+		"Tailscale unavailable: %s":                                           "Tailscale indisponible : %s",
+
+		// Errors
+		"Error auto-selecting Mullvad node": "Erreur lors de la sélection d'un nœud Mullvad",
+		"Error checking exit node":          "Erreur lors de la vérification du nœud de sortie",
+		"Error listing Mullvad nodes":       "Erreur lors de la liste des nœuds Mullvad",
+		"Error setting exit node":           "Erreur lors de la définition du nœud de sortie",
+		"Error checking WireGuard tunnel":   "Erreur lors de la vérification du tunnel WireGuard",
+		"Error setting WireGuard tunnel":    "Erreur lors de la définition du tunnel WireGuard",
+		"Error running %s":                  "Erreur lors de l'exécution de %s",
+
+		// Tray and menu bar
+		"protect-wan: unprotected":           "protect-wan : non protégé",
+		"protect-wan: paused %s":             "protect-wan : en pause %s",
+		"protect-wan: protected via %s (%s)": "protect-wan : protégé via %s (%s)",
+		"Paused":                             "En pause",
+		"Unprotected":                        "Non protégé",
+		"Country":                            "Pays",
+		"Country: automatic":                 "Pays : automatique",
+		"Country: %s":                        "Pays : %s",
+		"Automatic":                          "Automatique",
+		"Best anywhere":                      "Le meilleur, tous pays",
+		"Pause protection":                   "Mettre la protection en pause",
+		"Pause for 1 hour":                   "Pause d'une heure",
+		"Resume protection":                  "Reprendre la protection",
+		"Disable exit node":                  "Désactiver le nœud de sortie",
+		"Refresh":                            "Actualiser",
+		"Quit":                               "Quitter",
+
+		// Table headers
+		"NAME":       "NOM",
+		"NODE":       "NŒUD",
+		"LOCATION":   "EMPLACEMENT",
+		"LOC":        "LIEU",
+		"ONLINE":     "EN LIGNE",
+		"PROBE":      "TEST",
+		"PROFILE":    "PROFIL",
+		"RESULT":     "RÉSULTAT",
+		"DETAIL":     "DÉTAIL",
+		"LAST":       "DERNIÈRE",
+		"BEST":       "MEILLEURE",
+		"WORST":      "PIRE",
+		"AVG":        "MOY",
+		"JITTER":     "GIGUE",
+		"SENT":       "ENVOYÉS",
+		"LOSS%":      "PERTE%",
+		"HOSTNAME":   "NOM D'HÔTE",
+		"PRIORITY":   "PRIORITÉ",
+		"LATENCY":    "LATENCE",
+		"PATH":       "CHEMIN",
+		"PROVIDER":   "FOURNISSEUR",
+		"ATTRIBUTES": "ATTRIBUTS",
+		"UNTIL":      "JUSQU'À",
+	},
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+func init() {
+	commands["tray"] = command{
+		Usage: "Run the daemon with a tray icon to switch country, pause or disable (Windows; Linux with yad)",
+		Run:   runTray,
+	}
+}
+
+// runTray runs the daemon together with a tray icon until the icon's Quit
+// entry or a signal stops both
+func runTray(ctx context.Context, lc LocalClient, args []string) error {
+	if len(args) > 0 {
+		return errors.New("tray takes no arguments")
+	}
+	if err := trayAvailable(); err != nil {
+		return err
+	}
+
+	// A tray usually runs without a console, so it logs to a file as well
+	if *logFileFlag == "" {
+		if path := defaultLogFile(); path != "" {
+			if err := openLogFile(path); err != nil {
+				return err
+			}
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	d := newDaemon(lc)
+	c := newControl(ctx, lc, d)
+	changed := c.subscribe()
+	startDBus(ctx, c)
+
+	errc := make(chan error, 1)
+	go func() {
+		// daemon stop ends the tray too
+		errc <- d.run(ctx)
+		cancel()
+	}()
+	go c.poll()
+
+	err := runTrayUI(ctx, c, changed)
+	cancel()
+	if derr := <-errc; err == nil {
+		err = derr
+	}
+	return err
+}
+
+// trayTooltip is the one-line status shown when hovering the icon
+func trayTooltip(s controlStatus) string {
+	switch {
+	case s.Paused != nil:
+		return trf("protect-wan: paused %s", s.Paused)
+	case !s.Protected:
+		return tr("protect-wan: unprotected")
+	default:
+		return trf("protect-wan: protected via %s (%s)", s.Node.Country, mullvadHostname(s.Node))
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+)
+
+// Icon names from the freedesktop icon theme
+const (
+	trayIconProtected   = "security-high"
+	trayIconUnprotected = "security-low"
+	trayIconPaused      = "media-playback-pause"
+)
+
+// trayAvailable reports whether this build has a tray UI
+func trayAvailable() error {
+	if _, err := exec.LookPath("yad"); err != nil {
+		return errors.New("the Linux tray needs yad (e.g. apt install yad)")
+	}
+	return nil
+}
+
+// runTrayUI shows a StatusNotifier/AppIndicator icon through yad's
+// notification mode until Quit is picked or ctx is done. yad reads icon,
+// tooltip and menu updates from stdin; a picked menu entry runs `echo
+// <action>`, whose output reaches us through yad's stdout.
+func runTrayUI(ctx context.Context, c *control, changed <-chan struct{}) error {
+	s := c.current()
+	cmd := exec.CommandContext(ctx, "yad", "--notification", "--listen", "--command=menu",
+		"--image="+trayIcon(s), "--text="+trayTooltip(s))
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start yad: %w", err)
+	}
+
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-changed:
+				writeTrayStatus(stdin, c.current())
+			}
+		}
+	}()
+
+	scanner := bufio.NewScanner(stdout)
+	for scanner.Scan() {
+		action, arg, _ := strings.Cut(strings.TrimSpace(scanner.Text()), " ")
+		switch action {
+		case "pause":
+			c.pause(0)
+		case "resume":
+			c.resume()
+		case "disable":
+			c.disable()
+		case "country":
+			c.pickCountry(arg)
+		case "quit":
+			fmt.Fprintln(stdin, "quit")
+		}
+	}
+
+	if err := cmd.Wait(); err != nil && ctx.Err() == nil {
+		return fmt.Errorf("yad exited: %w", err)
+	}
+	return nil
+}
+
+// writeTrayStatus sends yad the icon, tooltip and menu for a status
+func writeTrayStatus(w io.Writer, s controlStatus) {
+	fmt.Fprintf(w, "icon:%s\n", trayIcon(s))
+	fmt.Fprintf(w, "tooltip:%s\n", trayTooltip(s))
+
+	// yad has no submenus: items are "label!command", separated by |
+	items := []string{trayMenuText(trayTooltip(s)) + "!true"}
+	if s.Paused != nil {
+		items = append(items, tr("Resume protection")+"!echo resume")
+	} else {
+		items = append(items, tr("Pause protection")+"!echo pause")
+	}
+	items = append(items, tr("Disable exit node")+"!echo disable")
+
+	automatic := tr("Country: automatic")
+	if s.Country == "" {
+		automatic += " ✓"
+	}
+	items = append(items, automatic+"!echo country")
+	for _, c := range s.Countries {
+		label := trf("Country: %s", trayMenuText(c.Name))
+		if c.Code == s.Country {
+			label += " ✓"
+		}
+		items = append(items, fmt.Sprintf("%s!echo country %s", label, c.Code))
+	}
+	items = append(items, tr("Quit")+"!echo quit")
+	fmt.Fprintf(w, "menu:%s\n", strings.Join(items, "|"))
+}
+
+// trayIcon returns the icon name for a status
+func trayIcon(s controlStatus) string {
+	switch {
+	case s.Paused != nil:
+		return trayIconPaused
+	case !s.Protected:
+		return trayIconUnprotected
+	default:
+		return trayIconProtected
+	}
+}
+
+// trayMenuText keeps text from being read as yad menu syntax
+func trayMenuText(s string) string {
+	return strings.NewReplacer("|", "/", "!", "", "\n", " ").Replace(s)
+}
@@ -0,0 +1,17 @@
+//go:build !windows && !linux
+
+package main
+
+import (
+	"context"
+	"errors"
+)
+
+// trayAvailable reports whether this build has a tray UI
+func trayAvailable() error {
+	return errors.New("the tray is only available on Windows and Linux")
+}
+
+func runTrayUI(ctx context.Context, c *control, changed <-chan struct{}) error {
+	return trayAvailable()
+}
@@ -0,0 +1,309 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	user32  = syscall.NewLazyDLL("user32.dll")
+	shell32 = syscall.NewLazyDLL("shell32.dll")
+
+	procRegisterClassExW = user32.NewProc("RegisterClassExW")
+	procCreateWindowExW  = user32.NewProc("CreateWindowExW")
+	procDefWindowProcW   = user32.NewProc("DefWindowProcW")
+	procDestroyWindow    = user32.NewProc("DestroyWindow")
+	procGetMessageW      = user32.NewProc("GetMessageW")
+	procTranslateMessage = user32.NewProc("TranslateMessage")
+	procDispatchMessageW = user32.NewProc("DispatchMessageW")
+	procPostMessageW     = user32.NewProc("PostMessageW")
+	procPostQuitMessage  = user32.NewProc("PostQuitMessage")
+	procLoadIconW        = user32.NewProc("LoadIconW")
+	procCreatePopupMenu  = user32.NewProc("CreatePopupMenu")
+	procAppendMenuW      = user32.NewProc("AppendMenuW")
+	procTrackPopupMenu   = user32.NewProc("TrackPopupMenu")
+	procDestroyMenu      = user32.NewProc("DestroyMenu")
+	procSetForegroundWnd = user32.NewProc("SetForegroundWindow")
+	procGetCursorPos     = user32.NewProc("GetCursorPos")
+	procShellNotifyIconW = shell32.NewProc("Shell_NotifyIconW")
+	procGetModuleHandleW = syscall.NewLazyDLL("kernel32.dll").NewProc("GetModuleHandleW")
+)
+
+// Win32 constants used by the tray
+const (
+	wmDestroy     = 0x0002
+	wmClose       = 0x0010
+	wmCommand     = 0x0111
+	wmLButtonUp   = 0x0202
+	wmRButtonUp   = 0x0205
+	wmApp         = 0x8000
+	wmTrayIcon    = wmApp + 1 // Mouse events on the icon
+	wmTrayRefresh = wmApp + 2 // Status changed
+
+	nimAdd     = 0
+	nimModify  = 1
+	nimDelete  = 2
+	nifMessage = 0x01
+	nifIcon    = 0x02
+	nifTip     = 0x04
+
+	mfString    = 0x0000
+	mfGrayed    = 0x0001
+	mfChecked   = 0x0008
+	mfPopup     = 0x0010
+	mfSeparator = 0x0800
+
+	tpmRightButton = 0x0002
+	tpmBottomAlign = 0x0020
+	tpmReturnCmd   = 0x0100
+
+	idiWarning = 32515
+	idiShield  = 32518
+)
+
+// Menu command IDs. Countries are numbered from menuCountry.
+const (
+	menuPause = iota + 1
+	menuResume
+	menuDisable
+	menuQuit
+	menuAutomatic
+	menuCountry = 100
+)
+
+type wndClassEx struct {
+	Size       uint32
+	Style      uint32
+	WndProc    uintptr
+	ClsExtra   int32
+	WndExtra   int32
+	Instance   uintptr
+	Icon       uintptr
+	Cursor     uintptr
+	Background uintptr
+	MenuName   *uint16
+	ClassName  *uint16
+	IconSm     uintptr
+}
+
+type notifyIconData struct {
+	Size            uint32
+	Wnd             uintptr
+	ID              uint32
+	Flags           uint32
+	CallbackMessage uint32
+	Icon            uintptr
+	Tip             [128]uint16
+	State           uint32
+	StateMask       uint32
+	Info            [256]uint16
+	Version         uint32
+	InfoTitle       [64]uint16
+	InfoFlags       uint32
+	GUID            [16]byte
+	BalloonIcon     uintptr
+}
+
+type winMsg struct {
+	Wnd     uintptr
+	Message uint32
+	WParam  uintptr
+	LParam  uintptr
+	Time    uint32
+	Pt      struct{ X, Y int32 }
+	Private uint32
+}
+
+// winTray is the notification area icon. Windows calls back into a single
+// window procedure, hence the package variable.
+var winTray struct {
+	c         *control
+	wnd       uintptr
+	countries []countryEntry // As numbered in the open menu
+}
+
+// trayAvailable reports whether this build has a tray UI
+func trayAvailable() error {
+	return shell32.Load()
+}
+
+// runTrayUI shows the notification area icon and runs the message loop
+// until Quit is picked or ctx is done
+func runTrayUI(ctx context.Context, c *control, changed <-chan struct{}) error {
+	// Windows delivers a window's messages to the thread that created it
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	winTray.c = c
+	instance, _, _ := procGetModuleHandleW.Call(0)
+	className, _ := syscall.UTF16PtrFromString("protect-wan-tray")
+	wc := wndClassEx{
+		WndProc:   syscall.NewCallback(trayWndProc),
+		Instance:  instance,
+		ClassName: className,
+	}
+	wc.Size = uint32(unsafe.Sizeof(wc))
+	if atom, _, err := procRegisterClassExW.Call(uintptr(unsafe.Pointer(&wc))); atom == 0 {
+		return fmt.Errorf("failed to register tray window class: %w", err)
+	}
+
+	// A hidden top-level window, since the tray menu needs a window that
+	// can take the foreground
+	wnd, _, err := procCreateWindowExW.Call(0, uintptr(unsafe.Pointer(className)), uintptr(unsafe.Pointer(className)),
+		0, 0, 0, 0, 0, 0, 0, instance, 0)
+	if wnd == 0 {
+		return fmt.Errorf("failed to create tray window: %w", err)
+	}
+	winTray.wnd = wnd
+
+	nid := trayIconData(c.current())
+	nid.Flags |= nifMessage
+	nid.CallbackMessage = wmTrayIcon
+	if ok, _, err := procShellNotifyIconW.Call(nimAdd, uintptr(unsafe.Pointer(&nid))); ok == 0 {
+		procDestroyWindow.Call(wnd)
+		return fmt.Errorf("failed to add tray icon: %w", err)
+	}
+
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				procPostMessageW.Call(wnd, wmClose, 0, 0)
+				return
+			case <-changed:
+				procPostMessageW.Call(wnd, wmTrayRefresh, 0, 0)
+			}
+		}
+	}()
+
+	var m winMsg
+	for {
+		r, _, err := procGetMessageW.Call(uintptr(unsafe.Pointer(&m)), 0, 0, 0)
+		switch int32(r) {
+		case -1:
+			return fmt.Errorf("tray message loop failed: %w", err)
+		case 0:
+			return nil // WM_QUIT
+		}
+		procTranslateMessage.Call(uintptr(unsafe.Pointer(&m)))
+		procDispatchMessageW.Call(uintptr(unsafe.Pointer(&m)))
+	}
+}
+
+// trayIconData returns the icon and tooltip for a status: a shield while
+// protected, a warning sign otherwise
+func trayIconData(s controlStatus) notifyIconData {
+	nid := notifyIconData{Wnd: winTray.wnd, ID: 1, Flags: nifIcon | nifTip}
+	nid.Size = uint32(unsafe.Sizeof(nid))
+
+	icon := uintptr(idiShield)
+	if !s.Protected || s.Paused != nil {
+		icon = idiWarning
+	}
+	nid.Icon, _, _ = procLoadIconW.Call(0, icon)
+
+	tip, _ := syscall.UTF16FromString(trayTooltip(s))
+	copy(nid.Tip[:len(nid.Tip)-1], tip)
+	return nid
+}
+
+func trayWndProc(wnd, msg, wparam, lparam uintptr) uintptr {
+	c := winTray.c
+	switch msg {
+	case wmTrayIcon:
+		if lparam == wmRButtonUp || lparam == wmLButtonUp {
+			showTrayMenu(wnd, c.current())
+		}
+		return 0
+	case wmTrayRefresh:
+		nid := trayIconData(c.current())
+		procShellNotifyIconW.Call(nimModify, uintptr(unsafe.Pointer(&nid)))
+		return 0
+	case wmCommand:
+		trayCommand(c, int(wparam&0xffff))
+		return 0
+	case wmDestroy:
+		nid := notifyIconData{Wnd: wnd, ID: 1}
+		nid.Size = uint32(unsafe.Sizeof(nid))
+		procShellNotifyIconW.Call(nimDelete, uintptr(unsafe.Pointer(&nid)))
+		procPostQuitMessage.Call(0)
+		return 0
+	}
+	r, _, _ := procDefWindowProcW.Call(wnd, msg, wparam, lparam)
+	return r
+}
+
+// showTrayMenu pops up the menu at the mouse pointer
+func showTrayMenu(wnd uintptr, s controlStatus) {
+	menu, _, _ := procCreatePopupMenu.Call()
+	if menu == 0 {
+		return
+	}
+	defer procDestroyMenu.Call(menu)
+	countryMenu, _, _ := procCreatePopupMenu.Call()
+
+	appendMenu(menu, mfString|mfGrayed, 0, trayTooltip(s))
+	appendMenu(menu, mfSeparator, 0, "")
+
+	winTray.countries = s.Countries
+	flags := uintptr(mfString)
+	if s.Country == "" {
+		flags |= mfChecked
+	}
+	appendMenu(countryMenu, flags, menuAutomatic, tr("Automatic"))
+	appendMenu(countryMenu, mfSeparator, 0, "")
+	for i, c := range s.Countries {
+		flags := uintptr(mfString)
+		if c.Code == s.Country {
+			flags |= mfChecked
+		}
+		appendMenu(countryMenu, flags, uintptr(menuCountry+i), c.Name)
+	}
+	appendMenu(menu, mfPopup, countryMenu, tr("Country"))
+
+	if s.Paused != nil {
+		appendMenu(menu, mfString, menuResume, tr("Resume protection"))
+	} else {
+		appendMenu(menu, mfString, menuPause, tr("Pause protection"))
+	}
+	appendMenu(menu, mfString, menuDisable, tr("Disable exit node"))
+	appendMenu(menu, mfSeparator, 0, "")
+	appendMenu(menu, mfString, menuQuit, tr("Quit"))
+
+	var pt struct{ X, Y int32 }
+	procGetCursorPos.Call(uintptr(unsafe.Pointer(&pt)))
+	// Without taking the foreground, the menu wouldn't close on a click
+	// elsewhere
+	procSetForegroundWnd.Call(wnd)
+	cmd, _, _ := procTrackPopupMenu.Call(menu, tpmRightButton|tpmBottomAlign|tpmReturnCmd,
+		uintptr(pt.X), uintptr(pt.Y), 0, wnd, 0)
+	if cmd != 0 {
+		procPostMessageW.Call(wnd, wmCommand, cmd, 0)
+	}
+}
+
+func appendMenu(menu, flags, id uintptr, text string) {
+	p, _ := syscall.UTF16PtrFromString(text)
+	procAppendMenuW.Call(menu, flags, id, uintptr(unsafe.Pointer(p)))
+}
+
+// trayCommand runs a picked menu entry
+func trayCommand(c *control, id int) {
+	switch {
+	case id == menuPause:
+		c.pause(0)
+	case id == menuResume:
+		c.resume()
+	case id == menuDisable:
+		c.disable()
+	case id == menuQuit:
+		procDestroyWindow.Call(winTray.wnd)
+	case id == menuAutomatic:
+		c.pickCountry("")
+	case id >= menuCountry && id-menuCountry < len(winTray.countries):
+		c.pickCountry(winTray.countries[id-menuCountry].Code)
+	}
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var trialFlag = flag.Bool("trial", false, "Verify a newly set exit node end-to-end and roll back if it fails or is slower than the previous one")
+
+const (
+	// trialOnlineTimeout is how long a new exit node may take to come online
+	trialOnlineTimeout = 20 * time.Second
+
+	// trialTolerance is how much slower than the previous exit node the
+	// candidate may be before it is rolled back
+	trialTolerance = 1.2
+
+	// exitProbeSamples is the number of timed requests per exit probe
+	exitProbeSamples = 3
+)
+
+// errTrialFailed is returned when a trialed exit node was rolled back
+var errTrialFailed = errors.New("trial failed")
+
+// errTrialSlower is the trial failure of a working but slower exit node
+var errTrialSlower = errors.New("slower than previous exit node")
+
+// applyExitNode sets the exit node to node. With --trial, the switch is
+// verified end-to-end and rolled back to the previous exit node on failure.
+func applyExitNode(ctx context.Context, lc LocalClient, node MullvadNode) error {
+	if err := checkForbidden(ctx, node); err != nil {
+		return err
+	}
+	if err := checkSubnetRoutes(ctx, lc); err != nil {
+		return err
+	}
+	if !*trialFlag {
+		return setExitNode(ctx, lc, node.ID)
+	}
+	err := trialSwitch(ctx, lc, node)
+	verifyOutcome(node, err)
+	return err
+}
+
+// trialSwitch applies node, runs verifyTrial and restores the previous exit
+// node (or none) if verification fails
+func trialSwitch(ctx context.Context, lc LocalClient, node MullvadNode) error {
+	prefs, err := lc.GetPrefs(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get prefs: %w", err)
+	}
+	previous := prefs.ExitNodeID
+
+	if previous == node.ID {
+		if *verboseFlag {
+			fmt.Println("Candidate is already the exit node, nothing to trial")
+		}
+		return nil
+	}
+
+	// Baseline: how fast is the web through the current exit node?
+	var baseline time.Duration
+	if !previous.IsZero() {
+		if baseline, err = probeThroughExit(ctx); err != nil {
+			// A broken current exit node loses against any working candidate
+			baseline = 0
+			if *verboseFlag {
+				fmt.Printf("Current exit node probe failed: %v\n", err)
+			}
+		} else if *verboseFlag {
+			fmt.Printf("Current exit node probe: %dms\n", baseline.Milliseconds())
+		}
+	}
+
+	if err := setExitNode(ctx, lc, node.ID); err != nil {
+		return err
+	}
+
+	verifyErr := verifyTrial(ctx, lc, node, baseline)
+	if verifyErr == nil {
+		return nil
+	}
+
+	fmt.Printf("Trial of %s failed: %v\n", displayName(node), verifyErr)
+	if previous.IsZero() {
+		if err := clearExitNode(withAuditReason(ctx, "trial rollback"), lc); err != nil {
+			return fmt.Errorf("trial failed (%v) and rollback failed: %w", verifyErr, err)
+		}
+		fmt.Println("Rolled back: exit node cleared")
+	} else {
+		if err := setExitNode(withAuditReason(ctx, "trial rollback"), lc, previous); err != nil {
+			return fmt.Errorf("trial failed (%v) and rollback failed: %w", verifyErr, err)
+		}
+		fmt.Printf("Rolled back to previous exit node %s\n", previous)
+	}
+
+	return fmt.Errorf("%w: %w", errTrialFailed, verifyErr)
+}
+
+// verifyTrial checks that traffic now egresses via a Mullvad exit, is not
+// slower than baseline (if known) and reaches the --probe-service targets
+func verifyTrial(ctx context.Context, lc LocalClient, node MullvadNode, baseline time.Duration) error {
+	if err := waitForExitNode(ctx, lc, trialOnlineTimeout); err != nil {
+		return err
+	}
+
+	info, err := fetchPublicIP(ctx, mullvadCheckURL)
+	if err != nil {
+		return err
+	}
+	if !info.MullvadExitIP {
+		return fmt.Errorf("public IP %s is not a Mullvad exit", info.IP)
+	}
+	if *verboseFlag {
+		fmt.Printf("Public IP %s (%s) is Mullvad exit %s\n", info.IP, info.Country, info.MullvadExitIPHostname)
+	}
+	if want := mullvadHostname(node); info.MullvadExitIPHostname != "" && !strings.EqualFold(info.MullvadExitIPHostname, want) {
+		fmt.Printf("Warning: egress via Mullvad server %s, expected %s\n", info.MullvadExitIPHostname, want)
+	}
+
+	latency, err := probeThroughExit(ctx)
+	if err != nil {
+		return err
+	}
+	if *verboseFlag {
+		fmt.Printf("New exit node probe: %dms\n", latency.Milliseconds())
+	}
+	if baseline > 0 && float64(latency) > float64(baseline)*trialTolerance {
+		return fmt.Errorf("%w (%dms vs %dms)", errTrialSlower, latency.Milliseconds(), baseline.Milliseconds())
+	}
+
+	if *requireIPv6Flag || *preferIPv6Flag {
+		if err := probeIPv6Egress(ctx, node); err != nil && *requireIPv6Flag {
+			return err
+		}
+	}
+
+	if err := probeServices(ctx, node); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// waitForExitNode polls until the configured exit node is online and
+// routed, or timeout passes
+func waitForExitNode(ctx context.Context, lc LocalClient, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		active, err := checkExitNode(ctx, lc)
+		if err != nil {
+			return err
+		}
+		if active {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return errors.New("exit node did not come online")
+		}
+		time.Sleep(2 * time.Second)
+	}
+}
+
+// probeThroughExit measures the HTTPS round trip to Mullvad's check API
+// through the current exit. A fresh transport makes sure no connection from
+// a previous exit node is reused; the first request warms it up (DNS, TCP,
+// TLS) and the best of the timed requests is returned.
+func probeThroughExit(ctx context.Context) (time.Duration, error) {
+	transport := newTransport()
+	defer transport.CloseIdleConnections()
+	client := &http.Client{Timeout: httpClient.Timeout, Transport: transport}
+
+	get := func() error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, mullvadCheckURL, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return fmt.Errorf("exit probe failed: %w", err)
+		}
+		resp.Body.Close()
+		return nil
+	}
+
+	if err := get(); err != nil {
+		return 0, err
+	}
+
+	var best time.Duration
+	for range exitProbeSamples {
+		start := time.Now()
+		if err := get(); err != nil {
+			return 0, err
+		}
+		if d := time.Since(start); best == 0 || d < best {
+			best = d
+		}
+	}
+
+	return best, nil
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"tailscale.com/tailcfg"
+)
+
+func init() {
+	commands["stats"] = command{
+		Usage: "Show protection history and data usage per exit node and country; stats export for CSV/JSON aggregates",
+		Run:   runStats,
+	}
+}
+
+// usageRecord is the data that went through one exit node on one day,
+// and the latencies measured to it that day
+type usageRecord struct {
+	Day         string               `json:"day"` // 2006-01-02, local time
+	Node        tailcfg.StableNodeID `json:"node"`
+	Name        string               `json:"name"`
+	CountryCode string               `json:"country_code"`
+	RxBytes     int64                `json:"rx_bytes"`
+	TxBytes     int64                `json:"tx_bytes"`
+
+	LatencySamples int           `json:"latency_samples,omitempty"`
+	LatencySum     time.Duration `json:"latency_sum,omitempty"`
+	LatencyMin     time.Duration `json:"latency_min,omitempty"`
+	LatencyMax     time.Duration `json:"latency_max,omitempty"`
+}
+
+// usageFor returns node's record for the day of t, adding it if needed
+func (st *state) usageFor(node MullvadNode, t time.Time) *usageRecord {
+	day := t.Format(time.DateOnly)
+	for i := range st.Usage {
+		if u := &st.Usage[i]; u.Day == day && u.Node == node.ID {
+			return u
+		}
+	}
+	st.Usage = append(st.Usage, usageRecord{
+		Day:         day,
+		Node:        node.ID,
+		Name:        strings.TrimSuffix(node.DNSName, "."),
+		CountryCode: node.CountryCode,
+	})
+	return &st.Usage[len(st.Usage)-1]
+}
+
+// addUsage adds rx and tx bytes to node's record for the day of t
+func (st *state) addUsage(node MullvadNode, t time.Time, rx, tx int64) {
+	if rx == 0 && tx == 0 {
+		return
+	}
+	u := st.usageFor(node, t)
+	u.RxBytes += rx
+	u.TxBytes += tx
+}
+
+// addLatency records a latency measured to node at t
+func (st *state) addLatency(node MullvadNode, t time.Time, latency time.Duration) {
+	u := st.usageFor(node, t)
+	u.LatencySamples++
+	u.LatencySum += latency
+	if u.LatencyMin == 0 || latency < u.LatencyMin {
+		u.LatencyMin = latency
+	}
+	u.LatencyMax = max(u.LatencyMax, latency)
+}
+
+// recordLatency stores the latency measured to the exit node selected or
+// kept, for the per-day aggregates of stats export. The other candidates
+// are left out so the state doesn't grow by every node probed.
+func recordLatency(node MullvadNode) {
+	if !stateEnabled() || node.Latency <= 0 {
+		return
+	}
+	now := time.Now()
+	err := updateState(func(st *state) {
+		st.addLatency(node, now, node.Latency)
+	})
+	if err != nil {
+		log.Printf("Warning: failed to update state: %v", err)
+	}
+}
+
+// recordActiveLatency records the latency measured during a selection to
+// the active exit node, when the selection keeps it
+func recordActiveLatency(ctx context.Context, lc LocalClient, candidates []MullvadNode) {
+	current, ok, err := currentExitNode(ctx, lc)
+	if err != nil || !ok {
+		return
+	}
+	for _, node := range candidates {
+		if node.ID == current.ID {
+			recordLatency(node)
+			return
+		}
+	}
+}
+
+// usageTotal is the data summed over records sharing a key
+type usageTotal struct {
+	Key     string
+	RxBytes int64
+	TxBytes int64
+	Days    int
+}
+
+// sumUsage totals records by key, most data first
+func sumUsage(records []usageRecord, key func(usageRecord) string) []usageTotal {
+	byKey := make(map[string]*usageTotal)
+	days := make(map[string]map[string]bool)
+	for _, u := range records {
+		k := key(u)
+		t, ok := byKey[k]
+		if !ok {
+			t = &usageTotal{Key: k}
+			byKey[k] = t
+			days[k] = make(map[string]bool)
+		}
+		t.RxBytes += u.RxBytes
+		t.TxBytes += u.TxBytes
+		if u.RxBytes+u.TxBytes > 0 {
+			days[k][u.Day] = true
+		}
+	}
+
+	totals := make([]usageTotal, 0, len(byKey))
+	for k, t := range byKey {
+		t.Days = len(days[k])
+		totals = append(totals, *t)
+	}
+	sort.Slice(totals, func(i, j int) bool {
+		return totals[i].RxBytes+totals[i].TxBytes > totals[j].RxBytes+totals[j].TxBytes
+	})
+	return totals
+}
+
+// runStats prints the data usage recorded by the daemon per exit node and
+// per country
+func runStats(ctx context.Context, lc LocalClient, args []string) error {
+	if len(args) == 1 && args[0] == "export" {
+		return statsExport()
+	}
+	if len(args) > 0 {
+		return fmt.Errorf("usage: %s stats [export [--format csv|json] [--since 30d]]", os.Args[0])
+	}
+	if !stateEnabled() {
+		return errors.New("no state file (see --state)")
+	}
+	st, err := loadState()
+	if err != nil {
+		return err
+	}
+	if len(st.Usage) == 0 && len(st.Days) == 0 {
+		fmt.Println("No usage recorded yet (usage is sampled by --daemon)")
+		return nil
+	}
+	if len(st.Days) > 0 {
+		printHistory(st.Days)
+		if len(st.Usage) == 0 {
+			return nil
+		}
+		fmt.Println()
+	}
+
+	first := st.Usage[0].Day
+	for _, u := range st.Usage {
+		first = min(first, u.Day)
+	}
+	fmt.Printf("Data through exit nodes since %s\n", first)
+
+	printUsage("By exit node", sumUsage(st.Usage, func(u usageRecord) string { return u.Name }))
+	printUsage("By country", sumUsage(st.Usage, func(u usageRecord) string { return u.CountryCode }))
+	return nil
+}
+
+// printUsage prints one table of usage totals
+func printUsage(title string, totals []usageTotal) {
+	fmt.Printf("\n%s:\n", title)
+	fmt.Printf("  %-40s %12s %12s %5s\n", "", "RECEIVED", "SENT", "DAYS")
+	for _, t := range totals {
+		if t.RxBytes+t.TxBytes == 0 {
+			continue // Only measured, never used
+		}
+		fmt.Printf("  %-40s %12s %12s %5d\n", t.Key, formatBytes(float64(t.RxBytes)), formatBytes(float64(t.TxBytes)), t.Days)
+	}
+}
@@ -0,0 +1,21 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestSelectionRecordsOnlySelectedLatency(t *testing.T) {
+	fake := newTestClient(t, testTailnet...)
+	if err := autoSelectMullvad(context.Background(), fake); err != nil {
+		t.Fatalf("autoSelectMullvad: %v", err)
+	}
+
+	st, err := loadState()
+	if err != nil {
+		t.Fatalf("loadState: %v", err)
+	}
+	if len(st.Usage) != 1 || st.Usage[0].Node != "de1" || st.Usage[0].LatencySamples != 1 {
+		t.Errorf("usage = %+v, want one latency sample for de1", st.Usage)
+	}
+}
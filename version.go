@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// tailscaleFeature is a tailscaled capability protect-wan relies on, with
+// the release that introduced it
+type tailscaleFeature struct {
+	name       string
+	minVersion string
+}
+
+var (
+	featureMullvad  = tailscaleFeature{"Mullvad exit nodes", "1.52"}
+	featureProfiles = tailscaleFeature{"Login profiles", "1.34"}
+	featureSuggest  = tailscaleFeature{"The exit node suggestion API", "1.66"}
+	featureAutoExit = tailscaleFeature{"The automatic exit node pref", "1.84"}
+)
+
+// backendVersion caches tailscaled's version for the run
+var backendVersion struct {
+	sync.Mutex
+	version string
+	known   bool
+}
+
+// tailscaledVersion returns the version tailscaled reports, e.g.
+// "1.76.1-t2e5b6c9f0", or "" if it is unknown
+func tailscaledVersion(ctx context.Context, lc LocalClient) string {
+	backendVersion.Lock()
+	defer backendVersion.Unlock()
+	if !backendVersion.known {
+		status, err := lc.StatusWithoutPeers(ctx)
+		if err != nil {
+			return ""
+		}
+		backendVersion.version, backendVersion.known = status.Version, true
+	}
+	return backendVersion.version
+}
+
+// parseVersion returns the major and minor number of a Tailscale version
+func parseVersion(v string) (major, minor int, ok bool) {
+	v, _, _ = strings.Cut(v, "-")
+	parts := strings.Split(v, ".")
+	if len(parts) < 2 {
+		return 0, 0, false
+	}
+	major, err1 := strconv.Atoi(parts[0])
+	minor, err2 := strconv.Atoi(parts[1])
+	return major, minor, err1 == nil && err2 == nil
+}
+
+// versionAtLeast reports whether version v is min or newer
+func versionAtLeast(v, min string) bool {
+	major, minor, ok := parseVersion(v)
+	wantMajor, wantMinor, _ := parseVersion(min)
+	if !ok {
+		return true
+	}
+	return major > wantMajor || major == wantMajor && minor >= wantMinor
+}
+
+// requireTailscale returns a clear error when tailscaled is too old for a
+// feature, instead of the opaque LocalAPI error the call would fail with.
+// An unknown version (e.g. a development build) is given the benefit of
+// the doubt.
+func requireTailscale(ctx context.Context, lc LocalClient, f tailscaleFeature) error {
+	v := tailscaledVersion(ctx, lc)
+	if v == "" || versionAtLeast(v, f.minVersion) {
+		return nil
+	}
+	short, _, _ := strings.Cut(v, "-")
+	return fmt.Errorf("%s requires tailscale >= %s (tailscaled is %s), please update Tailscale", f.name, f.minVersion, short)
+}
+
+// autoExitSupported reports whether prefs edits may clear the automatic
+// exit node pref, which older daemons don't know
+func autoExitSupported(ctx context.Context, lc LocalClient) bool {
+	return requireTailscale(ctx, lc, featureAutoExit) == nil
+}
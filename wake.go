@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+const (
+	// wakeCheckInterval is how often the clock-jump detector samples time
+	wakeCheckInterval = 15 * time.Second
+
+	// wakeJumpThreshold is how far the wall clock may run ahead of the
+	// monotonic clock before we assume the system was suspended
+	wakeJumpThreshold = 30 * time.Second
+
+	// wakeSettleDelay gives Wi-Fi and tunnels time to come back after resume
+	wakeSettleDelay = 5 * time.Second
+)
+
+// watchWake returns a channel that receives a value shortly after the system
+// resumes from suspend. OS suspend notifications are used where available
+// (systemd-logind on Linux); everywhere else, including as a fallback, a
+// resume is detected by the wall clock jumping ahead of the monotonic clock,
+// which stops while the machine sleeps.
+func watchWake(ctx context.Context) <-chan struct{} {
+	wakes := make(chan struct{}, 1)
+	signals := make(chan struct{}, 1)
+
+	notify := func(ch chan struct{}) {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+
+	go osWakeEvents(ctx, func() { notify(signals) })
+
+	go func() {
+		ticker := time.NewTicker(wakeCheckInterval)
+		defer ticker.Stop()
+
+		last := time.Now()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				// Round(0) strips the monotonic reading, leaving wall time
+				wall := now.Round(0).Sub(last.Round(0))
+				mono := now.Sub(last)
+				last = now
+				if wall-mono > wakeJumpThreshold {
+					notify(signals)
+				}
+			}
+		}
+	}()
+
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-signals:
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(wakeSettleDelay):
+			}
+			notify(wakes)
+		}
+	}()
+
+	return wakes
+}
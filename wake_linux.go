@@ -0,0 +1,37 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// osWakeEvents follows systemd-logind's PrepareForSleep signal, which is
+// emitted with false on resume. Needs gdbus; without it the clock-jump
+// detector in watchWake still catches resumes.
+func osWakeEvents(ctx context.Context, onWake func()) {
+	cmd := exec.CommandContext(ctx, "gdbus", "monitor", "--system",
+		"--dest", "org.freedesktop.login1", "--object-path", "/org/freedesktop/login1")
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return
+	}
+	if err := cmd.Start(); err != nil {
+		if *verboseFlag {
+			fmt.Printf("logind sleep signals unavailable (%v), using clock jump detection\n", err)
+		}
+		return
+	}
+	defer cmd.Wait()
+
+	// /org/freedesktop/login1: org.freedesktop.login1.Manager.PrepareForSleep (false,)
+	scanner := bufio.NewScanner(stdout)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.Contains(line, ".PrepareForSleep") && strings.Contains(line, "(false") {
+			onWake()
+		}
+	}
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+
+package main
+
+import "context"
+
+// osWakeEvents has no native implementation on this platform; resumes are
+// detected by the clock-jump check in watchWake
+func osWakeEvents(ctx context.Context, onWake func()) {}
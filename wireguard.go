@@ -0,0 +1,451 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var wireguardDirFlag = flag.String("wireguard-dir", "", "Protect with plain WireGuard tunnels instead of Tailscale: directory of Mullvad wg-quick configs (e.g. ch-zrh-wg-001.conf)")
+
+// wgVerifyTimeout bounds how long a new tunnel may take to egress via
+// Mullvad
+const wgVerifyTimeout = 15 * time.Second
+
+// wgConfig is one Mullvad wg-quick config. wg-quick names the interface
+// after the file, so Name is also the interface name when the tunnel is up.
+type wgConfig struct {
+	Name     string
+	Path     string
+	Endpoint string // host:port of the relay
+	Node     MullvadNode
+}
+
+// mullvadServerName matches Mullvad server names, e.g. ch-zrh-wg-001
+var mullvadServerName = regexp.MustCompile(`^([a-z]{2})-([a-z]{3})-wg-\d+`)
+
+// loadWireGuardConfigs reads the *.conf files of --wireguard-dir. The
+// location of a tunnel is taken from its Mullvad server name.
+func loadWireGuardConfigs(dir string) ([]wgConfig, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.conf"))
+	if err != nil {
+		return nil, err
+	}
+
+	var configs []wgConfig
+	for _, path := range paths {
+		name := strings.TrimSuffix(filepath.Base(path), ".conf")
+		endpoint, err := wgEndpoint(path)
+		if err != nil {
+			return nil, err
+		}
+
+		c := wgConfig{Name: name, Path: path, Endpoint: endpoint}
+		c.Node = MullvadNode{DNSName: mullvadDNSName(name), Online: true}
+		if m := mullvadServerName.FindStringSubmatch(strings.ToLower(name)); m != nil {
+			c.Node.CountryCode = strings.ToUpper(m[1])
+			c.Node.Country = c.Node.CountryCode
+			c.Node.CityCode = strings.ToUpper(m[2])
+			c.Node.City = c.Node.CityCode
+		}
+		configs = append(configs, c)
+	}
+	if len(configs) == 0 {
+		return nil, fmt.Errorf("no WireGuard configs (*.conf) in %s; download them from https://mullvad.net/account/wireguard-config", dir)
+	}
+	return configs, nil
+}
+
+// wgEndpoint returns the Endpoint of the [Peer] section of a config
+func wgEndpoint(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to open WireGuard config: %w", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), "=")
+		if ok && strings.EqualFold(strings.TrimSpace(key), "Endpoint") {
+			return strings.TrimSpace(value), nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", fmt.Errorf("failed to read WireGuard config: %w", err)
+	}
+	return "", fmt.Errorf("%s has no Endpoint", path)
+}
+
+// activeWireGuard returns the config whose tunnel is up, if any
+func activeWireGuard(ctx context.Context, configs []wgConfig) (*wgConfig, error) {
+	out, err := exec.CommandContext(ctx, "wg", "show", "interfaces").Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list WireGuard interfaces (is wireguard-tools installed?): %w", err)
+	}
+	up := strings.Fields(string(out))
+	for i, c := range configs {
+		for _, iface := range up {
+			if iface == c.Name {
+				return &configs[i], nil
+			}
+		}
+	}
+	return nil, nil
+}
+
+// wgQuick runs `wg-quick up|down <config>`
+func wgQuick(ctx context.Context, action string, c *wgConfig) error {
+	cmd := exec.CommandContext(ctx, "wg-quick", action, c.Path)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		msg := strings.TrimSpace(string(out))
+		if strings.Contains(msg, "must be run as root") || strings.Contains(msg, "Operation not permitted") {
+			return fmt.Errorf("wg-quick %s %s: permission denied, run with sudo", action, c.Name)
+		}
+		return fmt.Errorf("wg-quick %s %s failed: %w: %s", action, c.Name, err, msg)
+	}
+	return nil
+}
+
+// pingTimeRe matches the round trip of a system ping on Linux, macOS and
+// Windows
+var pingTimeRe = regexp.MustCompile(`time[=<]([0-9.]+) ?ms`)
+
+// pingEndpoint measures the latency to a relay with the system ping, as
+// there is no tailscaled to ask
+func pingEndpoint(ctx context.Context, endpoint string) (time.Duration, error) {
+	host, _, err := net.SplitHostPort(endpoint)
+	if err != nil {
+		host = endpoint
+	}
+	args := []string{"-c", "1", host}
+	if runtime.GOOS == "windows" {
+		args = []string{"-n", "1", "-w", strconv.Itoa(int(pingTimeout.Milliseconds())), host}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, pingTimeout)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, "ping", args...).Output()
+	if err != nil {
+		return 0, fmt.Errorf("ping %s failed: %w", host, err)
+	}
+	m := pingTimeRe.FindSubmatch(out)
+	if m == nil {
+		return 0, fmt.Errorf("ping %s: no reply", host)
+	}
+	ms, err := strconv.ParseFloat(string(m[1]), 64)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(ms * float64(time.Millisecond)), nil
+}
+
+// rankWireGuard pings the relays of the configs passing the location
+// filters, at most --parallel at a time. Returns those that answered,
+// fastest first.
+func rankWireGuard(ctx context.Context, configs []wgConfig) ([]wgConfig, error) {
+	nodes := make([]MullvadNode, len(configs))
+	for i, c := range configs {
+		nodes[i] = c.Node
+	}
+	if err := validateForbidden(nodes); err != nil {
+		return nil, err
+	}
+	nodes, err := filterByLocation(nodes)
+	if err != nil {
+		return nil, err
+	}
+	nodes, err = filterByNodePatterns(nodes)
+	if err != nil {
+		return nil, err
+	}
+	nodes, err = filterForbidden(ctx, nodes)
+	if err != nil {
+		return nil, err
+	}
+	var candidates []wgConfig
+	for _, c := range configs {
+		if nodeIndexByName(nodes, c.Node.DNSName) >= 0 {
+			candidates = append(candidates, c)
+		}
+	}
+
+	if *verboseFlag {
+		fmt.Printf("Testing latency to %d WireGuard relays...\n", len(candidates))
+	}
+	var (
+		mu     sync.Mutex
+		ranked []wgConfig
+		wg     sync.WaitGroup
+	)
+	sem := make(chan struct{}, max(1, *parallelFlag))
+	for _, c := range candidates {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			latency, err := pingEndpoint(ctx, c.Endpoint)
+			if err != nil {
+				if *verboseFlag {
+					fmt.Printf("  %s: failed (%v)\n", c.Name, err)
+				}
+				return
+			}
+			if *verboseFlag {
+				fmt.Printf("  %s: %dms\n", c.Name, latency.Milliseconds())
+			}
+			c.Node.Latency = latency
+			mu.Lock()
+			ranked = append(ranked, c)
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	if len(ranked) == 0 {
+		return nil, errors.New("no WireGuard relay answered a ping")
+	}
+	sort.SliceStable(ranked, func(i, j int) bool {
+		return ranked[i].Node.Latency < ranked[j].Node.Latency
+	})
+	return ranked, nil
+}
+
+// nodeIndexByName returns the index of the node with the DNS name, or -1
+func nodeIndexByName(nodes []MullvadNode, dnsName string) int {
+	for i, node := range nodes {
+		if node.DNSName == dnsName {
+			return i
+		}
+	}
+	return -1
+}
+
+// switchWireGuard brings the tunnel of c up in place of the active one.
+// With --trial a tunnel that fails verification is replaced by the
+// previous one again.
+func switchWireGuard(ctx context.Context, active, c *wgConfig) error {
+	if active != nil && active.Name != c.Name {
+		if err := wgQuick(ctx, "down", active); err != nil {
+			return err
+		}
+	}
+	if active == nil || active.Name != c.Name {
+		if err := wgQuick(ctx, "up", c); err != nil {
+			return err
+		}
+	}
+	fmt.Printf("Successfully brought up WireGuard tunnel: %s\n", c.Name)
+
+	verifyErr := verifyWireGuard(ctx, c)
+	if verifyErr == nil {
+		return nil
+	}
+	if *trialFlag && active != nil && active.Name != c.Name {
+		fmt.Printf("Trial failed: %v\n", verifyErr)
+		if err := wgQuick(ctx, "down", c); err != nil {
+			return fmt.Errorf("trial failed (%v) and rollback failed: %w", verifyErr, err)
+		}
+		if err := wgQuick(ctx, "up", active); err != nil {
+			return fmt.Errorf("trial failed (%v) and rollback failed: %w", verifyErr, err)
+		}
+		fmt.Printf("Rolled back to previous tunnel %s\n", active.Name)
+	}
+	return fmt.Errorf("%w: %w", errTrialFailed, verifyErr)
+}
+
+// verifyWireGuard waits for traffic to egress via a Mullvad server
+func verifyWireGuard(ctx context.Context, c *wgConfig) error {
+	deadline := time.Now().Add(wgVerifyTimeout)
+	for {
+		info, err := fetchPublicIP(ctx, mullvadCheckURL)
+		if err == nil && info.MullvadExitIP {
+			if *verboseFlag {
+				fmt.Printf("Public IP %s (%s) is Mullvad exit %s\n", info.IP, info.Country, info.MullvadExitIPHostname)
+			}
+			if info.MullvadExitIPHostname != "" && !strings.EqualFold(info.MullvadExitIPHostname, c.Name) &&
+				mullvadServerName.MatchString(strings.ToLower(c.Name)) {
+				fmt.Printf("Warning: egress via Mullvad server %s, expected %s\n", info.MullvadExitIPHostname, c.Name)
+			}
+			return nil
+		}
+		if time.Now().After(deadline) {
+			if err != nil {
+				return err
+			}
+			return fmt.Errorf("public IP %s is not a Mullvad exit", info.IP)
+		}
+		time.Sleep(2 * time.Second)
+	}
+}
+
+// findWireGuard resolves --set for tunnels: a config name, or a country or
+// city code whose fastest tunnel is used
+func findWireGuard(ctx context.Context, configs []wgConfig, name string) (*wgConfig, error) {
+	for i, c := range configs {
+		if strings.EqualFold(c.Name, name) {
+			if err := checkWireGuardForbidden(ctx, configs, &configs[i]); err != nil {
+				return nil, err
+			}
+			return &configs[i], nil
+		}
+	}
+	var matching []wgConfig
+	for _, c := range configs {
+		if strings.EqualFold(c.Node.CountryCode, name) || strings.EqualFold(c.Node.CityCode, name) {
+			matching = append(matching, c)
+		}
+	}
+	if len(matching) == 0 {
+		return nil, fmt.Errorf("no WireGuard config matches %q", name)
+	}
+	ranked, err := rankWireGuard(ctx, matching)
+	if err != nil {
+		return nil, err
+	}
+	return &ranked[0], nil
+}
+
+// checkWireGuardForbidden fails for a tunnel in a forbidden country, as
+// checkForbidden does for exit nodes. A tunnel whose name doesn't tell its
+// country is refused while the admin config locks the countries.
+func checkWireGuardForbidden(ctx context.Context, configs []wgConfig, c *wgConfig) error {
+	if len(forbidCountryFlag) == 0 && len(allowCountryFlag) == 0 {
+		return nil
+	}
+	nodes := make([]MullvadNode, len(configs))
+	for i, c := range configs {
+		nodes[i] = c.Node
+	}
+	if err := validateForbidden(nodes); err != nil {
+		return err
+	}
+	if c.Node.CountryCode == "" && countriesLocked() {
+		return fmt.Errorf("WireGuard tunnel %s has no known country, refused while the admin config locks the countries", c.Name)
+	}
+	return checkForbidden(ctx, c.Node)
+}
+
+// runWireGuard is main for --wireguard-dir: the check, list, set, auto,
+// disable and default modes, with wg-quick tunnels in place of Tailscale
+// exit nodes. It does not return.
+func runWireGuard(ctx context.Context, cmdName string, cmdArgs []string) {
+	if runtime.GOOS == "windows" {
+		log.Fatalf("Error: --wireguard-dir needs wg-quick; on Windows use the WireGuard app")
+	}
+	if cmdName != "" {
+		if err := applyFlagCommand(cmdName, cmdArgs); err != nil {
+			log.Fatalf("Error: %v (with --wireguard-dir only check, list, set, auto and disable are available)", err)
+		}
+	}
+	if *daemonFlag {
+		log.Fatalf("Error: --daemon is not supported with --wireguard-dir")
+	}
+
+	configs, err := loadWireGuardConfigs(*wireguardDirFlag)
+	if err != nil {
+		log.Fatalf("Error loading WireGuard configs: %v", err)
+	}
+
+	if *listFlag {
+		fmt.Printf("Available WireGuard Tunnels (%d):\n", len(configs))
+		fmt.Println(strings.Repeat("-", 80))
+		fmt.Printf("%-30s %-12s %s\n", "NAME", "LOCATION", "ENDPOINT")
+		fmt.Println(strings.Repeat("-", 80))
+		for _, c := range configs {
+			location := "-"
+			if c.Node.CountryCode != "" {
+				location = fmt.Sprintf("%s, %s", c.Node.CityCode, c.Node.CountryCode)
+			}
+			fmt.Printf("%-30s %-12s %s\n", c.Name, location, c.Endpoint)
+		}
+		os.Exit(0)
+	}
+
+	active, err := activeWireGuard(ctx, configs)
+	if err != nil {
+		fatal(ctx, "Error checking WireGuard tunnel", err)
+	}
+
+	switch {
+	case *disableFlag:
+		if err := checkEnforced(); err != nil {
+			log.Fatalf("Error disabling WireGuard tunnel: %v", err)
+		}
+		if active == nil {
+			fmt.Println("No WireGuard tunnel active")
+			os.Exit(0)
+		}
+		if err := wgQuick(ctx, "down", active); err != nil {
+			log.Fatalf("Error disabling WireGuard tunnel: %v", err)
+		}
+		fmt.Println("WireGuard tunnel disabled successfully")
+		os.Exit(0)
+
+	case *setFlag != "":
+		c, err := findWireGuard(ctx, configs, *setFlag)
+		if err != nil {
+			fatal(ctx, "Error setting WireGuard tunnel", err)
+		}
+		if err := switchWireGuard(ctx, active, c); err != nil {
+			fatal(ctx, "Error setting WireGuard tunnel", err)
+		}
+		os.Exit(0)
+
+	case *checkFlag || (!*autoFlag && active != nil):
+		if active != nil {
+			// Brought up outside protect-wan, say, a tunnel may still be
+			// in a forbidden country. The default run replaces it.
+			if err := checkWireGuardForbidden(ctx, configs, active); err != nil {
+				if *checkFlag {
+					fatal(ctx, "Error checking WireGuard tunnel", err)
+				}
+				fmt.Printf("Warning: %v, selecting another\n", err)
+				break
+			}
+			if err := verifyWireGuard(ctx, active); err != nil {
+				fatal(ctx, "Error checking WireGuard tunnel", fmt.Errorf("%w: %w", errTrialFailed, err))
+			}
+			fmt.Println(trf("WAN is protected (WireGuard %s)", active.Name))
+			os.Exit(0)
+		}
+		if rule, trusted := onTrustedNetwork(); trusted {
+			fmt.Printf("No WireGuard tunnel active (trusted network: %s)\n", rule)
+			os.Exit(0)
+		}
+		fmt.Println("No WireGuard tunnel active")
+		os.Exit(1)
+
+	case !*autoFlag:
+		if rule, trusted := onTrustedNetwork(); trusted {
+			fmt.Printf("Trusted network (%s): tunnel not enforced\n", rule)
+			os.Exit(0)
+		}
+	}
+
+	ranked, err := rankWireGuard(ctx, configs)
+	if err != nil {
+		fatalSelection(ctx, err)
+	}
+	best := ranked[0]
+	fmt.Printf("Selected %s (%dms)\n", best.Name, best.Node.Latency.Milliseconds())
+	if err := switchWireGuard(ctx, active, &best); err != nil {
+		fatalSelection(ctx, err)
+	}
+	os.Exit(0)
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestFindWireGuardForbidden(t *testing.T) {
+	newTestClient(t)
+	configs := []wgConfig{
+		{Name: "ch-zrh-wg-001", Node: MullvadNode{CountryCode: "CH", Country: "CH", CityCode: "ZRH"}},
+		{Name: "se-sto-wg-001", Node: MullvadNode{CountryCode: "SE", Country: "SE", CityCode: "STO"}},
+		{Name: "home"},
+	}
+	tests := []struct {
+		name    string
+		flags   map[string]string
+		locked  bool
+		set     string
+		wantErr bool
+	}{
+		{name: "allowed", flags: map[string]string{"forbid-country": "SE"}, set: "ch-zrh-wg-001"},
+		{name: "forbidden country", flags: map[string]string{"forbid-country": "SE"}, set: "se-sto-wg-001", wantErr: true},
+		{name: "outside --allow-country", flags: map[string]string{"allow-country": "CH"}, set: "se-sto-wg-001", wantErr: true},
+		{name: "unknown country", flags: map[string]string{"forbid-country": "SE"}, set: "home"},
+		{name: "unknown country while locked", flags: map[string]string{"forbid-country": "SE"}, locked: true, set: "home", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for name, value := range tt.flags {
+				withFlag(t, name, value)
+			}
+			if tt.locked {
+				adminLocked["forbid-country"] = tt.flags["forbid-country"]
+				t.Cleanup(func() { delete(adminLocked, "forbid-country") })
+			}
+
+			c, err := findWireGuard(context.Background(), configs, tt.set)
+			switch {
+			case tt.wantErr && err == nil:
+				t.Errorf("findWireGuard(%q) = %s, want an error", tt.set, c.Name)
+			case !tt.wantErr && err != nil:
+				t.Errorf("findWireGuard(%q): %v", tt.set, err)
+			}
+			var forbidden *forbiddenError
+			if tt.wantErr && !tt.locked && !errors.As(err, &forbidden) {
+				t.Errorf("findWireGuard(%q) error = %v, want a forbiddenError", tt.set, err)
+			}
+		})
+	}
+}